@@ -3,9 +3,14 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"text/tabwriter"
 	"time"
@@ -47,15 +52,28 @@ func main() {
 		createCmd(),
 		deleteCmd(),
 		listCmd(),
+		statusCmd(),
 		startCmd(),
 		stopCmd(),
+		suspendCmd(),
+		bundleCmd(),
+		trimCmd(),
+		compactCmd(),
+		memoryCmd(),
+		memoryGuardCmd(),
 		sshCmd(),
+		consoleCmd(),
 		configCmd(),
 		imageCmd(),
 		kernelCmd(),
 		portForwardCmd(),
+		netCheckCmd(),
+		diagnosticsCmd(),
 		mountCmd(),
+		manifestCmd(),
+		applyCmd(),
 		versionCmd(),
+		checkCmd(),
 		autostartCmd(),
 		autostopCmd(),
 	)
@@ -66,14 +84,53 @@ func main() {
 }
 
 func createCmd() *cobra.Command {
-	var cpus int
+	var cpus float64
 	var memory int
+	var cpuLimit float64
+	var cpuAffinity []int
+	var priority int
+	var memoryLimit int
+	var minMemory int
 	var disk int
 	var sshKeyPath string
 	var dnsServers []string
+	var dnsSearch []string
+	var ntpServers []string
+	var postStopHook string
+	var preStartHook string
+	var envVars []string
+	var kernelArgs string
+	var replaceKernelArgs bool
+	var cloudInitUserData string
+	var cloudInitNetworkConfig string
 	var imageName string
 	var kernelName string
+	var initrdPath string
+	var modulesImage string
+	var guestInit string
+	var syncClock bool
 	var mounts []string
+	var tmpfsMounts []string
+	var archiveMounts []string
+	var mountInodeRatio int
+	var mountBlockSize int
+	var mountReservedBlocks int
+	var mountMkfsOptions []string
+	var mountSyncMode string
+	var mountEncrypt bool
+	var mountShared bool
+	var mountKeyFile string
+	var mountOrders []string
+	var mountDriveIDs []string
+	var mountPaths []string
+	var mountFilesystems []string
+	var mountSubPaths []string
+	var driveSpecs []string
+	var guestAgent bool
+	var readOnlyRootfs bool
+	var verity bool
+	var diskCache string
+	var diskIOEngine string
 
 	cmd := &cobra.Command{
 		Use:   "create <name>",
@@ -93,6 +150,16 @@ func createCmd() *cobra.Command {
 			if vm.Exists(paths.VMs, name) {
 				return fmt.Errorf("VM '%s' already exists", name)
 			}
+			socketPath, err := paths.SocketPath(name)
+			if err != nil {
+				return err
+			}
+			vsockPath := ""
+			if guestAgent {
+				if vsockPath, err = paths.VsockPath(name); err != nil {
+					return err
+				}
+			}
 
 			// Resolve values: CLI flag → config default → hardcoded fallback
 			defaults := cfg.GetVMDefaults()
@@ -100,7 +167,7 @@ func createCmd() *cobra.Command {
 			// CPUs
 			if !cmd.Flags().Changed("cpus") {
 				if defaults.CPUs > 0 {
-					cpus = defaults.CPUs
+					cpus = float64(defaults.CPUs)
 				} else {
 					cpus = 1
 				}
@@ -143,6 +210,62 @@ func createCmd() *cobra.Command {
 			if !cmd.Flags().Changed("dns") && len(defaults.DNSServers) > 0 {
 				dnsServers = defaults.DNSServers
 			}
+			if err := image.ValidateDNSServers(dnsServers); err != nil {
+				return err
+			}
+			if err := image.ValidateSearchDomains(dnsSearch); err != nil {
+				return err
+			}
+			if err := image.ValidateNTPServers(ntpServers); err != nil {
+				return err
+			}
+
+			// Guest environment
+			env := make(map[string]string)
+			for _, assignment := range envVars {
+				name, value, err := image.ParseEnvVar(assignment)
+				if err != nil {
+					return err
+				}
+				env[name] = value
+			}
+
+			// A fractional --cpus is the vCPUs rounded up, capped to the fraction with the cgroup CPU limit
+			if cpus <= 0 {
+				return fmt.Errorf("invalid --cpus %g: must be positive", cpus)
+			}
+			vcpus, cpuQuota := firecracker.SplitCPUs(cpus)
+			if cpuQuota > 0 {
+				if cmd.Flags().Changed("cpu-limit") {
+					return fmt.Errorf("a fractional --cpus (%g) sets the CPU cap itself; use whole --cpus with --cpu-limit instead", cpus)
+				}
+				cpuLimit = cpuQuota
+			}
+
+			// Host resource caps
+			if cpuLimit < 0 || memoryLimit < 0 {
+				return fmt.Errorf("--cpu-limit and --memory-limit cannot be negative")
+			}
+			if memoryLimit > 0 && memoryLimit <= memory {
+				return fmt.Errorf("--memory-limit (%d MB) must be larger than the VM memory (%d MB) to leave room for Firecracker itself", memoryLimit, memory)
+			}
+			if minMemory < 0 || minMemory > memory {
+				return fmt.Errorf("--min-memory (%d MB) must be between 0 and the VM memory (%d MB)", minMemory, memory)
+			}
+			if limits := firecracker.NewCgroupLimits(cpuLimit, memoryLimit); limits != nil {
+				if err := limits.Validate(); err != nil {
+					return fmt.Errorf("invalid --cpu-limit: %w", err)
+				}
+			}
+			if err := firecracker.ValidateCPUAffinity(cpuAffinity); err != nil {
+				return fmt.Errorf("invalid --cpu-affinity: %w", err)
+			}
+			if _, err := firecracker.ParseCacheType(diskCache); err != nil {
+				return fmt.Errorf("invalid --disk-cache: %w", err)
+			}
+			if _, err := firecracker.ParseIOEngine(diskIOEngine); err != nil {
+				return fmt.Errorf("invalid --disk-io-engine: %w", err)
+			}
 
 			// Create image manager for validation
 			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
@@ -154,6 +277,15 @@ func createCmd() *cobra.Command {
 				}
 			}
 
+			// Resolve a version constraint such as '>=6.1' to the newest matching kernel
+			if image.IsKernelConstraint(kernelName) {
+				selected, err := imgMgr.SelectKernel(kernelName)
+				if err != nil {
+					return fmt.Errorf("failed to select kernel: %w", err)
+				}
+				kernelName = selected
+			}
+
 			// Validate kernel exists if specified
 			if kernelName != "" {
 				if !imgMgr.KernelExists(kernelName) {
@@ -161,30 +293,200 @@ func createCmd() *cobra.Command {
 				}
 			}
 
-			// Parse mount specifications
+			// Validate initrd exists if specified
+			if initrdPath != "" {
+				absInitrd, err := filepath.Abs(initrdPath)
+				if err != nil {
+					return fmt.Errorf("invalid initrd path: %w", err)
+				}
+				if _, err := os.Stat(absInitrd); err != nil {
+					return fmt.Errorf("initrd not found at %s", absInitrd)
+				}
+				initrdPath = absInitrd
+			}
+
+			// cloud-init seed files are read at each start, so keep them where they are
+			if cloudInitNetworkConfig != "" && cloudInitUserData == "" {
+				return fmt.Errorf("--cloud-init-network-config requires --cloud-init-user-data")
+			}
+			if len(ntpServers) > 0 && cloudInitUserData == "" {
+				return fmt.Errorf("--ntp requires --cloud-init-user-data: NTP servers reach the guest through the cloud-init seed")
+			}
+			for _, path := range []*string{&cloudInitUserData, &cloudInitNetworkConfig} {
+				if *path == "" {
+					continue
+				}
+				absPath, err := filepath.Abs(*path)
+				if err != nil {
+					return fmt.Errorf("invalid cloud-init file path: %w", err)
+				}
+				if _, err := os.Stat(absPath); err != nil {
+					return fmt.Errorf("cloud-init file not found at %s", absPath)
+				}
+				*path = absPath
+			}
+
+			// Validate the modules image exists if specified; its version is checked against the kernel at start
+			if modulesImage != "" {
+				absModules, err := filepath.Abs(modulesImage)
+				if err != nil {
+					return fmt.Errorf("invalid modules image path: %w", err)
+				}
+				if _, err := os.Stat(absModules); err != nil {
+					return fmt.Errorf("modules image not found at %s", absModules)
+				}
+				modulesImage = absModules
+			}
+			if err := firecracker.ValidateInit(guestInit); err != nil {
+				return fmt.Errorf("invalid --init: %w", err)
+			}
+
+			if _, err := mount.ParseSyncMode(mountSyncMode); err != nil {
+				return err
+			}
+			if mountKeyFile != "" {
+				if !mountEncrypt {
+					return fmt.Errorf("--mount-key-file requires --mount-encrypt")
+				}
+				absKeyFile, err := filepath.Abs(mountKeyFile)
+				if err != nil {
+					return fmt.Errorf("invalid key file path: %w", err)
+				}
+				mountKeyFile = absKeyFile
+			}
+
+			// Parse mount specifications, reporting every problem at once
+			parsedMounts, err := mount.ParseMountSpecs(mounts)
+			if err != nil {
+				return fmt.Errorf("invalid mount specification:\n%w", err)
+			}
 			var vmMounts []vm.Mount
-			for _, mountSpec := range mounts {
-				parsedMount, err := mount.ParseMountSpec(mountSpec)
+			mountMgr := newMountManager()
+			for _, parsedMount := range parsedMounts {
+				if err := mountMgr.ValidateSourcePaths(parsedMount); err != nil {
+					return err
+				}
+				parsedMount.InodeRatio = mountInodeRatio
+				parsedMount.BlockSize = mountBlockSize
+				parsedMount.ReservedBlocksPercent = mountReservedBlocks
+				parsedMount.MkfsOptions = mountMkfsOptions
+				parsedMount.SyncMode = mountSyncMode
+				parsedMount.Encrypted = mountEncrypt
+				parsedMount.KeyFile = mountKeyFile
+				if mountShared {
+					if parsedMount.ReadOnly && !mountEncrypt {
+						parsedMount.Shared = true
+					} else {
+						fmt.Printf("Warning: mount '%s' is not shared: only unencrypted read-only mounts can be; it gets its own copy\n", parsedMount.GuestTag)
+					}
+				}
+				if err := mount.ValidateMkfsOptions(parsedMount); err != nil {
+					return err
+				}
+				if err := mount.CheckEncryption(parsedMount); err != nil {
+					return err
+				}
+				vmMounts = append(vmMounts, *parsedMount)
+			}
+			for _, tmpfsSpec := range tmpfsMounts {
+				parsedMount, err := mount.ParseTmpfsSpec(tmpfsSpec)
+				if err != nil {
+					return fmt.Errorf("invalid tmpfs specification: %w", err)
+				}
+				vmMounts = append(vmMounts, *parsedMount)
+			}
+			for _, archiveSpec := range archiveMounts {
+				parsedMount, err := mount.ParseArchiveSpec(archiveSpec)
 				if err != nil {
-					return fmt.Errorf("invalid mount specification: %w", err)
+					return fmt.Errorf("invalid archive mount specification: %w", err)
+				}
+				parsedMount.InodeRatio = mountInodeRatio
+				parsedMount.BlockSize = mountBlockSize
+				parsedMount.ReservedBlocksPercent = mountReservedBlocks
+				parsedMount.MkfsOptions = mountMkfsOptions
+				if err := mount.ValidateMkfsOptions(parsedMount); err != nil {
+					return err
 				}
 				vmMounts = append(vmMounts, *parsedMount)
 			}
+			seenTags := make(map[string]bool)
+			for _, m := range vmMounts {
+				if seenTags[m.GuestTag] {
+					return fmt.Errorf("mount tag '%s' is used more than once", m.GuestTag)
+				}
+				seenTags[m.GuestTag] = true
+			}
+			if err := applyMountDriveFlags(vmMounts, mountOrders, mountDriveIDs); err != nil {
+				return err
+			}
+			if err := validateMountDrives(vmMounts); err != nil {
+				return err
+			}
+			if err := applyMountPaths(vmMounts, mountPaths); err != nil {
+				return err
+			}
+			if err := applyMountFilesystems(vmMounts, mountFilesystems); err != nil {
+				return err
+			}
+			if err := applyMountSubPaths(vmMounts, mountSubPaths); err != nil {
+				return err
+			}
+			if err := validateMountPaths(vmMounts, modulesImage != ""); err != nil {
+				return err
+			}
+			var drives []vm.Drive
+			for _, spec := range driveSpecs {
+				drive, err := parseDriveSpec(spec)
+				if err != nil {
+					return err
+				}
+				drives = append(drives, drive)
+			}
 
 			// Create new VM
 			newVM := vm.NewVM(name)
-			newVM.CPUs = cpus
+			newVM.CPUs = vcpus
 			newVM.MemoryMB = memory
+			newVM.MinMemoryMB = minMemory
+			newVM.CPULimit = cpuLimit
+			newVM.CPUAffinity = cpuAffinity
+			newVM.Priority = priority
+			newVM.MemoryLimitMB = memoryLimit
 			newVM.DiskSizeMB = disk
 			newVM.Image = imageName
 			newVM.Kernel = kernelName
+			newVM.InitrdPath = initrdPath
+			newVM.ModulesImage = modulesImage
+			newVM.Init = guestInit
+			newVM.SyncClock = syncClock
 			newVM.MacAddress = newVM.GenerateMacAddress()
 			newVM.TapDevice = network.GenerateTapName(newVM.ID)
 			newVM.DNSServers = dnsServers
+			newVM.DNSSearch = dnsSearch
+			newVM.NTPServers = ntpServers
+			newVM.PostStopHook = postStopHook
+			newVM.PreStartHook = preStartHook
+			if len(env) > 0 {
+				newVM.EnvVars = env
+			}
+			newVM.ExtraKernelArgs = kernelArgs
+			newVM.CloudInitUserData = cloudInitUserData
+			newVM.CloudInitNetworkConfig = cloudInitNetworkConfig
+			newVM.ReplaceKernelArgs = replaceKernelArgs
 			newVM.Mounts = vmMounts
+			newVM.Drives = drives
+			if err := checkHostDrives(newVM, false); err != nil {
+				return err
+			}
 
 			// Set paths
-			newVM.SocketPath = fmt.Sprintf("%s/%s.sock", paths.Sockets, name)
+			newVM.SocketPath = socketPath
+			newVM.VsockPath = vsockPath
+			newVM.GuestAgent = guestAgent
+			newVM.RootReadOnly = readOnlyRootfs
+			newVM.Verity = verity
+			newVM.DiskCache = diskCache
+			newVM.DiskIOEngine = diskIOEngine
 
 			// Read SSH public key if provided
 			if sshKeyPath != "" {
@@ -203,13 +505,22 @@ func createCmd() *cobra.Command {
 				newVM.SSHPublicKey = string(keyData)
 			}
 
+			if err := allocateVMIP(newVM); err != nil {
+				return err
+			}
+
 			// Save VM config
 			if err := newVM.Save(paths.VMs); err != nil {
+				newIPAM().Release(name)
 				return fmt.Errorf("failed to save VM config: %w", err)
 			}
 
 			fmt.Printf("Created VM '%s' (ID: %s)\n", name, newVM.ID)
+			fmt.Printf("  IP Address: %s\n", newVM.IPAddress)
 			fmt.Printf("  CPUs: %d, Memory: %d MB, Disk: %d MB\n", newVM.CPUs, newVM.MemoryMB, newVM.DiskSizeMB)
+			if newVM.MinMemoryMB > 0 {
+				fmt.Printf("  Memory range: %d-%d MB (resize with 'vmm memory')\n", newVM.MinMemoryMB, newVM.MemoryMB)
+			}
 			if newVM.Image != "" {
 				fmt.Printf("  Image: %s\n", newVM.Image)
 			}
@@ -223,6 +534,25 @@ func createCmd() *cobra.Command {
 			if len(newVM.DNSServers) > 0 {
 				fmt.Printf("  DNS servers: %v\n", newVM.DNSServers)
 			}
+			if len(newVM.DNSSearch) > 0 {
+				fmt.Printf("  DNS search: %v\n", newVM.DNSSearch)
+			}
+			if len(newVM.NTPServers) > 0 {
+				fmt.Printf("  NTP servers: %v\n", newVM.NTPServers)
+			}
+			if newVM.PreStartHook != "" {
+				fmt.Printf("  Pre-start hook: %s\n", newVM.PreStartHook)
+			}
+			if newVM.PostStopHook != "" {
+				fmt.Printf("  Post-stop hook: %s\n", newVM.PostStopHook)
+			}
+			for _, d := range newVM.Drives {
+				mode := "rw"
+				if d.ReadOnly {
+					mode = "ro"
+				}
+				fmt.Printf("  Drive: %s (%s)\n", d.Path, mode)
+			}
 			if len(newVM.Mounts) > 0 {
 				fmt.Printf("  Mounts:\n")
 				for _, m := range newVM.Mounts {
@@ -230,21 +560,63 @@ func createCmd() *cobra.Command {
 					if m.ReadOnly {
 						mode = "ro"
 					}
-					fmt.Printf("    - %s -> /mnt/%s (%s)\n", m.HostPath, m.GuestTag, mode)
+					if m.IsVFAT() {
+						mode += ", vfat"
+					}
+					fmt.Printf("    - %s -> %s (%s)\n", m.SourceDescription(), m.MountPath(), mode)
 				}
 			}
 			return nil
 		},
 	}
 
-	cmd.Flags().IntVar(&cpus, "cpus", 0, "Number of vCPUs")
+	cmd.Flags().Float64Var(&cpus, "cpus", 0, "Number of vCPUs; a fraction such as 0.5 gives whole vCPUs capped to that much host CPU (cgroup v2)")
 	cmd.Flags().IntVar(&memory, "memory", 0, "Memory in MB")
+	cmd.Flags().IntSliceVar(&cpuAffinity, "cpu-affinity", nil, "Host CPUs to pin the vCPUs to, e.g. 2,3: vCPU i runs on the i-th CPU, wrapping around")
+	cmd.Flags().Float64Var(&cpuLimit, "cpu-limit", 0, "Host CPU cap for the VM process in cores, e.g. 1.5 (cgroup v2)")
+	cmd.Flags().IntVar(&memoryLimit, "memory-limit", 0, "Host memory cap for the VM process in MB (cgroup v2)")
+	cmd.Flags().IntVar(&priority, "priority", 0, "Priority for 'vmm memory-guard': the lowest-priority VMs are paused first when host memory runs low")
+	cmd.Flags().IntVar(&minMemory, "min-memory", 0, "Memory in MB the VM starts with; --memory becomes the most it can be given with 'vmm memory' (balloon)")
 	cmd.Flags().IntVar(&disk, "disk", 0, "Disk size in MB")
 	cmd.Flags().StringVar(&sshKeyPath, "ssh-key", "", "Path to SSH public key file for root access")
 	cmd.Flags().StringSliceVar(&dnsServers, "dns", nil, "Custom DNS servers (can be specified multiple times)")
+	cmd.Flags().StringSliceVar(&dnsSearch, "dns-search", nil, "DNS search domain for the guest's resolv.conf (can be specified multiple times)")
+	cmd.Flags().StringSliceVar(&ntpServers, "ntp", nil, "NTP server for cloud-init to configure in the guest (can be specified multiple times; needs --cloud-init-user-data)")
+	cmd.Flags().StringVar(&preStartHook, "pre-start-hook", "", "Host shell command run before each start, with VMM_VM_NAME, VMM_VM_IP and VMM_TAP_DEVICE set; the start fails if it does")
+	cmd.Flags().StringVar(&postStopHook, "post-stop-hook", "", "Host shell command run after the VM has stopped, with VMM_VM_NAME, VMM_VM_IP and VMM_TAP_DEVICE set")
+	cmd.Flags().StringArrayVarP(&envVars, "env", "e", nil, "Environment variable NAME=VALUE for the guest's /etc/environment (can be repeated)")
 	cmd.Flags().StringVar(&imageName, "image", "", "Name of rootfs image to use (from 'vmm image import')")
-	cmd.Flags().StringVar(&kernelName, "kernel", "", "Name of kernel to use (from 'vmm kernel import')")
-	cmd.Flags().StringArrayVar(&mounts, "mount", nil, "Mount host directory in VM (format: /host/path:tag[:ro|rw])")
+	cmd.Flags().StringVar(&kernelName, "kernel", "", "Name of kernel to use (from 'vmm kernel import'), or a version constraint such as '>=6.1'")
+	cmd.Flags().StringVar(&initrdPath, "initrd", "", "Path to an initrd/initramfs to boot with the kernel")
+	cmd.Flags().StringVar(&kernelArgs, "kernel-args", "", "Extra kernel arguments, merged into the defaults with these values winning")
+	cmd.Flags().BoolVar(&replaceKernelArgs, "replace-kernel-args", false, "Use --kernel-args instead of the console, reboot, panic and pci defaults")
+	cmd.Flags().StringVar(&cloudInitUserData, "cloud-init-user-data", "", "user-data file for a cloud-init NoCloud seed attached to the VM")
+	cmd.Flags().StringVar(&cloudInitNetworkConfig, "cloud-init-network-config", "", "network-config file for the cloud-init seed (needs --cloud-init-user-data)")
+	cmd.Flags().BoolVar(&syncClock, "sync-clock", false, "Set the guest clock from the host time at boot (systemd guests)")
+	cmd.Flags().StringVar(&guestInit, "init", "", "Absolute guest path to run as PID 1 instead of the rootfs's init")
+	cmd.Flags().StringVar(&modulesImage, "modules-image", "", "Kernel modules image to mount at /lib/modules (from 'vmm kernel modules-image')")
+	cmd.Flags().StringArrayVar(&mounts, "mount", nil, "Mount host directory in VM (format: /host/path[,/overlay/path...]:tag[:ro|rw]; escape commas in paths as \\,)")
+	cmd.Flags().StringArrayVar(&tmpfsMounts, "tmpfs", nil, "Mount a guest tmpfs at /mnt/<tag>, discarded on stop (format: tag:size_mb)")
+	cmd.Flags().StringArrayVar(&archiveMounts, "mount-archive", nil, "Mount an image extracted from a tar archive on first start (format: /path/archive.tar[.gz|.xz|.zst]:tag[:size_mb][:ro|rw])")
+	cmd.Flags().IntVar(&mountInodeRatio, "mount-inode-ratio", 0, "Bytes per inode for mount images (lower values allow more files)")
+	cmd.Flags().IntVar(&mountBlockSize, "mount-block-size", 0, "Filesystem block size for mount images (1024, 2048 or 4096)")
+	cmd.Flags().IntVar(&mountReservedBlocks, "mount-reserved-blocks", 0, "Percentage of mount image blocks reserved for root (0-50)")
+	cmd.Flags().StringArrayVar(&mountMkfsOptions, "mount-mkfs-opt", nil, "Extra argument passed to mkfs.ext4 for mount images (can be repeated)")
+	cmd.Flags().StringVar(&mountSyncMode, "mount-sync-mode", "", "How mount images are refreshed on start: mirror (default) or merge to keep files written by the guest")
+	cmd.Flags().BoolVar(&mountEncrypt, "mount-encrypt", false, "Store --mount images encrypted with LUKS2 (passphrase from $"+mount.MountKeyEnv+" or --mount-key-file)")
+	cmd.Flags().BoolVar(&mountShared, "mount-shared", false, "Share one image between all VMs with the same read-only --mount content")
+	cmd.Flags().StringVar(&mountKeyFile, "mount-key-file", "", "File holding the passphrase for encrypted mount images")
+	cmd.Flags().StringArrayVar(&mountOrders, "mount-order", nil, "Attach order of a mount's drive, lowest first, fixing its /dev/vdX (format: tag=N, can be repeated)")
+	cmd.Flags().StringArrayVar(&mountDriveIDs, "mount-drive-id", nil, "Firecracker drive ID for a mount instead of mount<N> (format: tag=id, can be repeated)")
+	cmd.Flags().StringArrayVar(&mountPaths, "mount-path", nil, "Where the guest mounts a mount instead of /mnt/<tag> (format: tag=/path, can be repeated)")
+	cmd.Flags().StringArrayVar(&driveSpecs, "drive", nil, "Host block device or image file attached to the guest as is, such as a partition or LVM volume (format: path[:ro], can be repeated)")
+	cmd.Flags().StringArrayVar(&mountSubPaths, "mount-subpath", nil, "Copy only this subdirectory of a mount's host directories, as the root of its image (format: tag=relative/path, can be repeated)")
+	cmd.Flags().StringArrayVar(&mountFilesystems, "mount-fs", nil, "Filesystem for a mount's image, ext4 (default) or vfat for guests that can't read ext4 (format: tag=vfat, can be repeated)")
+	cmd.Flags().BoolVar(&guestAgent, "guest-agent", false, "Attach a vsock device for a guest agent used for clean shutdown")
+	cmd.Flags().BoolVar(&readOnlyRootfs, "read-only-rootfs", false, "Attach the rootfs read-only; pair with a writable --mount or --tmpfs for scratch data")
+	cmd.Flags().BoolVar(&verity, "verity", false, "Boot the rootfs read-only through dm-verity so the guest detects tampering (needs veritysetup and a kernel with CONFIG_DM_VERITY and CONFIG_DM_INIT)")
+	cmd.Flags().StringVar(&diskCache, "disk-cache", "", "Cache type for the rootfs and mount drives: writeback (default) or unsafe (faster, but a host crash can lose data)")
+	cmd.Flags().StringVar(&diskIOEngine, "disk-io-engine", "", "I/O engine for the rootfs and mount drives: sync (default) or async (io_uring, host kernel 5.10.51+)")
 
 	return cmd
 }
@@ -257,74 +629,93 @@ func deleteCmd() *cobra.Command {
 		Short: "Delete a microVM",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			name := args[0]
-			paths := cfg.GetPaths()
+			return deleteVM(args[0], force)
+		},
+	}
 
-			// Load VM to check state
-			existingVM, err := vm.Load(paths.VMs, name)
-			if err != nil {
-				return fmt.Errorf("VM '%s' not found", name)
-			}
+	cmd.Flags().BoolVarP(&force, "force", "f", false, "Force delete even if running")
 
-			// Update state based on actual running status
-			fcClient := firecracker.NewClient()
-			fcClient.UpdateVMState(existingVM)
+	return cmd
+}
 
-			// Check if running
-			if existingVM.State == vm.StateRunning {
-				if !force {
-					return fmt.Errorf("VM '%s' is running. Use --force to delete anyway", name)
-				}
-				// Stop VM if force
-				fmt.Printf("Stopping VM '%s'...\n", name)
-				ctx := context.Background()
-				if err := fcClient.StopVM(ctx, existingVM.SocketPath); err != nil {
-					fmt.Printf("Warning: failed to stop VM gracefully: %v\n", err)
-				}
-			}
+// deleteVM removes a VM and its rootfs, mount images and network resources
+// A running VM is only deleted (after being stopped) when force is set
+func deleteVM(name string, force bool) error {
+	paths := cfg.GetPaths()
 
-			// Cleanup network resources
-			netMgr := network.NewManager(cfg.BridgeName, cfg.Subnet, cfg.Gateway, cfg.HostInterface)
-			if existingVM.TapDevice != "" && netMgr.TapExists(existingVM.TapDevice) {
-				if err := netMgr.DeleteTap(existingVM.TapDevice); err != nil {
-					fmt.Printf("Warning: failed to delete TAP device: %v\n", err)
-				}
-			}
+	// Load VM to check state
+	existingVM, err := vm.Load(paths.VMs, name)
+	if err != nil {
+		return fmt.Errorf("VM '%s' not found", name)
+	}
 
-			// Delete VM rootfs
-			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
-			if err := imgMgr.DeleteVMRootfs(name, paths.VMs); err != nil {
-				fmt.Printf("Warning: failed to delete VM rootfs: %v\n", err)
-			}
+	// Update state based on actual running status
+	fcClient := firecracker.NewClient()
+	fcClient.PostStopHook = runPostStopHook
+	fcClient.UpdateVMState(existingVM)
+
+	// Check if running
+	if existingVM.State == vm.StateRunning {
+		if !force {
+			return fmt.Errorf("VM '%s' is running. Use --force to delete anyway", name)
+		}
+		// Stop VM if force
+		fmt.Printf("Stopping VM '%s'...\n", name)
+		ctx := context.Background()
+		if err := fcClient.StopVMWithOptions(ctx, existingVM.SocketPath, stopOptions(existingVM)); err != nil {
+			fmt.Printf("Warning: failed to stop VM gracefully: %v\n", err)
+		}
+	}
+	firecracker.RemoveCgroup(name)
+
+	// Cleanup network resources
+	netMgr := network.NewManager(cfg.BridgeName, cfg.Subnet, cfg.Gateway, cfg.HostInterface)
+	if existingVM.TapDevice != "" && netMgr.TapExists(existingVM.TapDevice) {
+		if err := netMgr.DeleteTap(existingVM.TapDevice); err != nil {
+			fmt.Printf("Warning: failed to delete TAP device: %v\n", err)
+		}
+	}
 
-			// Delete mount images
-			if len(existingVM.Mounts) > 0 {
-				mountMgr := mount.NewManager(paths.Mounts)
-				if err := mountMgr.DeleteAllMountImages(name, existingVM.Mounts); err != nil {
-					fmt.Printf("Warning: failed to delete mount images: %v\n", err)
-				}
-			}
+	// Delete VM rootfs
+	imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
+	if err := imgMgr.DeleteVMRootfs(name, paths.VMs); err != nil {
+		fmt.Printf("Warning: failed to delete VM rootfs: %v\n", err)
+	}
 
-			// Delete socket file
-			os.Remove(existingVM.SocketPath)
+	// Removed even if the VM no longer has user-data, in case it had when it last started
+	os.Remove(filepath.Join(paths.VMs, name+".seed.iso"))
 
-			// Delete VM config
-			if err := vm.Delete(paths.VMs, name); err != nil {
-				return fmt.Errorf("failed to delete VM: %w", err)
-			}
+	// Delete mount images
+	if len(existingVM.Mounts) > 0 {
+		mountMgr := newMountManager()
+		if err := mountMgr.DeleteAllMountImages(name, existingVM.Mounts); err != nil {
+			fmt.Printf("Warning: failed to delete mount images: %v\n", err)
+		}
+	}
 
-			fmt.Printf("Deleted VM '%s'\n", name)
-			return nil
-		},
+	// Delete socket file
+	os.Remove(existingVM.SocketPath)
+	if existingVM.VsockPath != "" {
+		os.Remove(existingVM.VsockPath)
 	}
+	discardSnapshot(existingVM)
 
-	cmd.Flags().BoolVarP(&force, "force", "f", false, "Force delete even if running")
+	// Delete VM config
+	if err := vm.Delete(paths.VMs, name); err != nil {
+		return fmt.Errorf("failed to delete VM: %w", err)
+	}
+	if err := newIPAM().Release(name); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	refreshBridgeDNS()
 
-	return cmd
+	fmt.Printf("Deleted VM '%s'\n", name)
+	return nil
 }
 
 func listCmd() *cobra.Command {
 	var all bool
+	var stateName string
 
 	cmd := &cobra.Command{
 		Use:     "list",
@@ -333,20 +724,25 @@ func listCmd() *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			paths := cfg.GetPaths()
 
-			vms, err := vm.List(paths.VMs)
+			filter, err := firecracker.ParseStateFilter(stateName)
 			if err != nil {
-				return fmt.Errorf("failed to list VMs: %w", err)
+				return err
 			}
 
-			if len(vms) == 0 {
-				fmt.Println("No VMs found. Create one with: vmm create <name>")
-				return nil
+			// Refresh each VM's state from its process before filtering
+			fcClient := firecracker.NewClient()
+			vms, err := fcClient.ListVMs(paths.VMs, filter, false)
+			if err != nil {
+				return err
 			}
 
-			// Update state for each VM
-			fcClient := firecracker.NewClient()
-			for _, v := range vms {
-				fcClient.UpdateVMState(v)
+			if len(vms) == 0 {
+				if filter == firecracker.FilterAll {
+					fmt.Println("No VMs found. Create one with: vmm create <name>")
+				} else {
+					fmt.Printf("No %s VMs\n", filter)
+				}
+				return nil
 			}
 
 			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
@@ -369,14 +765,17 @@ func listCmd() *cobra.Command {
 	}
 
 	cmd.Flags().BoolVarP(&all, "all", "a", true, "Show all VMs including stopped")
+	cmd.Flags().StringVar(&stateName, "state", "", "Only show VMs in this state: running, stopped or suspended")
 
 	return cmd
 }
 
-func startCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "start <name>",
-		Short: "Start a microVM",
+func statusCmd() *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "status <name>",
+		Short: "Show detailed status of a microVM",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			name := args[0]
@@ -387,702 +786,2764 @@ func startCmd() *cobra.Command {
 				return fmt.Errorf("VM '%s' not found", name)
 			}
 
-			// Update state
 			fcClient := firecracker.NewClient()
-			fcClient.UpdateVMState(existingVM)
-
-			if existingVM.State == vm.StateRunning {
-				return fmt.Errorf("VM '%s' is already running", name)
+			status, err := fcClient.Status(existingVM)
+			if err != nil {
+				return fmt.Errorf("failed to get VM status: %w", err)
 			}
 
-			fmt.Printf("Starting VM '%s'...\n", name)
-
-			// Ensure images are available
-			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
-			if err := imgMgr.EnsureDefaultImages(); err != nil {
-				return fmt.Errorf("failed to ensure images: %w", err)
+			if jsonOutput {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(status)
 			}
 
-			// Create VM-specific rootfs if needed
-			vmRootfs, err := imgMgr.CreateVMRootfs(name, paths.VMs, existingVM.DiskSizeMB, existingVM.Image)
-			if err != nil {
-				return fmt.Errorf("failed to create VM rootfs: %w", err)
+			fmt.Printf("Name:      %s\n", status.Name)
+			fmt.Printf("ID:        %s\n", status.ID)
+			fmt.Printf("State:     %s\n", status.State)
+			fmt.Printf("CPUs:      %d\n", status.CPUs)
+			fmt.Printf("Memory:    %d MB\n", status.MemoryMB)
+			if status.IPAddress != "" {
+				fmt.Printf("IP:        %s\n", status.IPAddress)
 			}
-			existingVM.RootfsPath = vmRootfs
-
-			// Set kernel path based on custom kernel or default
-			existingVM.KernelPath = imgMgr.GetKernelPath(existingVM.Kernel)
-
-			// Inject SSH key if configured
-			if existingVM.SSHPublicKey != "" {
-				fmt.Println("Injecting SSH public key...")
-				if err := image.InjectSSHKey(existingVM.RootfsPath, existingVM.SSHPublicKey); err != nil {
-					return fmt.Errorf("failed to inject SSH key: %w", err)
+			if status.State == vm.StateRunning {
+				fmt.Printf("PID:       %d\n", status.PID)
+				if status.ProcessAlive {
+					fmt.Printf("Uptime:    %s\n", time.Duration(status.UptimeSeconds)*time.Second)
+					fmt.Printf("CPU time:  %.1fs\n", status.Resources.CPUSeconds)
+					fmt.Printf("RSS:       %.1f MB\n", float64(status.Resources.RSSBytes)/(1024*1024))
+				}
+				if status.KernelArgs != "" {
+					fmt.Printf("Kernel:    %s\n", status.KernelArgs)
+				}
+				if status.Instance != nil {
+					fmt.Printf("VMM:       Firecracker %s, %s\n", status.Instance.VMMVersion, status.Instance.State)
+				} else {
+					fmt.Printf("VMM:       not responding on %s\n", status.SocketPath)
 				}
 			}
-
-			// Inject DNS configuration
-			fmt.Println("Configuring DNS...")
-			if err := image.InjectDNSConfig(existingVM.RootfsPath, existingVM.DNSServers); err != nil {
-				return fmt.Errorf("failed to inject DNS config: %w", err)
+			if len(status.Mounts) > 0 {
+				fmt.Println("Mounts:")
+				for _, m := range status.Mounts {
+					mode := "rw"
+					if m.ReadOnly {
+						mode = "ro"
+					}
+					source := strings.Join(m.HostPaths, " + ")
+					switch m.Mode {
+					case vm.MountModeTmpfs:
+						source = "tmpfs"
+					case vm.MountModeArchive:
+						source = "archive " + m.ArchivePath
+					}
+					line := fmt.Sprintf("  %s: %s -> %s (%s)", m.GuestTag, source, m.GuestPath, mode)
+					if m.DriveID != "" {
+						line += fmt.Sprintf(" [drive %s]", m.DriveID)
+					}
+					if m.ImagePath != "" {
+						line += fmt.Sprintf(", image %.1f MB used", float64(m.ImageBytes)/(1024*1024))
+					}
+					fmt.Println(line)
+				}
 			}
+			return nil
+		},
+	}
 
-			// Create mount images and configure fstab
-			var mountDrives []firecracker.MountDrive
-			if len(existingVM.Mounts) > 0 {
-				fmt.Println("Creating mount images...")
-				mountMgr := mount.NewManager(paths.Mounts)
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output status as JSON")
 
-				// Create mount images and collect drive configs
-				var mountEntries []image.MountEntry
-				for i := range existingVM.Mounts {
-					m := &existingVM.Mounts[i]
-					if err := mountMgr.CreateMountImage(m, name); err != nil {
-						return fmt.Errorf("failed to create mount image for '%s': %w", m.GuestTag, err)
-					}
+	return cmd
+}
 
-					// Device names: vdb, vdc, vdd, etc. (vda is rootfs)
-					deviceLetter := string(rune('b' + i))
-					device := fmt.Sprintf("/dev/vd%s", deviceLetter)
-					mountPath := fmt.Sprintf("/mnt/%s", m.GuestTag)
+func startCmd() *cobra.Command {
+	var opts startOptions
 
-					mountEntries = append(mountEntries, image.MountEntry{
-						Device:    device,
-						MountPath: mountPath,
-						ReadOnly:  m.ReadOnly,
-					})
+	cmd := &cobra.Command{
+		Use:   "start <name>",
+		Short: "Start a microVM",
+		Long: `Start a microVM.
 
-					mountDrives = append(mountDrives, firecracker.MountDrive{
-						ImagePath: m.ImagePath,
-						Tag:       m.GuestTag,
-						ReadOnly:  m.ReadOnly,
-					})
-				}
+A VM saved with 'vmm suspend' is resumed from its saved memory instead
+of being booted; use --discard-snapshot to boot it from scratch instead.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.VerifyMounts = opts.VerifyMounts || cfg.VerifyMounts
+			return startVM(args[0], opts)
+		},
+	}
 
-				// Inject fstab entries for mounts
-				fmt.Println("Configuring mount points in guest...")
-				if err := image.InjectMountFstab(existingVM.RootfsPath, mountEntries); err != nil {
-					return fmt.Errorf("failed to inject mount fstab: %w", err)
-				}
+	cmd.Flags().BoolVar(&opts.VerifyMounts, "verify-mounts", false, "Compare each mount image with its host directories after it is created or synced")
+	cmd.Flags().BoolVar(&opts.DiscardSnapshot, "discard-snapshot", false, "Boot a suspended VM from scratch, deleting its saved memory")
+	cmd.Flags().BoolVar(&opts.Foreground, "foreground", false, "Stay attached, printing Firecracker's output, until the VM exits; Ctrl-C stops it")
 
-				// Save updated mount image paths
-				existingVM.Save(paths.VMs)
-			}
+	return cmd
+}
 
-			// Setup networking
-			netMgr := network.NewManager(cfg.BridgeName, cfg.Subnet, cfg.Gateway, cfg.HostInterface)
+// startOptions controls how startVM brings a VM up
+type startOptions struct {
+	VerifyMounts    bool // Check every mount image against its host directories before boot
+	DiscardSnapshot bool // Boot a suspended VM fresh instead of resuming it
+	Foreground      bool // Pipe Firecracker's output to the terminal and wait for the VM to exit
+}
 
-			// Ensure bridge exists
-			if err := netMgr.EnsureBridge(); err != nil {
-				return fmt.Errorf("failed to setup bridge: %w", err)
-			}
+// startVM prepares a VM's rootfs, mounts and networking and boots it with Firecracker
+// A suspended VM is resumed from its saved memory, leaving its disks untouched
+func startVM(name string, opts startOptions) error {
+	paths := cfg.GetPaths()
 
-			// Create TAP device if it doesn't exist
-			if !netMgr.TapExists(existingVM.TapDevice) {
-				if err := netMgr.CreateTap(existingVM.TapDevice); err != nil {
-					return fmt.Errorf("failed to create TAP device: %w", err)
-				}
-			}
+	existingVM, err := vm.Load(paths.VMs, name)
+	if err != nil {
+		return fmt.Errorf("VM '%s' not found", name)
+	}
 
-			// Allocate IP (use VM index based on creation order for simplicity)
-			vms, _ := vm.List(paths.VMs)
-			vmIndex := 0
-			for i, v := range vms {
-				if v.Name == name {
-					vmIndex = i
-					break
-				}
-			}
-			ip, err := netMgr.AllocateIP(vmIndex)
-			if err != nil {
-				return fmt.Errorf("failed to allocate IP: %w", err)
-			}
-			existingVM.IPAddress = ip
+	// Update state
+	fcClient := firecracker.NewClient()
+	fcClient.UpdateVMState(existingVM)
 
-			// Update state to starting
-			existingVM.State = vm.StateStarting
-			existingVM.Save(paths.VMs)
+	if existingVM.State == vm.StateRunning {
+		return fmt.Errorf("VM '%s' is already running", name)
+	}
+	if err := checkHostDrives(existingVM, true); err != nil {
+		return fmt.Errorf("cannot start VM '%s': %w", name, err)
+	}
 
-			// Start Firecracker
-			ctx := context.Background()
-			vmCfg := &firecracker.VMConfig{
-				SocketPath:  existingVM.SocketPath,
-				KernelPath:  existingVM.KernelPath,
-				RootfsPath:  existingVM.RootfsPath,
-				CPUs:        existingVM.CPUs,
-				MemoryMB:    existingVM.MemoryMB,
-				TapDevice:   existingVM.TapDevice,
-				MacAddress:  existingVM.MacAddress,
-				LogPath:     fmt.Sprintf("%s/%s.log", paths.Logs, name),
-				IPAddress:   existingVM.IPAddress,
-				Gateway:     cfg.Gateway,
-				MountDrives: mountDrives,
-			}
-
-			machine, err := fcClient.StartVM(ctx, vmCfg)
-			if err != nil {
-				existingVM.State = vm.StateError
-				existingVM.Save(paths.VMs)
-				return fmt.Errorf("failed to start VM: %w", err)
-			}
+	// Report missing privileges and tools up front rather than from deep inside the start
+	if errs := fcClient.CheckPrerequisites(); len(errs) > 0 {
+		return fmt.Errorf("cannot start VM '%s' (run 'vmm check' for details):\n%w", name, errors.Join(errs...))
+	}
 
-			// Update VM state
-			existingVM.State = vm.StateRunning
-			existingVM.PID = fcClient.GetVMPID(machine)
-			existingVM.StartedAt = time.Now()
-			existingVM.Save(paths.VMs)
+	fmt.Printf("Starting VM '%s'...\n", name)
 
-			fmt.Printf("VM '%s' started successfully\n", name)
-			fmt.Printf("  IP Address: %s\n", existingVM.IPAddress)
-			fmt.Printf("  PID: %d\n", existingVM.PID)
-			fmt.Printf("  Socket: %s\n", existingVM.SocketPath)
+	// A suspended VM's disks must stay exactly as the guest left them
+	resuming := existingVM.MemSnapshot != ""
+	if resuming && opts.DiscardSnapshot {
+		fmt.Println("Discarding saved memory...")
+		discardSnapshot(existingVM)
+		existingVM.Save(paths.VMs)
+		resuming = false
+	}
+	if resuming && len(existingVM.DiskSnapshots) > 0 {
+		fmt.Println("Restoring disks saved with the snapshot...")
+		if err := firecracker.RestoreDisks(existingVM); err != nil {
+			return fmt.Errorf("cannot resume VM '%s': %w", name, err)
+		}
+	}
 
-			return nil
-		},
+	if err := preflightMountImages(existingVM, resuming); err != nil {
+		return fmt.Errorf("cannot start VM '%s':\n%w", name, err)
 	}
-}
 
-func stopCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "stop <name>",
-		Short: "Stop a microVM",
-		Args:  cobra.ExactArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			name := args[0]
-			paths := cfg.GetPaths()
+	// Encrypted mounts opened below are closed again unless the VM comes up
+	started := false
+	defer func() {
+		if !started {
+			closeMountDevices(existingVM)
+		}
+	}()
+
+	var mountDrives []firecracker.MountDrive
+	if resuming {
+		fmt.Println("Resuming from saved memory...")
+		// The saved device state refers to the mapped devices of encrypted mounts
+		if err := openMountDevices(existingVM); err != nil {
+			return err
+		}
+	} else {
+		mountDrives, _, err = prepareVMDisks(existingVM, opts.VerifyMounts, nil)
+		if err != nil {
+			return err
+		}
+	}
 
-			existingVM, err := vm.Load(paths.VMs, name)
-			if err != nil {
-				return fmt.Errorf("VM '%s' not found", name)
-			}
+	// Setup networking
+	netMgr := network.NewManager(cfg.BridgeName, cfg.Subnet, cfg.Gateway, cfg.HostInterface)
 
-			// Update state
-			fcClient := firecracker.NewClient()
-			fcClient.UpdateVMState(existingVM)
+	// Ensure bridge exists
+	if err := netMgr.EnsureBridge(); err != nil {
+		return fmt.Errorf("failed to setup bridge: %w", err)
+	}
 
-			if existingVM.State != vm.StateRunning {
-				return fmt.Errorf("VM '%s' is not running (state: %s)", name, existingVM.State)
-			}
+	// Create TAP device if it doesn't exist
+	if !netMgr.TapExists(existingVM.TapDevice) {
+		if err := netMgr.CreateTap(existingVM.TapDevice); err != nil {
+			return fmt.Errorf("failed to create TAP device: %w", err)
+		}
+	}
 
-			fmt.Printf("Stopping VM '%s'...\n", name)
-
-			existingVM.State = vm.StateStopping
+	if err := allocateVMIP(existingVM); err != nil {
+		return err
+	}
+	refreshBridgeDNS()
+
+	// Update state to starting
+	existingVM.State = vm.StateStarting
+	existingVM.Save(paths.VMs)
+
+	// Start Firecracker
+	ctx := context.Background()
+	existingVM.LogPath = fmt.Sprintf("%s/%s.log", paths.Logs, name)
+	vmCfg := &firecracker.VMConfig{
+		SocketPath:   existingVM.SocketPath,
+		KernelPath:   existingVM.KernelPath,
+		InitrdPath:   existingVM.InitrdPath,
+		RootfsPath:   existingVM.RootfsPath,
+		CPUs:         existingVM.CPUs,
+		MemoryMB:     existingVM.MemoryMB,
+		TapDevice:    existingVM.TapDevice,
+		MacAddress:   existingVM.MacAddress,
+		LogPath:      existingVM.LogPath,
+		LogRotation:  firecracker.NewLogRotation(cfg.LogMaxSizeMB, cfg.LogRetention),
+		IPAddress:    existingVM.IPAddress,
+		Gateway:      cfg.Gateway,
+		DNSServers:   vmDNSServers(existingVM),
+		MountDrives:  mountDrives,
+		VsockPath:    existingVM.VsockPath,
+		Name:         name,
+		CgroupLimits: firecracker.NewCgroupLimits(existingVM.CPULimit, existingVM.MemoryLimitMB),
+
+		ModulesImagePath: existingVM.ModulesImage,
+		ExtraDrives:      hostDrives(existingVM),
+		Init:             existingVM.Init,
+		KernelArgs:       existingVM.ExtraKernelArgs,
+		RootfsReadOnly:   existingVM.RootReadOnly,
+		RootfsCacheType:  existingVM.DiskCache,
+		RootfsIOEngine:   existingVM.DiskIOEngine,
+		WritableScratch:  hasTmpfsMount(existingVM),
+		Foreground:       opts.Foreground,
+
+		ReplaceKernelArgs: existingVM.ReplaceKernelArgs,
+	}
+	setMemoryRange(vmCfg, existingVM)
+	vmCfg.CPUAffinity = existingVM.CPUAffinity
+	vmCfg.SeedISOPath = seedISOPath(existingVM)
+	// A resumed VM keeps the hash tree it booted with; it isn't rebuilt while the guest uses it
+	if existingVM.Verity && !resuming {
+		fmt.Println("Building dm-verity hash tree for rootfs...")
+		hashTree, rootHash, err := image.GenerateVerity(existingVM.RootfsPath)
+		if err != nil {
+			existingVM.State = vm.StateError
 			existingVM.Save(paths.VMs)
+			return fmt.Errorf("failed to build verity hash tree: %w", err)
+		}
+		vmCfg.VerityHashTreePath = hashTree
+		vmCfg.VerityRootHash = rootHash
+	}
+	if resuming {
+		vmCfg.MemBackendType = firecracker.MemBackendFile
+		vmCfg.MemBackendPath = existingVM.MemSnapshot
+		vmCfg.SnapshotPath = existingVM.StateSnapshot
+	}
 
-			ctx := context.Background()
-			if err := fcClient.StopVM(ctx, existingVM.SocketPath); err != nil {
-				// Try to kill by PID as fallback
-				if existingVM.PID > 0 {
-					if proc, err := os.FindProcess(existingVM.PID); err == nil {
-						proc.Signal(syscall.SIGKILL)
-					}
-				}
-			}
+	fcClient.PreStartHook = preStartHook(existingVM.PreStartHook)
+	result, err := fcClient.StartVM(ctx, vmCfg)
+	if err != nil {
+		existingVM.State = vm.StateError
+		existingVM.Save(paths.VMs)
+		if resuming {
+			return fmt.Errorf("failed to resume VM (use --discard-snapshot to boot it from scratch): %w", err)
+		}
+		return fmt.Errorf("failed to start VM: %w", err)
+	}
 
-			// Wait briefly for process to exit
-			time.Sleep(500 * time.Millisecond)
+	// Update VM state
+	started = true
+	existingVM.State = vm.StateRunning
+	existingVM.PID = fcClient.GetVMPID(result.Machine)
+	if !resuming {
+		// A resumed VM keeps the drives it was suspended with
+		existingVM.MountDriveIDs = result.MountDriveIDs
+		existingVM.KernelArgs = result.KernelArgs
+	}
+	existingVM.StartedAt = time.Now()
+	existingVM.Save(paths.VMs)
 
-			// Clean up TAP device so it can be reused on next start
-			netMgr := network.NewManager(cfg.BridgeName, cfg.Subnet, cfg.Gateway, cfg.HostInterface)
-			if existingVM.TapDevice != "" && netMgr.TapExists(existingVM.TapDevice) {
-				if err := netMgr.DeleteTap(existingVM.TapDevice); err != nil {
-					fmt.Printf("Warning: failed to delete TAP device: %v\n", err)
-				}
-			}
+	fmt.Printf("VM '%s' started successfully\n", name)
+	fmt.Printf("  IP Address: %s\n", existingVM.IPAddress)
+	fmt.Printf("  PID: %d\n", existingVM.PID)
+	fmt.Printf("  Socket: %s\n", existingVM.SocketPath)
 
-			// Cleanup
-			existingVM.State = vm.StateStopped
-			existingVM.PID = 0
-			existingVM.Save(paths.VMs)
+	if opts.Foreground {
+		return waitForeground(existingVM, result)
+	}
+	return nil
+}
 
-			// Remove socket
-			os.Remove(existingVM.SocketPath)
+// waitForeground waits for a VM started with --foreground to exit, stopping it on Ctrl-C,
+// and releases its host resources once Firecracker is gone
+func waitForeground(existingVM *vm.VM, result *firecracker.StartResult) error {
+	fmt.Println("Firecracker output follows; press Ctrl-C to stop the VM")
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	err := result.Machine.Wait(ctx)
+	if ctx.Err() != nil {
+		// stopVM shuts the guest down and cleans up after it
+		cancel()
+		return stopVM(existingVM.Name)
+	}
 
-			fmt.Printf("VM '%s' stopped\n", name)
-			return nil
-		},
+	releaseVMResources(existingVM)
+	if err != nil {
+		return fmt.Errorf("VM '%s' exited: %w", existingVM.Name, err)
 	}
+	fmt.Printf("VM '%s' exited\n", existingVM.Name)
+	return nil
 }
 
-func sshCmd() *cobra.Command {
-	var user string
+// prepareVMDisks makes a VM's rootfs and mount images ready for boot and writes its guest configuration into the rootfs
+// It returns the mount drives and the modules image to attach. Given a warn function, failures the VM can boot
+// without (a mount that can't be prepared, a modules image for another kernel) are passed to it and skipped
+func prepareVMDisks(existingVM *vm.VM, verifyMounts bool, warn func(error)) ([]firecracker.MountDrive, string, error) {
+	paths := cfg.GetPaths()
+	recoverable := func(err error) error {
+		if warn == nil {
+			return err
+		}
+		warn(err)
+		return nil
+	}
 
-	cmd := &cobra.Command{
-		Use:   "ssh <name> [-- <ssh-args>]",
-		Short: "SSH into a microVM",
-		Args:  cobra.MinimumNArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			name := args[0]
-			paths := cfg.GetPaths()
+	// Ensure images are available
+	imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
+	imgMgr.CheckQuota = cfg.CheckDiskQuota
+	if err := imgMgr.EnsureDefaultImages(); err != nil {
+		return nil, "", fmt.Errorf("failed to ensure images: %w", err)
+	}
 
-			existingVM, err := vm.Load(paths.VMs, name)
-			if err != nil {
-				return fmt.Errorf("VM '%s' not found", name)
-			}
+	// Create VM-specific rootfs if needed
+	vmRootfs, err := imgMgr.CreateVMRootfs(existingVM.Name, paths.VMs, existingVM.DiskSizeMB, existingVM.Image)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create VM rootfs: %w", err)
+	}
+	existingVM.RootfsPath = vmRootfs
 
-			// Update state
-			fcClient := firecracker.NewClient()
-			fcClient.UpdateVMState(existingVM)
+	// Set kernel path based on custom kernel or default
+	existingVM.KernelPath = imgMgr.GetKernelPath(existingVM.Kernel)
 
-			if existingVM.State != vm.StateRunning {
-				return fmt.Errorf("VM '%s' is not running", name)
+	// Inject SSH key if configured
+	if existingVM.SSHPublicKey != "" {
+		fmt.Println("Injecting SSH public key...")
+		if err := image.InjectSSHKey(existingVM.RootfsPath, existingVM.SSHPublicKey); err != nil {
+			if err := recoverable(fmt.Errorf("failed to inject SSH key: %w", err)); err != nil {
+				return nil, "", err
 			}
+		}
+	}
 
-			if existingVM.IPAddress == "" {
-				return fmt.Errorf("VM '%s' has no IP address assigned", name)
+	// Inject DNS configuration
+	fmt.Println("Configuring DNS...")
+	if err := image.InjectDNSConfig(existingVM.RootfsPath, vmDNSServers(existingVM), existingVM.DNSSearch); err != nil {
+		if err := recoverable(fmt.Errorf("failed to inject DNS config: %w", err)); err != nil {
+			return nil, "", err
+		}
+	}
+
+	// Always rewritten, so variables removed from the VM are removed from the guest too
+	if err := image.InjectEnvironment(existingVM.RootfsPath, existingVM.EnvVars); err != nil {
+		if err := recoverable(fmt.Errorf("failed to inject environment: %w", err)); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if existingVM.CloudInitUserData != "" {
+		fmt.Println("Building cloud-init seed...")
+		if err := buildSeedISO(existingVM); err != nil {
+			return nil, "", err
+		}
+	}
+
+	modulesImage := existingVM.ModulesImage
+	if modulesImage != "" {
+		if err := image.ValidateModulesImage(modulesImage, existingVM.KernelPath); err != nil {
+			if err := recoverable(fmt.Errorf("not attaching modules image: %w", err)); err != nil {
+				return nil, "", err
 			}
+			modulesImage = ""
+		}
+	}
 
-			// Build SSH command
-			sshArgs := []string{
-				"-o", "StrictHostKeyChecking=no",
-				"-o", "UserKnownHostsFile=/dev/null",
+	// Create mount images and configure fstab
+	var mountDrives []firecracker.MountDrive
+	if len(existingVM.Mounts) > 0 || modulesImage != "" {
+		fmt.Println("Preparing mount images...")
+		mountMgr := newMountManager()
+		mountMgr.VerifyCopies = verifyMounts
+
+		// Create mount images and collect drive configs
+		var mountEntries []image.MountEntry
+		driveEntries := make(map[string]int) // mount tag -> index of its entry in mountEntries
+		for i := range existingVM.Mounts {
+			m := &existingVM.Mounts[i]
+			mountPath := m.MountPath()
+
+			// tmpfs mounts live only in the guest and don't use a drive
+			if m.IsTmpfs() {
+				mountEntries = append(mountEntries, image.MountEntry{
+					MountPath: mountPath,
+					Tmpfs:     true,
+					SizeMB:    m.TmpfsSizeMB,
+				})
+				continue
 			}
 
-			// If running under sudo, use the original user's SSH keys
-			if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" {
-				// Get the original user's home directory
-				userHome := fmt.Sprintf("/home/%s", sudoUser)
-				if sudoUser == "root" {
-					userHome = "/root"
+			if err := mountMgr.PrepareMountImage(m, existingVM.Name); err != nil {
+				if err := recoverable(fmt.Errorf("failed to prepare mount image for '%s': %w", m.GuestTag, err)); err != nil {
+					return nil, "", err
 				}
-
-				// Look for common SSH key files
-				keyFiles := []string{
-					"id_ed25519",
-					"id_rsa",
-					"id_ecdsa",
-					"id_dsa",
+				continue
+			}
+			drivePath, err := mountMgr.OpenMountDevice(m)
+			if err != nil {
+				if err := recoverable(fmt.Errorf("failed to open mount image for '%s': %w", m.GuestTag, err)); err != nil {
+					return nil, "", err
 				}
+				continue
+			}
 
-				for _, keyFile := range keyFiles {
-					keyPath := fmt.Sprintf("%s/.ssh/%s", userHome, keyFile)
-					if _, err := os.Stat(keyPath); err == nil {
-						sshArgs = append(sshArgs, "-i", keyPath)
-						break
-					}
-				}
+			// The device is filled in below, once the drives' attach order is known
+			driveEntries[m.GuestTag] = len(mountEntries)
+			mountEntries = append(mountEntries, image.MountEntry{
+				MountPath: mountPath,
+				ReadOnly:  m.ReadOnly,
+				FSType:    m.FSType(),
+			})
+
+			mountDrives = append(mountDrives, firecracker.MountDrive{
+				ImagePath:  drivePath,
+				Tag:        m.GuestTag,
+				ReadOnly:   m.ReadOnly,
+				CacheType:  existingVM.DiskCache,
+				IOEngine:   existingVM.DiskIOEngine,
+				DriveOrder: m.DriveOrder,
+				DriveID:    m.DriveID,
+			})
+		}
+		setMountDevices(mountEntries, driveEntries, mountDrives)
+		if modulesImage != "" {
+			mountEntries = append(mountEntries, modulesMountEntry(len(mountDrives)))
+		}
+
+		// Inject fstab entries for mounts
+		fmt.Println("Configuring mount points in guest...")
+		if err := image.InjectMountFstab(existingVM.RootfsPath, mountEntries); err != nil {
+			if err := recoverable(fmt.Errorf("failed to inject mount fstab: %w", err)); err != nil {
+				return nil, "", err
 			}
+		}
 
-			sshArgs = append(sshArgs, fmt.Sprintf("%s@%s", user, existingVM.IPAddress))
+		// Save updated mount image paths
+		existingVM.Save(paths.VMs)
+	}
 
-			// Append any additional SSH args
-			if len(args) > 1 {
-				sshArgs = append(sshArgs, args[1:]...)
+	// Last, so the host time is as close to boot as possible
+	if existingVM.SyncClock {
+		if err := image.InjectClockSync(existingVM.RootfsPath); err != nil {
+			if err := recoverable(fmt.Errorf("failed to inject host time: %w", err)); err != nil {
+				return nil, "", err
 			}
+		}
+	}
 
-			// Execute SSH
-			sshCmd := exec.Command("ssh", sshArgs...)
-			sshCmd.Stdin = os.Stdin
-			sshCmd.Stdout = os.Stdout
-			sshCmd.Stderr = os.Stderr
+	return mountDrives, modulesImage, nil
+}
 
-			return sshCmd.Run()
-		},
+// setMountDevices sets the guest device of each mount drive's fstab entry from the drives' attach order:
+// /dev/vdb, /dev/vdc, etc. (vda is the rootfs). driveEntries maps a mount tag to the index of its entry
+func setMountDevices(entries []image.MountEntry, driveEntries map[string]int, drives []firecracker.MountDrive) {
+	for i, drive := range firecracker.OrderMountDrives(drives) {
+		entries[driveEntries[drive.Tag]].Device = firecracker.GuestDeviceName(i + 1)
 	}
+}
 
-	cmd.Flags().StringVarP(&user, "user", "u", "root", "SSH user")
+// applyMountDriveFlags sets the drive orders and IDs given as tag=value to the mounts with those tags
+func applyMountDriveFlags(mounts []vm.Mount, orders, ids []string) error {
+	byTag := make(map[string]*vm.Mount, len(mounts))
+	for i := range mounts {
+		byTag[mounts[i].GuestTag] = &mounts[i]
+	}
+	lookup := func(flag, spec string) (*vm.Mount, string, error) {
+		tag, value, ok := strings.Cut(spec, "=")
+		if !ok || value == "" {
+			return nil, "", fmt.Errorf("invalid --%s '%s': expected tag=value", flag, spec)
+		}
+		m, ok := byTag[tag]
+		if !ok {
+			return nil, "", fmt.Errorf("--%s: no mount with tag '%s'", flag, tag)
+		}
+		if m.IsTmpfs() {
+			return nil, "", fmt.Errorf("--%s: tmpfs mount '%s' has no drive", flag, tag)
+		}
+		return m, value, nil
+	}
+	for _, spec := range orders {
+		m, value, err := lookup("mount-order", spec)
+		if err != nil {
+			return err
+		}
+		order, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid --mount-order '%s': order must be an integer", spec)
+		}
+		m.DriveOrder = order
+	}
+	for _, spec := range ids {
+		m, value, err := lookup("mount-drive-id", spec)
+		if err != nil {
+			return err
+		}
+		m.DriveID = value
+	}
+	return nil
+}
 
-	return cmd
+// applyMountPaths sets the guest paths given as tag=path to the mounts with those tags
+func applyMountPaths(mounts []vm.Mount, specs []string) error {
+	for _, spec := range specs {
+		tag, guestPath, ok := strings.Cut(spec, "=")
+		if !ok || guestPath == "" {
+			return fmt.Errorf("invalid --mount-path '%s': expected tag=path", spec)
+		}
+		found := false
+		for i := range mounts {
+			if mounts[i].GuestTag == tag {
+				mounts[i].GuestPath = guestPath
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("--mount-path: no mount with tag '%s'", tag)
+		}
+	}
+	return nil
 }
 
-func configCmd() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "config",
-		Short: "Manage VMM configuration",
+// digestSuffix formats an image digest for the end of a listing line, or "" when there is none
+func digestSuffix(digest string) string {
+	if digest == "" {
+		return ""
 	}
+	return "  sha256:" + digest
+}
 
-	showCmd := &cobra.Command{
-		Use:   "show",
-		Short: "Show current configuration",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			fmt.Printf("Data directory:    %s\n", cfg.DataDir)
-			fmt.Printf("Bridge name:       %s\n", cfg.BridgeName)
-			fmt.Printf("Subnet:            %s\n", cfg.Subnet)
-			fmt.Printf("Gateway:           %s\n", cfg.Gateway)
-			fmt.Printf("Host interface:    %s\n", cfg.HostInterface)
-			fmt.Printf("Config file:       %s\n", config.ConfigPath())
+// parseDriveSpec parses a --drive path[:ro|rw] into a drive, checking the path is an image file
+// or block device. A writable block device gets a warning, as nothing stops the host using it too
+func parseDriveSpec(spec string) (vm.Drive, error) {
+	path, mode := spec, ""
+	if i := strings.LastIndex(spec, ":"); i >= 0 && (spec[i+1:] == "ro" || spec[i+1:] == "rw") {
+		path, mode = spec[:i], spec[i+1:]
+	}
+	if !filepath.IsAbs(path) {
+		return vm.Drive{}, fmt.Errorf("invalid --drive '%s': path must be absolute", spec)
+	}
+	block, err := firecracker.CheckDrivePath(path)
+	if err != nil {
+		return vm.Drive{}, fmt.Errorf("invalid --drive '%s': %w", spec, err)
+	}
+	drive := vm.Drive{Path: path, ReadOnly: mode == "ro"}
+	if block && !drive.ReadOnly {
+		fmt.Printf("Warning: %s is given to the VM read-write; it must not be mounted or written on the host, or by any other VM, while the VM runs, or its filesystem will be corrupted\n", path)
+	}
+	return drive, nil
+}
 
-			// Display VM defaults
-			fmt.Printf("\nVM Defaults:\n")
-			defaults := cfg.GetVMDefaults()
+// hostDrives returns a VM's host drives for VMConfig.ExtraDrives
+func hostDrives(v *vm.VM) []firecracker.DriveSpec {
+	var specs []firecracker.DriveSpec
+	for _, d := range v.Drives {
+		specs = append(specs, firecracker.DriveSpec{Path: d.Path, ReadOnly: d.ReadOnly, ID: d.ID})
+	}
+	return specs
+}
 
-			// CPUs
-			if defaults.CPUs > 0 {
-				fmt.Printf("  cpus:            %d (from config)\n", defaults.CPUs)
-			} else {
-				fmt.Printf("  cpus:            1 (default)\n")
-			}
+// checkHostDrives refuses a VM's host drives that another VM also writes or reads while this one
+// writes: at create against every VM, at start against the running ones
+func checkHostDrives(v *vm.VM, runningOnly bool) error {
+	if len(v.Drives) == 0 {
+		return nil
+	}
+	vms, err := vm.List(cfg.GetPaths().VMs)
+	if err != nil {
+		return fmt.Errorf("failed to list VMs: %w", err)
+	}
+	var others []*vm.VM
+	for _, other := range vms {
+		if !runningOnly || other.State == vm.StateRunning {
+			others = append(others, other)
+		}
+	}
+	return firecracker.CheckDriveConflicts(v, others)
+}
 
-			// Memory
-			if defaults.MemoryMB > 0 {
-				fmt.Printf("  memory_mb:       %d (from config)\n", defaults.MemoryMB)
-			} else {
-				fmt.Printf("  memory_mb:       512 (default)\n")
+// applyMountSubPaths sets the subpaths given as tag=path to the mounts with those tags, checking
+// each stays within its mount's host directories
+func applyMountSubPaths(mounts []vm.Mount, specs []string) error {
+	for _, spec := range specs {
+		tag, subPath, ok := strings.Cut(spec, "=")
+		if !ok || subPath == "" {
+			return fmt.Errorf("invalid --mount-subpath '%s': expected tag=path", spec)
+		}
+		var m *vm.Mount
+		for i := range mounts {
+			if mounts[i].GuestTag == tag {
+				m = &mounts[i]
 			}
+		}
+		if m == nil {
+			return fmt.Errorf("--mount-subpath: no mount with tag '%s'", tag)
+		}
+		if m.IsTmpfs() || m.IsArchive() {
+			return fmt.Errorf("--mount-subpath: mount '%s' has no host directory", tag)
+		}
+		m.SubPath = subPath
+		if err := mount.ValidateSubPath(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			// Disk
-			if defaults.DiskSizeMB > 0 {
-				fmt.Printf("  disk_size_mb:    %d (from config)\n", defaults.DiskSizeMB)
-			} else {
-				fmt.Printf("  disk_size_mb:    1024 (default)\n")
+// applyMountFilesystems sets the filesystems given as tag=fs to the mounts with those tags and
+// checks each changed mount's options still suit its filesystem
+func applyMountFilesystems(mounts []vm.Mount, specs []string) error {
+	for _, spec := range specs {
+		tag, fs, ok := strings.Cut(spec, "=")
+		if !ok || fs == "" {
+			return fmt.Errorf("invalid --mount-fs '%s': expected tag=filesystem", spec)
+		}
+		var m *vm.Mount
+		for i := range mounts {
+			if mounts[i].GuestTag == tag {
+				m = &mounts[i]
 			}
+		}
+		if m == nil {
+			return fmt.Errorf("--mount-fs: no mount with tag '%s'", tag)
+		}
+		if m.IsTmpfs() {
+			return fmt.Errorf("--mount-fs: tmpfs mount '%s' has no image", tag)
+		}
+		m.Filesystem = fs
+		if err := mount.ValidateMkfsOptions(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			// Image
-			if defaults.Image != "" {
-				fmt.Printf("  image:           %s (from config)\n", defaults.Image)
-			} else {
-				fmt.Printf("  image:           (default rootfs)\n")
-			}
+// validateMountPaths checks the mounts' guest paths, and that none takes the modules image's place
+func validateMountPaths(mounts []vm.Mount, hasModules bool) error {
+	if err := mount.ValidateGuestPaths(mounts); err != nil {
+		return err
+	}
+	if !hasModules {
+		return nil
+	}
+	for _, m := range mounts {
+		if m.MountPath() == image.ModulesMountPath {
+			return fmt.Errorf("mount '%s' is at %s, where the modules image is mounted", m.GuestTag, image.ModulesMountPath)
+		}
+	}
+	return nil
+}
 
-			// Kernel
-			if defaults.Kernel != "" {
-				fmt.Printf("  kernel:          %s (from config)\n", defaults.Kernel)
-			} else {
-				fmt.Printf("  kernel:          (default kernel)\n")
-			}
+// modulesMountEntry returns the fstab entry for a modules image, which StartVM attaches after the given number of mount drives
+func modulesMountEntry(mountDrives int) image.MountEntry {
+	return image.MountEntry{
+		Device:    firecracker.GuestDeviceName(mountDrives + 1),
+		MountPath: image.ModulesMountPath,
+		ReadOnly:  true,
+	}
+}
 
-			// SSH key path
-			if defaults.SSHKeyPath != "" {
-				fmt.Printf("  ssh_key_path:    %s (from config)\n", defaults.SSHKeyPath)
-			} else {
-				fmt.Printf("  ssh_key_path:    (none)\n")
-			}
+func suspendCmd() *cobra.Command {
+	var withDisks bool
 
-			// DNS servers
-			if len(defaults.DNSServers) > 0 {
-				fmt.Printf("  dns_servers:     %v (from config)\n", defaults.DNSServers)
-			} else {
-				fmt.Printf("  dns_servers:     [8.8.8.8, 8.8.4.4, 1.1.1.1] (default)\n")
-			}
+	cmd := &cobra.Command{
+		Use:   "suspend <name>",
+		Short: "Save a running microVM's memory to disk and stop it",
+		Long: `Pause a running VM, save its guest memory and device state to disk, and
+stop its Firecracker process. The next 'vmm start' resumes the VM where
+it left off instead of booting it.
 
-			return nil
+The memory file is as large as the VM's memory. Its disks and mount
+images are not modified while the VM is suspended or when it resumes.
+
+With --with-disks the rootfs and mount images are copied too, and the
+copies are put back when the VM resumes, so it sees its disks exactly as
+they were even if the images were changed on the host meanwhile. The
+copies are reflinked where the filesystem supports it (btrfs, XFS);
+elsewhere they take as much disk as the images hold.
+
+Example:
+  vmm suspend myvm
+  vmm suspend --with-disks myvm
+  vmm start myvm`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return suspendVM(args[0], withDisks)
 		},
 	}
+	cmd.Flags().BoolVar(&withDisks, "with-disks", false, "Also copy the rootfs and mount images, restored when the VM resumes")
+	return cmd
+}
 
-	initCmd := &cobra.Command{
-		Use:   "init",
-		Short: "Initialize VMM directories and default config",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			if err := cfg.EnsureDirectories(); err != nil {
-				return fmt.Errorf("failed to create directories: %w", err)
-			}
+// suspendVM snapshots a running VM's memory and state to the state directory and stops it
+// With withDisks its rootfs and mount images are copied there too once the process is gone
+func suspendVM(name string, withDisks bool) error {
+	paths := cfg.GetPaths()
 
-			if err := cfg.Save(config.ConfigPath()); err != nil {
-				return fmt.Errorf("failed to save config: %w", err)
+	existingVM, err := vm.Load(paths.VMs, name)
+	if err != nil {
+		return fmt.Errorf("VM '%s' not found", name)
+	}
+
+	fcClient := firecracker.NewClient()
+	fcClient.UpdateVMState(existingVM)
+	if existingVM.State != vm.StateRunning {
+		return fmt.Errorf("VM '%s' is not running (state: %s)", name, existingVM.State)
+	}
+	if withDisks {
+		// Checked up front, as the copies can only be taken once the VM is stopped
+		for _, m := range existingVM.Mounts {
+			if m.Shared {
+				return fmt.Errorf("mount '%s' uses a shared image, which can't be snapshotted with the VM", m.GuestTag)
 			}
+		}
+	}
 
-			fmt.Println("VMM initialized successfully")
-			fmt.Printf("Config saved to: %s\n", config.ConfigPath())
-			fmt.Printf("Data directory: %s\n", cfg.DataDir)
-			return nil
-		},
+	if err := cfg.EnsureDirectories(); err != nil {
+		return fmt.Errorf("failed to create directories: %w", err)
 	}
+	memPath := filepath.Join(paths.State, name+".mem")
+	statePath := filepath.Join(paths.State, name+".vmstate")
 
-	cmd.AddCommand(showCmd, initCmd)
-	return cmd
+	fmt.Printf("Suspending VM '%s'...\n", name)
+	ctx := context.Background()
+	if err := fcClient.SnapshotVM(ctx, existingVM.SocketPath, existingVM.MemoryMB, memPath, statePath); err != nil {
+		return fmt.Errorf("failed to suspend VM: %w", err)
+	}
+
+	// The guest is paused and saved, so the process can simply be killed
+	if existingVM.PID > 0 {
+		if proc, err := os.FindProcess(existingVM.PID); err == nil {
+			proc.Signal(syscall.SIGKILL)
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	existingVM.State = vm.StateStopped
+	existingVM.PID = 0
+	existingVM.MemSnapshot = memPath
+	existingVM.StateSnapshot = statePath
+	if withDisks {
+		fmt.Println("Copying disks...")
+		copies, err := firecracker.SnapshotDisks(existingVM, filepath.Join(paths.State, name+".disks"))
+		if err != nil {
+			fmt.Printf("Warning: the VM's disks were not snapshotted: %v\n", err)
+		}
+		existingVM.DiskSnapshots = copies
+	}
+	if err := existingVM.Save(paths.VMs); err != nil {
+		return fmt.Errorf("failed to save VM config: %w", err)
+	}
+
+	os.Remove(existingVM.SocketPath)
+	if existingVM.VsockPath != "" {
+		os.Remove(existingVM.VsockPath)
+	}
+	if err := firecracker.RemoveCgroup(name); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	closeMountDevices(existingVM)
+
+	fmt.Printf("VM '%s' suspended (memory saved to %s)\n", name, memPath)
+	return nil
 }
 
-func imageCmd() *cobra.Command {
+func bundleCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "image",
-		Short: "Manage VM images",
+		Use:   "bundle",
+		Short: "Move suspended microVMs between hosts",
+		Long: `Package a suspended VM into a single tar stream and restore it on another
+host, where 'vmm start' resumes it where it left off.
+
+The bundle holds the VM's configuration, saved memory and state, rootfs and
+mount images. Both hosts need the same data directory, CPU model and
+Firecracker version, and the VM's kernel at the same path; the import checks
+this before writing anything.
+
+Examples:
+  sudo vmm suspend myvm
+  sudo vmm bundle export myvm - | ssh otherhost sudo vmm bundle import -`,
 	}
 
-	listCmd := &cobra.Command{
-		Use:   "list",
-		Short: "List available images",
+	exportCmd := &cobra.Command{
+		Use:   "export <name> <file|->",
+		Short: "Write a suspended VM to a bundle file, or to stdout with '-'",
+		Args:  cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			name, dest := args[0], args[1]
 			paths := cfg.GetPaths()
-			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
 
-			fmt.Println("Kernels:")
-			kernels, _ := imgMgr.ListKernels()
-			if len(kernels) == 0 {
-				fmt.Println("  (none)")
-			} else {
-				for _, k := range kernels {
-					fmt.Printf("  - %s\n", k)
-				}
+			existingVM, err := vm.Load(paths.VMs, name)
+			if err != nil {
+				return fmt.Errorf("VM '%s' not found", name)
+			}
+			fcClient := firecracker.NewClient()
+			fcClient.UpdateVMState(existingVM)
+			if existingVM.State == vm.StateRunning {
+				return fmt.Errorf("VM '%s' is running; run 'vmm suspend' first", name)
 			}
 
-			fmt.Println("\nRoot filesystems:")
-			rootfs, _ := imgMgr.ListRootfs()
-			if len(rootfs) == 0 {
-				fmt.Println("  (none)")
-			} else {
-				for _, r := range rootfs {
-					// Remove .ext4 extension for display
-					name := r
-					if len(r) > 5 && r[len(r)-5:] == ".ext4" {
-						name = r[:len(r)-5]
+			// Progress goes to stderr so the bundle can be piped through stdout
+			fmt.Fprintf(os.Stderr, "Bundling VM '%s'...\n", name)
+			if dest == "-" {
+				return fcClient.WriteBundle(os.Stdout, existingVM)
+			}
+			tmpPath := dest + ".tmp"
+			f, err := os.Create(tmpPath)
+			if err != nil {
+				return fmt.Errorf("failed to create bundle: %w", err)
+			}
+			err = fcClient.WriteBundle(f, existingVM)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err == nil {
+				err = os.Rename(tmpPath, dest)
+			}
+			if err != nil {
+				os.Remove(tmpPath)
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "VM '%s' bundled to %s\n", name, dest)
+			return nil
+		},
+	}
+
+	importCmd := &cobra.Command{
+		Use:   "import <file|->",
+		Short: "Restore a VM from a bundle file, or from stdin with '-'",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cfg.EnsureDirectories(); err != nil {
+				return fmt.Errorf("failed to create directories: %w", err)
+			}
+			paths := cfg.GetPaths()
+
+			src := os.Stdin
+			if args[0] != "-" {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return fmt.Errorf("failed to open bundle: %w", err)
+				}
+				defer f.Close()
+				src = f
+			}
+
+			fcClient := firecracker.NewClient()
+			restored, err := fcClient.RestoreBundle(src, func(v *vm.VM) error {
+				if vm.Exists(paths.VMs, v.Name) {
+					return fmt.Errorf("VM '%s' already exists", v.Name)
+				}
+				fmt.Printf("Restoring VM '%s'...\n", v.Name)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if err := restored.Save(paths.VMs); err != nil {
+				return fmt.Errorf("failed to save VM config: %w", err)
+			}
+
+			fmt.Printf("VM '%s' restored; run 'vmm start %s' to resume it\n", restored.Name, restored.Name)
+			for _, m := range restored.Mounts {
+				if m.Encrypted && m.KeyFile != "" {
+					if _, err := os.Stat(m.KeyFile); err != nil {
+						fmt.Printf("Warning: key file %s for encrypted mount '%s' is not on this host\n", m.KeyFile, m.GuestTag)
 					}
-					fmt.Printf("  - %s\n", name)
 				}
 			}
+			return nil
+		},
+	}
+
+	cmd.AddCommand(exportCmd, importCmd)
+	return cmd
+}
+
+// hasTmpfsMount reports whether a VM has a guest tmpfs mount, which is writable even on a read-only rootfs
+func hasTmpfsMount(v *vm.VM) bool {
+	for _, m := range v.Mounts {
+		if m.IsTmpfs() {
+			return true
+		}
+	}
+	return false
+}
+
+// newIPAM creates the allocator for guest addresses on the configured subnet
+func newIPAM() *network.IPAM {
+	paths := cfg.GetPaths()
+	return network.NewIPAM(filepath.Join(paths.State, "ip-leases.json"), cfg.Subnet, cfg.Gateway)
+}
+
+// allocateVMIP sets a VM's IP address from its lease, leasing one if it has none
+// The VM keeps its current address if that is free; addresses of running VMs are avoided
+func allocateVMIP(v *vm.VM) error {
+	paths := cfg.GetPaths()
+	var inUse []string
+	vms, _ := vm.List(paths.VMs)
+	for _, other := range vms {
+		if other.Name != v.Name && other.State == vm.StateRunning && other.IPAddress != "" {
+			inUse = append(inUse, other.IPAddress)
+		}
+	}
+	ip, err := newIPAM().Allocate(v.Name, v.IPAddress, inUse)
+	if err != nil {
+		return err
+	}
+	v.IPAddress = ip
+	return nil
+}
+
+// vmDNSServers returns the DNS servers a VM's guest is given: its own, or the bridge's
+// dnsmasq when the config enables bridge DNS, or none for the image defaults
+func vmDNSServers(v *vm.VM) []string {
+	if len(v.DNSServers) == 0 && cfg.BridgeDNS {
+		return []string{cfg.Gateway}
+	}
+	return v.DNSServers
+}
+
+// refreshBridgeDNS updates the bridge's dnsmasq with every VM's name and address, starting it
+// if needed, when the config enables bridge DNS. Failures are warnings: VMs still reach each
+// other by IP
+func refreshBridgeDNS() {
+	if !cfg.BridgeDNS {
+		return
+	}
+	paths := cfg.GetPaths()
+	leases, err := newIPAM().Leases()
+	if err != nil {
+		fmt.Printf("Warning: failed to read IP leases: %v\n", err)
+		return
+	}
+	var hosts []network.BridgeHost
+	vms, _ := vm.List(paths.VMs)
+	for _, v := range vms {
+		if ip, ok := leases[v.Name]; ok {
+			hosts = append(hosts, network.BridgeHost{Name: v.Name, IP: ip, MAC: v.MacAddress})
+		}
+	}
+	netMgr := network.NewManager(cfg.BridgeName, cfg.Subnet, cfg.Gateway, cfg.HostInterface)
+	if err := netMgr.EnsureBridgeDNS(filepath.Join(paths.State, "bridge-dns"), hosts); err != nil {
+		fmt.Printf("Warning: bridge DNS: %v\n", err)
+	}
+}
+
+// newMountManager creates a mount manager with the mount settings from the config
+func newMountManager() *mount.Manager {
+	paths := cfg.GetPaths()
+	mountMgr := mount.NewManager(paths.Mounts)
+	mountMgr.TempDir = cfg.MountTempDir
+	mountMgr.ProtectedDirs = []string{paths.Images, paths.VMs, paths.State}
+	mountMgr.CheckQuota = cfg.CheckDiskQuota
+	mountMgr.MaxSyncDuration = time.Duration(cfg.MaxMountSyncSeconds) * time.Second
+	return mountMgr
+}
+
+// openMountDevices opens the encrypted mount images of a VM on the host
+func openMountDevices(v *vm.VM) error {
+	mountMgr := newMountManager()
+	for i := range v.Mounts {
+		if _, err := mountMgr.OpenMountDevice(&v.Mounts[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preflightMountImages checks a VM's mount images before a start, so a deleted image is reported
+// by mount rather than by Firecracker's drive setup. A missing image is recreated by the start's
+// sync when its host directories or archive still exist; one that can't be, or whose path holds
+// something other than a file, is an error. A suspended guest was using the images, so a resume
+// fails on any problem
+func preflightMountImages(v *vm.VM, resuming bool) error {
+	problems := mount.CheckMountImages(v.Mounts)
+	if len(problems) == 0 {
+		return nil
+	}
+	var errs []error
+	if resuming {
+		for _, p := range problems {
+			errs = append(errs, p.Err)
+		}
+		errs = append(errs, fmt.Errorf("the suspended guest was using these images; start with --discard-snapshot to boot from scratch and recreate them"))
+		return errors.Join(errs...)
+	}
+	mountMgr := newMountManager()
+	for _, p := range problems {
+		if !p.Missing {
+			errs = append(errs, p.Err)
+			continue
+		}
+		if err := mountMgr.CanRecreateImage(p.Mount); err != nil {
+			errs = append(errs, fmt.Errorf("%w, and it can't be recreated: %w", p.Err, err))
+			continue
+		}
+		source := strings.Join(p.Mount.SourcePaths(), " + ")
+		if p.Mount.IsArchive() {
+			source = p.Mount.ArchivePath
+		}
+		fmt.Printf("Mount image for '%s' is missing; recreating it from %s\n", p.Mount.GuestTag, source)
+	}
+	return errors.Join(errs...)
+}
+
+// closeMountDevices closes the encrypted mount images of a VM that is no longer running
+func closeMountDevices(v *vm.VM) {
+	mountMgr := newMountManager()
+	if err := mountMgr.CloseMountDevices(v.Name, v.Mounts); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+}
+
+// discardSnapshot deletes the memory and state files saved by 'vmm suspend' and forgets them
+func discardSnapshot(v *vm.VM) {
+	if v.MemSnapshot != "" {
+		os.Remove(v.MemSnapshot)
+	}
+	if v.StateSnapshot != "" {
+		os.Remove(v.StateSnapshot)
+	}
+	v.MemSnapshot = ""
+	v.StateSnapshot = ""
+	firecracker.DiscardDiskSnapshots(v)
+}
+
+func stopCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "stop <name>",
+		Short: "Stop a microVM",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return stopVM(args[0])
+		},
+	}
+}
+
+// stopOptions returns the shutdown options for a VM: its PID, and its guest agent when enabled
+func stopOptions(v *vm.VM) firecracker.StopOptions {
+	opts := firecracker.StopOptions{PID: v.PID, VM: v}
+	if v.GuestAgent && v.VsockPath != "" {
+		opts.AgentVsockPath = v.VsockPath
+	}
+	return opts
+}
+
+// runPostStopHook runs a VM's post-stop hook command
+func runPostStopHook(v *vm.VM) error {
+	if v.PostStopHook == "" {
+		return nil
+	}
+	return runHookCommand(v.PostStopHook, v.Name, v.IPAddress, v.TapDevice)
+}
+
+// preStartHook returns a firecracker pre-start hook running command for the VM being started
+func preStartHook(command string) func(*firecracker.VMConfig) error {
+	return func(vmCfg *firecracker.VMConfig) error {
+		if command == "" {
+			return nil
+		}
+		return runHookCommand(command, vmCfg.Name, vmCfg.IPAddress, vmCfg.TapDevice)
+	}
+}
+
+// runHookCommand runs a VM hook command through the shell, with the VM's name, IP address
+// and TAP device in its environment
+func runHookCommand(command, name, ip, tap string) error {
+	hook := exec.Command("sh", "-c", command)
+	hook.Env = append(os.Environ(),
+		"VMM_VM_NAME="+name,
+		"VMM_VM_IP="+ip,
+		"VMM_TAP_DEVICE="+tap,
+	)
+	hook.Stdout = os.Stdout
+	hook.Stderr = os.Stderr
+	if err := hook.Run(); err != nil {
+		return fmt.Errorf("%q: %w", command, err)
+	}
+	return nil
+}
+
+// stopVM shuts down a running VM and releases its TAP device and socket
+func stopVM(name string) error {
+	paths := cfg.GetPaths()
+
+	existingVM, err := vm.Load(paths.VMs, name)
+	if err != nil {
+		return fmt.Errorf("VM '%s' not found", name)
+	}
+
+	// Update state
+	fcClient := firecracker.NewClient()
+	fcClient.PostStopHook = runPostStopHook
+	fcClient.UpdateVMState(existingVM)
+
+	if existingVM.State != vm.StateRunning {
+		return fmt.Errorf("VM '%s' is not running (state: %s)", name, existingVM.State)
+	}
+
+	fmt.Printf("Stopping VM '%s'...\n", name)
+
+	existingVM.State = vm.StateStopping
+	existingVM.Save(paths.VMs)
+
+	ctx := context.Background()
+	if err := fcClient.StopVMWithOptions(ctx, existingVM.SocketPath, stopOptions(existingVM)); err != nil {
+		if errors.Is(err, firecracker.ErrVMMUnresponsive) {
+			fmt.Println("Firecracker is not responding, killing the process...")
+		}
+		// Try to kill by PID as fallback
+		if existingVM.PID > 0 {
+			if proc, err := os.FindProcess(existingVM.PID); err == nil {
+				proc.Signal(syscall.SIGKILL)
+			}
+		}
+		time.Sleep(500 * time.Millisecond)
+		if !fcClient.IsRunning(existingVM.SocketPath, existingVM.PID) {
+			fcClient.RunPostStopHook(existingVM)
+		}
+	} else {
+		// Wait briefly for process to exit
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	releaseVMResources(existingVM)
+	fmt.Printf("VM '%s' stopped\n", name)
+
+	if cfg.TrimOnStop {
+		if err := trimVMImages(existingVM); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// releaseVMResources marks a VM whose Firecracker process has exited as stopped and frees
+// what it held on the host: its TAP device, sockets, cgroup, encrypted mount devices and
+// the memory snapshot it was resumed from
+func releaseVMResources(existingVM *vm.VM) {
+	paths := cfg.GetPaths()
+
+	// Clean up TAP device so it can be reused on next start
+	netMgr := network.NewManager(cfg.BridgeName, cfg.Subnet, cfg.Gateway, cfg.HostInterface)
+	if existingVM.TapDevice != "" && netMgr.TapExists(existingVM.TapDevice) {
+		if err := netMgr.DeleteTap(existingVM.TapDevice); err != nil {
+			fmt.Printf("Warning: failed to delete TAP device: %v\n", err)
+		}
+	}
+
+	// Cleanup
+	existingVM.State = vm.StateStopped
+	existingVM.PID = 0
+	existingVM.Save(paths.VMs)
+
+	// Remove socket
+	os.Remove(existingVM.SocketPath)
+	if existingVM.VsockPath != "" {
+		os.Remove(existingVM.VsockPath)
+	}
+	if err := firecracker.RemoveCgroup(existingVM.Name); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+	closeMountDevices(existingVM)
+	os.Remove(firecracker.MemoryRangePath(existingVM.SocketPath))
+
+	// A resumed VM no longer needs the memory it was restored from
+	if existingVM.MemSnapshot != "" {
+		discardSnapshot(existingVM)
+		existingVM.Save(paths.VMs)
+	}
+}
+
+// seedISOPath returns where a VM's cloud-init seed is built, or "" if the VM has no cloud-init user-data
+func seedISOPath(v *vm.VM) string {
+	if v.CloudInitUserData == "" {
+		return ""
+	}
+	return filepath.Join(cfg.GetPaths().VMs, v.Name+".seed.iso")
+}
+
+// driveLayout returns the drives a VM is given at its next start, in the order StartVM attaches them
+// Images that don't exist yet are shown at the paths they will be created at
+func driveLayout(v *vm.VM) []firecracker.DriveInfo {
+	paths := cfg.GetPaths()
+	mountMgr := newMountManager()
+	vmCfg := &firecracker.VMConfig{
+		RootfsPath:       v.RootfsPath,
+		RootfsReadOnly:   v.RootReadOnly,
+		ModulesImagePath: v.ModulesImage,
+		SeedISOPath:      seedISOPath(v),
+		ExtraDrives:      hostDrives(v),
+	}
+	if vmCfg.RootfsPath == "" {
+		vmCfg.RootfsPath = filepath.Join(paths.VMs, v.Name+".ext4")
+	}
+	if v.Verity {
+		vmCfg.VerityHashTreePath = vmCfg.RootfsPath + ".verity"
+	}
+	for _, m := range v.Mounts {
+		if m.IsTmpfs() {
+			continue
+		}
+		imagePath := m.ImagePath
+		if imagePath == "" {
+			imagePath = mountMgr.GetMountImagePath(v.Name, m.GuestTag)
+		}
+		vmCfg.MountDrives = append(vmCfg.MountDrives, firecracker.MountDrive{
+			ImagePath:  imagePath,
+			Tag:        m.GuestTag,
+			ReadOnly:   m.ReadOnly,
+			DriveOrder: m.DriveOrder,
+			DriveID:    m.DriveID,
+		})
+	}
+	return vmCfg.DriveLayout()
+}
+
+// buildSeedISO builds a VM's cloud-init NoCloud seed from its user-data and network-config files
+// The VM ID is the instance ID, so cloud-init treats the VM as the same instance on every boot
+func buildSeedISO(v *vm.VM) error {
+	userData, err := os.ReadFile(v.CloudInitUserData)
+	if err != nil {
+		return fmt.Errorf("failed to read cloud-init user-data: %w", err)
+	}
+	var networkConfig []byte
+	if v.CloudInitNetworkConfig != "" {
+		if networkConfig, err = os.ReadFile(v.CloudInitNetworkConfig); err != nil {
+			return fmt.Errorf("failed to read cloud-init network-config: %w", err)
+		}
+	}
+	metaData := image.SeedMetaData(v.ID, v.Name)
+	vendorData := image.SeedVendorData(vmDNSServers(v), v.DNSSearch, v.NTPServers)
+	if err := image.CreateSeedISO(string(userData), metaData, string(networkConfig), vendorData, seedISOPath(v)); err != nil {
+		return fmt.Errorf("failed to build cloud-init seed: %w", err)
+	}
+	return nil
+}
+
+// setMemoryRange gives the VM config the VM's memory range, if it has one
+func setMemoryRange(vmCfg *firecracker.VMConfig, v *vm.VM) {
+	if v.MinMemoryMB > 0 {
+		vmCfg.MinMemoryMB = v.MinMemoryMB
+		vmCfg.MaxMemoryMB = v.MemoryMB
+	}
+}
+
+func memoryCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "memory <name> <MB>",
+		Short: "Change the memory available to a running microVM created with --min-memory",
+		Long: `Inflate or deflate the balloon of a running VM so the guest can use the given
+amount of memory. The amount is clamped to the VM's range: --min-memory up to
+--memory. The guest reclaims ballooned memory by itself if it runs out.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			mb, err := strconv.Atoi(args[1])
+			if err != nil || mb <= 0 {
+				return fmt.Errorf("invalid memory size '%s': must be a positive number of MB", args[1])
+			}
+			paths := cfg.GetPaths()
+
+			existingVM, err := vm.Load(paths.VMs, name)
+			if err != nil {
+				return fmt.Errorf("VM '%s' not found", name)
+			}
+			if existingVM.MinMemoryMB == 0 {
+				return fmt.Errorf("VM '%s' has fixed memory; create it with --min-memory to resize it", name)
+			}
+
+			fcClient := firecracker.NewClient()
+			fcClient.UpdateVMState(existingVM)
+			if existingVM.State != vm.StateRunning {
+				return fmt.Errorf("VM '%s' is not running", name)
+			}
 
+			target, err := fcClient.SetMemoryTarget(context.Background(), existingVM.SocketPath, mb)
+			if err != nil {
+				return err
+			}
+			if target != mb {
+				fmt.Printf("Requested %d MB is outside the range %d-%d MB\n", mb, existingVM.MinMemoryMB, existingVM.MemoryMB)
+			}
+			fmt.Printf("VM '%s' memory set to %d MB\n", name, target)
 			return nil
 		},
 	}
+}
 
-	pullCmd := &cobra.Command{
-		Use:   "pull",
-		Short: "Download default kernel and rootfs images",
+func memoryGuardCmd() *cobra.Command {
+	var policy firecracker.ThresholdPolicy
+	var interval time.Duration
+	cmd := &cobra.Command{
+		Use:   "memory-guard",
+		Short: "Pause low-priority VMs while host memory is low",
+		Long: `Watch the host's available memory and pause running VMs when it drops
+below --pause-below, lowest --priority first (the larger VM first among
+equals), one VM per check. Paused VMs are resumed, highest priority first,
+once available memory is back above --resume-above. Every pause and resume
+is logged with the reason.
+
+Pausing stops a guest from using more memory, so the host doesn't OOM-kill
+the VMs' Firecracker processes. It doesn't free memory already in use.
+
+The guard runs until interrupted, then resumes every VM it paused. Run it
+under systemd or similar to keep it going.
+
+Example:
+  vmm create batch --priority -10
+  vmm memory-guard --pause-below 1024 --resume-above 4096`,
+		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if err := cfg.EnsureDirectories(); err != nil {
-				return fmt.Errorf("failed to create directories: %w", err)
+			if err := policy.Validate(); err != nil {
+				return err
+			}
+			paths := cfg.GetPaths()
+
+			guard := firecracker.NewMemoryGuard(firecracker.NewClient(), paths.VMs, policy)
+			guard.Interval = interval
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			fmt.Printf("Pausing VMs below %d MB of available host memory, resuming above %d MB\n", policy.PauseBelowMB, policy.ResumeAboveMB)
+			return guard.Run(ctx)
+		},
+	}
+	cmd.Flags().IntVar(&policy.PauseBelowMB, "pause-below", 0, "Pause a VM when host available memory falls below this many MB (required)")
+	cmd.Flags().IntVar(&policy.ResumeAboveMB, "resume-above", 0, "Resume a paused VM when host available memory rises above this many MB (required)")
+	cmd.Flags().DurationVar(&interval, "interval", firecracker.DefaultMemoryGuardInterval, "How often to check host memory")
+	cmd.MarkFlagRequired("pause-below")
+	cmd.MarkFlagRequired("resume-above")
+	return cmd
+}
+
+func trimCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "trim <name>",
+		Short: "Return space freed inside a stopped microVM's disks to the host",
+		Long: `Discard the unused blocks of a stopped VM's rootfs and mount images so the
+sparse image files shrink back to the data they hold. Files deleted inside
+the guest otherwise keep occupying host disk.
+
+Encrypted mount images are skipped. Set "trim_on_stop": true in the config
+to trim after every 'vmm stop'.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			existingVM, err := loadStoppedVM(args[0], "trimming its disks")
+			if err != nil {
+				return err
+			}
+			return trimVMImages(existingVM)
+		},
+	}
+}
+
+func compactCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "compact <name>",
+		Short: "Rewrite a stopped microVM's rootfs to reclaim as much host disk as possible",
+		Long: `Check a stopped VM's rootfs, discard its free blocks as 'vmm trim' does, and
+then rewrite the image with a sparse-aware copy so allocated blocks that only
+hold zeros are released too. Use it after heavy churn in the guest; the copy
+needs free host disk for the data the rootfs holds.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			existingVM, err := loadStoppedVM(args[0], "compacting its rootfs")
+			if err != nil {
+				return err
+			}
+			paths := cfg.GetPaths()
+			before, _ := image.AllocatedBytes(existingVM.RootfsPath)
+			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
+			if err := imgMgr.CompactRootfs(existingVM.Name, paths.VMs); err != nil {
+				return err
+			}
+			after, _ := image.AllocatedBytes(existingVM.RootfsPath)
+			fmt.Printf("Compacted %s: %.1f MB reclaimed\n", filepath.Base(existingVM.RootfsPath), float64(before-after)/(1024*1024))
+			return nil
+		},
+	}
+}
+
+// loadStoppedVM loads a VM whose disks are about to be rewritten, refusing one that is running or suspended
+func loadStoppedVM(name, action string) (*vm.VM, error) {
+	existingVM, err := vm.Load(cfg.GetPaths().VMs, name)
+	if err != nil {
+		return nil, fmt.Errorf("VM '%s' not found", name)
+	}
+	firecracker.NewClient().UpdateVMState(existingVM)
+	if existingVM.State == vm.StateRunning {
+		return nil, fmt.Errorf("VM '%s' is running; stop it before %s", name, action)
+	}
+	if existingVM.MemSnapshot != "" {
+		return nil, fmt.Errorf("VM '%s' is suspended; its disks must stay as they were saved", name)
+	}
+	return existingVM, nil
+}
+
+// trimVMImages trims a stopped VM's rootfs and plain mount images, reporting the space reclaimed
+func trimVMImages(v *vm.VM) error {
+	mountMgr := newMountManager()
+	images := []string{}
+	if v.RootfsPath != "" {
+		images = append(images, v.RootfsPath)
+	}
+	for _, m := range v.Mounts {
+		if m.IsTmpfs() || m.ImagePath == "" {
+			continue
+		}
+		if m.Encrypted {
+			fmt.Printf("  Skipping encrypted mount '%s'\n", m.GuestTag)
+			continue
+		}
+		if mountMgr.IsSharedImage(m.ImagePath) {
+			// Other VMs may have it attached
+			fmt.Printf("  Skipping shared mount '%s'\n", m.GuestTag)
+			continue
+		}
+		images = append(images, m.ImagePath)
+	}
+
+	var errs []error
+	for _, path := range images {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		before, _ := image.AllocatedBytes(path)
+		if err := image.TrimImage(path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		after, _ := image.AllocatedBytes(path)
+		fmt.Printf("  Trimmed %s: %.1f MB reclaimed\n", filepath.Base(path), float64(before-after)/(1024*1024))
+	}
+	return errors.Join(errs...)
+}
+
+func sshCmd() *cobra.Command {
+	var user string
+
+	cmd := &cobra.Command{
+		Use:   "ssh <name> [-- <ssh-args>]",
+		Short: "SSH into a microVM",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			paths := cfg.GetPaths()
+
+			existingVM, err := vm.Load(paths.VMs, name)
+			if err != nil {
+				return fmt.Errorf("VM '%s' not found", name)
+			}
+
+			// Update state
+			fcClient := firecracker.NewClient()
+			fcClient.UpdateVMState(existingVM)
+
+			if existingVM.State != vm.StateRunning {
+				return fmt.Errorf("VM '%s' is not running", name)
+			}
+
+			if existingVM.IPAddress == "" {
+				return fmt.Errorf("VM '%s' has no IP address assigned", name)
+			}
+
+			// Build SSH command
+			sshArgs := []string{
+				"-o", "StrictHostKeyChecking=no",
+				"-o", "UserKnownHostsFile=/dev/null",
+			}
+
+			// If running under sudo, use the original user's SSH keys
+			if sudoUser := os.Getenv("SUDO_USER"); sudoUser != "" {
+				// Get the original user's home directory
+				userHome := fmt.Sprintf("/home/%s", sudoUser)
+				if sudoUser == "root" {
+					userHome = "/root"
+				}
+
+				// Look for common SSH key files
+				keyFiles := []string{
+					"id_ed25519",
+					"id_rsa",
+					"id_ecdsa",
+					"id_dsa",
+				}
+
+				for _, keyFile := range keyFiles {
+					keyPath := fmt.Sprintf("%s/.ssh/%s", userHome, keyFile)
+					if _, err := os.Stat(keyPath); err == nil {
+						sshArgs = append(sshArgs, "-i", keyPath)
+						break
+					}
+				}
+			}
+
+			sshArgs = append(sshArgs, fmt.Sprintf("%s@%s", user, existingVM.IPAddress))
+
+			// Append any additional SSH args
+			if len(args) > 1 {
+				sshArgs = append(sshArgs, args[1:]...)
+			}
+
+			// Execute SSH
+			sshCmd := exec.Command("ssh", sshArgs...)
+			sshCmd.Stdin = os.Stdin
+			sshCmd.Stdout = os.Stdout
+			sshCmd.Stderr = os.Stderr
+
+			return sshCmd.Run()
+		},
+	}
+
+	cmd.Flags().StringVarP(&user, "user", "u", "root", "SSH user")
+
+	return cmd
+}
+
+func consoleCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "console <name>",
+		Short: "Attach to a microVM's serial console",
+		Long:  "Stream a running microVM's serial console and send typed lines to it. Press Ctrl-C to detach; the VM keeps running.",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			paths := cfg.GetPaths()
+
+			existingVM, err := vm.Load(paths.VMs, name)
+			if err != nil {
+				return fmt.Errorf("VM '%s' not found", name)
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			fmt.Printf("Attached to the console of '%s' (press Ctrl-C to detach)\n", name)
+			fcClient := firecracker.NewClient()
+			if err := fcClient.AttachConsole(ctx, existingVM, os.Stdout, os.Stdin); err != nil {
+				return err
+			}
+			fmt.Printf("\nDetached from '%s'\n", name)
+			return nil
+		},
+	}
+}
+
+func configCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage VMM configuration",
+	}
+
+	showCmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show current configuration",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fmt.Printf("Data directory:    %s\n", cfg.DataDir)
+			fmt.Printf("Bridge name:       %s\n", cfg.BridgeName)
+			fmt.Printf("Subnet:            %s\n", cfg.Subnet)
+			fmt.Printf("Gateway:           %s\n", cfg.Gateway)
+			fmt.Printf("Host interface:    %s\n", cfg.HostInterface)
+			fmt.Printf("Verify mounts:     %t\n", cfg.VerifyMounts)
+			fmt.Printf("Trim on stop:      %t\n", cfg.TrimOnStop)
+			fmt.Printf("Bridge DNS:        %t\n", cfg.BridgeDNS)
+			if cfg.MaxMountSyncSeconds > 0 {
+				fmt.Printf("Max mount sync:    %ds\n", cfg.MaxMountSyncSeconds)
+			}
+			if cfg.MountTempDir != "" {
+				fmt.Printf("Mount temp dir:    %s\n", cfg.MountTempDir)
+			}
+			if cfg.MaxTotalDiskBytes > 0 {
+				used, err := cfg.GetPaths().VMDiskUsage()
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Disk quota:        %.1f MB of %.1f MB used\n", float64(used)/(1024*1024), float64(cfg.MaxTotalDiskBytes)/(1024*1024))
+			}
+			rotation := firecracker.NewLogRotation(cfg.LogMaxSizeMB, cfg.LogRetention)
+			fmt.Printf("Log rotation:      %d MB, %d kept\n", rotation.MaxSizeBytes/(1024*1024), rotation.Keep)
+			fmt.Printf("Config file:       %s\n", config.ConfigPath())
+
+			// Display VM defaults
+			fmt.Printf("\nVM Defaults:\n")
+			defaults := cfg.GetVMDefaults()
+
+			// CPUs
+			if defaults.CPUs > 0 {
+				fmt.Printf("  cpus:            %d (from config)\n", defaults.CPUs)
+			} else {
+				fmt.Printf("  cpus:            1 (default)\n")
+			}
+
+			// Memory
+			if defaults.MemoryMB > 0 {
+				fmt.Printf("  memory_mb:       %d (from config)\n", defaults.MemoryMB)
+			} else {
+				fmt.Printf("  memory_mb:       512 (default)\n")
+			}
+
+			// Disk
+			if defaults.DiskSizeMB > 0 {
+				fmt.Printf("  disk_size_mb:    %d (from config)\n", defaults.DiskSizeMB)
+			} else {
+				fmt.Printf("  disk_size_mb:    1024 (default)\n")
+			}
+
+			// Image
+			if defaults.Image != "" {
+				fmt.Printf("  image:           %s (from config)\n", defaults.Image)
+			} else {
+				fmt.Printf("  image:           (default rootfs)\n")
+			}
+
+			// Kernel
+			if defaults.Kernel != "" {
+				fmt.Printf("  kernel:          %s (from config)\n", defaults.Kernel)
+			} else {
+				fmt.Printf("  kernel:          (default kernel)\n")
+			}
+
+			// SSH key path
+			if defaults.SSHKeyPath != "" {
+				fmt.Printf("  ssh_key_path:    %s (from config)\n", defaults.SSHKeyPath)
+			} else {
+				fmt.Printf("  ssh_key_path:    (none)\n")
+			}
+
+			// DNS servers
+			if len(defaults.DNSServers) > 0 {
+				fmt.Printf("  dns_servers:     %v (from config)\n", defaults.DNSServers)
+			} else {
+				fmt.Printf("  dns_servers:     [8.8.8.8, 8.8.4.4, 1.1.1.1] (default)\n")
+			}
+
+			return nil
+		},
+	}
+
+	initCmd := &cobra.Command{
+		Use:   "init",
+		Short: "Initialize VMM directories and default config",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cfg.EnsureDirectories(); err != nil {
+				return fmt.Errorf("failed to create directories: %w", err)
+			}
+
+			if err := cfg.Save(config.ConfigPath()); err != nil {
+				return fmt.Errorf("failed to save config: %w", err)
+			}
+
+			fmt.Println("VMM initialized successfully")
+			fmt.Printf("Config saved to: %s\n", config.ConfigPath())
+			fmt.Printf("Data directory: %s\n", cfg.DataDir)
+			return nil
+		},
+	}
+
+	cmd.AddCommand(showCmd, initCmd)
+	return cmd
+}
+
+func imageCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "image",
+		Short: "Manage VM images",
+	}
+
+	var showDigests bool
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List available images",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			paths := cfg.GetPaths()
+			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
+
+			fmt.Println("Kernels:")
+			kernels, err := imgMgr.ListKernelsWithInfo(showDigests)
+			if err != nil {
+				return fmt.Errorf("failed to list kernels: %w", err)
+			}
+			if len(kernels) == 0 {
+				fmt.Println("  (none)")
+			} else {
+				for _, k := range kernels {
+					fmt.Printf("  - %s%s\n", k.Name, digestSuffix(k.SHA256))
+				}
+			}
+
+			fmt.Println("\nRoot filesystems:")
+			rootfs, err := imgMgr.ListRootfsWithInfo(showDigests)
+			if err != nil {
+				return fmt.Errorf("failed to list root filesystems: %w", err)
+			}
+			if len(rootfs) == 0 {
+				fmt.Println("  (none)")
+			} else {
+				for _, r := range rootfs {
+					fmt.Printf("  - %s%s\n", r.Name, digestSuffix(r.SHA256))
+				}
+			}
+
+			return nil
+		},
+	}
+	listCmd.Flags().BoolVar(&showDigests, "sha256", false, "Show each image's SHA256 digest (computed once per image version and cached beside it)")
+
+	pullCmd := &cobra.Command{
+		Use:   "pull",
+		Short: "Download default kernel and rootfs images",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := cfg.EnsureDirectories(); err != nil {
+				return fmt.Errorf("failed to create directories: %w", err)
+			}
+
+			paths := cfg.GetPaths()
+			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
+
+			if err := imgMgr.EnsureDefaultImages(); err != nil {
+				return fmt.Errorf("failed to download images: %w", err)
+			}
+
+			fmt.Println("Images downloaded successfully")
+			fmt.Printf("  Kernel: %s\n", imgMgr.GetDefaultKernelPath())
+			fmt.Printf("  Rootfs: %s\n", imgMgr.GetDefaultRootfsPath())
+			return nil
+		},
+	}
+
+	var importSize int
+	importCmd := &cobra.Command{
+		Use:   "import <docker-image> --name <name>",
+		Short: "Import a Docker image as a VMM rootfs",
+		Long: `Import a Docker image as a VMM rootfs.
+
+The Docker image will be exported, configured with systemd and SSH,
+and converted to an ext4 filesystem suitable for Firecracker VMs.
+
+Examples:
+  vmm image import ubuntu:22.04 --name ubuntu-base
+  vmm image import myregistry/myapp:latest --name myapp --size 4096`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dockerImage := args[0]
+			name, _ := cmd.Flags().GetString("name")
+
+			if name == "" {
+				return fmt.Errorf("--name is required")
+			}
+
+			if err := cfg.EnsureDirectories(); err != nil {
+				return fmt.Errorf("failed to create directories: %w", err)
+			}
+
+			paths := cfg.GetPaths()
+			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
+
+			if err := imgMgr.ImportDockerImage(dockerImage, name, importSize); err != nil {
+				return err
+			}
+
+			fmt.Printf("Successfully imported '%s' as '%s'\n", dockerImage, name)
+			fmt.Printf("  Image path: %s\n", imgMgr.GetImagePath(name))
+			return nil
+		},
+	}
+	importCmd.Flags().String("name", "", "Name for the imported image (required)")
+	importCmd.Flags().IntVar(&importSize, "size", 2048, "Size of the image in MB")
+	importCmd.MarkFlagRequired("name")
+
+	deleteCmd := &cobra.Command{
+		Use:   "delete <name>",
+		Short: "Delete an imported image",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			paths := cfg.GetPaths()
+			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
+
+			if err := imgMgr.DeleteImage(name); err != nil {
+				return err
+			}
+
+			fmt.Printf("Deleted image '%s'\n", name)
+			return nil
+		},
+	}
+
+	var gcDryRun bool
+	gcCmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Delete kernels and rootfs images no VM uses",
+		Long: `Delete the kernels and rootfs images that no VM refers to. The default
+kernel and rootfs, the image and kernel set in vm_defaults, and any image a
+process has open are always kept. Use --dry-run to list what would be deleted.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			paths := cfg.GetPaths()
+			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
+
+			// Without the full list of VMs an image in use could look unused
+			vms, err := vm.List(paths.VMs)
+			if err != nil {
+				return fmt.Errorf("failed to list VMs: %w", err)
+			}
+			defaults := cfg.GetVMDefaults()
+			defaultKernel := defaults.Kernel
+			if image.IsKernelConstraint(defaultKernel) {
+				// A constraint no kernel meets protects nothing
+				defaultKernel, _ = imgMgr.SelectKernel(defaultKernel)
+			}
+			vms = append(vms, &vm.VM{Image: defaults.Image, Kernel: defaultKernel})
+
+			removed, err := imgMgr.GCImages(vms, gcDryRun)
+			for _, path := range removed {
+				if gcDryRun {
+					fmt.Printf("Would delete %s\n", path)
+				} else {
+					fmt.Printf("Deleted %s\n", path)
+				}
+			}
+			if err != nil {
+				return err
+			}
+			if len(removed) == 0 {
+				fmt.Println("No unused images")
+			}
+			return nil
+		},
+	}
+	gcCmd.Flags().BoolVar(&gcDryRun, "dry-run", false, "List the images that would be deleted without deleting them")
+
+	var diffContents bool
+	diffCmd := &cobra.Command{
+		Use:   "diff <image-a> <image-b>",
+		Short: "Show the files that differ between two ext4 images",
+		Long: `Mount two ext4 images read-only and list the files added (+), removed (-)
+and changed (~) from the first to the second. Each image is a file path or
+the name of an imported image, so a base image can be compared with a VM's
+rootfs or one mount image with another.
+
+Files are compared by type, size and modification time. With --content,
+regular files of the same size are compared by their contents instead,
+so files that were only touched aren't listed. Images open in a running
+VM are refused.
+
+Example:
+  vmm image diff ubuntu /var/lib/vmm/vms/web.ext4`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			paths := cfg.GetPaths()
+			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
+			var imagePaths []string
+			for _, arg := range args {
+				path := arg
+				if _, err := os.Stat(path); err != nil {
+					if !imgMgr.ImageExists(arg) {
+						return fmt.Errorf("'%s' is neither an image file nor an imported image", arg)
+					}
+					path = imgMgr.GetImagePath(arg)
+				}
+				imagePaths = append(imagePaths, path)
+			}
+
+			mountMgr := newMountManager()
+			mountMgr.DiffContents = diffContents
+			diffs, err := mountMgr.DiffImages(imagePaths[0], imagePaths[1])
+			if err != nil {
+				return fmt.Errorf("failed to compare images: %w", err)
+			}
+			if len(diffs) == 0 {
+				fmt.Println("The images have the same files")
+				return nil
+			}
+			for _, d := range diffs {
+				switch d.Change {
+				case mount.DiffAdded:
+					fmt.Printf("+ %s\n", d.Path)
+				case mount.DiffRemoved:
+					fmt.Printf("- %s\n", d.Path)
+				default:
+					fmt.Printf("~ %s (%s)\n", d.Path, d.Reason)
+				}
+			}
+			fmt.Printf("%d paths differ\n", len(diffs))
+			return nil
+		},
+	}
+	diffCmd.Flags().BoolVar(&diffContents, "content", false, "Compare file contents rather than modification times (slower)")
+
+	var prefetchLimitMB int
+	prefetchCmd := &cobra.Command{
+		Use:   "prefetch <spec-file>",
+		Short: "Download a batch of kernels and rootfs images ahead of time",
+		Long: `Download a batch of kernels and rootfs images ahead of time.
+
+The spec file is a JSON list of images. Images already present are skipped,
+the rest are downloaded several at once and checked against their sha256 if
+one is given. A failed download moves on to the image's mirrors, in order,
+and the whole list is retried with a growing delay; an interrupted download
+resumes where it stopped. A URL ending in .gz is decompressed after download.
+
+Example spec file:
+  [
+    {"kind": "kernel", "name": "vmlinux-6.1", "url": "https://example.com/vmlinux-6.1"},
+    {"kind": "rootfs", "name": "ubuntu", "url": "https://example.com/ubuntu.ext4.gz",
+     "sha256": "<hex digest of ubuntu.ext4.gz>",
+     "mirrors": ["https://mirror.example.org/ubuntu.ext4.gz"]}
+  ]`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to read spec file: %w", err)
+			}
+			var specs []image.ImageSpec
+			if err := json.Unmarshal(data, &specs); err != nil {
+				return fmt.Errorf("failed to parse spec file: %w", err)
+			}
+
+			if err := cfg.EnsureDirectories(); err != nil {
+				return fmt.Errorf("failed to create directories: %w", err)
+			}
+
+			paths := cfg.GetPaths()
+			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
+			if prefetchLimitMB < 0 {
+				return fmt.Errorf("invalid --limit-rate %d: must not be negative", prefetchLimitMB)
+			}
+			if prefetchLimitMB > 0 {
+				imgMgr.RateLimit = image.NewRateLimiter(int64(prefetchLimitMB) * 1024 * 1024)
+			}
+			if err := imgMgr.Prefetch(specs); err != nil {
+				return fmt.Errorf("prefetch failed: %w", err)
+			}
+			return nil
+		},
+	}
+
+	prefetchCmd.Flags().IntVar(&prefetchLimitMB, "limit-rate", 0, "Cap the combined download bandwidth in MB/s (0 = unlimited)")
+
+	cmd.AddCommand(listCmd, pullCmd, importCmd, deleteCmd, gcCmd, diffCmd, prefetchCmd)
+	return cmd
+}
+
+func kernelCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "kernel",
+		Short: "Manage VM kernels",
+	}
+
+	var showDigests bool
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List available kernels",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			paths := cfg.GetPaths()
+			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
+
+			kernels, err := imgMgr.ListKernelsWithInfo(showDigests)
+			if err != nil {
+				return fmt.Errorf("failed to list kernels: %w", err)
+			}
+
+			if len(kernels) == 0 {
+				fmt.Println("No kernels found. Run 'vmm image pull' to download the default kernel.")
+				return nil
+			}
+
+			fmt.Println("Available kernels:")
+			for _, k := range kernels {
+				sizeMB := float64(k.Size) / (1024 * 1024)
+				defaultMarker := ""
+				if k.IsDefault {
+					defaultMarker = " (default)"
+				}
+				fmt.Printf("  - %s%s (%.2f MB)%s\n", k.Name, defaultMarker, sizeMB, digestSuffix(k.SHA256))
+			}
+
+			return nil
+		},
+	}
+	listCmd.Flags().BoolVar(&showDigests, "sha256", false, "Show each kernel's SHA256 digest (computed once per kernel version and cached beside it)")
+
+	var forceImport bool
+	importCmd := &cobra.Command{
+		Use:   "import <path>",
+		Short: "Import a custom kernel binary",
+		Long: `Import a custom kernel binary (vmlinux format).
+
+The kernel must be an uncompressed vmlinux ELF binary compatible with
+Firecracker. The architecture must match the host system.
+
+Examples:
+  vmm kernel import /path/to/vmlinux --name my-kernel
+  vmm kernel import ./vmlinux-6.1 --name kernel-6.1 --force`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			srcPath := args[0]
+			name, _ := cmd.Flags().GetString("name")
+
+			if name == "" {
+				return fmt.Errorf("--name is required")
+			}
+
+			if err := cfg.EnsureDirectories(); err != nil {
+				return fmt.Errorf("failed to create directories: %w", err)
+			}
+
+			paths := cfg.GetPaths()
+			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
+
+			if err := imgMgr.ImportKernel(srcPath, name, forceImport); err != nil {
+				return err
+			}
+
+			kernelPath := imgMgr.GetKernelPath(name)
+			fmt.Printf("Successfully imported kernel '%s'\n", name)
+			fmt.Printf("  Path: %s\n", kernelPath)
+			if info, err := os.Stat(kernelPath); err == nil {
+				fmt.Printf("  Size: %.2f MB\n", float64(info.Size())/(1024*1024))
+			}
+			return nil
+		},
+	}
+	importCmd.Flags().String("name", "", "Name for the imported kernel (required)")
+	importCmd.Flags().BoolVarP(&forceImport, "force", "f", false, "Overwrite existing kernel with same name")
+	importCmd.MarkFlagRequired("name")
+
+	deleteCmd := &cobra.Command{
+		Use:   "delete <name>",
+		Short: "Delete a custom kernel",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			paths := cfg.GetPaths()
+			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
+
+			// Check if any VMs are using this kernel
+			vms, _ := vm.List(paths.VMs)
+			var usingVMs []string
+			for _, v := range vms {
+				if v.Kernel == name {
+					usingVMs = append(usingVMs, v.Name)
+				}
+			}
+			if len(usingVMs) > 0 {
+				fmt.Printf("Warning: The following VMs are using kernel '%s': %v\n", name, usingVMs)
+				fmt.Println("These VMs will fail to start if the kernel is deleted.")
+			}
+
+			if err := imgMgr.DeleteKernel(name); err != nil {
+				return err
+			}
+
+			fmt.Printf("Deleted kernel '%s'\n", name)
+			return nil
+		},
+	}
+
+	var buildVersion string
+	var buildName string
+	buildCmd := &cobra.Command{
+		Use:   "build",
+		Short: "Build a kernel from source",
+		Long: `Build a Firecracker-compatible kernel from source.
+
+This command runs the build-kernel.sh script to compile a Linux kernel
+configured for Firecracker. Requires build dependencies to be installed.
+
+Supported kernel versions: 5.10, 6.1, 6.6
+
+Examples:
+  vmm kernel build --version 6.1 --name kernel-6.1
+  vmm kernel build --version 5.10 --name kernel-lts`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if buildVersion == "" {
+				return fmt.Errorf("--version is required (e.g., 5.10, 6.1, 6.6)")
+			}
+			if buildName == "" {
+				return fmt.Errorf("--name is required")
+			}
+
+			// Find the build script
+			scriptPath := "/usr/local/share/vmm/build-kernel.sh"
+			if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
+				// Try relative path for development
+				scriptPath = "scripts/build-kernel.sh"
+				if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
+					return fmt.Errorf("build-kernel.sh not found. Please install it to /usr/local/share/vmm/")
+				}
+			}
+
+			paths := cfg.GetPaths()
+			buildArgs := []string{scriptPath, "--version", buildVersion, "--name", buildName, "--output", paths.Kernels}
+
+			fmt.Printf("Building kernel %s as '%s'...\n", buildVersion, buildName)
+			fmt.Println("This may take a while depending on your system.")
+
+			buildExec := exec.Command("bash", buildArgs...)
+			buildExec.Stdout = os.Stdout
+			buildExec.Stderr = os.Stderr
+			buildExec.Stdin = os.Stdin
+
+			if err := buildExec.Run(); err != nil {
+				return fmt.Errorf("kernel build failed: %w", err)
+			}
+
+			fmt.Printf("\nKernel '%s' built successfully.\n", buildName)
+			return nil
+		},
+	}
+	buildCmd.Flags().StringVar(&buildVersion, "version", "", "Kernel version to build (e.g., 5.10, 6.1, 6.6)")
+	buildCmd.Flags().StringVar(&buildName, "name", "", "Name for the built kernel (required)")
+	buildCmd.MarkFlagRequired("version")
+	buildCmd.MarkFlagRequired("name")
+
+	modulesCmd := &cobra.Command{
+		Use:   "modules-image <modules-dir> <image-path>",
+		Short: "Build a kernel modules image for --modules-image",
+		Long: `Build an ext4 image from a kernel's modules directory, for VMs that need
+modules matching a custom kernel. The directory's name must be the kernel
+version (as in /lib/modules/<version>) and it must contain modules.dep.
+
+Example:
+  vmm kernel modules-image /lib/modules/6.1.102 /var/lib/vmm/images/modules-6.1.102.ext4
+  vmm create myvm --kernel kernel-6.1 --modules-image /var/lib/vmm/images/modules-6.1.102.ext4`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fmt.Printf("Building modules image from %s...\n", args[0])
+			version, err := image.BuildModulesImage(args[0], args[1])
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Modules image for kernel %s written to %s\n", version, args[1])
+			return nil
+		},
+	}
+
+	cmd.AddCommand(listCmd, importCmd, deleteCmd, buildCmd, modulesCmd)
+	return cmd
+}
+
+func portForwardCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "port-forward <name> <host-port>:<guest-port>",
+		Short: "Forward a port from host to VM",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			portSpec := args[1]
+			paths := cfg.GetPaths()
+
+			existingVM, err := vm.Load(paths.VMs, name)
+			if err != nil {
+				return fmt.Errorf("VM '%s' not found", name)
+			}
+
+			if existingVM.IPAddress == "" {
+				return fmt.Errorf("VM '%s' has no IP address", name)
+			}
+
+			// Parse port spec
+			var hostPort, guestPort int
+			if _, err := fmt.Sscanf(portSpec, "%d:%d", &hostPort, &guestPort); err != nil {
+				return fmt.Errorf("invalid port spec '%s', expected format: host-port:guest-port", portSpec)
+			}
+
+			netMgr := network.NewManager(cfg.BridgeName, cfg.Subnet, cfg.Gateway, cfg.HostInterface)
+			if err := netMgr.AddPortForward(hostPort, guestPort, existingVM.IPAddress, "tcp"); err != nil {
+				return fmt.Errorf("failed to add port forward: %w", err)
+			}
+
+			// Save port forward to VM config
+			existingVM.PortForwards = append(existingVM.PortForwards, vm.PortForward{
+				HostPort:  hostPort,
+				GuestPort: guestPort,
+				Protocol:  "tcp",
+			})
+			existingVM.Save(paths.VMs)
+
+			fmt.Printf("Port forward added: %d -> %s:%d\n", hostPort, existingVM.IPAddress, guestPort)
+			return nil
+		},
+	}
+
+	return cmd
+}
+
+func diagnosticsCmd() *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "diagnostics <name>",
+		Short: "Collect a VM's logs, configuration and status into a tar.gz for a bug report",
+		Long: `Collect a VM's logs, configuration and status into a tar.gz for a bug report.
+
+The bundle holds the VM's configuration, its status and kernel command line,
+the configuration Firecracker is running with (if the VM is up), the end of
+its Firecracker and console log, and the host's kernel and Firecracker
+versions. Private keys, authorization headers, password- and token-like
+values, environment variable values and the SSH key are redacted; check the
+bundle before sharing it all the same.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			paths := cfg.GetPaths()
+
+			existingVM, err := vm.Load(paths.VMs, name)
+			if err != nil {
+				return fmt.Errorf("VM '%s' not found", name)
+			}
+			if output == "" {
+				output = fmt.Sprintf("%s-diagnostics-%s.tar.gz", name, time.Now().Format("20060102-150405"))
+			}
+
+			fcClient := firecracker.NewClient()
+			if err := fcClient.CollectDiagnostics(existingVM, output); err != nil {
+				return err
+			}
+			fmt.Printf("Diagnostics for VM '%s' written to %s\n", name, output)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Bundle path (default <name>-diagnostics-<time>.tar.gz)")
+
+	return cmd
+}
+
+func netCheckCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "net-check <name>",
+		Short: "Test a running VM's network connectivity from the host",
+		Long: `Test a running VM's network connectivity from the host.
+
+Checks each layer in turn and reports which one is failing:
+  link     the TAP device exists, is up, is on the bridge and carries
+           packets from the guest
+  ip       the guest answers ARP and ping at its IP address
+  routing  the host routes the guest IP through the bridge, and IP
+           forwarding and NAT are set up for outbound traffic`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			paths := cfg.GetPaths()
+
+			existingVM, err := vm.Load(paths.VMs, name)
+			if err != nil {
+				return fmt.Errorf("VM '%s' not found", name)
+			}
+			fcClient := firecracker.NewClient()
+			fcClient.UpdateVMState(existingVM)
+			if existingVM.State != vm.StateRunning {
+				return fmt.Errorf("VM '%s' is not running", name)
+			}
+
+			netMgr := network.NewManager(cfg.BridgeName, cfg.Subnet, cfg.Gateway, cfg.HostInterface)
+			result, err := netMgr.TestConnectivity(existingVM)
+			if err != nil {
+				return err
+			}
+
+			check := func(ok bool) string {
+				if ok {
+					return "ok"
+				}
+				return "FAIL"
+			}
+			fmt.Printf("Link:    TAP %s %s, bridge %s %s (%d packets from guest, %d to guest)\n",
+				existingVM.TapDevice, check(result.TapUp && result.TapOnBridge), cfg.BridgeName, check(result.BridgeUp),
+				result.RxPackets, result.TxPackets)
+			fmt.Printf("IP:      ARP %s, ping %s\n", check(result.GuestMAC != ""), check(result.PingOK))
+			fmt.Printf("Routing: route via %s %s, forwarding %s, NAT %s\n",
+				result.RouteDevice, check(result.RouteDevice == cfg.BridgeName), check(result.ForwardingOn), check(result.MasqueradeSet))
+
+			if result.OK() {
+				fmt.Printf("\nVM '%s' has working network connectivity\n", name)
+				return nil
+			}
+			fmt.Println()
+			for _, problem := range result.Problems {
+				fmt.Printf("  - %s\n", problem)
+			}
+			return fmt.Errorf("network check failed at the %s layer", result.FailedLayer)
+		},
+	}
+
+	return cmd
+}
+
+// refreshRunningMount attaches a freshly synced image to the running VM in place of the old one
+// Failures only leave the VM on the old image until its next restart, so they are warnings
+func refreshRunningMount(fcClient *firecracker.Client, v *vm.VM, m *vm.Mount) {
+	if m.Encrypted {
+		// The VM's drive is the LUKS mapping of the old image, which a drive patch can't replace
+		fmt.Printf("  The running VM sees the new contents of encrypted mount '%s' after it is restarted\n", m.GuestTag)
+		return
+	}
+	remounted, err := fcClient.RefreshMountDrive(context.Background(), v, m, m.ImagePath)
+	if err != nil {
+		fmt.Printf("Warning: %v\n", err)
+		fmt.Printf("  The running VM sees the new contents after it is restarted\n")
+		return
+	}
+	if remounted {
+		fmt.Printf("  Remounted %s in the running VM\n", m.MountPath())
+		return
+	}
+	fmt.Printf("  The running VM's drive now reads the new image. Remount it in the guest to see the new contents:\n")
+	fmt.Printf("    umount %s && mount %s\n", m.MountPath(), m.MountPath())
+}
+
+func mountCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "mount",
+		Short: "Manage VM directory mounts",
+	}
+
+	var syncModeName string
+	var syncVerify bool
+	var syncForce bool
+	syncCmd := &cobra.Command{
+		Use:   "sync <vm-name> <tag>",
+		Short: "Sync a mount image from host directory",
+		Long: `Refresh a mount image with the current contents of the host directory.
+
+This command updates the ext4 image used for the mount with the latest
+files from the host directory. A read-write mount can only be synced
+while the VM is stopped. A read-only mount can be synced while it runs:
+its drive is then switched to the new image. A VM with --guest-agent is
+asked to remount it; otherwise remount it in the guest to see the
+new contents.
+
+Sync modes:
+  mirror  Make the image an exact copy of the host directory
+  merge   Add and update files from the host, keeping files that only
+          exist in the image (e.g. results written by the guest)
+
+Without --mode, the mount's own sync mode is used (set with
+--mount-sync-mode on create; mirror if unset). The same mode is used
+to refresh the image every time the VM starts.
+
+A sync is skipped when neither the host directories nor the image have
+changed since the last one. Use --force to copy anyway.
+
+Example:
+  vmm mount sync myvm code
+  vmm mount sync myvm output --mode merge`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			vmName := args[0]
+			tag := args[1]
+			paths := cfg.GetPaths()
+
+			// Load VM
+			existingVM, err := vm.Load(paths.VMs, vmName)
+			if err != nil {
+				return fmt.Errorf("VM '%s' not found", vmName)
+			}
+
+			fcClient := firecracker.NewClient()
+			fcClient.UpdateVMState(existingVM)
+			if existingVM.MemSnapshot != "" {
+				return fmt.Errorf("VM '%s' is suspended and its saved memory expects the current mount images. Resume and stop it before syncing mounts", vmName)
+			}
+
+			// Find the mount with the given tag
+			var targetMount *vm.Mount
+			for i := range existingVM.Mounts {
+				if existingVM.Mounts[i].GuestTag == tag {
+					targetMount = &existingVM.Mounts[i]
+					break
+				}
+			}
+
+			if targetMount == nil {
+				return fmt.Errorf("mount '%s' not found in VM '%s'", tag, vmName)
+			}
+
+			// The guest writes to a read-write mount, so only a read-only one can be synced under a running VM
+			running := existingVM.State == vm.StateRunning
+			if running && !targetMount.ReadOnly {
+				return fmt.Errorf("VM '%s' is running and mount '%s' is read-write. Stop it before syncing the mount", vmName, tag)
+			}
+
+			if !cmd.Flags().Changed("mode") {
+				syncModeName = targetMount.SyncMode
+			}
+			syncMode, err := mount.ParseSyncMode(syncModeName)
+			if err != nil {
+				return err
+			}
+
+			// Sync the mount
+			fmt.Printf("Syncing mount '%s' for VM '%s'...\n", tag, vmName)
+			mountMgr := newMountManager()
+			mountMgr.VerifyCopies = syncVerify
+			mountMgr.ForceSync = syncForce
+			if err := mountMgr.SyncMountImage(targetMount, vmName, syncMode); err != nil {
+				return fmt.Errorf("failed to sync mount: %w", err)
 			}
 
-			paths := cfg.GetPaths()
-			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
+			// Save updated mount image path
+			existingVM.Save(paths.VMs)
 
-			if err := imgMgr.EnsureDefaultImages(); err != nil {
-				return fmt.Errorf("failed to download images: %w", err)
+			fmt.Printf("Mount '%s' synced successfully\n", tag)
+			if running {
+				refreshRunningMount(fcClient, existingVM, targetMount)
 			}
-
-			fmt.Println("Images downloaded successfully")
-			fmt.Printf("  Kernel: %s\n", imgMgr.GetDefaultKernelPath())
-			fmt.Printf("  Rootfs: %s\n", imgMgr.GetDefaultRootfsPath())
 			return nil
 		},
 	}
 
-	var importSize int
-	importCmd := &cobra.Command{
-		Use:   "import <docker-image> --name <name>",
-		Short: "Import a Docker image as a VMM rootfs",
-		Long: `Import a Docker image as a VMM rootfs.
+	syncCmd.Flags().StringVar(&syncModeName, "mode", "", "Sync mode: mirror or merge (default: the mount's sync mode)")
+	syncCmd.Flags().BoolVar(&syncVerify, "verify", false, "Compare the image with the host directory after syncing")
+	syncCmd.Flags().BoolVar(&syncForce, "force", false, "Sync even if the host directory and image are unchanged since the last sync")
 
-The Docker image will be exported, configured with systemd and SSH,
-and converted to an ext4 filesystem suitable for Firecracker VMs.
+	verifyCmd := &cobra.Command{
+		Use:   "verify <vm-name> <tag>",
+		Short: "Check a mount image against its host directory",
+		Long: `Mount an image read-only and compare its files with the host directory.
 
-Examples:
-  vmm image import ubuntu:22.04 --name ubuntu-base
-  vmm image import myregistry/myapp:latest --name myapp --size 4096`,
-		Args: cobra.ExactArgs(1),
+Reports files that are missing from the image, files that only exist in
+the image, and files whose size differs. Files written by the guest or
+kept by a merge-mode sync are reported as unexpected.
+
+Example:
+  vmm mount verify myvm code`,
+		Args: cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			dockerImage := args[0]
-			name, _ := cmd.Flags().GetString("name")
+			vmName := args[0]
+			tag := args[1]
+			paths := cfg.GetPaths()
 
-			if name == "" {
-				return fmt.Errorf("--name is required")
+			existingVM, err := vm.Load(paths.VMs, vmName)
+			if err != nil {
+				return fmt.Errorf("VM '%s' not found", vmName)
 			}
 
-			if err := cfg.EnsureDirectories(); err != nil {
-				return fmt.Errorf("failed to create directories: %w", err)
+			fcClient := firecracker.NewClient()
+			fcClient.UpdateVMState(existingVM)
+			if existingVM.State == vm.StateRunning {
+				return fmt.Errorf("VM '%s' is running. Stop it before verifying mounts", vmName)
 			}
 
-			paths := cfg.GetPaths()
-			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
-
-			if err := imgMgr.ImportDockerImage(dockerImage, name, importSize); err != nil {
-				return err
+			var targetMount *vm.Mount
+			for i := range existingVM.Mounts {
+				if existingVM.Mounts[i].GuestTag == tag {
+					targetMount = &existingVM.Mounts[i]
+					break
+				}
+			}
+			if targetMount == nil {
+				return fmt.Errorf("mount '%s' not found in VM '%s'", tag, vmName)
 			}
 
-			return nil
-		},
-	}
-	importCmd.Flags().String("name", "", "Name for the imported image (required)")
-	importCmd.Flags().IntVar(&importSize, "size", 2048, "Size of the image in MB")
-	importCmd.MarkFlagRequired("name")
-
-	deleteCmd := &cobra.Command{
-		Use:   "delete <name>",
-		Short: "Delete an imported image",
-		Args:  cobra.ExactArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			name := args[0]
-			paths := cfg.GetPaths()
-			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
-
-			if err := imgMgr.DeleteImage(name); err != nil {
+			mountMgr := newMountManager()
+			result, err := mountMgr.VerifyMountImage(targetMount, vmName)
+			if err != nil {
+				return fmt.Errorf("failed to verify mount: %w", err)
+			}
+			if err := result.Err(); err != nil {
 				return err
 			}
 
-			fmt.Printf("Deleted image '%s'\n", name)
+			fmt.Printf("Mount '%s' matches its host directory (%d files, %.1f MB)\n",
+				tag, result.ActualFiles, float64(result.ActualBytes)/(1024*1024))
 			return nil
 		},
 	}
 
-	cmd.AddCommand(listCmd, pullCmd, importCmd, deleteCmd)
-	return cmd
-}
+	recreateCmd := &cobra.Command{
+		Use:   "recreate <vm-name> <tag>",
+		Short: "Replace a corrupted mount image with a fresh one",
+		Long: `Delete a mount image and build it again from its host directory or archive.
 
-func kernelCmd() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "kernel",
-		Short: "Manage VM kernels",
-	}
+Use this when an image is too damaged for e2fsck to repair. It is not a
+repair: anything the guest wrote to the image is lost. The mount keeps
+its tag, mode and filesystem options. The VM must be stopped.
 
-	listCmd := &cobra.Command{
-		Use:   "list",
-		Short: "List available kernels",
+Example:
+  vmm mount recreate myvm data`,
+		Args: cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			vmName := args[0]
+			tag := args[1]
 			paths := cfg.GetPaths()
-			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
 
-			kernels, err := imgMgr.ListKernelsWithInfo()
+			existingVM, err := vm.Load(paths.VMs, vmName)
 			if err != nil {
-				return fmt.Errorf("failed to list kernels: %w", err)
+				return fmt.Errorf("VM '%s' not found", vmName)
 			}
 
-			if len(kernels) == 0 {
-				fmt.Println("No kernels found. Run 'vmm image pull' to download the default kernel.")
-				return nil
+			fcClient := firecracker.NewClient()
+			fcClient.UpdateVMState(existingVM)
+			if existingVM.State == vm.StateRunning {
+				return fmt.Errorf("VM '%s' is running. Stop it before recreating mounts", vmName)
+			}
+			if existingVM.MemSnapshot != "" {
+				return fmt.Errorf("VM '%s' is suspended and its saved memory expects the current mount images. Resume and stop it before recreating mounts", vmName)
 			}
 
-			fmt.Println("Available kernels:")
-			for _, k := range kernels {
-				sizeMB := float64(k.Size) / (1024 * 1024)
-				defaultMarker := ""
-				if k.IsDefault {
-					defaultMarker = " (default)"
+			var targetMount *vm.Mount
+			for i := range existingVM.Mounts {
+				if existingVM.Mounts[i].GuestTag == tag {
+					targetMount = &existingVM.Mounts[i]
+					break
 				}
-				fmt.Printf("  - %s%s (%.2f MB)\n", k.Name, defaultMarker, sizeMB)
 			}
+			if targetMount == nil {
+				return fmt.Errorf("mount '%s' not found in VM '%s'", tag, vmName)
+			}
+
+			mountMgr := newMountManager()
+			if err := mountMgr.RecreateMountImage(targetMount, vmName); err != nil {
+				return fmt.Errorf("failed to recreate mount: %w", err)
+			}
+			existingVM.Save(paths.VMs)
 
+			fmt.Printf("Mount '%s' recreated from %s; changes the guest had made to it are gone\n", tag, targetMount.SourceDescription())
 			return nil
 		},
 	}
 
-	var forceImport bool
-	importCmd := &cobra.Command{
-		Use:   "import <path>",
-		Short: "Import a custom kernel binary",
-		Long: `Import a custom kernel binary (vmlinux format).
-
-The kernel must be an uncompressed vmlinux ELF binary compatible with
-Firecracker. The architecture must match the host system.
-
-Examples:
-  vmm kernel import /path/to/vmlinux --name my-kernel
-  vmm kernel import ./vmlinux-6.1 --name kernel-6.1 --force`,
-		Args: cobra.ExactArgs(1),
+	listCmd := &cobra.Command{
+		Use:   "list <vm-name>",
+		Short: "List mounts for a VM",
+		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			srcPath := args[0]
-			name, _ := cmd.Flags().GetString("name")
+			vmName := args[0]
+			paths := cfg.GetPaths()
 
-			if name == "" {
-				return fmt.Errorf("--name is required")
+			// Load VM
+			existingVM, err := vm.Load(paths.VMs, vmName)
+			if err != nil {
+				return fmt.Errorf("VM '%s' not found", vmName)
 			}
 
-			if err := cfg.EnsureDirectories(); err != nil {
-				return fmt.Errorf("failed to create directories: %w", err)
+			if len(existingVM.Mounts) == 0 {
+				fmt.Printf("VM '%s' has no mounts configured\n", vmName)
+				return nil
 			}
 
-			paths := cfg.GetPaths()
-			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
-
-			if err := imgMgr.ImportKernel(srcPath, name, forceImport); err != nil {
-				return err
+			fmt.Printf("Mounts for VM '%s':\n", vmName)
+			mountMgr := newMountManager()
+			devices := make(map[string]string)
+			for _, drive := range driveLayout(existingVM) {
+				if drive.Kind == firecracker.DriveKindMount {
+					devices[drive.Tag] = drive.Device
+				}
+			}
+			for _, m := range existingVM.Mounts {
+				mode := "rw"
+				if m.ReadOnly {
+					mode = "ro"
+				}
+				if m.IsTmpfs() {
+					fmt.Printf("  %s: %s -> %s (rw, lost on stop)\n", m.GuestTag, m.SourceDescription(), m.MountPath())
+					continue
+				}
+				if m.Encrypted {
+					mode += ", encrypted"
+				}
+				if m.ImagePath != "" && mountMgr.IsSharedImage(m.ImagePath) {
+					users, _ := mount.SharedImageUsers(m.ImagePath)
+					mode += fmt.Sprintf(", shared by %d", len(users))
+				}
+				device := devices[m.GuestTag]
+				if driveID, ok := existingVM.MountDriveIDs[m.GuestTag]; ok {
+					device += ", drive " + driveID
+				}
+				fmt.Printf("  %s: %s -> %s (%s) [%s]\n",
+					m.GuestTag, m.SourceDescription(), m.MountPath(), mode, device)
+				if m.ImagePath != "" {
+					fmt.Printf("       Image: %s\n", m.ImagePath)
+				}
 			}
-
 			return nil
 		},
 	}
-	importCmd.Flags().String("name", "", "Name for the imported kernel (required)")
-	importCmd.Flags().BoolVarP(&forceImport, "force", "f", false, "Overwrite existing kernel with same name")
-	importCmd.MarkFlagRequired("name")
 
-	deleteCmd := &cobra.Command{
-		Use:   "delete <name>",
-		Short: "Delete a custom kernel",
+	statusCmd := &cobra.Command{
+		Use:   "status <vm-name>",
+		Short: "Show each mount's image and whether the running VM has it attached",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			name := args[0]
+			vmName := args[0]
 			paths := cfg.GetPaths()
-			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
 
-			// Check if any VMs are using this kernel
-			vms, _ := vm.List(paths.VMs)
-			var usingVMs []string
-			for _, v := range vms {
-				if v.Kernel == name {
-					usingVMs = append(usingVMs, v.Name)
-				}
+			existingVM, err := vm.Load(paths.VMs, vmName)
+			if err != nil {
+				return fmt.Errorf("VM '%s' not found", vmName)
 			}
-			if len(usingVMs) > 0 {
-				fmt.Printf("Warning: The following VMs are using kernel '%s': %v\n", name, usingVMs)
-				fmt.Println("These VMs will fail to start if the kernel is deleted.")
+			if len(existingVM.Mounts) == 0 {
+				fmt.Printf("VM '%s' has no mounts configured\n", vmName)
+				return nil
 			}
+			firecracker.NewClient().UpdateVMState(existingVM)
 
-			if err := imgMgr.DeleteKernel(name); err != nil {
+			statuses, err := newMountManager().MountStatus(existingVM)
+			if err != nil {
 				return err
 			}
 
-			fmt.Printf("Deleted kernel '%s'\n", name)
-			return nil
+			fmt.Printf("Mounts for VM '%s' (%s):\n", vmName, existingVM.State)
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "TAG\tMODE\tSOURCE\tIMAGE\tSIZE\tON DISK\tATTACHED")
+			for _, s := range statuses {
+				mode := s.Mode
+				if s.ReadOnly {
+					mode += ",ro"
+				}
+				if s.Encrypted {
+					mode += ",encrypted"
+				}
+				if s.Shared {
+					mode += ",shared"
+				}
+				imagePath, size, onDisk := "-", "-", "-"
+				if s.ImageExists {
+					imagePath = s.ImagePath
+					size = fmt.Sprintf("%.1f MB", float64(s.ImageBytes)/(1024*1024))
+					onDisk = fmt.Sprintf("%.1f MB", float64(s.AllocatedBytes)/(1024*1024))
+				} else if s.Mode != vm.MountModeTmpfs {
+					imagePath = "(not created)"
+				}
+				attached := "no"
+				switch {
+				case s.Attached && s.DriveID != "":
+					attached = "yes (" + s.DriveID + ")"
+				case s.Attached:
+					attached = "yes"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					s.Tag, mode, s.Source, imagePath, size, onDisk, attached)
+			}
+			return w.Flush()
 		},
 	}
 
-	var buildVersion string
-	var buildName string
-	buildCmd := &cobra.Command{
-		Use:   "build",
-		Short: "Build a kernel from source",
-		Long: `Build a Firecracker-compatible kernel from source.
-
-This command runs the build-kernel.sh script to compile a Linux kernel
-configured for Firecracker. Requires build dependencies to be installed.
+	renameCmd := &cobra.Command{
+		Use:   "rename <vm-name> <old-tag> <new-tag>",
+		Short: "Rename a mount tag",
+		Long: `Rename the tag of a mount without recreating its image.
 
-Supported kernel versions: 5.10, 6.1, 6.6
+The mount image file is renamed and its ext4 label updated in place.
+The mount will appear at /mnt/<new-tag> on the next VM start.
+The VM must be stopped when renaming.
 
-Examples:
-  vmm kernel build --version 6.1 --name kernel-6.1
-  vmm kernel build --version 5.10 --name kernel-lts`,
+Example:
+  vmm mount rename myvm code src`,
+		Args: cobra.ExactArgs(3),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if buildVersion == "" {
-				return fmt.Errorf("--version is required (e.g., 5.10, 6.1, 6.6)")
+			vmName := args[0]
+			oldTag := args[1]
+			newTag := args[2]
+			paths := cfg.GetPaths()
+
+			// Load VM
+			existingVM, err := vm.Load(paths.VMs, vmName)
+			if err != nil {
+				return fmt.Errorf("VM '%s' not found", vmName)
 			}
-			if buildName == "" {
-				return fmt.Errorf("--name is required")
+
+			// Check if VM is running
+			fcClient := firecracker.NewClient()
+			fcClient.UpdateVMState(existingVM)
+			if existingVM.State == vm.StateRunning {
+				return fmt.Errorf("VM '%s' is running. Stop it before renaming mounts", vmName)
+			}
+			if existingVM.MemSnapshot != "" {
+				return fmt.Errorf("VM '%s' is suspended and its saved memory expects the current mount images. Resume and stop it before renaming mounts", vmName)
 			}
 
-			// Find the build script
-			scriptPath := "/usr/local/share/vmm/build-kernel.sh"
-			if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
-				// Try relative path for development
-				scriptPath = "scripts/build-kernel.sh"
-				if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
-					return fmt.Errorf("build-kernel.sh not found. Please install it to /usr/local/share/vmm/")
-				}
+			if err := mount.ValidateTag(newTag); err != nil {
+				return err
 			}
 
-			paths := cfg.GetPaths()
-			buildArgs := []string{scriptPath, "--version", buildVersion, "--name", buildName, "--output", paths.Kernels}
+			// Find the mount with the given tag and make sure the new tag is free
+			var targetMount *vm.Mount
+			for i := range existingVM.Mounts {
+				switch existingVM.Mounts[i].GuestTag {
+				case oldTag:
+					targetMount = &existingVM.Mounts[i]
+				case newTag:
+					return fmt.Errorf("mount '%s' already exists in VM '%s'", newTag, vmName)
+				}
+			}
 
-			fmt.Printf("Building kernel %s as '%s'...\n", buildVersion, buildName)
-			fmt.Println("This may take a while depending on your system.")
+			if targetMount == nil {
+				return fmt.Errorf("mount '%s' not found in VM '%s'", oldTag, vmName)
+			}
 
-			buildExec := exec.Command("bash", buildArgs...)
-			buildExec.Stdout = os.Stdout
-			buildExec.Stderr = os.Stderr
-			buildExec.Stdin = os.Stdin
+			// Rename the image if it has already been created; a shared image keeps its name
+			mountMgr := newMountManager()
+			if mountMgr.IsSharedImage(targetMount.ImagePath) {
+				if err := mountMgr.RenameSharedRef(targetMount.ImagePath, vmName, oldTag, newTag); err != nil {
+					return fmt.Errorf("failed to rename mount: %w", err)
+				}
+			} else if _, err := os.Stat(mountMgr.GetMountImagePath(vmName, oldTag)); err == nil {
+				if err := mountMgr.RenameMountTag(vmName, oldTag, newTag); err != nil {
+					return fmt.Errorf("failed to rename mount: %w", err)
+				}
+				targetMount.ImagePath = mountMgr.GetMountImagePath(vmName, newTag)
+			}
+			targetMount.GuestTag = newTag
 
-			if err := buildExec.Run(); err != nil {
-				return fmt.Errorf("kernel build failed: %w", err)
+			// The drive keeps its position, so carry its ID over to the new tag
+			if driveID, ok := existingVM.MountDriveIDs[oldTag]; ok {
+				delete(existingVM.MountDriveIDs, oldTag)
+				existingVM.MountDriveIDs[newTag] = driveID
 			}
 
-			fmt.Printf("\nKernel '%s' built successfully.\n", buildName)
+			if err := existingVM.Save(paths.VMs); err != nil {
+				return fmt.Errorf("failed to save VM config: %w", err)
+			}
+
+			fmt.Printf("Mount '%s' renamed to '%s'\n", oldTag, newTag)
 			return nil
 		},
 	}
-	buildCmd.Flags().StringVar(&buildVersion, "version", "", "Kernel version to build (e.g., 5.10, 6.1, 6.6)")
-	buildCmd.Flags().StringVar(&buildName, "name", "", "Name for the built kernel (required)")
-	buildCmd.MarkFlagRequired("version")
-	buildCmd.MarkFlagRequired("name")
 
-	cmd.AddCommand(listCmd, importCmd, deleteCmd, buildCmd)
+	cmd.AddCommand(syncCmd, verifyCmd, recreateCmd, listCmd, statusCmd, renameCmd)
 	return cmd
 }
 
-func portForwardCmd() *cobra.Command {
+func manifestCmd() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "port-forward <name> <host-port>:<guest-port>",
-		Short: "Forward a port from host to VM",
-		Args:  cobra.ExactArgs(2),
+		Use:   "manifest",
+		Short: "Export and import portable VM manifests",
+	}
+
+	var outputPath string
+	exportCmd := &cobra.Command{
+		Use:   "export <name>",
+		Short: "Export a VM's configuration as a JSON manifest",
+		Long: `Export a VM's configuration as a portable JSON manifest.
+
+The manifest contains everything needed to recreate the VM (CPUs, memory,
+disk, image, kernel, SSH key, DNS, port forwards and mounts). Runtime
+details such as the VM ID, PID, socket and IP address are not included.
+
+Examples:
+  vmm manifest export myvm > myvm.json
+  vmm manifest export myvm -o myvm.json`,
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			name := args[0]
-			portSpec := args[1]
 			paths := cfg.GetPaths()
 
 			existingVM, err := vm.Load(paths.VMs, name)
@@ -1090,138 +3551,515 @@ func portForwardCmd() *cobra.Command {
 				return fmt.Errorf("VM '%s' not found", name)
 			}
 
-			if existingVM.IPAddress == "" {
-				return fmt.Errorf("VM '%s' has no IP address", name)
+			data, err := vm.ExportManifest(existingVM)
+			if err != nil {
+				return err
 			}
 
-			// Parse port spec
-			var hostPort, guestPort int
-			if _, err := fmt.Sscanf(portSpec, "%d:%d", &hostPort, &guestPort); err != nil {
-				return fmt.Errorf("invalid port spec '%s', expected format: host-port:guest-port", portSpec)
+			if outputPath == "" {
+				_, err := os.Stdout.Write(data)
+				return err
+			}
+			if err := os.WriteFile(outputPath, data, 0644); err != nil {
+				return fmt.Errorf("failed to write manifest: %w", err)
 			}
+			fmt.Printf("Exported VM '%s' to %s\n", name, outputPath)
+			return nil
+		},
+	}
+	exportCmd.Flags().StringVarP(&outputPath, "output", "o", "", "Write the manifest to a file instead of stdout")
 
-			netMgr := network.NewManager(cfg.BridgeName, cfg.Subnet, cfg.Gateway, cfg.HostInterface)
-			if err := netMgr.AddPortForward(hostPort, guestPort, existingVM.IPAddress, "tcp"); err != nil {
-				return fmt.Errorf("failed to add port forward: %w", err)
+	var importName string
+	importCmd := &cobra.Command{
+		Use:   "import <manifest-file>",
+		Short: "Create a VM from a JSON manifest",
+		Long: `Create a new VM from a manifest produced by 'vmm manifest export'.
+
+The VM gets a fresh ID, MAC address and TAP device. Use --name to
+create it under a different name than the one in the manifest.
+
+Examples:
+  vmm manifest import myvm.json
+  vmm manifest import myvm.json --name myvm-copy`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return fmt.Errorf("failed to read manifest: %w", err)
 			}
 
-			// Save port forward to VM config
-			existingVM.PortForwards = append(existingVM.PortForwards, vm.PortForward{
-				HostPort:  hostPort,
-				GuestPort: guestPort,
-				Protocol:  "tcp",
-			})
-			existingVM.Save(paths.VMs)
+			newVM, err := vm.ImportManifest(data)
+			if err != nil {
+				return err
+			}
+			if importName != "" {
+				if err := vm.ValidateName(importName); err != nil {
+					return err
+				}
+				newVM.Name = importName
+			}
 
-			fmt.Printf("Port forward added: %d -> %s:%d\n", hostPort, existingVM.IPAddress, guestPort)
+			if err := cfg.EnsureDirectories(); err != nil {
+				return fmt.Errorf("failed to create directories: %w", err)
+			}
+			paths := cfg.GetPaths()
+
+			if err := createVMFromManifest(newVM, paths); err != nil {
+				return err
+			}
+
+			fmt.Printf("Created VM '%s' (ID: %s) from manifest\n", newVM.Name, newVM.ID)
 			return nil
 		},
 	}
+	importCmd.Flags().StringVar(&importName, "name", "", "Override the VM name from the manifest")
 
+	cmd.AddCommand(exportCmd, importCmd)
 	return cmd
 }
 
-func mountCmd() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:   "mount",
-		Short: "Manage VM directory mounts",
+// createVMFromManifest validates an imported VM against the host and saves it
+func createVMFromManifest(newVM *vm.VM, paths *config.Paths) error {
+	if vm.Exists(paths.VMs, newVM.Name) {
+		return fmt.Errorf("VM '%s' already exists", newVM.Name)
+	}
+	if err := validateVMForHost(newVM, paths); err != nil {
+		return err
 	}
 
-	syncCmd := &cobra.Command{
-		Use:   "sync <vm-name> <tag>",
-		Short: "Sync a mount image from host directory",
-		Long: `Refresh a mount image with the current contents of the host directory.
+	socketPath, err := paths.SocketPath(newVM.Name)
+	if err != nil {
+		return err
+	}
+	newVM.TapDevice = network.GenerateTapName(newVM.ID)
+	newVM.SocketPath = socketPath
+	if newVM.GuestAgent {
+		if newVM.VsockPath, err = paths.VsockPath(newVM.Name); err != nil {
+			return err
+		}
+	}
+	if err := allocateVMIP(newVM); err != nil {
+		return err
+	}
 
-This command updates the ext4 image used for the mount with the latest
-files from the host directory. The VM should be stopped when syncing.
+	if err := newVM.Save(paths.VMs); err != nil {
+		newIPAM().Release(newVM.Name)
+		return fmt.Errorf("failed to save VM config: %w", err)
+	}
+	return nil
+}
 
-Example:
-  vmm mount sync myvm code`,
-		Args: cobra.ExactArgs(2),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			vmName := args[0]
-			tag := args[1]
-			paths := cfg.GetPaths()
+// updateVMFromManifest applies a manifest to an existing VM, validating the result before it is saved
+// Images of mounts the manifest no longer has are deleted
+func updateVMFromManifest(manifest *vm.Manifest, paths *config.Paths) error {
+	existingVM, err := vm.Load(paths.VMs, manifest.Name)
+	if err != nil {
+		return err
+	}
 
-			// Load VM
-			existingVM, err := vm.Load(paths.VMs, vmName)
-			if err != nil {
-				return fmt.Errorf("VM '%s' not found", vmName)
-			}
+	removed := manifest.ApplyTo(existingVM)
+	if err := validateVMForHost(existingVM, paths); err != nil {
+		return err
+	}
+	if existingVM.GuestAgent && existingVM.VsockPath == "" {
+		if existingVM.VsockPath, err = paths.VsockPath(existingVM.Name); err != nil {
+			return err
+		}
+	}
+	if err := existingVM.Save(paths.VMs); err != nil {
+		return fmt.Errorf("failed to save VM config: %w", err)
+	}
 
-			// Check if VM is running
-			fcClient := firecracker.NewClient()
-			fcClient.UpdateVMState(existingVM)
-			if existingVM.State == vm.StateRunning {
-				return fmt.Errorf("VM '%s' is running. Stop it before syncing mounts", vmName)
+	mountMgr := newMountManager()
+	for i := range removed {
+		m := &removed[i]
+		if m.IsTmpfs() {
+			continue
+		}
+		if err := mountMgr.ReleaseMountImage(m, existingVM.Name); err != nil {
+			fmt.Printf("Warning: failed to delete mount image for '%s': %v\n", m.GuestTag, err)
+		}
+	}
+	return nil
+}
+
+// validateVMForHost checks that the images, kernel, initrd, DNS servers, mounts and drives a VM refers to are usable on this host
+func validateVMForHost(v *vm.VM, paths *config.Paths) error {
+	imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
+	if v.Image != "" && !imgMgr.ImageExists(v.Image) {
+		return fmt.Errorf("image '%s' not found. Use 'vmm image list' to see available images", v.Image)
+	}
+	if v.Kernel != "" && !imgMgr.KernelExists(v.Kernel) {
+		return fmt.Errorf("kernel '%s' not found. Use 'vmm kernel list' to see available kernels", v.Kernel)
+	}
+	if v.InitrdPath != "" {
+		if _, err := os.Stat(v.InitrdPath); err != nil {
+			return fmt.Errorf("initrd not found at %s", v.InitrdPath)
+		}
+	}
+	if err := firecracker.ValidateInit(v.Init); err != nil {
+		return fmt.Errorf("invalid init: %w", err)
+	}
+	if v.ModulesImage != "" {
+		if _, err := os.Stat(v.ModulesImage); err != nil {
+			return fmt.Errorf("modules image not found at %s", v.ModulesImage)
+		}
+	}
+	if err := image.ValidateDNSServers(v.DNSServers); err != nil {
+		return err
+	}
+	if err := image.ValidateSearchDomains(v.DNSSearch); err != nil {
+		return err
+	}
+	if err := image.ValidateNTPServers(v.NTPServers); err != nil {
+		return err
+	}
+	if len(v.NTPServers) > 0 && v.CloudInitUserData == "" {
+		return fmt.Errorf("ntp_servers requires cloud_init_user_data")
+	}
+	if err := image.ValidateEnvVars(v.EnvVars); err != nil {
+		return err
+	}
+	if v.CloudInitNetworkConfig != "" && v.CloudInitUserData == "" {
+		return fmt.Errorf("cloud_init_network_config requires cloud_init_user_data")
+	}
+	for _, path := range []string{v.CloudInitUserData, v.CloudInitNetworkConfig} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("cloud-init file not found at %s", path)
+		}
+	}
+	if limits := firecracker.NewCgroupLimits(v.CPULimit, v.MemoryLimitMB); limits != nil {
+		if err := limits.Validate(); err != nil {
+			return fmt.Errorf("invalid cpu_limit: %w", err)
+		}
+	}
+	if err := firecracker.ValidateCPUAffinity(v.CPUAffinity); err != nil {
+		return fmt.Errorf("invalid cpu_affinity: %w", err)
+	}
+	if _, err := firecracker.ParseCacheType(v.DiskCache); err != nil {
+		return fmt.Errorf("invalid disk_cache: %w", err)
+	}
+	if _, err := firecracker.ParseIOEngine(v.DiskIOEngine); err != nil {
+		return fmt.Errorf("invalid disk_io_engine: %w", err)
+	}
+
+	mountMgr := newMountManager()
+	for _, m := range v.Mounts {
+		if err := mount.ValidateTag(m.GuestTag); err != nil {
+			return err
+		}
+		if err := mount.ValidateMkfsOptions(&m); err != nil {
+			return err
+		}
+		if _, err := mount.ParseSyncMode(m.SyncMode); err != nil {
+			return err
+		}
+		if err := mount.CheckEncryption(&m); err != nil {
+			return err
+		}
+		if m.IsArchive() {
+			if m.SyncMode == string(mount.SyncModeMerge) {
+				return fmt.Errorf("archive mount '%s' cannot use the %s sync mode", m.GuestTag, mount.SyncModeMerge)
 			}
+			if info, err := os.Stat(m.ArchivePath); err != nil || info.IsDir() {
+				return fmt.Errorf("archive '%s' for mount '%s' does not exist", m.ArchivePath, m.GuestTag)
+			}
+		}
+		for _, path := range m.SourcePaths() {
+			if _, err := os.Stat(path); err != nil {
+				return fmt.Errorf("host path '%s' for mount '%s' does not exist", path, m.GuestTag)
+			}
+		}
+		if err := mountMgr.ValidateSourcePaths(&m); err != nil {
+			return err
+		}
+	}
+	if err := validateMountDrives(v.Mounts); err != nil {
+		return err
+	}
+	for _, d := range v.Drives {
+		if _, err := firecracker.CheckDrivePath(d.Path); err != nil {
+			return err
+		}
+	}
+	if err := checkHostDrives(v, false); err != nil {
+		return err
+	}
+	return validateMountPaths(v.Mounts, v.ModulesImage != "")
+}
 
-			// Find the mount with the given tag
-			var targetMount *vm.Mount
-			for i := range existingVM.Mounts {
-				if existingVM.Mounts[i].GuestTag == tag {
-					targetMount = &existingVM.Mounts[i]
-					break
-				}
+// validateMountDrives checks the mounts' explicit drive IDs: valid, unique and not on a tmpfs
+func validateMountDrives(mounts []vm.Mount) error {
+	used := make(map[string]string)
+	for _, m := range mounts {
+		if m.DriveID == "" {
+			continue
+		}
+		if m.IsTmpfs() {
+			return fmt.Errorf("tmpfs mount '%s' has no drive to name", m.GuestTag)
+		}
+		if err := firecracker.ValidateMountDriveID(m.DriveID); err != nil {
+			return fmt.Errorf("mount '%s': %w", m.GuestTag, err)
+		}
+		if other, dup := used[m.DriveID]; dup {
+			return fmt.Errorf("mounts '%s' and '%s' both use drive ID '%s'", other, m.GuestTag, m.DriveID)
+		}
+		used[m.DriveID] = m.GuestTag
+	}
+	return nil
+}
+
+func applyCmd() *cobra.Command {
+	var prune bool
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "apply <manifest-file>",
+		Short: "Create, update and start VMs to match a manifest",
+		Long: `Declaratively manage a group of VMs from a manifest file.
+
+The manifest lists VMs in the same format as 'vmm manifest export',
+wrapped in a "vms" array:
+
+  {
+    "version": 1,
+    "vms": [
+      {"name": "web", "cpus": 2, "memory_mb": 1024, "disk_size_mb": 2048, "auto_start": true},
+      {"name": "db", "cpus": 2, "memory_mb": 2048, "disk_size_mb": 8192, "auto_start": true}
+    ]
+  }
+
+Missing VMs are created, changed VMs have their configuration updated
+(running VMs pick up changes on their next restart), and stopped VMs are
+started. With --prune, VMs that are not in the manifest are stopped and
+deleted. The plan is printed before anything is changed.
+
+Examples:
+  vmm apply cluster.json --dry-run
+  vmm apply cluster.json --prune`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return applyManifest(args[0], prune, dryRun)
+		},
+	}
+
+	cmd.Flags().BoolVar(&prune, "prune", false, "Delete VMs that are not in the manifest")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the plan without applying it")
+
+	return cmd
+}
+
+// applyAction is a single step of an apply plan
+type applyAction struct {
+	Kind     string // create, update, start, delete
+	Name     string
+	Changes  []string
+	Manifest *vm.Manifest
+}
+
+// printMountEstimates prints the projected size of the mount images that the plan's creates would build
+func printMountEstimates(actions []applyAction) {
+	mountMgr := newMountManager()
+	header := false
+	for _, a := range actions {
+		if a.Kind != "create" {
+			continue
+		}
+		for _, m := range a.Manifest.ToVM().Mounts {
+			if m.IsTmpfs() {
+				continue
 			}
+			if !header {
+				fmt.Println("\nMount images to create:")
+				header = true
+			}
+			if sizeMB, err := mountMgr.EstimateMountImageSize(&m); err != nil {
+				fmt.Printf("  %s/%s: cannot estimate: %v\n", a.Name, m.GuestTag, err)
+			} else {
+				fmt.Printf("  %s/%s: %d MB\n", a.Name, m.GuestTag, sizeMB)
+			}
+		}
+	}
+}
 
-			if targetMount == nil {
-				return fmt.Errorf("mount '%s' not found in VM '%s'", tag, vmName)
+// printDriveLayouts shows the block devices each VM to create or update will see at its next start
+func printDriveLayouts(actions []applyAction) {
+	for _, a := range actions {
+		if a.Kind != "create" && a.Kind != "update" {
+			continue
+		}
+		v := a.Manifest.ToVM()
+		if existing, err := vm.Load(cfg.GetPaths().VMs, a.Name); err == nil {
+			a.Manifest.ApplyTo(existing)
+			v = existing
+		}
+		fmt.Printf("\nGuest drives of %s:\n", a.Name)
+		for _, drive := range driveLayout(v) {
+			mode := "rw"
+			if drive.ReadOnly {
+				mode = "ro"
+			}
+			label := drive.Kind
+			if drive.Tag != "" {
+				label += " " + drive.Tag
 			}
+			fmt.Printf("  %-9s %-14s %s (%s) [drive %s]\n", drive.Device, label, drive.HostPath, mode, drive.ID)
+		}
+	}
+}
 
-			// Sync the mount
-			fmt.Printf("Syncing mount '%s' for VM '%s'...\n", tag, vmName)
-			mountMgr := mount.NewManager(paths.Mounts)
-			if err := mountMgr.SyncMountImage(targetMount, vmName); err != nil {
-				return fmt.Errorf("failed to sync mount: %w", err)
+// planApply compares the manifest set with existing VMs and returns the steps needed to converge
+func planApply(set *vm.ManifestSet, prune bool) ([]applyAction, error) {
+	paths := cfg.GetPaths()
+
+	existing, err := vm.List(paths.VMs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VMs: %w", err)
+	}
+	existingByName := make(map[string]*vm.VM)
+	fcClient := firecracker.NewClient()
+	for _, v := range existing {
+		fcClient.UpdateVMState(v)
+		existingByName[v.Name] = v
+	}
+
+	var actions []applyAction
+	desired := make(map[string]bool)
+	for i := range set.VMs {
+		manifest := &set.VMs[i]
+		desired[manifest.Name] = true
+
+		current, ok := existingByName[manifest.Name]
+		if !ok {
+			actions = append(actions,
+				applyAction{Kind: "create", Name: manifest.Name, Manifest: manifest},
+				applyAction{Kind: "start", Name: manifest.Name})
+			continue
+		}
+
+		if changes := vm.NewManifest(current).Diff(manifest); len(changes) > 0 {
+			actions = append(actions, applyAction{Kind: "update", Name: manifest.Name, Changes: changes, Manifest: manifest})
+		}
+		if current.State != vm.StateRunning {
+			actions = append(actions, applyAction{Kind: "start", Name: manifest.Name})
+		}
+	}
+
+	if prune {
+		for _, v := range existing {
+			if !desired[v.Name] {
+				actions = append(actions, applyAction{Kind: "delete", Name: v.Name})
 			}
+		}
+	}
 
-			// Save updated mount image path
-			existingVM.Save(paths.VMs)
+	return actions, nil
+}
 
-			fmt.Printf("Mount '%s' synced successfully\n", tag)
-			return nil
-		},
+// applyManifest converges the host's VMs to the given manifest file
+func applyManifest(manifestPath string, prune, dryRun bool) error {
+	set, err := vm.LoadManifestSet(manifestPath)
+	if err != nil {
+		return err
 	}
 
-	listCmd := &cobra.Command{
-		Use:   "list <vm-name>",
-		Short: "List mounts for a VM",
-		Args:  cobra.ExactArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			vmName := args[0]
-			paths := cfg.GetPaths()
+	actions, err := planApply(set, prune)
+	if err != nil {
+		return err
+	}
 
-			// Load VM
-			existingVM, err := vm.Load(paths.VMs, vmName)
-			if err != nil {
-				return fmt.Errorf("VM '%s' not found", vmName)
+	if len(actions) == 0 {
+		fmt.Println("No changes. VMs match the manifest.")
+		return nil
+	}
+
+	fmt.Println("Plan:")
+	symbols := map[string]string{"create": "+", "update": "~", "start": ">", "delete": "-"}
+	for _, a := range actions {
+		fmt.Printf("  %s %s %s\n", symbols[a.Kind], a.Kind, a.Name)
+		for _, change := range a.Changes {
+			fmt.Printf("      %s\n", change)
+		}
+	}
+
+	if dryRun {
+		printDriveLayouts(actions)
+		printMountEstimates(actions)
+		return nil
+	}
+	fmt.Println()
+
+	if err := cfg.EnsureDirectories(); err != nil {
+		return fmt.Errorf("failed to create directories: %w", err)
+	}
+	paths := cfg.GetPaths()
+
+	var failed []string
+	failedNames := make(map[string]bool)
+	for _, a := range actions {
+		// Skip remaining steps for a VM whose earlier step failed
+		if failedNames[a.Name] {
+			continue
+		}
+
+		var err error
+		switch a.Kind {
+		case "create":
+			err = createVMFromManifest(a.Manifest.ToVM(), paths)
+			if err == nil {
+				fmt.Printf("Created VM '%s'\n", a.Name)
 			}
+		case "update":
+			err = updateVMFromManifest(a.Manifest, paths)
+			if err == nil {
+				fmt.Printf("Updated VM '%s' (restart it to apply changes if running)\n", a.Name)
+			}
+		case "start":
+			err = startVM(a.Name, startOptions{VerifyMounts: cfg.VerifyMounts})
+		case "delete":
+			err = deleteVM(a.Name, true)
+		}
+		if err != nil {
+			fmt.Printf("Error: %s %s: %v\n", a.Kind, a.Name, err)
+			failed = append(failed, a.Name)
+			failedNames[a.Name] = true
+		}
+	}
 
-			if len(existingVM.Mounts) == 0 {
-				fmt.Printf("VM '%s' has no mounts configured\n", vmName)
+	if len(failed) > 0 {
+		return fmt.Errorf("apply finished with errors for: %v", failed)
+	}
+	fmt.Println("Apply complete")
+	return nil
+}
+
+func checkCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "check",
+		Short: "Check that this host can run VMs",
+		Long: `Report everything vmm needs that is missing on this host: the
+Firecracker binary, host tools such as ip and mkfs.ext4, root capabilities
+and access to /dev/kvm. Every problem is listed at once.
+
+Example:
+  sudo vmm check`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			errs := firecracker.NewClient().CheckPrerequisites()
+			if len(errs) == 0 {
+				fmt.Println("This host is ready to run VMs")
 				return nil
 			}
-
-			fmt.Printf("Mounts for VM '%s':\n", vmName)
-			for i, m := range existingVM.Mounts {
-				mode := "rw"
-				if m.ReadOnly {
-					mode = "ro"
-				}
-				deviceLetter := string(rune('b' + i))
-				fmt.Printf("  %s: %s -> /mnt/%s (%s) [/dev/vd%s]\n",
-					m.GuestTag, m.HostPath, m.GuestTag, mode, deviceLetter)
-				if m.ImagePath != "" {
-					fmt.Printf("       Image: %s\n", m.ImagePath)
-				}
+			fmt.Println("This host is missing prerequisites:")
+			for _, err := range errs {
+				fmt.Printf("  - %v\n", err)
 			}
-			return nil
+			return fmt.Errorf("%d prerequisite(s) missing", len(errs))
 		},
 	}
-
-	cmd.AddCommand(syncCmd, listCmd)
-	return cmd
 }
 
 func versionCmd() *cobra.Command {
@@ -1271,6 +4109,7 @@ func autostartCmd() *cobra.Command {
 
 			fcClient := firecracker.NewClient()
 			imgMgr := image.NewManager(paths.Kernels, paths.Rootfs)
+			imgMgr.CheckQuota = cfg.CheckDiskQuota
 			netMgr := network.NewManager(cfg.BridgeName, cfg.Subnet, cfg.Gateway, cfg.HostInterface)
 
 			// Ensure bridge exists first
@@ -1279,7 +4118,7 @@ func autostartCmd() *cobra.Command {
 			}
 
 			started := 0
-			for i, v := range vms {
+			for _, v := range vms {
 				// Skip VMs not marked for autostart
 				if !v.AutoStart {
 					continue
@@ -1292,68 +4131,29 @@ func autostartCmd() *cobra.Command {
 					continue
 				}
 
-				fmt.Printf("Auto-starting VM '%s'...\n", v.Name)
-
-				// Ensure images
-				if err := imgMgr.EnsureDefaultImages(); err != nil {
-					fmt.Printf("  Error: failed to ensure images: %v\n", err)
+				// Booting would bypass the saved memory and leave its disks inconsistent with it
+				if v.MemSnapshot != "" {
+					fmt.Printf("VM '%s' is suspended; resume it with 'vmm start %s'\n", v.Name, v.Name)
 					continue
 				}
-
-				// Create rootfs if needed
-				vmRootfs, err := imgMgr.CreateVMRootfs(v.Name, paths.VMs, v.DiskSizeMB, v.Image)
-				if err != nil {
-					fmt.Printf("  Error: failed to create rootfs: %v\n", err)
+				if err := checkHostDrives(v, true); err != nil {
+					fmt.Printf("VM '%s' not started: %v\n", v.Name, err)
 					continue
 				}
-				v.RootfsPath = vmRootfs
 
-				// Set kernel path based on custom kernel or default
-				v.KernelPath = imgMgr.GetKernelPath(v.Kernel)
-
-				// Inject SSH key if configured
-				if v.SSHPublicKey != "" {
-					if err := image.InjectSSHKey(v.RootfsPath, v.SSHPublicKey); err != nil {
-						fmt.Printf("  Warning: failed to inject SSH key: %v\n", err)
-					}
-				}
+				fmt.Printf("Auto-starting VM '%s'...\n", v.Name)
 
-				// Inject DNS configuration
-				if err := image.InjectDNSConfig(v.RootfsPath, v.DNSServers); err != nil {
-					fmt.Printf("  Warning: failed to inject DNS config: %v\n", err)
+				// Mounts whose image can't be recreated are left out below, like other mount failures
+				if err := preflightMountImages(v, false); err != nil {
+					fmt.Printf("  Warning: %v\n", strings.ReplaceAll(err.Error(), "\n", "\n    "))
 				}
 
-				// Create mount images and configure fstab
-				var mountDrives []firecracker.MountDrive
-				if len(v.Mounts) > 0 {
-					mountMgr := mount.NewManager(paths.Mounts)
-					var mountEntries []image.MountEntry
-					for j := range v.Mounts {
-						m := &v.Mounts[j]
-						if err := mountMgr.CreateMountImage(m, v.Name); err != nil {
-							fmt.Printf("  Warning: failed to create mount image for '%s': %v\n", m.GuestTag, err)
-							continue
-						}
-						deviceLetter := string(rune('b' + j))
-						device := fmt.Sprintf("/dev/vd%s", deviceLetter)
-						mountPath := fmt.Sprintf("/mnt/%s", m.GuestTag)
-						mountEntries = append(mountEntries, image.MountEntry{
-							Device:    device,
-							MountPath: mountPath,
-							ReadOnly:  m.ReadOnly,
-						})
-						mountDrives = append(mountDrives, firecracker.MountDrive{
-							ImagePath: m.ImagePath,
-							Tag:       m.GuestTag,
-							ReadOnly:  m.ReadOnly,
-						})
-					}
-					if len(mountEntries) > 0 {
-						if err := image.InjectMountFstab(v.RootfsPath, mountEntries); err != nil {
-							fmt.Printf("  Warning: failed to inject mount fstab: %v\n", err)
-						}
-					}
-					v.Save(paths.VMs)
+				mountDrives, modulesImage, err := prepareVMDisks(v, cfg.VerifyMounts, func(err error) {
+					fmt.Printf("  Warning: %v\n", err)
+				})
+				if err != nil {
+					fmt.Printf("  Error: %v\n", err)
+					continue
 				}
 
 				// Create TAP if needed
@@ -1364,36 +4164,75 @@ func autostartCmd() *cobra.Command {
 					}
 				}
 
-				// Allocate IP
-				ip, _ := netMgr.AllocateIP(i)
-				v.IPAddress = ip
+				if err := allocateVMIP(v); err != nil {
+					fmt.Printf("  Error: %v\n", err)
+					continue
+				}
+				refreshBridgeDNS()
 
 				// Start VM
 				ctx := context.Background()
+				v.LogPath = fmt.Sprintf("%s/%s.log", paths.Logs, v.Name)
 				vmCfg := &firecracker.VMConfig{
-					SocketPath:  v.SocketPath,
-					KernelPath:  v.KernelPath,
-					RootfsPath:  v.RootfsPath,
-					CPUs:        v.CPUs,
-					MemoryMB:    v.MemoryMB,
-					TapDevice:   v.TapDevice,
-					MacAddress:  v.MacAddress,
-					LogPath:     fmt.Sprintf("%s/%s.log", paths.Logs, v.Name),
-					IPAddress:   v.IPAddress,
-					Gateway:     cfg.Gateway,
-					MountDrives: mountDrives,
-				}
-
-				machine, err := fcClient.StartVM(ctx, vmCfg)
+					SocketPath:   v.SocketPath,
+					KernelPath:   v.KernelPath,
+					InitrdPath:   v.InitrdPath,
+					RootfsPath:   v.RootfsPath,
+					CPUs:         v.CPUs,
+					MemoryMB:     v.MemoryMB,
+					TapDevice:    v.TapDevice,
+					MacAddress:   v.MacAddress,
+					LogPath:      v.LogPath,
+					LogRotation:  firecracker.NewLogRotation(cfg.LogMaxSizeMB, cfg.LogRetention),
+					IPAddress:    v.IPAddress,
+					Gateway:      cfg.Gateway,
+					DNSServers:   vmDNSServers(v),
+					MountDrives:  mountDrives,
+					VsockPath:    v.VsockPath,
+					Name:         v.Name,
+					CgroupLimits: firecracker.NewCgroupLimits(v.CPULimit, v.MemoryLimitMB),
+
+					ModulesImagePath: modulesImage,
+					ExtraDrives:      hostDrives(v),
+					Init:             v.Init,
+					KernelArgs:       v.ExtraKernelArgs,
+					RootfsReadOnly:   v.RootReadOnly,
+					RootfsCacheType:  v.DiskCache,
+					RootfsIOEngine:   v.DiskIOEngine,
+					WritableScratch:  hasTmpfsMount(v),
+
+					ReplaceKernelArgs: v.ReplaceKernelArgs,
+				}
+				setMemoryRange(vmCfg, v)
+				vmCfg.CPUAffinity = v.CPUAffinity
+				vmCfg.SeedISOPath = seedISOPath(v)
+				if v.Verity {
+					hashTree, rootHash, err := image.GenerateVerity(v.RootfsPath)
+					if err != nil {
+						fmt.Printf("  Error: failed to build verity hash tree: %v\n", err)
+						v.State = vm.StateError
+						v.Save(paths.VMs)
+						closeMountDevices(v)
+						continue
+					}
+					vmCfg.VerityHashTreePath = hashTree
+					vmCfg.VerityRootHash = rootHash
+				}
+
+				fcClient.PreStartHook = preStartHook(v.PreStartHook)
+				result, err := fcClient.StartVM(ctx, vmCfg)
 				if err != nil {
 					fmt.Printf("  Error: failed to start: %v\n", err)
 					v.State = vm.StateError
 					v.Save(paths.VMs)
+					closeMountDevices(v)
 					continue
 				}
 
 				v.State = vm.StateRunning
-				v.PID = fcClient.GetVMPID(machine)
+				v.PID = fcClient.GetVMPID(result.Machine)
+				v.MountDriveIDs = result.MountDriveIDs
+				v.KernelArgs = result.KernelArgs
 				v.StartedAt = time.Now()
 				v.Save(paths.VMs)
 
@@ -1421,6 +4260,7 @@ func autostopCmd() *cobra.Command {
 			}
 
 			fcClient := firecracker.NewClient()
+			fcClient.PostStopHook = runPostStopHook
 			stopped := 0
 
 			for _, v := range vms {
@@ -1432,20 +4272,30 @@ func autostopCmd() *cobra.Command {
 				fmt.Printf("Stopping VM '%s'...\n", v.Name)
 
 				ctx := context.Background()
-				if err := fcClient.StopVM(ctx, v.SocketPath); err != nil {
+				if err := fcClient.StopVMWithOptions(ctx, v.SocketPath, stopOptions(v)); err != nil {
 					// Try SIGKILL as fallback
 					if v.PID > 0 {
 						if proc, err := os.FindProcess(v.PID); err == nil {
 							proc.Signal(syscall.SIGKILL)
+							time.Sleep(500 * time.Millisecond)
 						}
 					}
+					if !fcClient.IsRunning(v.SocketPath, v.PID) {
+						fcClient.RunPostStopHook(v)
+					}
 				}
 
 				v.State = vm.StateStopped
 				v.PID = 0
+				discardSnapshot(v)
 				v.Save(paths.VMs)
 
 				os.Remove(v.SocketPath)
+				if v.VsockPath != "" {
+					os.Remove(v.VsockPath)
+				}
+				firecracker.RemoveCgroup(v.Name)
+				closeMountDevices(v)
 				stopped++
 			}
 
@@ -36,6 +36,18 @@ type Config struct {
 	KernelPath    string      `json:"kernel_path"`
 	RootfsPath    string      `json:"rootfs_path"`
 	VMDefaults    *VMDefaults `json:"vm_defaults,omitempty"`
+	VerifyMounts  bool        `json:"verify_mounts,omitempty"` // Verify mount images after they are created or synced at start
+	LogMaxSizeMB  int         `json:"log_max_size_mb,omitempty"` // Size at which a VM log is rotated on start (0 = default)
+	LogRetention  int         `json:"log_retention,omitempty"`   // Rotated VM logs kept per VM (0 = default)
+	TrimOnStop    bool        `json:"trim_on_stop,omitempty"`    // Trim a VM's rootfs and mount images after 'vmm stop'
+	MountTempDir  string      `json:"mount_temp_dir,omitempty"`  // Where mount images are temporarily mounted (empty = the system temp dir)
+	BridgeDNS     bool        `json:"bridge_dns,omitempty"`      // Run dnsmasq on the bridge so VMs resolve each other by name
+
+	// MaxMountSyncSeconds cancels a mount image create or sync that runs longer than this (0 = no limit)
+	MaxMountSyncSeconds int `json:"max_mount_sync_seconds,omitempty"`
+
+	// MaxTotalDiskBytes caps the host disk all VM rootfs, snapshot and mount images may use (0 = no limit)
+	MaxTotalDiskBytes int64 `json:"max_total_disk_bytes,omitempty"`
 }
 
 // GetVMDefaults returns the VM defaults, or an empty struct if none configured
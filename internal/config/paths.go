@@ -0,0 +1,34 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// maxSocketPathLen is the longest path a unix socket can be bound to: sun_path is 108 bytes with the NUL
+const maxSocketPathLen = 107
+
+// vsockPortSuffixLen is room for the _<port> Firecracker appends to the vsock socket for guest-initiated connections
+const vsockPortSuffixLen = 11
+
+// SocketPath returns the Firecracker API socket path for a VM: <sockets>/<name>.sock
+// VM names are unique, so so are their sockets. An error is returned if the path is too long
+// for a unix socket, which happens with a long VM name or data directory
+func (p *Paths) SocketPath(vmName string) (string, error) {
+	return p.socketPath(vmName, ".sock", 0)
+}
+
+// VsockPath returns the guest vsock socket path for a VM: <sockets>/<name>.vsock
+func (p *Paths) VsockPath(vmName string) (string, error) {
+	return p.socketPath(vmName, ".vsock", vsockPortSuffixLen)
+}
+
+// socketPath builds a socket path in the sockets directory, leaving reserve bytes for suffixes added to it
+func (p *Paths) socketPath(vmName, ext string, reserve int) (string, error) {
+	path := filepath.Join(p.Sockets, vmName+ext)
+	if limit := maxSocketPathLen - reserve; len(path) > limit {
+		return "", fmt.Errorf("socket path %s is %d bytes, over the %d byte unix socket limit; use a VM name at least %d characters shorter",
+			path, len(path), limit, len(path)-limit)
+	}
+	return path, nil
+}
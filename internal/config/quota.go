@@ -0,0 +1,70 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// ErrDiskQuota is returned when creating a disk image would take VMs over MaxTotalDiskBytes
+var ErrDiskQuota = errors.New("disk quota exceeded")
+
+// VMDiskUsage returns the host disk allocated to VMs: every file under the VMs and mounts
+// directories, which hold the rootfs, snapshot and mount images. Sparse files count only
+// their allocated blocks
+func (p *Paths) VMDiskUsage() (int64, error) {
+	var total int64
+	for _, dir := range []string{p.VMs, p.Mounts} {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				// Removed while walking
+				return nil
+			}
+			if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+				total += stat.Blocks * 512
+			} else {
+				total += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to measure disk usage of %s: %w", dir, err)
+		}
+	}
+	return total, nil
+}
+
+// CheckDiskQuota returns an ErrDiskQuota error if allocating need more bytes for a VM image
+// would take VMs over MaxTotalDiskBytes. There is no quota when it is 0
+func (c *Config) CheckDiskQuota(need int64) error {
+	if c.MaxTotalDiskBytes <= 0 {
+		return nil
+	}
+	used, err := c.GetPaths().VMDiskUsage()
+	if err != nil {
+		return err
+	}
+	if used+need > c.MaxTotalDiskBytes {
+		return fmt.Errorf("%w: VMs use %s of the %s quota and this needs %s more; delete, trim or compact VMs to free space",
+			ErrDiskQuota, formatMB(used), formatMB(c.MaxTotalDiskBytes), formatMB(need))
+	}
+	return nil
+}
+
+// formatMB formats a byte count in MB
+func formatMB(bytes int64) string {
+	return fmt.Sprintf("%.1f MB", float64(bytes)/(1024*1024))
+}
@@ -0,0 +1,137 @@
+package firecracker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// vcpuThreadPrefix is how Firecracker names its vCPU threads: "fc_vcpu 0", "fc_vcpu 1", ...
+const vcpuThreadPrefix = "fc_vcpu"
+
+// onlineCPUsPath lists the host CPUs that are online, as ranges such as 0-7,9
+const onlineCPUsPath = "/sys/devices/system/cpu/online"
+
+// ValidateCPUAffinity checks that every CPU in a vCPU affinity list is an online host CPU
+func ValidateCPUAffinity(cpus []int) error {
+	if len(cpus) == 0 {
+		return nil
+	}
+	data, err := os.ReadFile(onlineCPUsPath)
+	if err != nil {
+		return fmt.Errorf("failed to read online CPUs: %w", err)
+	}
+	online, err := parseCPUList(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", onlineCPUsPath, err)
+	}
+	for _, cpu := range cpus {
+		if !online[cpu] {
+			return fmt.Errorf("CPU %d is not an online host CPU (online: %s)", cpu, strings.TrimSpace(string(data)))
+		}
+	}
+	return nil
+}
+
+// parseCPUList parses a kernel CPU list such as 0-3,8,10-11
+func parseCPUList(list string) (map[int]bool, error) {
+	cpus := make(map[int]bool)
+	for _, part := range strings.Split(list, ",") {
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CPU '%s'", part)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(last); err != nil || end < start {
+				return nil, fmt.Errorf("invalid CPU range '%s'", part)
+			}
+		}
+		for cpu := start; cpu <= end; cpu++ {
+			cpus[cpu] = true
+		}
+	}
+	return cpus, nil
+}
+
+// vcpuThreads returns the thread IDs of a Firecracker process's vCPU threads, ordered by vCPU index
+func vcpuThreads(pid int) ([]int, error) {
+	taskDir := fmt.Sprintf("/proc/%d/task", pid)
+	entries, err := os.ReadDir(taskDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list threads of process %d: %w", pid, err)
+	}
+
+	type vcpuThread struct{ index, tid int }
+	var threads []vcpuThread
+	for _, entry := range entries {
+		tid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		comm, err := os.ReadFile(filepath.Join(taskDir, entry.Name(), "comm"))
+		if err != nil {
+			continue // The thread has exited
+		}
+		name := strings.TrimSpace(string(comm))
+		if !strings.HasPrefix(name, vcpuThreadPrefix) {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(name, vcpuThreadPrefix)))
+		if err != nil {
+			continue
+		}
+		threads = append(threads, vcpuThread{index, tid})
+	}
+	sort.Slice(threads, func(i, j int) bool { return threads[i].index < threads[j].index })
+
+	tids := make([]int, len(threads))
+	for i, thread := range threads {
+		tids[i] = thread.tid
+	}
+	return tids, nil
+}
+
+// PinVCPUs pins a running VM's vCPU threads to host CPUs: vCPU i runs only on cpus[i % len(cpus)],
+// so giving one CPU per vCPU pins them one to one
+func PinVCPUs(pid int, cpus []int) error {
+	if len(cpus) == 0 {
+		return nil
+	}
+	tids, err := vcpuThreads(pid)
+	if err != nil {
+		return err
+	}
+	if len(tids) == 0 {
+		return fmt.Errorf("no vCPU threads found in process %d", pid)
+	}
+	for i, tid := range tids {
+		if err := setAffinity(tid, cpus[i%len(cpus)]); err != nil {
+			return fmt.Errorf("failed to pin vCPU %d to CPU %d: %w", i, cpus[i%len(cpus)], err)
+		}
+	}
+	return nil
+}
+
+// setAffinity restricts a thread to a single host CPU with sched_setaffinity
+func setAffinity(tid, cpu int) error {
+	var mask [1024 / 64]uint64 // cpu_set_t
+	if cpu < 0 || cpu >= len(mask)*64 {
+		return fmt.Errorf("CPU %d is out of range", cpu)
+	}
+	mask[cpu/64] |= 1 << (uint(cpu) % 64)
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, uintptr(tid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
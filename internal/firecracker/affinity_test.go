@@ -0,0 +1,23 @@
+package firecracker
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestParseCPUList(t *testing.T) {
+	got, err := parseCPUList("0-2,5,7-8")
+	if err != nil {
+		t.Fatalf("parseCPUList() error = %v", err)
+	}
+	want := map[int]bool{0: true, 1: true, 2: true, 5: true, 7: true, 8: true}
+	if !maps.Equal(got, want) {
+		t.Errorf("parseCPUList() = %v, want %v", got, want)
+	}
+
+	for _, list := range []string{"a", "3-1", "1-x"} {
+		if _, err := parseCPUList(list); err == nil {
+			t.Errorf("parseCPUList(%q) = nil error, want one", list)
+		}
+	}
+}
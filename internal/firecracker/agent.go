@@ -0,0 +1,134 @@
+package firecracker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// GuestAgentPort is the vsock port the guest agent listens on
+	GuestAgentPort = 52
+
+	// GuestAgentCID is the context ID assigned to the guest's vsock device
+	GuestAgentCID = 3
+
+	// DefaultAgentTimeout bounds how long to wait for the guest agent to respond
+	DefaultAgentTimeout = 10 * time.Second
+)
+
+// AgentRequest is sent to the guest agent as a single JSON line
+//
+// Wire protocol: the host connects to Firecracker's vsock UDS, sends
+// "CONNECT <GuestAgentPort>\n" and waits for "OK <port>\n". It then writes one
+// JSON request line, e.g. {"command":"shutdown"}, and reads one JSON response
+// line, e.g. {"status":"ok"}. For "shutdown" the agent should reply before
+// rebooting the guest (with reboot=k, a guest reboot makes Firecracker exit).
+// "unmount" and "mount" name a mount by its tag and guest path, e.g.
+// {"command":"unmount","tag":"code","path":"/mnt/code"}, and should unmount or
+// mount the path before replying.
+type AgentRequest struct {
+	Command string `json:"command"`
+	Tag     string `json:"tag,omitempty"`  // Mount tag for unmount and mount
+	Path    string `json:"path,omitempty"` // Mount point in the guest for unmount and mount
+}
+
+// AgentResponse is the guest agent's single JSON line reply
+type AgentResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// sendAgentCommand connects to the guest agent through the vsock UDS and sends a single command
+func sendAgentCommand(ctx context.Context, vsockPath, command string) (*AgentResponse, error) {
+	return sendAgentRequest(ctx, vsockPath, AgentRequest{Command: command})
+}
+
+// sendAgentRequest connects to the guest agent through the vsock UDS and sends a single request
+func sendAgentRequest(ctx context.Context, vsockPath string, request AgentRequest) (*AgentResponse, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", vsockPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to vsock: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Firecracker's host-initiated vsock handshake
+	if _, err := fmt.Fprintf(conn, "CONNECT %d\n", GuestAgentPort); err != nil {
+		return nil, fmt.Errorf("failed to send vsock connect: %w", err)
+	}
+	reader := bufio.NewReader(conn)
+	ack, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("guest agent not responding: %w", err)
+	}
+	if !strings.HasPrefix(ack, "OK ") {
+		return nil, fmt.Errorf("unexpected vsock handshake reply: %q", strings.TrimSpace(ack))
+	}
+
+	req, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append(req, '\n')); err != nil {
+		return nil, fmt.Errorf("failed to send agent request: %w", err)
+	}
+
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agent response: %w", err)
+	}
+	var resp AgentResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, fmt.Errorf("invalid agent response: %w", err)
+	}
+	if resp.Status != "ok" {
+		return &resp, fmt.Errorf("guest agent refused %s: %s", request.Command, resp.Error)
+	}
+	return &resp, nil
+}
+
+// shutdownViaAgent asks the guest agent to shut down and waits for Firecracker to exit
+func (c *Client) shutdownViaAgent(ctx context.Context, socketPath, vsockPath string, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = DefaultAgentTimeout
+	}
+
+	agentCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if _, err := sendAgentCommand(agentCtx, vsockPath, "shutdown"); err != nil {
+		return err
+	}
+
+	// The agent confirmed; wait for the VMM to go away
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for {
+		if !apiSocketAlive(socketPath) {
+			return nil
+		}
+		select {
+		case <-waitCtx.Done():
+			return fmt.Errorf("guest agent acknowledged shutdown but VM did not exit within %s", timeout)
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+}
+
+// apiSocketAlive reports whether something is accepting connections on the Firecracker API socket
+func apiSocketAlive(socketPath string) bool {
+	conn, err := net.DialTimeout("unix", socketPath, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
@@ -0,0 +1,96 @@
+package firecracker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	sdk "github.com/firecracker-microvm/firecracker-go-sdk"
+)
+
+// balloonStatsIntervalSeconds is how often the guest balloon driver reports memory statistics
+const balloonStatsIntervalSeconds = 1
+
+// hasMemoryRange reports whether the VM's memory is resized at runtime with a balloon
+func (cfg *VMConfig) hasMemoryRange() bool {
+	return cfg.MinMemoryMB > 0 || cfg.MaxMemoryMB > 0
+}
+
+// guestMemoryMB returns the memory the VM is booted with: the top of its range, if it has one
+func (cfg *VMConfig) guestMemoryMB() int {
+	if cfg.MaxMemoryMB > 0 {
+		return cfg.MaxMemoryMB
+	}
+	return cfg.MemoryMB
+}
+
+// validateMemoryRange checks that a memory range has both ends, in order
+func validateMemoryRange(cfg *VMConfig) error {
+	if !cfg.hasMemoryRange() {
+		return nil
+	}
+	if cfg.MinMemoryMB <= 0 || cfg.MaxMemoryMB <= 0 {
+		return fmt.Errorf("a memory range needs both a minimum and a maximum")
+	}
+	if cfg.MinMemoryMB > cfg.MaxMemoryMB {
+		return fmt.Errorf("minimum memory %d MB is larger than the maximum %d MB", cfg.MinMemoryMB, cfg.MaxMemoryMB)
+	}
+	return nil
+}
+
+// balloonHandler inflates the balloon at boot so the guest starts with its minimum memory
+// The balloon deflates by itself if the guest runs out of memory
+func balloonHandler(cfg *VMConfig) sdk.Handler {
+	return sdk.NewCreateBalloonHandler(int64(cfg.MaxMemoryMB-cfg.MinMemoryMB), true, balloonStatsIntervalSeconds)
+}
+
+// MemoryRangePath returns the file recording the memory range of the VM with the given API socket
+func MemoryRangePath(socketPath string) string {
+	return strings.TrimSuffix(socketPath, ".sock") + ".memrange"
+}
+
+// writeMemoryRange records a VM's memory range for SetMemoryTarget
+func writeMemoryRange(socketPath string, minMB, maxMB int) error {
+	data := fmt.Sprintf("%d %d\n", minMB, maxMB)
+	if err := os.WriteFile(MemoryRangePath(socketPath), []byte(data), 0644); err != nil {
+		return fmt.Errorf("failed to record memory range: %w", err)
+	}
+	return nil
+}
+
+// readMemoryRange loads the memory range StartVM recorded for a VM
+func readMemoryRange(socketPath string) (int, int, error) {
+	data, err := os.ReadFile(MemoryRangePath(socketPath))
+	if os.IsNotExist(err) {
+		return 0, 0, fmt.Errorf("the VM was not started with a memory range")
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read memory range: %w", err)
+	}
+	var minMB, maxMB int
+	if _, err := fmt.Sscanf(string(data), "%d %d", &minMB, &maxMB); err != nil {
+		return 0, 0, fmt.Errorf("malformed memory range file %s: %w", MemoryRangePath(socketPath), err)
+	}
+	return minMB, maxMB, nil
+}
+
+// SetMemoryTarget sets how much memory a VM started with a memory range can use
+// mb is clamped to the range, and the balloon is inflated to take the rest of the guest's
+// memory or deflated to give it back. The target applied is returned
+func (c *Client) SetMemoryTarget(ctx context.Context, socketPath string, mb int) (int, error) {
+	minMB, maxMB, err := readMemoryRange(socketPath)
+	if err != nil {
+		return 0, err
+	}
+	target := min(max(mb, minMB), maxMB)
+
+	machine, err := c.connectToMachine(ctx, socketPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to VM: %w", err)
+	}
+	if err := machine.UpdateBalloon(ctx, int64(maxMB-target)); err != nil {
+		return 0, fmt.Errorf("failed to resize balloon: %w", err)
+	}
+	return target, nil
+}
@@ -0,0 +1,390 @@
+package firecracker
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/raesene/baremetalvmm/internal/image"
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+const (
+	// bundleFormatVersion is the version of the bundle layout written by WriteBundle
+	bundleFormatVersion = 1
+	// bundleManifestName is the first entry of a bundle, describing the VM and the files that follow
+	bundleManifestName = "bundle.json"
+	// noCPUTemplate is recorded for VMs started without a Firecracker CPU template, which is all of them
+	noCPUTemplate = "None"
+	// bundleRestoreSuffix marks files being extracted, renamed into place once the whole bundle is read
+	bundleRestoreSuffix = ".restore"
+)
+
+// firecrackerVersionPattern finds the major and minor version in 'firecracker --version' output
+var firecrackerVersionPattern = regexp.MustCompile(`v(\d+\.\d+)`)
+
+// BundleHost describes what restoring a snapshot depends on: the CPU, the Firecracker release
+// and the guest kernel. A snapshot only resumes on a host where they all match
+type BundleHost struct {
+	Arch               string `json:"arch"`
+	CPUVendor          string `json:"cpu_vendor"`
+	CPUModel           string `json:"cpu_model"`
+	CPUTemplate        string `json:"cpu_template"`
+	FirecrackerVersion string `json:"firecracker_version"` // Major and minor version, e.g. 1.7
+	KernelVersion      string `json:"kernel_version"`
+}
+
+// bundleFile is a file carried in a bundle, restored to the same path on the destination
+type bundleFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+
+	source string // Where the file is read from when bundling, if not Path
+}
+
+// bundleManifest is the bundle.json entry of a bundle
+type bundleManifest struct {
+	Version int          `json:"version"`
+	Host    BundleHost   `json:"host"`
+	VM      *vm.VM       `json:"vm"`
+	Files   []bundleFile `json:"files"`
+}
+
+// LocalBundleHost describes this host for a VM booted from the kernel at kernelPath
+func (c *Client) LocalBundleHost(kernelPath string) (*BundleHost, error) {
+	vendor, model, err := cpuIdentity()
+	if err != nil {
+		return nil, err
+	}
+	fcVersion, err := c.firecrackerVersion()
+	if err != nil {
+		return nil, err
+	}
+	kernelVersion, err := image.KernelVersion(kernelPath)
+	if err != nil {
+		return nil, err
+	}
+	return &BundleHost{
+		Arch:               runtime.GOARCH,
+		CPUVendor:          vendor,
+		CPUModel:           model,
+		CPUTemplate:        noCPUTemplate,
+		FirecrackerVersion: fcVersion,
+		KernelVersion:      kernelVersion,
+	}, nil
+}
+
+// checkCompatible reports every difference that would stop a snapshot from h resuming on local
+func (h *BundleHost) checkCompatible(local *BundleHost) error {
+	var errs []error
+	check := func(what, bundled, here string) {
+		if bundled != here {
+			errs = append(errs, fmt.Errorf("%s differs: bundle has %q, this host has %q", what, bundled, here))
+		}
+	}
+	check("architecture", h.Arch, local.Arch)
+	check("CPU vendor", h.CPUVendor, local.CPUVendor)
+	check("CPU model", h.CPUModel, local.CPUModel)
+	check("CPU template", h.CPUTemplate, local.CPUTemplate)
+	check("Firecracker version", h.FirecrackerVersion, local.FirecrackerVersion)
+	check("guest kernel version", h.KernelVersion, local.KernelVersion)
+	if len(errs) > 0 {
+		return fmt.Errorf("bundle can't be restored on this host:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// cpuIdentity reads the CPU vendor and model from /proc/cpuinfo
+// x86 reports vendor_id and model name; arm64 reports the implementer and part numbers
+func cpuIdentity() (string, string, error) {
+	data, err := os.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read CPU information: %w", err)
+	}
+	fields := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		if _, seen := fields[key]; !seen {
+			fields[key] = strings.TrimSpace(value)
+		}
+	}
+	if fields["vendor_id"] != "" {
+		return fields["vendor_id"], fields["model name"], nil
+	}
+	return fields["CPU implementer"], fields["CPU part"], nil
+}
+
+// firecrackerVersion returns the major and minor version of the Firecracker binary
+func (c *Client) firecrackerVersion() (string, error) {
+	bin, err := c.firecrackerBinary()
+	if err != nil {
+		return "", err
+	}
+	output, err := exec.Command(bin, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get Firecracker version: %w", err)
+	}
+	match := firecrackerVersionPattern.FindSubmatch(output)
+	if match == nil {
+		return "", fmt.Errorf("unrecognised Firecracker version output: %s", strings.TrimSpace(string(output)))
+	}
+	return string(match[1]), nil
+}
+
+// bundlePaths lists the files a suspended VM needs to resume: its saved memory and state,
+// and every drive the saved state refers to
+func bundlePaths(v *vm.VM) ([]string, error) {
+	paths := []string{v.MemSnapshot, v.StateSnapshot, v.RootfsPath}
+	if v.ModulesImage != "" {
+		paths = append(paths, v.ModulesImage)
+	}
+	for _, m := range v.Mounts {
+		if m.IsTmpfs() {
+			continue
+		}
+		if m.Shared {
+			return nil, fmt.Errorf("mount '%s' uses a shared image, which can't be bundled", m.GuestTag)
+		}
+		if m.ImagePath == "" {
+			return nil, fmt.Errorf("mount '%s' has no image", m.GuestTag)
+		}
+		paths = append(paths, m.ImagePath)
+	}
+	return paths, nil
+}
+
+// WriteBundle streams a suspended VM to w as a tar bundle for RestoreBundle on another host
+// The bundle holds the VM's configuration, its saved memory and state, its rootfs and its
+// mount and modules images, which all keep their paths. Images with a copy in v.DiskSnapshots
+// are bundled from the copy. The kernel and initrd are not included; the destination needs
+// the same kernel at the same path
+func (c *Client) WriteBundle(w io.Writer, v *vm.VM) error {
+	if v.MemSnapshot == "" || v.StateSnapshot == "" {
+		return fmt.Errorf("VM '%s' is not suspended; run 'vmm suspend' first", v.Name)
+	}
+	host, err := c.LocalBundleHost(v.KernelPath)
+	if err != nil {
+		return err
+	}
+	paths, err := bundlePaths(v)
+	if err != nil {
+		return err
+	}
+
+	// The bundled images already are the snapshot, so the copies aren't carried over
+	bundled := *v
+	bundled.DiskSnapshots = nil
+	manifest := bundleManifest{Version: bundleFormatVersion, Host: *host, VM: &bundled}
+	for _, path := range paths {
+		source := path
+		if copyPath, ok := v.DiskSnapshots[path]; ok {
+			source = copyPath
+		}
+		info, err := os.Stat(source)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", source, err)
+		}
+		manifest.Files = append(manifest.Files, bundleFile{Path: path, Size: info.Size(), source: source})
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode bundle manifest: %w", err)
+	}
+
+	tw := tar.NewWriter(w)
+	if err := tw.WriteHeader(&tar.Header{Name: bundleManifestName, Mode: 0644, Size: int64(len(data))}); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	for i, file := range manifest.Files {
+		if err := writeBundleFile(tw, bundleEntryName(i), file); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return nil
+}
+
+// bundleEntryName names the tar entry of the i-th file in a bundle
+func bundleEntryName(i int) string {
+	return fmt.Sprintf("files/%d", i)
+}
+
+// writeBundleFile adds one file to a bundle
+func writeBundleFile(tw *tar.Writer, name string, file bundleFile) error {
+	f, err := os.Open(file.source)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", file.source, err)
+	}
+	defer f.Close()
+
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: file.Size}); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if _, err := io.CopyN(tw, f, file.Size); err != nil {
+		return fmt.Errorf("failed to add %s to bundle: %w", file.source, err)
+	}
+	return nil
+}
+
+// RestoreBundle unpacks a bundle written by WriteBundle and returns its VM, stopped and ready to resume
+// check is called with the bundled VM before anything is written, so the caller can refuse it.
+// Nothing is written either if this host can't resume the snapshot (see BundleHost) or if any
+// of the bundle's files already exists. Files are restored to the paths they had on the source
+// host, so both hosts should use the same data directory
+func (c *Client) RestoreBundle(r io.Reader, check func(v *vm.VM) error) (*vm.VM, error) {
+	tr := tar.NewReader(r)
+	manifest, err := readBundleManifest(tr)
+	if err != nil {
+		return nil, err
+	}
+	v := manifest.VM
+	if err := check(v); err != nil {
+		return nil, err
+	}
+
+	local, err := c.LocalBundleHost(v.KernelPath)
+	if err != nil {
+		return nil, fmt.Errorf("kernel for VM '%s' is not usable on this host: %w", v.Name, err)
+	}
+	if err := manifest.Host.checkCompatible(local); err != nil {
+		return nil, err
+	}
+	if v.InitrdPath != "" {
+		if _, err := os.Stat(v.InitrdPath); err != nil {
+			return nil, fmt.Errorf("initrd %s for VM '%s' not found on this host", v.InitrdPath, v.Name)
+		}
+	}
+	for _, file := range manifest.Files {
+		if _, err := os.Stat(file.Path); err == nil {
+			return nil, fmt.Errorf("%s already exists on this host", file.Path)
+		}
+	}
+
+	if err := extractBundleFiles(tr, manifest.Files); err != nil {
+		return nil, err
+	}
+	v.State = vm.StateStopped
+	v.PID = 0
+	return v, nil
+}
+
+// readBundleManifest reads and checks the first entry of a bundle
+func readBundleManifest(tr *tar.Reader) (*bundleManifest, error) {
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+	if hdr.Name != bundleManifestName {
+		return nil, fmt.Errorf("not a VM bundle: first entry is %s, expected %s", hdr.Name, bundleManifestName)
+	}
+	var manifest bundleManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode bundle manifest: %w", err)
+	}
+	if manifest.Version != bundleFormatVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d (expected %d)", manifest.Version, bundleFormatVersion)
+	}
+	if manifest.VM == nil || manifest.VM.Name == "" {
+		return nil, fmt.Errorf("bundle manifest has no VM")
+	}
+	if err := vm.ValidateName(manifest.VM.Name); err != nil {
+		return nil, err
+	}
+	for _, file := range manifest.Files {
+		if !filepath.IsAbs(file.Path) || filepath.Clean(file.Path) != file.Path {
+			return nil, fmt.Errorf("bundle has an invalid file path %q", file.Path)
+		}
+	}
+	return &manifest, nil
+}
+
+// extractBundleFiles writes the files following a bundle's manifest next to their destinations
+// and renames them into place once all are complete; on failure nothing is left behind
+func extractBundleFiles(tr *tar.Reader, files []bundleFile) (err error) {
+	var written []string
+	defer func() {
+		if err != nil {
+			for _, path := range written {
+				os.Remove(path)
+			}
+		}
+	}()
+
+	for i, file := range files {
+		hdr, err := tr.Next()
+		if err != nil {
+			return fmt.Errorf("bundle is truncated: %w", err)
+		}
+		if hdr.Name != bundleEntryName(i) || hdr.Size != file.Size {
+			return fmt.Errorf("bundle entry %s does not match its manifest", hdr.Name)
+		}
+		if err := os.MkdirAll(filepath.Dir(file.Path), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", file.Path, err)
+		}
+		tmpPath := file.Path + bundleRestoreSuffix
+		written = append(written, tmpPath)
+		if err := writeSparse(tmpPath, tr, file.Size); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", file.Path, err)
+		}
+	}
+
+	for i, file := range files {
+		if err := os.Rename(written[i], file.Path); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", file.Path, err)
+		}
+		written[i] = file.Path
+	}
+	return nil
+}
+
+// writeSparse writes size bytes from r to a new file, leaving holes where the data is all zeros
+// Disk images and guest memory are mostly empty, so this keeps the restored files sparse
+func writeSparse(path string, r io.Reader, size int64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 1024*1024)
+	zeros := make([]byte, len(buf))
+	for remaining := size; remaining > 0; {
+		n, err := io.ReadFull(r, buf[:min(int64(len(buf)), remaining)])
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(buf[:n], zeros[:n]) {
+			if _, err := f.Seek(int64(n), io.SeekCurrent); err != nil {
+				return err
+			}
+		} else if _, err := f.Write(buf[:n]); err != nil {
+			return err
+		}
+		remaining -= int64(n)
+	}
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,185 @@
+package firecracker
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// CgroupRoot is the cgroup v2 mount point
+	CgroupRoot = "/sys/fs/cgroup"
+	// CgroupSlice is the cgroup that holds one child cgroup per VM
+	CgroupSlice = "vmm.slice"
+
+	// DefaultCPUPeriodUS is the cpu.max period used when CgroupLimits doesn't set one
+	DefaultCPUPeriodUS = 100000
+)
+
+// CgroupLimits caps the host resources the Firecracker process of a VM may use
+// Zero values leave the corresponding limit unset
+type CgroupLimits struct {
+	CPUQuotaUS     int64 // CPU time allowed per period, in microseconds (cpu.max)
+	CPUPeriodUS    int64 // Length of the CPU period, in microseconds (default DefaultCPUPeriodUS)
+	MemoryMaxBytes int64 // Hard memory limit including guest memory (memory.max)
+}
+
+// NewCgroupLimits builds limits from a CPU allowance in cores (e.g. 1.5) and a memory cap in MB
+func NewCgroupLimits(cpus float64, memoryMB int) *CgroupLimits {
+	if cpus <= 0 && memoryMB <= 0 {
+		return nil
+	}
+	limits := &CgroupLimits{}
+	if cpus > 0 {
+		limits.CPUPeriodUS = DefaultCPUPeriodUS
+		limits.CPUQuotaUS = int64(cpus * DefaultCPUPeriodUS)
+	}
+	if memoryMB > 0 {
+		limits.MemoryMaxBytes = int64(memoryMB) * 1024 * 1024
+	}
+	return limits
+}
+
+// SplitCPUs turns a possibly fractional CPU count into the whole vCPUs the guest sees and the
+// cgroup CPU cap, in cores, that holds them to the fraction: 0.5 is one vCPU capped at half a
+// core, 2.5 is three vCPUs capped at two and a half. A whole count needs no cap and gives 0
+func SplitCPUs(cpus float64) (vcpus int, limit float64) {
+	vcpus = int(math.Ceil(cpus))
+	if float64(vcpus) != cpus {
+		limit = cpus
+	}
+	return vcpus, limit
+}
+
+// Validate checks the limits for values the kernel would reject
+func (l *CgroupLimits) Validate() error {
+	if l.CPUQuotaUS < 0 || l.CPUPeriodUS < 0 || l.MemoryMaxBytes < 0 {
+		return fmt.Errorf("cgroup limits cannot be negative")
+	}
+	if l.CPUPeriodUS != 0 && (l.CPUPeriodUS < 1000 || l.CPUPeriodUS > 1000000) {
+		return fmt.Errorf("cpu period must be between 1000 and 1000000 microseconds")
+	}
+	if l.CPUQuotaUS != 0 && l.CPUQuotaUS < 1000 {
+		return fmt.Errorf("cpu quota must be at least 1000 microseconds")
+	}
+	return nil
+}
+
+// CgroupPath returns the cgroup directory used for a VM
+func CgroupPath(vmName string) string {
+	return filepath.Join(CgroupRoot, CgroupSlice, vmName)
+}
+
+// openCgroup creates the VM's cgroup, writes its limits and returns the open cgroup directory
+// The Firecracker process is started directly inside it with clone3's CLONE_INTO_CGROUP, so
+// it never runs uncapped; the caller closes the directory once the process has started
+func openCgroup(vmName string, limits *CgroupLimits) (*os.File, error) {
+	if err := createCgroup(vmName, limits); err != nil {
+		return nil, err
+	}
+	path := CgroupPath(vmName)
+	dir, err := os.OpenFile(path, os.O_RDONLY|syscall.O_DIRECTORY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cgroup %s: %w", path, err)
+	}
+	return dir, nil
+}
+
+// createCgroup creates the VM's cgroup and writes its limits
+func createCgroup(vmName string, limits *CgroupLimits) error {
+	if _, err := os.Stat(filepath.Join(CgroupRoot, "cgroup.controllers")); err != nil {
+		return fmt.Errorf("cgroup v2 is not mounted at %s", CgroupRoot)
+	}
+
+	// Controllers must be enabled on every ancestor for the leaf to accept limits
+	slicePath := filepath.Join(CgroupRoot, CgroupSlice)
+	if err := os.MkdirAll(slicePath, 0755); err != nil {
+		return fmt.Errorf("failed to create cgroup %s: %w", slicePath, err)
+	}
+	for _, dir := range []string{CgroupRoot, slicePath} {
+		if err := enableControllers(dir, "cpu", "memory"); err != nil {
+			return err
+		}
+	}
+
+	path := CgroupPath(vmName)
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return fmt.Errorf("failed to create cgroup %s: %w", path, err)
+	}
+
+	if limits.CPUQuotaUS > 0 {
+		period := limits.CPUPeriodUS
+		if period == 0 {
+			period = DefaultCPUPeriodUS
+		}
+		if err := writeCgroupFile(path, "cpu.max", fmt.Sprintf("%d %d", limits.CPUQuotaUS, period)); err != nil {
+			return err
+		}
+	}
+	if limits.MemoryMaxBytes > 0 {
+		if err := writeCgroupFile(path, "memory.max", strconv.FormatInt(limits.MemoryMaxBytes, 10)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveCgroup deletes a VM's cgroup once its processes have exited
+// It is a no-op if the VM never had one
+func RemoveCgroup(vmName string) error {
+	path := CgroupPath(vmName)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	// The kernel refuses to remove a cgroup until the killed process has fully exited
+	var err error
+	for attempt := 0; attempt < 10; attempt++ {
+		if err = os.Remove(path); err == nil || os.IsNotExist(err) {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("failed to remove cgroup %s: %w", path, err)
+}
+
+// enableControllers turns on the given controllers for the children of a cgroup
+func enableControllers(dir string, controllers ...string) error {
+	data, err := os.ReadFile(filepath.Join(dir, "cgroup.subtree_control"))
+	if err != nil {
+		return fmt.Errorf("failed to read controllers of %s: %w", dir, err)
+	}
+	enabled := strings.Fields(string(data))
+
+	var missing []string
+	for _, controller := range controllers {
+		found := false
+		for _, e := range enabled {
+			if e == controller {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, "+"+controller)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return writeCgroupFile(dir, "cgroup.subtree_control", strings.Join(missing, " "))
+}
+
+// writeCgroupFile writes a value to a cgroup interface file
+func writeCgroupFile(dir, name, value string) error {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package firecracker
+
+import "testing"
+
+func TestSplitCPUs(t *testing.T) {
+	tests := []struct {
+		cpus  float64
+		vcpus int
+		limit float64
+	}{
+		{0.5, 1, 0.5},
+		{1, 1, 0},
+		{2.5, 3, 2.5},
+		{4, 4, 0},
+	}
+	for _, tt := range tests {
+		vcpus, limit := SplitCPUs(tt.cpus)
+		if vcpus != tt.vcpus || limit != tt.limit {
+			t.Errorf("SplitCPUs(%g) = %d, %g; want %d, %g", tt.cpus, vcpus, limit, tt.vcpus, tt.limit)
+		}
+	}
+}
@@ -2,7 +2,10 @@ package firecracker
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -13,17 +16,28 @@ import (
 	"github.com/firecracker-microvm/firecracker-go-sdk/client/models"
 	"github.com/sirupsen/logrus"
 
+	"github.com/raesene/baremetalvmm/internal/image"
 	"github.com/raesene/baremetalvmm/internal/vm"
 )
 
 const (
 	DefaultFirecrackerBin = "/usr/local/bin/firecracker"
+
+	// DefaultConnectTimeout bounds how long connecting to a running VM waits for the API to answer
+	DefaultConnectTimeout = 5 * time.Second
 )
 
+// ErrVMMUnresponsive is returned when a VM's API socket exists but Firecracker does not answer in time
+// Callers can treat it as a signal to kill the Firecracker process directly
+var ErrVMMUnresponsive = errors.New("firecracker API is not responding")
+
 // Client wraps the Firecracker SDK for VM management
 type Client struct {
 	FirecrackerBin string
 	Logger         *logrus.Logger
+	ConnectTimeout time.Duration             // Deadline for reaching the API of a running VM
+	PostStopHook   func(v *vm.VM) error      // Run once a VM stopped with StopOptions.VM set has exited
+	PreStartHook   func(cfg *VMConfig) error // Run by StartVM before it sets up the machine; an error aborts the start
 }
 
 // NewClient creates a new Firecracker client
@@ -34,6 +48,7 @@ func NewClient() *Client {
 	return &Client{
 		FirecrackerBin: DefaultFirecrackerBin,
 		Logger:         logger,
+		ConnectTimeout: DefaultConnectTimeout,
 	}
 }
 
@@ -42,67 +57,322 @@ type MountDrive struct {
 	ImagePath string
 	Tag       string
 	ReadOnly  bool
+	CacheType string // writeback (default when empty) or unsafe; see ParseCacheType
+	IOEngine  string // sync (default when empty) or async; see ParseIOEngine
+
+	// DriveOrder places the drive among the mount drives, lowest first; ties keep the list order
+	DriveOrder int
+	// DriveID is the Firecracker drive ID (empty = mount<N> by attach position)
+	DriveID string
 }
 
 // VMConfig holds the configuration needed to start a Firecracker VM
 type VMConfig struct {
-	SocketPath   string
-	KernelPath   string
-	RootfsPath   string
-	CPUs         int
-	MemoryMB     int
-	TapDevice    string
-	MacAddress   string
-	KernelArgs   string
-	LogPath      string
-	IPAddress    string
-	Gateway      string
-	MountDrives  []MountDrive
+	Name        string // VM name, used to name per-VM host resources such as the cgroup
+	SocketPath  string
+	KernelPath  string
+	InitrdPath  string // Optional initrd/initramfs (empty = boot without one)
+	RootfsPath  string
+	CPUs        int
+	MemoryMB    int // Ignored when the VM has a memory range
+	TapDevice   string
+	MacAddress  string
+	KernelArgs  string      // Merged into the default args, user values winning; see ReplaceKernelArgs
+	LogPath     string      // Firecracker's output, including the serial console (empty = discarded)
+	LogRotation LogRotation // When LogPath is rotated before the VM starts
+	IPAddress   string
+	Gateway     string
+	DNSServers  []string // Passed to the kernel ip= parameter (first two IPv4 servers are used)
+	MountDrives []MountDrive
+	VsockPath   string // Host UDS for the guest vsock device (empty = no vsock)
+	ConsoleType string // ConsoleSerial (default when empty); sets console= in the default kernel args
+	Init        string // Absolute guest path run as PID 1 via init= (empty = the rootfs's own init)
+
+	// KernelURL and RootfsURL name a kernel and rootfs to boot without registering them as images;
+	// ResolveURLs downloads them into the image cache and sets KernelPath and RootfsPath. The
+	// SHA256 fields, if set, are hex digests the downloads must match
+	KernelURL    string
+	KernelSHA256 string
+	RootfsURL    string
+	RootfsSHA256 string
+
+	// VerityHashTreePath and VerityRootHash, from image.GenerateVerity, boot the rootfs as a
+	// dm-verity device: the rootfs is attached read-only, the hash tree after every other drive,
+	// and the guest kernel verifies each block it reads against the root hash
+	VerityHashTreePath string
+	VerityRootHash     string
+
+	// ModulesImagePath is a kernel modules image attached read-only after the mount drives (empty = none)
+	ModulesImagePath string
+	// SeedISOPath is a cloud-init NoCloud seed (see image.CreateSeedISO) attached read-only after the modules image
+	SeedISOPath string
+	// ExtraDrives are attached after every other drive, in order, for block devices that aren't mounts
+	ExtraDrives []DriveSpec
+
+	// PassthroughDevices lists host PCI devices ([domain:]bus:device.function) to pass through with VFIO
+	PassthroughDevices []string
+
+	// MemBackendType selects anonymous (default) or file-backed guest memory; with the
+	// file backend StartVM resumes the VM saved by SnapshotVM instead of booting it
+	MemBackendType MemBackendType
+	// MemBackendPath is the guest memory file for the file backend
+	MemBackendPath string
+	// SnapshotPath is the VM state file paired with MemBackendPath
+	SnapshotPath string
+
+	// RootfsCacheType is the rootfs drive's cache type: writeback (default when empty) or unsafe
+	RootfsCacheType string
+	// RootfsIOEngine is the rootfs drive's I/O engine: sync (default when empty) or async (io_uring)
+	RootfsIOEngine string
+	// RootfsReadOnly attaches the rootfs read-only; the guest then needs a writable mount or tmpfs for scratch data
+	RootfsReadOnly bool
+	// WritableScratch tells StartVM the guest mounts writable scratch space (e.g. a tmpfs) that isn't a drive
+	WritableScratch bool
+
+	// CgroupLimits, if set, caps the Firecracker process through a cgroup v2 group named after the VM
+	CgroupLimits *CgroupLimits
+
+	// MinMemoryMB and MaxMemoryMB, if set, give the VM a memory range: it boots with MaxMemoryMB
+	// and a balloon device that holds it down to MinMemoryMB until SetMemoryTarget raises it
+	MinMemoryMB int
+	MaxMemoryMB int
+
+	// CPUAffinity pins the vCPU threads once the VM is running: vCPU i to host CPU CPUAffinity[i % len] (empty = unpinned)
+	CPUAffinity []int
+
+	// ReplaceKernelArgs uses KernelArgs in place of the console, reboot, panic and pci defaults
+	// rather than merging it with them; ip= and init= are still added
+	ReplaceKernelArgs bool
+
+	// Foreground also copies Firecracker's stdout and stderr to Stdout and Stderr (os.Stdout and
+	// os.Stderr when nil), so failures before the API socket comes up are seen as they happen.
+	// The output is piped through the caller, which must keep running until the VM exits;
+	// the process gets its own process group so a terminal's Ctrl-C reaches only the caller
+	Foreground bool
+	Stdout     io.Writer
+	Stderr     io.Writer
+}
+
+// StartResult describes a VM started by StartVM
+type StartResult struct {
+	Machine *sdk.Machine
+	// MountDriveIDs maps each mount's guest tag to the Firecracker drive ID it was attached as
+	MountDriveIDs map[string]string
+	// KernelArgs is the kernel command line the VM booted with (empty when it was resumed from a snapshot)
+	KernelArgs string
+}
+
+// MountDriveID returns the default Firecracker drive ID for the mount at the given attach position
+// Positions follow OrderMountDrives, so IDs are stable while the mount list and orders are unchanged
+func MountDriveID(index int) string {
+	return fmt.Sprintf("mount%d", index)
 }
 
 // StartVM starts a Firecracker microVM with the given configuration
-func (c *Client) StartVM(ctx context.Context, cfg *VMConfig) (*sdk.Machine, error) {
+func (c *Client) StartVM(ctx context.Context, cfg *VMConfig) (*StartResult, error) {
 	// Ensure socket doesn't exist
 	os.Remove(cfg.SocketPath)
 
 	// Validate paths
+	if (cfg.KernelURL != "" && cfg.KernelPath == "") || (cfg.RootfsURL != "" && cfg.RootfsPath == "") {
+		return nil, fmt.Errorf("kernel or rootfs URL not fetched; call ResolveURLs before StartVM")
+	}
 	if _, err := os.Stat(cfg.KernelPath); err != nil {
 		return nil, fmt.Errorf("kernel not found at %s: %w", cfg.KernelPath, err)
 	}
 	if _, err := os.Stat(cfg.RootfsPath); err != nil {
 		return nil, fmt.Errorf("rootfs not found at %s: %w", cfg.RootfsPath, err)
 	}
+	if cfg.InitrdPath != "" {
+		if _, err := os.Stat(cfg.InitrdPath); err != nil {
+			return nil, fmt.Errorf("initrd not found at %s: %w", cfg.InitrdPath, err)
+		}
+	}
+	if err := CheckMountDrives(cfg.MountDrives); err != nil {
+		return nil, err
+	}
+	if err := validateMemBackend(cfg); err != nil {
+		return nil, err
+	}
+	if err := validateMemoryRange(cfg); err != nil {
+		return nil, err
+	}
+	if cfg.CgroupLimits != nil {
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("cgroup limits require a VM name")
+		}
+		if err := cfg.CgroupLimits.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if c.PreStartHook != nil {
+		// The hook may still fill in host networking such as the TAP device and IP address
+		if err := c.PreStartHook(cfg); err != nil {
+			return nil, fmt.Errorf("pre-start hook: %w", err)
+		}
+	}
+
+	if err := CheckKVM(); err != nil {
+		return nil, err
+	}
+	if err := configurePassthrough(cfg.PassthroughDevices); err != nil {
+		return nil, err
+	}
+
+	consoleArg, err := consoleKernelArg(cfg.ConsoleType)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidateInit(cfg.Init); err != nil {
+		return nil, err
+	}
+	if err := ValidateCPUAffinity(cfg.CPUAffinity); err != nil {
+		return nil, err
+	}
 
 	// Default kernel args for a basic Linux boot
-	kernelArgs := cfg.KernelArgs
-	if kernelArgs == "" {
-		kernelArgs = "console=ttyS0 reboot=k panic=1 pci=off"
+	kernelArgs := consoleArg + " reboot=k panic=1 pci=off"
+	if cfg.ReplaceKernelArgs && cfg.KernelArgs != "" {
+		kernelArgs = cfg.KernelArgs
 	}
 
 	// Add IP configuration if provided
-	// Format: ip=<client-ip>::<gateway-ip>:<netmask>::eth0:off
+	// Format: ip=<client-ip>::<gateway-ip>:<netmask>::eth0:off[:<dns0-ip>[:<dns1-ip>]]
 	if cfg.IPAddress != "" && cfg.Gateway != "" {
-		kernelArgs += fmt.Sprintf(" ip=%s::%s:255.255.0.0::eth0:off", cfg.IPAddress, cfg.Gateway)
+		if err := image.ValidateDNSServers(cfg.DNSServers); err != nil {
+			return nil, err
+		}
+		ipArg := fmt.Sprintf(" ip=%s::%s:255.255.0.0::eth0:off", cfg.IPAddress, cfg.Gateway)
+		added := 0
+		for _, server := range cfg.DNSServers {
+			// ip= fields are colon-separated and the kernel only takes two IPv4 resolvers;
+			// IPv6 servers still reach the guest through resolv.conf
+			if added == 2 || net.ParseIP(server).To4() == nil {
+				continue
+			}
+			ipArg += ":" + server
+			added++
+		}
+		kernelArgs += ipArg
+	}
+	if cfg.Init != "" {
+		kernelArgs += " init=" + cfg.Init
+	}
+	if !cfg.ReplaceKernelArgs && cfg.KernelArgs != "" {
+		kernelArgs = MergeKernelArgs(kernelArgs, cfg.KernelArgs)
+	}
+
+	if err := validateVerity(cfg); err != nil {
+		return nil, err
+	}
+	rootReadOnly := cfg.RootfsReadOnly || cfg.VerityHashTreePath != ""
+	if rootReadOnly && !cfg.WritableScratch && !hasWritableDrive(cfg.MountDrives) && !cfg.restoresFromMemFile() {
+		c.Logger.Warnf("The rootfs is read-only and the VM has no writable mount or tmpfs; the guest may fail to boot")
 	}
 
 	// Build drives list starting with rootfs
+	rootCache, err := ParseCacheType(cfg.RootfsCacheType)
+	if err != nil {
+		return nil, fmt.Errorf("rootfs: %w", err)
+	}
+	rootEngine, err := c.resolveIOEngine("rootfs", cfg.RootfsIOEngine)
+	if err != nil {
+		return nil, fmt.Errorf("rootfs: %w", err)
+	}
 	drives := []models.Drive{
 		{
-			DriveID:      sdk.String("rootfs"),
-			PathOnHost:   sdk.String(cfg.RootfsPath),
-			IsRootDevice: sdk.Bool(true),
-			IsReadOnly:   sdk.Bool(false),
+			DriveID:    sdk.String("rootfs"),
+			PathOnHost: sdk.String(cfg.RootfsPath),
+			// With verity the guest's root is the dm-verity device, set by verityKernelArgs
+			IsRootDevice: sdk.Bool(cfg.VerityHashTreePath == ""),
+			IsReadOnly:   sdk.Bool(rootReadOnly),
+			CacheType:    sdk.String(rootCache),
+			IoEngine:     sdk.String(rootEngine),
 		},
 	}
 
-	// Add mount drives (vdb, vdc, etc.)
-	for i, mountDrive := range cfg.MountDrives {
-		driveID := fmt.Sprintf("mount%d", i)
+	// Add mount drives (vdb, vdc, etc.) in their attach order
+	mountDriveIDs := make(map[string]string, len(cfg.MountDrives))
+	usedDriveIDs := make(map[string]string, len(cfg.MountDrives))
+	for i, mountDrive := range OrderMountDrives(cfg.MountDrives) {
+		driveID := mountDrive.DriveID
+		if driveID == "" {
+			driveID = MountDriveID(i)
+		} else if err := ValidateMountDriveID(driveID); err != nil {
+			return nil, fmt.Errorf("mount '%s': %w", mountDrive.Tag, err)
+		}
+		if _, dup := mountDriveIDs[mountDrive.Tag]; dup {
+			return nil, fmt.Errorf("duplicate mount tag '%s'", mountDrive.Tag)
+		}
+		if other, dup := usedDriveIDs[driveID]; dup {
+			return nil, fmt.Errorf("mounts '%s' and '%s' both use drive ID '%s'", other, mountDrive.Tag, driveID)
+		}
+		mountDriveIDs[mountDrive.Tag] = driveID
+		usedDriveIDs[driveID] = mountDrive.Tag
+		cacheType, err := ParseCacheType(mountDrive.CacheType)
+		if err != nil {
+			return nil, fmt.Errorf("mount '%s': %w", mountDrive.Tag, err)
+		}
+		ioEngine, err := c.resolveIOEngine(mountDrive.Tag, mountDrive.IOEngine)
+		if err != nil {
+			return nil, fmt.Errorf("mount '%s': %w", mountDrive.Tag, err)
+		}
 		drives = append(drives, models.Drive{
 			DriveID:      sdk.String(driveID),
 			PathOnHost:   sdk.String(mountDrive.ImagePath),
 			IsRootDevice: sdk.Bool(false),
 			IsReadOnly:   sdk.Bool(mountDrive.ReadOnly),
+			CacheType:    sdk.String(cacheType),
+			IoEngine:     sdk.String(ioEngine),
+		})
+	}
+
+	if cfg.ModulesImagePath != "" {
+		if _, err := os.Stat(cfg.ModulesImagePath); err != nil {
+			return nil, fmt.Errorf("modules image not found at %s: %w", cfg.ModulesImagePath, err)
+		}
+		drives = append(drives, models.Drive{
+			DriveID:      sdk.String(modulesDriveID),
+			PathOnHost:   sdk.String(cfg.ModulesImagePath),
+			IsRootDevice: sdk.Bool(false),
+			IsReadOnly:   sdk.Bool(true),
+		})
+	}
+	if cfg.SeedISOPath != "" {
+		if _, err := os.Stat(cfg.SeedISOPath); err != nil {
+			return nil, fmt.Errorf("cloud-init seed not found at %s: %w", cfg.SeedISOPath, err)
+		}
+		drives = append(drives, models.Drive{
+			DriveID:      sdk.String(seedDriveID),
+			PathOnHost:   sdk.String(cfg.SeedISOPath),
+			IsRootDevice: sdk.Bool(false),
+			IsReadOnly:   sdk.Bool(true),
+		})
+	}
+
+	extra, err := extraDrives(cfg.ExtraDrives)
+	if err != nil {
+		return nil, err
+	}
+	for _, drive := range extra {
+		if tag, dup := usedDriveIDs[*drive.DriveID]; dup {
+			return nil, fmt.Errorf("extra drive ID '%s' is already used by mount '%s'", *drive.DriveID, tag)
+		}
+	}
+	drives = append(drives, extra...)
+
+	if cfg.VerityHashTreePath != "" {
+		rootfsInfo, err := os.Stat(cfg.RootfsPath)
+		if err != nil {
+			return nil, fmt.Errorf("rootfs not found at %s: %w", cfg.RootfsPath, err)
+		}
+		layout := cfg.DriveLayout()
+		kernelArgs = insertKernelArgs(kernelArgs, verityKernelArgs(layout[0].Device, layout[len(layout)-1].Device, rootfsInfo.Size(), cfg.VerityRootHash))
+		drives = append(drives, models.Drive{
+			DriveID:      sdk.String(verityDriveID),
+			PathOnHost:   sdk.String(cfg.VerityHashTreePath),
+			IsRootDevice: sdk.Bool(false),
+			IsReadOnly:   sdk.Bool(true),
 		})
 	}
 
@@ -110,11 +380,12 @@ func (c *Client) StartVM(ctx context.Context, cfg *VMConfig) (*sdk.Machine, erro
 	fcCfg := sdk.Config{
 		SocketPath:      cfg.SocketPath,
 		KernelImagePath: cfg.KernelPath,
+		InitrdPath:      cfg.InitrdPath,
 		KernelArgs:      kernelArgs,
 		Drives:          drives,
 		MachineCfg: models.MachineConfiguration{
 			VcpuCount:  sdk.Int64(int64(cfg.CPUs)),
-			MemSizeMib: sdk.Int64(int64(cfg.MemoryMB)),
+			MemSizeMib: sdk.Int64(int64(cfg.guestMemoryMB())),
 		},
 	}
 
@@ -130,54 +401,229 @@ func (c *Client) StartVM(ctx context.Context, cfg *VMConfig) (*sdk.Machine, erro
 		}
 	}
 
-	// Find Firecracker binary
-	fcBin := c.FirecrackerBin
-	if _, err := os.Stat(fcBin); err != nil {
-		// Try to find it in PATH
-		if path, err := exec.LookPath("firecracker"); err == nil {
-			fcBin = path
-		} else {
-			return nil, fmt.Errorf("firecracker binary not found at %s or in PATH", c.FirecrackerBin)
+	// Add vsock device for the guest agent if configured
+	// A restored VM gets its vsock device back from the saved state
+	restore := cfg.restoresFromMemFile()
+	if cfg.VsockPath != "" {
+		os.Remove(cfg.VsockPath)
+	}
+	if cfg.VsockPath != "" && !restore {
+		fcCfg.VsockDevices = []sdk.VsockDevice{
+			{
+				ID:   "agent",
+				Path: cfg.VsockPath,
+				CID:  GuestAgentCID,
+			},
 		}
 	}
 
+	// Find Firecracker binary
+	fcBin, err := c.firecrackerBinary()
+	if err != nil {
+		return nil, err
+	}
+
 	// Set up machine options
 	machineOpts := []sdk.Opt{
 		sdk.WithLogger(logrus.NewEntry(c.Logger)),
 	}
 
-	// Create log file if specified
+	// Create the Firecracker command
+	builder := sdk.VMCommandBuilder{}.
+		WithBin(fcBin).
+		WithSocketPath(cfg.SocketPath)
+
+	// Send the process output to the log file if specified; the child keeps its own descriptor
+	var logFile *os.File
 	if cfg.LogPath != "" {
 		logDir := filepath.Dir(cfg.LogPath)
 		if err := os.MkdirAll(logDir, 0755); err != nil {
 			return nil, fmt.Errorf("failed to create log directory: %w", err)
 		}
+		logFile, err = openLog(cfg.LogPath, cfg.LogRotation)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			// Left open for a foreground VM, whose output is copied into it until it exits
+			if logFile != nil {
+				logFile.Close()
+			}
+		}()
+		builder = builder.WithStdout(logFile).WithStderr(logFile)
+
+		// The console output lands in the log; give AttachConsole a way to type into it
+		consoleInput, err := createConsoleInput(ConsolePath(cfg.SocketPath))
+		if err != nil {
+			return nil, err
+		}
+		defer consoleInput.Close()
+		builder = builder.WithStdin(consoleInput)
+	}
+	if cfg.Foreground {
+		builder = builder.WithStdout(foregroundWriter(logFile, cfg.Stdout, os.Stdout)).
+			WithStderr(foregroundWriter(logFile, cfg.Stderr, os.Stderr))
+	}
+	cmd := builder.Build(ctx)
+	if cfg.Foreground {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	}
 
-	// Create the Firecracker command
-	cmd := sdk.VMCommandBuilder{}.
-		WithBin(fcBin).
-		WithSocketPath(cfg.SocketPath).
-		Build(ctx)
+	// Cap the Firecracker process itself from its first instruction; a VM without its
+	// requested ceiling is never started
+	if cfg.CgroupLimits != nil {
+		cgroupDir, err := openCgroup(cfg.Name, cfg.CgroupLimits)
+		if err != nil {
+			RemoveCgroup(cfg.Name)
+			return nil, fmt.Errorf("failed to apply cgroup limits: %w", err)
+		}
+		defer cgroupDir.Close()
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(cgroupDir.Fd())
+	}
 
 	machineOpts = append(machineOpts, sdk.WithProcessRunner(cmd))
 
+	// Restore guest memory and device state from disk rather than booting the kernel
+	if restore {
+		machineOpts = append(machineOpts, sdk.WithSnapshot(cfg.MemBackendPath, cfg.SnapshotPath,
+			func(snap *sdk.SnapshotConfig) { snap.ResumeVM = true }))
+	}
+
 	// Create the machine
 	machine, err := sdk.NewMachine(ctx, fcCfg, machineOpts...)
 	if err != nil {
+		if cfg.CgroupLimits != nil {
+			RemoveCgroup(cfg.Name)
+		}
 		return nil, fmt.Errorf("failed to create Firecracker machine: %w", err)
 	}
+	// A restored VM already has the balloon it was saved with
+	if cfg.hasMemoryRange() && !restore {
+		machine.Handlers.FcInit = machine.Handlers.FcInit.AppendAfter(sdk.CreateMachineHandlerName, balloonHandler(cfg))
+	}
 
 	// Start the machine
 	if err := machine.Start(ctx); err != nil {
+		if cfg.CgroupLimits != nil {
+			RemoveCgroup(cfg.Name)
+		}
 		return nil, fmt.Errorf("failed to start Firecracker machine: %w", err)
 	}
+	if cfg.hasMemoryRange() {
+		if err := writeMemoryRange(cfg.SocketPath, cfg.MinMemoryMB, cfg.MaxMemoryMB); err != nil {
+			c.Logger.Warnf("%v; the VM's memory can't be resized", err)
+		}
+	}
+	// The vCPU threads exist once the VM is running
+	if err := PinVCPUs(c.GetVMPID(machine), cfg.CPUAffinity); err != nil {
+		c.Logger.Warnf("%v; the VM runs unpinned", err)
+	}
+	if cfg.Foreground && logFile != nil {
+		go func(f *os.File) {
+			machine.Wait(context.Background())
+			f.Close()
+		}(logFile)
+		logFile = nil
+	}
 
-	return machine, nil
+	result := &StartResult{
+		Machine:       machine,
+		MountDriveIDs: mountDriveIDs,
+	}
+	if !restore {
+		result.KernelArgs = machine.Cfg.KernelArgs
+	}
+	return result, nil
+}
+
+// foregroundWriter returns where a foreground VM's output stream goes: w (or fallback when nil),
+// and the log file as well if there is one
+func foregroundWriter(logFile *os.File, w, fallback io.Writer) io.Writer {
+	if w == nil {
+		w = fallback
+	}
+	if logFile == nil {
+		return w
+	}
+	return io.MultiWriter(logFile, w)
+}
+
+// hasWritableDrive reports whether any mount drive is attached read-write
+func hasWritableDrive(drives []MountDrive) bool {
+	for _, drive := range drives {
+		if !drive.ReadOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// firecrackerBinary returns the configured Firecracker binary, falling back to the one in PATH
+func (c *Client) firecrackerBinary() (string, error) {
+	if _, err := os.Stat(c.FirecrackerBin); err == nil {
+		return c.FirecrackerBin, nil
+	}
+	if path, err := exec.LookPath("firecracker"); err == nil {
+		return path, nil
+	}
+	return "", fmt.Errorf("%w at %s or in PATH", ErrFirecrackerNotFound, c.FirecrackerBin)
+}
+
+// StopOptions controls how StopVMWithOptions shuts a VM down
+type StopOptions struct {
+	// AgentVsockPath is the VM's vsock UDS; when set, a guest agent shutdown is tried first
+	AgentVsockPath string
+	// AgentTimeout bounds the agent request and the wait for the VM to exit (default DefaultAgentTimeout)
+	AgentTimeout time.Duration
+	// PID is the Firecracker process, whose exit confirms the stop (0 = watch the API socket instead)
+	PID int
+	// VM is handed to the client's PostStopHook once the stop is confirmed
+	VM *vm.VM
 }
 
 // StopVM gracefully stops a running Firecracker VM
 func (c *Client) StopVM(ctx context.Context, socketPath string) error {
+	return c.StopVMWithOptions(ctx, socketPath, StopOptions{})
+}
+
+// StopVMWithOptions stops a running Firecracker VM, first asking the guest agent
+// to shut down if one is configured, then falling back to the SDK shutdown.
+// Once the VMM has exited its API socket is removed, so it isn't mistaken for a running VM
+func (c *Client) StopVMWithOptions(ctx context.Context, socketPath string, opts StopOptions) error {
+	if err := c.stopVMM(ctx, socketPath, opts); err != nil {
+		return err
+	}
+	if c.removeSocketAfterExit(ctx, socketPath, opts.PID, socketExitTimeout) && opts.VM != nil {
+		c.RunPostStopHook(opts.VM)
+	}
+	return nil
+}
+
+// RunPostStopHook runs the client's PostStopHook, if any, for a VM whose process has exited
+// A failing hook is logged rather than returned, so it never masks the result of the stop
+func (c *Client) RunPostStopHook(v *vm.VM) {
+	if c.PostStopHook == nil {
+		return
+	}
+	if err := c.PostStopHook(v); err != nil {
+		c.Logger.Warnf("Post-stop hook for VM %s failed: %v", v.Name, err)
+	}
+}
+
+// stopVMM asks a VMM to shut down, through the guest agent if configured, and forces it if that fails
+func (c *Client) stopVMM(ctx context.Context, socketPath string, opts StopOptions) error {
+	if opts.AgentVsockPath != "" {
+		err := c.shutdownViaAgent(ctx, socketPath, opts.AgentVsockPath, opts.AgentTimeout)
+		if err == nil {
+			return nil
+		}
+		c.Logger.Warnf("Guest agent shutdown failed, falling back to SDK shutdown: %v", err)
+	}
+
 	// Connect to existing machine
 	machine, err := c.connectToMachine(ctx, socketPath)
 	if err != nil {
@@ -199,7 +645,8 @@ func (c *Client) StopVM(ctx context.Context, socketPath string) error {
 	return nil
 }
 
-// connectToMachine connects to an existing Firecracker instance
+// connectToMachine connects to an existing Firecracker instance and checks that its API answers
+// It returns ErrVMMUnresponsive if the API does not respond within the client's ConnectTimeout
 func (c *Client) connectToMachine(ctx context.Context, socketPath string) (*sdk.Machine, error) {
 	if _, err := os.Stat(socketPath); err != nil {
 		return nil, fmt.Errorf("socket not found: %w", err)
@@ -217,34 +664,36 @@ func (c *Client) connectToMachine(ctx context.Context, socketPath string) (*sdk.
 		return nil, err
 	}
 
+	// The SDK connects lazily, so make one request now to find out whether the VMM is wedged
+	timeout := c.ConnectTimeout
+	if timeout <= 0 {
+		timeout = DefaultConnectTimeout
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if _, err := machine.DescribeInstanceInfo(probeCtx); err != nil {
+		if errors.Is(probeCtx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: no response on %s within %s", ErrVMMUnresponsive, socketPath, timeout)
+		}
+		return nil, err
+	}
+
 	return machine, nil
 }
 
-// IsRunning checks if a VM is running by checking the socket and process
+// IsRunning checks if a VM is running by checking its process and socket
+// The process is the primary check when its PID is known; the socket must also exist, which
+// guards against a reused PID. Without a PID, a socket file counts only while something still
+// accepts connections on it, so one left behind by an exited VMM is ignored
 func (c *Client) IsRunning(socketPath string, pid int) bool {
-	// Check if socket exists
 	if _, err := os.Stat(socketPath); err != nil {
 		return false
 	}
-
-	// Check if process is running
 	if pid > 0 {
-		process, err := os.FindProcess(pid)
-		if err != nil {
-			return false
-		}
-		// On Unix, FindProcess always succeeds, so we need to send signal 0
-		if err := process.Signal(syscall.Signal(0)); err != nil {
-			// EPERM means the process exists but we don't have permission to signal it
-			// This happens when the VM runs as root but vmm is run as regular user
-			if err == syscall.EPERM {
-				return true
-			}
-			return false
-		}
+		// processAlive treats EPERM as alive: the VM runs as root but vmm may be run as a regular user
+		return processAlive(pid)
 	}
-
-	return true
+	return socketListening(socketPath)
 }
 
 // GetVMPID extracts the PID from the machine (if available)
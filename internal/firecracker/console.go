@@ -0,0 +1,138 @@
+package firecracker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// Console types for VMConfig.ConsoleType
+const (
+	ConsoleSerial = "serial" // Emulated 8250 UART, ttyS0 in the guest (the default)
+	ConsoleVirtio = "virtio" // virtio-console, hvc0 in the guest
+)
+
+// consoleKernelArg returns the console= kernel argument for a console type
+func consoleKernelArg(consoleType string) (string, error) {
+	switch consoleType {
+	case "", ConsoleSerial:
+		return "console=ttyS0", nil
+	case ConsoleVirtio:
+		// The Firecracker API has no console device to configure; its only console is the serial port
+		return "", fmt.Errorf("virtio console is not supported: Firecracker only emulates a serial console (ttyS0)")
+	default:
+		return "", fmt.Errorf("invalid console type '%s': expected %s or %s", consoleType, ConsoleSerial, ConsoleVirtio)
+	}
+}
+
+// ValidateInit checks a guest init path for the init= kernel argument
+func ValidateInit(init string) error {
+	if init == "" {
+		return nil
+	}
+	if !path.IsAbs(init) {
+		return fmt.Errorf("init '%s' must be an absolute path in the guest", init)
+	}
+	if strings.ContainsAny(init, " \t\n") {
+		return fmt.Errorf("init '%s' cannot contain whitespace", init)
+	}
+	return nil
+}
+
+// consolePollInterval is how often AttachConsole checks the log for new console output
+const consolePollInterval = 100 * time.Millisecond
+
+// ConsolePath returns the FIFO that feeds the serial console input of the VM using socketPath
+func ConsolePath(socketPath string) string {
+	return strings.TrimSuffix(socketPath, ".sock") + ".console"
+}
+
+// createConsoleInput makes a fresh console FIFO and opens it for the Firecracker process's stdin
+// It is opened read-write so the open doesn't wait for a writer and the VM never sees end of input
+func createConsoleInput(path string) (*os.File, error) {
+	os.Remove(path)
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		return nil, fmt.Errorf("failed to create console input %s: %w", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open console input %s: %w", path, err)
+	}
+	return f, nil
+}
+
+// AttachConsole streams a running VM's serial console: output from its log is copied to out
+// and anything read from in is sent to the guest
+// It returns when ctx is cancelled or in is exhausted, leaving the VM running, or when the VM stops
+func (c *Client) AttachConsole(ctx context.Context, v *vm.VM, out io.Writer, in io.Reader) error {
+	if !c.IsRunning(v.SocketPath, v.PID) {
+		return fmt.Errorf("VM '%s' is not running", v.Name)
+	}
+	if v.LogPath == "" {
+		return fmt.Errorf("VM '%s' was started without a console log; restart it to attach", v.Name)
+	}
+
+	logFile, err := os.Open(v.LogPath)
+	if err != nil {
+		return fmt.Errorf("failed to open console log: %w", err)
+	}
+	defer logFile.Close()
+	// Only show what the guest writes from now on
+	if _, err := logFile.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("failed to open console log: %w", err)
+	}
+
+	inputDone := make(chan error, 1)
+	if in != nil {
+		// The VM process holds the read end, so opening for write doesn't block
+		input, err := os.OpenFile(ConsolePath(v.SocketPath), os.O_WRONLY, 0)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return fmt.Errorf("VM '%s' was started without console input; restart it to attach", v.Name)
+			}
+			return fmt.Errorf("failed to open console input: %w", err)
+		}
+		defer input.Close()
+		go func() {
+			_, err := io.Copy(input, in)
+			inputDone <- err
+		}()
+	}
+
+	ticker := time.NewTicker(consolePollInterval)
+	defer ticker.Stop()
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := logFile.Read(buf)
+		if n > 0 {
+			if _, err := out.Write(buf[:n]); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read console log: %w", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-inputDone:
+			if err != nil {
+				return fmt.Errorf("failed to send console input: %w", err)
+			}
+			return nil
+		case <-ticker.C:
+			if !c.IsRunning(v.SocketPath, v.PID) {
+				return fmt.Errorf("VM '%s' has stopped", v.Name)
+			}
+		}
+	}
+}
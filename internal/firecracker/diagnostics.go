@@ -0,0 +1,189 @@
+package firecracker
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	sdk "github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/sirupsen/logrus"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// diagnosticsLogTailBytes is how much of the end of each log generation a diagnostics bundle keeps
+const diagnosticsLogTailBytes = 4 << 20
+
+// redacted replaces secret values in a diagnostics bundle
+const redacted = "[REDACTED]"
+
+// secretPatterns find secrets in text going into a diagnostics bundle; the first group of each is kept
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?s)(-----BEGIN [A-Z ]*PRIVATE KEY-----).*?-----END [A-Z ]*PRIVATE KEY-----`),
+	regexp.MustCompile(`(?i)(\b(?:proxy-)?authorization"?\s*[:=]\s*"?)[^\r\n"]+`),
+	regexp.MustCompile(`(?i)(\b[\w.-]*(?:password|passwd|passphrase|secret|token|api[_-]?key|access[_-]?key)[\w.-]*"?\s*[:=]\s*"?)[^\s"&,;]+`),
+}
+
+// redactSecrets masks private keys, authorization headers and values of password-, token- and
+// key-like settings
+func redactSecrets(data []byte) []byte {
+	for _, pattern := range secretPatterns {
+		data = pattern.ReplaceAll(data, []byte("${1}"+redacted))
+	}
+	return data
+}
+
+// diagnosticsHost describes the host in a diagnostics bundle
+type diagnosticsHost struct {
+	CollectedAt        time.Time `json:"collected_at"`
+	Arch               string    `json:"arch"`
+	KernelRelease      string    `json:"kernel_release"`
+	FirecrackerVersion string    `json:"firecracker_version,omitempty"`
+}
+
+// CollectDiagnostics writes a tar.gz bundle for a bug report about a VM to outPath. It holds the
+// VM's saved configuration, its status (kernel args, process CPU and memory use, instance info),
+// the configuration Firecracker is running with when the VM is up, the end of its Firecracker
+// and console log and the previous log generation, and a description of the host. Private keys,
+// authorization headers, password- and token-like values, environment variable values and the
+// SSH key are redacted. Anything that can't be read is left out rather than failing the bundle
+func (c *Client) CollectDiagnostics(v *vm.VM, outPath string) error {
+	dir := v.Name + "-diagnostics"
+	files := make(map[string][]byte)
+	var order []string
+	add := func(name string, data []byte) {
+		files[name] = redactSecrets(data)
+		order = append(order, name)
+	}
+	addJSON := func(name string, value any) error {
+		data, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", name, err)
+		}
+		add(name, append(data, '\n'))
+		return nil
+	}
+
+	status, err := c.Status(v)
+	if err != nil {
+		return err
+	}
+	if err := addJSON("vm.json", redactVM(v)); err != nil {
+		return err
+	}
+	if err := addJSON("status.json", status); err != nil {
+		return err
+	}
+	if status.State == vm.StateRunning {
+		client := sdk.NewClient(v.SocketPath, logrus.NewEntry(c.Logger), false)
+		if resp, err := client.GetExportVMConfig(); err == nil && resp.Payload != nil {
+			if err := addJSON("firecracker-config.json", resp.Payload); err != nil {
+				return err
+			}
+		} else if err != nil {
+			c.Logger.Debugf("Failed to read Firecracker config of VM '%s': %v", v.Name, err)
+		}
+	}
+
+	host := diagnosticsHost{CollectedAt: time.Now().UTC(), Arch: runtime.GOARCH}
+	if release, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		host.KernelRelease = strings.TrimSpace(string(release))
+	}
+	if version, err := c.firecrackerVersion(); err == nil {
+		host.FirecrackerVersion = version
+	}
+	if err := addJSON("host.json", host); err != nil {
+		return err
+	}
+
+	if v.LogPath != "" {
+		for _, path := range []string{v.LogPath, v.LogPath + ".1"} {
+			data, err := readTail(path, diagnosticsLogTailBytes)
+			if err != nil {
+				continue
+			}
+			add(filepath.Base(path), data)
+		}
+	}
+
+	tmpPath := outPath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("failed to create diagnostics bundle: %w", err)
+	}
+	err = writeDiagnostics(f, dir, order, files)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, outPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write diagnostics bundle: %w", err)
+	}
+	return nil
+}
+
+// writeDiagnostics writes the named files, in order, under dir in a tar.gz stream
+func writeDiagnostics(w io.Writer, dir string, order []string, files map[string][]byte) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, name := range order {
+		data := files[name]
+		header := &tar.Header{Name: dir + "/" + name, Mode: 0644, Size: int64(len(data)), ModTime: now}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// redactVM returns a copy of a VM with its environment values and SSH key masked
+func redactVM(v *vm.VM) *vm.VM {
+	copied := *v
+	if v.SSHPublicKey != "" {
+		copied.SSHPublicKey = redacted
+	}
+	if len(v.EnvVars) > 0 {
+		copied.EnvVars = make(map[string]string, len(v.EnvVars))
+		for name := range v.EnvVars {
+			copied.EnvVars[name] = redacted
+		}
+	}
+	return &copied
+}
+
+// readTail reads up to max bytes from the end of a file
+func readTail(path string, max int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > max {
+		if _, err := f.Seek(info.Size()-max, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return io.ReadAll(f)
+}
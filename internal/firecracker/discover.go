@@ -0,0 +1,110 @@
+package firecracker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DiscoveredVM is a Firecracker instance found on the host without relying on saved VM state
+type DiscoveredVM struct {
+	Name       string `json:"name"`        // Socket file name without the .sock extension
+	SocketPath string `json:"socket_path"` // API socket path (may no longer exist if only the process was found)
+	Reachable  bool   `json:"reachable"`   // Whether the API answered
+	PID        int    `json:"pid,omitempty"`
+	Error      string `json:"error,omitempty"` // Why the API could not be reached
+}
+
+// DiscoverRunningVMs finds Firecracker instances whose API sockets live in socketDir
+// Each socket is probed through the API, and Firecracker processes are matched to sockets
+// through their --api-sock argument, so processes whose socket was deleted are reported too
+func (c *Client) DiscoverRunningVMs(socketDir string) ([]DiscoveredVM, error) {
+	entries, err := os.ReadDir(socketDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read socket directory: %w", err)
+	}
+
+	pids, err := firecrackerProcesses()
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]*DiscoveredVM)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sock") {
+			continue
+		}
+		socketPath := filepath.Join(socketDir, entry.Name())
+		discovered := &DiscoveredVM{
+			Name:       strings.TrimSuffix(entry.Name(), ".sock"),
+			SocketPath: socketPath,
+			PID:        pids[socketPath],
+		}
+		if _, err := c.connectToMachine(context.Background(), socketPath); err != nil {
+			discovered.Error = err.Error()
+		} else {
+			discovered.Reachable = true
+		}
+		found[socketPath] = discovered
+	}
+
+	// Processes still running after their socket file was removed
+	cleanDir := filepath.Clean(socketDir)
+	for socketPath, pid := range pids {
+		if _, ok := found[socketPath]; ok || filepath.Dir(socketPath) != cleanDir {
+			continue
+		}
+		found[socketPath] = &DiscoveredVM{
+			Name:       strings.TrimSuffix(filepath.Base(socketPath), ".sock"),
+			SocketPath: socketPath,
+			PID:        pid,
+			Error:      "API socket is missing",
+		}
+	}
+
+	discovered := make([]DiscoveredVM, 0, len(found))
+	for _, d := range found {
+		discovered = append(discovered, *d)
+	}
+	sort.Slice(discovered, func(i, j int) bool {
+		return discovered[i].SocketPath < discovered[j].SocketPath
+	})
+	return discovered, nil
+}
+
+// firecrackerProcesses maps the API socket path of every running Firecracker process to its PID
+func firecrackerProcesses() (map[string]int, error) {
+	procEntries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read /proc: %w", err)
+	}
+
+	pids := make(map[string]int)
+	for _, entry := range procEntries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		cmdline, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "cmdline"))
+		if err != nil || len(cmdline) == 0 {
+			continue
+		}
+
+		args := strings.Split(string(bytes.TrimRight(cmdline, "\x00")), "\x00")
+		if filepath.Base(args[0]) != "firecracker" {
+			continue
+		}
+		for i, arg := range args {
+			if arg == "--api-sock" && i+1 < len(args) {
+				pids[filepath.Clean(args[i+1])] = pid
+				break
+			}
+		}
+	}
+	return pids, nil
+}
@@ -0,0 +1,90 @@
+package firecracker
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// diskSnapshotPaths lists the writable images a suspended VM resumes with: its rootfs and
+// mount images. Shared mount images are refused, as other VMs write to them, and so are
+// host drives, which may be whole disks
+func diskSnapshotPaths(v *vm.VM) ([]string, error) {
+	if len(v.Drives) > 0 {
+		return nil, fmt.Errorf("host drive %s can't be snapshotted with the VM", v.Drives[0].Path)
+	}
+	paths := []string{v.RootfsPath}
+	for _, m := range v.Mounts {
+		if m.IsTmpfs() {
+			continue
+		}
+		if m.Shared {
+			return nil, fmt.Errorf("mount '%s' uses a shared image, which can't be snapshotted with the VM", m.GuestTag)
+		}
+		if m.ImagePath == "" {
+			return nil, fmt.Errorf("mount '%s' has no image", m.GuestTag)
+		}
+		paths = append(paths, m.ImagePath)
+	}
+	return paths, nil
+}
+
+// SnapshotDisks copies a suspended VM's rootfs and mount images into dir and returns the copies
+// by image path, for v.DiskSnapshots. Copies are reflinked where the filesystem supports it and
+// otherwise take as much disk as the images hold. On failure no copies are left
+func SnapshotDisks(v *vm.VM, dir string) (map[string]string, error) {
+	paths, err := diskSnapshotPaths(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	copies := make(map[string]string, len(paths))
+	for i, path := range paths {
+		copyPath := filepath.Join(dir, fmt.Sprintf("%d-%s", i, filepath.Base(path)))
+		if err := copyImage(path, copyPath); err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		copies[path] = copyPath
+	}
+	return copies, nil
+}
+
+// RestoreDisks puts the copies taken by SnapshotDisks back in place of the live images, so a
+// resumed VM finds its disks as they were when it was suspended. The copies are kept
+func RestoreDisks(v *vm.VM) error {
+	for path, copyPath := range v.DiskSnapshots {
+		tmpPath := path + ".restore"
+		if err := copyImage(copyPath, tmpPath); err != nil {
+			return err
+		}
+		if err := os.Rename(tmpPath, path); err != nil {
+			os.Remove(tmpPath)
+			return fmt.Errorf("failed to restore %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// DiscardDiskSnapshots deletes the copies taken by SnapshotDisks and forgets them
+func DiscardDiskSnapshots(v *vm.VM) {
+	for _, copyPath := range v.DiskSnapshots {
+		os.Remove(copyPath)
+		os.Remove(filepath.Dir(copyPath))
+	}
+	v.DiskSnapshots = nil
+}
+
+// copyImage copies a disk image, sharing its blocks with the original where possible
+func copyImage(src, dst string) error {
+	if output, err := exec.Command("cp", "--reflink=auto", "--sparse=always", src, dst).CombinedOutput(); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("failed to copy %s: %w: %s", src, err, string(output))
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package firecracker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+func TestSnapshotAndRestoreDisks(t *testing.T) {
+	dir := t.TempDir()
+	rootfs := filepath.Join(dir, "rootfs.ext4")
+	data := filepath.Join(dir, "data.img")
+	for _, path := range []string{rootfs, data} {
+		if err := os.WriteFile(path, []byte("before "+path), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v := &vm.VM{Name: "test", RootfsPath: rootfs, Mounts: []vm.Mount{{GuestTag: "data", ImagePath: data}}}
+
+	copies, err := SnapshotDisks(v, filepath.Join(dir, "test.disks"))
+	if err != nil {
+		t.Fatalf("SnapshotDisks: %v", err)
+	}
+	if len(copies) != 2 {
+		t.Fatalf("got %d copies, want 2", len(copies))
+	}
+	v.DiskSnapshots = copies
+
+	for _, path := range []string{rootfs, data} {
+		os.WriteFile(path, []byte("after"), 0644)
+	}
+	if err := RestoreDisks(v); err != nil {
+		t.Fatalf("RestoreDisks: %v", err)
+	}
+	for _, path := range []string{rootfs, data} {
+		got, _ := os.ReadFile(path)
+		if string(got) != "before "+path {
+			t.Errorf("%s = %q after restore, want its snapshot", path, got)
+		}
+	}
+
+	DiscardDiskSnapshots(v)
+	if _, err := os.Stat(filepath.Join(dir, "test.disks")); !os.IsNotExist(err) {
+		t.Errorf("snapshot directory left after discard: %v", err)
+	}
+}
+
+func TestSnapshotDisksRefusesSharedMount(t *testing.T) {
+	v := &vm.VM{Name: "test", RootfsPath: "/nonexistent", Mounts: []vm.Mount{{GuestTag: "data", ImagePath: "/x.img", Shared: true}}}
+	if _, err := SnapshotDisks(v, t.TempDir()); err == nil {
+		t.Error("SnapshotDisks accepted a shared mount")
+	}
+}
@@ -0,0 +1,29 @@
+package firecracker
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Drive cache types, as Firecracker names them
+const (
+	// CacheWriteback honours guest flushes, so data the guest has synced survives a host crash (the default)
+	CacheWriteback = "Writeback"
+	// CacheUnsafe ignores guest flushes: faster, but a host crash can lose writes the guest believes are on disk
+	CacheUnsafe = "Unsafe"
+)
+
+// ParseCacheType converts a user-supplied cache type to Firecracker's name for it
+// Empty selects CacheWriteback
+func ParseCacheType(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case "", "writeback":
+		return CacheWriteback, nil
+	case "unsafe":
+		return CacheUnsafe, nil
+	case "writethrough":
+		return "", fmt.Errorf("cache type 'writethrough' is not supported by Firecracker: use writeback or unsafe")
+	default:
+		return "", fmt.Errorf("invalid cache type '%s': expected writeback or unsafe", name)
+	}
+}
@@ -0,0 +1,172 @@
+package firecracker
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+
+	sdk "github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/firecracker-microvm/firecracker-go-sdk/client/models"
+)
+
+// modulesDriveID is the drive ID of the kernel modules image
+const modulesDriveID = "modules"
+
+// seedDriveID is the drive ID of the cloud-init seed
+const seedDriveID = "seed"
+
+// DriveSpec describes a block device attached as is, outside the mount abstraction
+type DriveSpec struct {
+	Path     string // Image file or block device on the host
+	ReadOnly bool
+	ID       string // Firecracker drive ID (empty = extraN by position)
+}
+
+// reservedDriveID matches the drive IDs StartVM assigns itself
+var reservedDriveID = regexp.MustCompile(`^(rootfs|modules|seed|verity|mount[0-9]+)$`)
+
+// driveIDPattern matches the drive IDs Firecracker accepts
+var driveIDPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// reservedMountDriveID matches the drive IDs a mount can't be given explicitly
+var reservedMountDriveID = regexp.MustCompile(`^(rootfs|modules|seed|verity|extra[0-9]+)$`)
+
+// ValidateMountDriveID checks an explicit drive ID for a mount; empty means mount<N> by position
+func ValidateMountDriveID(id string) error {
+	if id == "" {
+		return nil
+	}
+	if !driveIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid drive ID '%s': use letters, digits and underscores", id)
+	}
+	if reservedMountDriveID.MatchString(id) {
+		return fmt.Errorf("drive ID '%s' is reserved for the rootfs, modules, seed, verity or extra drives", id)
+	}
+	return nil
+}
+
+// CheckMountDrives verifies that each mount drive's image exists and is a regular file, or a block
+// device for an opened encrypted image, so a deleted image is reported by mount rather than by
+// Firecracker's drive setup
+func CheckMountDrives(drives []MountDrive) error {
+	for _, d := range drives {
+		info, err := os.Stat(d.ImagePath)
+		if err != nil {
+			return fmt.Errorf("mount '%s': image not found at %s: %w", d.Tag, d.ImagePath, err)
+		}
+		if !info.Mode().IsRegular() && info.Mode()&os.ModeDevice == 0 {
+			return fmt.Errorf("mount '%s': image %s is not a regular file", d.Tag, d.ImagePath)
+		}
+	}
+	return nil
+}
+
+// OrderMountDrives returns the mount drives in the order they are attached: by DriveOrder,
+// then by their position in the list. The guest names them /dev/vdb, /dev/vdc, ... in this order
+func OrderMountDrives(drives []MountDrive) []MountDrive {
+	ordered := append([]MountDrive(nil), drives...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].DriveOrder < ordered[j].DriveOrder
+	})
+	return ordered
+}
+
+// extraDrives validates the extra drives and converts them to Firecracker drives
+func extraDrives(specs []DriveSpec) ([]models.Drive, error) {
+	var drives []models.Drive
+	seen := make(map[string]bool, len(specs))
+	for i, spec := range specs {
+		id := extraDriveID(i, spec)
+		if reservedDriveID.MatchString(id) {
+			return nil, fmt.Errorf("extra drive ID '%s' is reserved for the rootfs, mount, modules, seed or verity drives", id)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("duplicate extra drive ID '%s'", id)
+		}
+		seen[id] = true
+		if _, err := CheckDrivePath(spec.Path); err != nil {
+			return nil, fmt.Errorf("extra drive '%s': %w", id, err)
+		}
+		drives = append(drives, models.Drive{
+			DriveID:      sdk.String(id),
+			PathOnHost:   sdk.String(spec.Path),
+			IsRootDevice: sdk.Bool(false),
+			IsReadOnly:   sdk.Bool(spec.ReadOnly),
+		})
+	}
+	return drives, nil
+}
+
+// Kinds of drive in a DriveLayout
+const (
+	DriveKindRootfs  = "rootfs"
+	DriveKindMount   = "mount"
+	DriveKindModules = "modules"
+	DriveKindSeed    = "seed"
+	DriveKindExtra   = "extra"
+	DriveKindVerity  = "verity"
+)
+
+// DriveInfo describes one drive of a VM as the guest sees it
+type DriveInfo struct {
+	Kind     string // DriveKindRootfs, DriveKindMount, DriveKindModules, DriveKindSeed, DriveKindExtra or DriveKindVerity
+	ID       string // Firecracker drive ID
+	Device   string // Guest block device, e.g. /dev/vdb
+	HostPath string
+	ReadOnly bool
+	Tag      string // Mount tag, for mount drives
+}
+
+// GuestDeviceName returns the guest block device of the drive attached at index: virtio
+// disks are named in attach order /dev/vda ... /dev/vdz, then /dev/vdaa, /dev/vdab, ...
+func GuestDeviceName(index int) string {
+	name := ""
+	for n := index; ; n = n/26 - 1 {
+		name = string(rune('a'+n%26)) + name
+		if n < 26 {
+			break
+		}
+	}
+	return "/dev/vd" + name
+}
+
+// DriveLayout returns the drives StartVM attaches for this config, in attach order: the rootfs,
+// the mount drives in OrderMountDrives order, the modules image, the cloud-init seed, the
+// extra drives and the rootfs's verity hash tree. It doesn't check the config; StartVM rejects
+// invalid or missing drives
+func (cfg *VMConfig) DriveLayout() []DriveInfo {
+	layout := []DriveInfo{{Kind: DriveKindRootfs, ID: "rootfs", HostPath: cfg.RootfsPath,
+		ReadOnly: cfg.RootfsReadOnly || cfg.VerityHashTreePath != ""}}
+	for i, drive := range OrderMountDrives(cfg.MountDrives) {
+		id := drive.DriveID
+		if id == "" {
+			id = MountDriveID(i)
+		}
+		layout = append(layout, DriveInfo{Kind: DriveKindMount, ID: id, HostPath: drive.ImagePath, ReadOnly: drive.ReadOnly, Tag: drive.Tag})
+	}
+	if cfg.ModulesImagePath != "" {
+		layout = append(layout, DriveInfo{Kind: DriveKindModules, ID: modulesDriveID, HostPath: cfg.ModulesImagePath, ReadOnly: true})
+	}
+	if cfg.SeedISOPath != "" {
+		layout = append(layout, DriveInfo{Kind: DriveKindSeed, ID: seedDriveID, HostPath: cfg.SeedISOPath, ReadOnly: true})
+	}
+	for i, spec := range cfg.ExtraDrives {
+		layout = append(layout, DriveInfo{Kind: DriveKindExtra, ID: extraDriveID(i, spec), HostPath: spec.Path, ReadOnly: spec.ReadOnly})
+	}
+	if cfg.VerityHashTreePath != "" {
+		layout = append(layout, DriveInfo{Kind: DriveKindVerity, ID: verityDriveID, HostPath: cfg.VerityHashTreePath, ReadOnly: true})
+	}
+	for i := range layout {
+		layout[i].Device = GuestDeviceName(i)
+	}
+	return layout
+}
+
+// extraDriveID returns the drive ID of the extra drive at position i
+func extraDriveID(i int, spec DriveSpec) string {
+	if spec.ID != "" {
+		return spec.ID
+	}
+	return fmt.Sprintf("extra%d", i)
+}
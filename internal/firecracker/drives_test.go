@@ -0,0 +1,72 @@
+package firecracker
+
+import "testing"
+
+func TestOrderMountDrives(t *testing.T) {
+	drives := []MountDrive{
+		{Tag: "data"},
+		{Tag: "logs", DriveOrder: -1},
+		{Tag: "cache"},
+		{Tag: "code", DriveOrder: 5},
+	}
+	ordered := OrderMountDrives(drives)
+
+	want := []string{"logs", "data", "cache", "code"}
+	for i, tag := range want {
+		if ordered[i].Tag != tag {
+			t.Fatalf("position %d: got %q, want %q", i, ordered[i].Tag, tag)
+		}
+	}
+	if drives[0].Tag != "data" || drives[1].Tag != "logs" {
+		t.Errorf("OrderMountDrives reordered its input")
+	}
+}
+
+func TestValidateMountDriveID(t *testing.T) {
+	for _, id := range []string{"", "data", "mount3", "disk_1"} {
+		if err := ValidateMountDriveID(id); err != nil {
+			t.Errorf("ValidateMountDriveID(%q) = %v, want nil", id, err)
+		}
+	}
+	for _, id := range []string{"rootfs", "modules", "seed", "extra0", "my-disk", "a b"} {
+		if err := ValidateMountDriveID(id); err == nil {
+			t.Errorf("ValidateMountDriveID(%q) = nil, want an error", id)
+		}
+	}
+}
+
+func TestGuestDeviceName(t *testing.T) {
+	for index, want := range map[int]string{0: "/dev/vda", 1: "/dev/vdb", 25: "/dev/vdz", 26: "/dev/vdaa", 27: "/dev/vdab", 52: "/dev/vdba"} {
+		if got := GuestDeviceName(index); got != want {
+			t.Errorf("GuestDeviceName(%d) = %q, want %q", index, got, want)
+		}
+	}
+}
+
+func TestDriveLayout(t *testing.T) {
+	cfg := &VMConfig{
+		RootfsPath: "/vms/web.ext4",
+		MountDrives: []MountDrive{
+			{Tag: "data", ImagePath: "/mounts/data.ext4"},
+			{Tag: "logs", ImagePath: "/mounts/logs.ext4", DriveOrder: -1, DriveID: "logs_drive"},
+		},
+		ModulesImagePath: "/images/modules.ext4",
+		ExtraDrives:      []DriveSpec{{Path: "/dev/sdb", ReadOnly: true}},
+	}
+	want := []DriveInfo{
+		{Kind: DriveKindRootfs, ID: "rootfs", Device: "/dev/vda", HostPath: "/vms/web.ext4"},
+		{Kind: DriveKindMount, ID: "logs_drive", Device: "/dev/vdb", HostPath: "/mounts/logs.ext4", Tag: "logs"},
+		{Kind: DriveKindMount, ID: "mount1", Device: "/dev/vdc", HostPath: "/mounts/data.ext4", Tag: "data"},
+		{Kind: DriveKindModules, ID: "modules", Device: "/dev/vdd", HostPath: "/images/modules.ext4", ReadOnly: true},
+		{Kind: DriveKindExtra, ID: "extra0", Device: "/dev/vde", HostPath: "/dev/sdb", ReadOnly: true},
+	}
+	got := cfg.DriveLayout()
+	if len(got) != len(want) {
+		t.Fatalf("got %d drives, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("drive %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
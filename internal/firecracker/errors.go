@@ -0,0 +1,11 @@
+package firecracker
+
+import "errors"
+
+// Errors callers can test for with errors.Is; they are returned wrapped with the details
+var (
+	// ErrFirecrackerNotFound is returned when no Firecracker binary is installed
+	ErrFirecrackerNotFound = errors.New("firecracker binary not found")
+	// ErrKVMUnavailable is returned when /dev/kvm is missing or can't be opened
+	ErrKVMUnavailable = errors.New("KVM is unavailable")
+)
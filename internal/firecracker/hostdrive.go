@@ -0,0 +1,65 @@
+package firecracker
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// CheckDrivePath verifies that a drive's host path is an image file or a block device, and
+// reports which. Firecracker can't use character devices, directories or sockets as drives
+func CheckDrivePath(path string) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, fmt.Errorf("drive not found at %s: %w", path, err)
+	}
+	mode := info.Mode()
+	switch {
+	case mode.IsRegular():
+		return false, nil
+	case mode&os.ModeDevice != 0 && mode&os.ModeCharDevice == 0:
+		return true, nil
+	}
+	return false, fmt.Errorf("drive %s is neither an image file nor a block device", path)
+}
+
+// CheckDriveConflicts refuses drives of v that another of the given VMs also attaches, unless
+// both attach it read-only: two guests, or a guest and the host, writing one filesystem at once
+// corrupt it. Paths are compared by the device or file they name, so /dev/disk/by-id links and
+// the /dev/sdX node they point to are the same drive
+func CheckDriveConflicts(v *vm.VM, others []*vm.VM) error {
+	for _, d := range v.Drives {
+		info, err := os.Stat(d.Path)
+		if err != nil {
+			continue
+		}
+		for _, other := range others {
+			if other.Name == v.Name {
+				continue
+			}
+			for _, od := range other.Drives {
+				if d.ReadOnly && od.ReadOnly {
+					continue
+				}
+				otherInfo, err := os.Stat(od.Path)
+				if err != nil || !sameDrive(info, otherInfo) {
+					continue
+				}
+				return fmt.Errorf("drive %s is also attached to VM '%s' (as %s); a drive can only be shared read-only", d.Path, other.Name, od.Path)
+			}
+		}
+	}
+	return nil
+}
+
+// sameDrive reports whether two stat results name the same block device or image file
+func sameDrive(a, b os.FileInfo) bool {
+	if a.Mode()&os.ModeDevice != 0 && b.Mode()&os.ModeDevice != 0 {
+		as, aok := a.Sys().(*syscall.Stat_t)
+		bs, bok := b.Sys().(*syscall.Stat_t)
+		return aok && bok && as.Rdev == bs.Rdev
+	}
+	return os.SameFile(a, b)
+}
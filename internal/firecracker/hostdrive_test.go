@@ -0,0 +1,46 @@
+package firecracker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+func TestCheckDrivePath(t *testing.T) {
+	dir := t.TempDir()
+	img := filepath.Join(dir, "disk.img")
+	if err := os.WriteFile(img, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if block, err := CheckDrivePath(img); err != nil || block {
+		t.Errorf("CheckDrivePath(file) = %t, %v; want false, nil", block, err)
+	}
+	for _, path := range []string{dir, "/dev/null", filepath.Join(dir, "missing")} {
+		if _, err := CheckDrivePath(path); err == nil {
+			t.Errorf("CheckDrivePath(%s) accepted it", path)
+		}
+	}
+}
+
+func TestCheckDriveConflicts(t *testing.T) {
+	img := filepath.Join(t.TempDir(), "disk.img")
+	if err := os.WriteFile(img, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := img + ".link"
+	if err := os.Symlink(img, link); err != nil {
+		t.Fatal(err)
+	}
+	other := &vm.VM{Name: "other", Drives: []vm.Drive{{Path: link, ReadOnly: true}}}
+
+	reader := &vm.VM{Name: "reader", Drives: []vm.Drive{{Path: img, ReadOnly: true}}}
+	if err := CheckDriveConflicts(reader, []*vm.VM{other, reader}); err != nil {
+		t.Errorf("read-only sharing refused: %v", err)
+	}
+	writer := &vm.VM{Name: "writer", Drives: []vm.Drive{{Path: img}}}
+	if err := CheckDriveConflicts(writer, []*vm.VM{other}); err == nil {
+		t.Error("read-write drive shared with another VM was accepted")
+	}
+}
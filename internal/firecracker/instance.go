@@ -0,0 +1,51 @@
+package firecracker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	sdk "github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/sirupsen/logrus"
+)
+
+// instanceInfoTimeout bounds an instance info query, so a VMM that stopped answering can't hang a status
+const instanceInfoTimeout = 2 * time.Second
+
+// InstanceInfo is what a Firecracker VMM reports about itself through its API
+type InstanceInfo struct {
+	ID         string `json:"id"`
+	State      string `json:"state"` // Not started, Running or Paused
+	VMMVersion string `json:"vmm_version"`
+	AppName    string `json:"app_name"`
+}
+
+// InstanceInfo connects to a VMM's API socket and asks it for its instance info
+// An answer means a VMM is serving the socket, whatever the recorded PID says
+func (c *Client) InstanceInfo(ctx context.Context, socketPath string) (*InstanceInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, instanceInfoTimeout)
+	defer cancel()
+
+	client := sdk.NewClient(socketPath, logrus.NewEntry(c.Logger), false)
+	resp, err := client.GetInstanceInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instance info: %w", err)
+	}
+	if resp.Payload == nil {
+		return nil, fmt.Errorf("VMM returned no instance info")
+	}
+	return &InstanceInfo{
+		ID:         stringValue(resp.Payload.ID),
+		State:      stringValue(resp.Payload.State),
+		VMMVersion: stringValue(resp.Payload.VmmVersion),
+		AppName:    stringValue(resp.Payload.AppName),
+	}, nil
+}
+
+// stringValue dereferences an optional API string, treating nil as empty
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
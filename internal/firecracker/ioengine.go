@@ -0,0 +1,87 @@
+package firecracker
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Drive I/O engines, as Firecracker names them
+const (
+	IOEngineSync  = "Sync"  // Blocking reads and writes on the VMM thread (the default)
+	IOEngineAsync = "Async" // io_uring; needs host kernel 5.10.51 or later
+)
+
+// ParseIOEngine converts a user-supplied I/O engine to Firecracker's name for it
+// Empty selects IOEngineSync
+func ParseIOEngine(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case "", "sync":
+		return IOEngineSync, nil
+	case "async", "io_uring":
+		return IOEngineAsync, nil
+	default:
+		return "", fmt.Errorf("invalid I/O engine '%s': expected sync or async", name)
+	}
+}
+
+// checkIOUring reports why the host can't run the async engine, or nil if it can
+func checkIOUring() error {
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		return fmt.Errorf("failed to read the kernel version: %w", err)
+	}
+	var release []byte
+	for _, c := range uts.Release {
+		if c == 0 {
+			break
+		}
+		release = append(release, byte(c))
+	}
+	if !kernelAtLeast(string(release), 5, 10, 51) {
+		return fmt.Errorf("host kernel %s is older than 5.10.51", release)
+	}
+	// 2 disables io_uring for every process
+	if data, err := os.ReadFile("/proc/sys/kernel/io_uring_disabled"); err == nil && strings.TrimSpace(string(data)) == "2" {
+		return fmt.Errorf("io_uring is disabled by kernel.io_uring_disabled")
+	}
+	return nil
+}
+
+// kernelAtLeast reports whether a kernel release string such as "6.1.0-18-amd64" is at least major.minor.patch
+func kernelAtLeast(release string, major, minor, patch int) bool {
+	var parts [3]int
+	fields := strings.FieldsFunc(release, func(r rune) bool { return r == '.' || r == '-' || r == '+' })
+	for i := 0; i < len(parts) && i < len(fields); i++ {
+		n, err := strconv.Atoi(fields[i])
+		if err != nil {
+			break
+		}
+		parts[i] = n
+	}
+	want := [3]int{major, minor, patch}
+	for i := range parts {
+		if parts[i] != want[i] {
+			return parts[i] > want[i]
+		}
+	}
+	return true
+}
+
+// resolveIOEngine returns the engine to give Firecracker for a drive, falling back
+// to sync with a warning when the host can't run the async engine
+func (c *Client) resolveIOEngine(drive, name string) (string, error) {
+	engine, err := ParseIOEngine(name)
+	if err != nil {
+		return "", err
+	}
+	if engine == IOEngineAsync {
+		if err := checkIOUring(); err != nil {
+			c.Logger.Warnf("Drive '%s' falls back to the sync I/O engine: %v", drive, err)
+			return IOEngineSync, nil
+		}
+	}
+	return engine, nil
+}
@@ -0,0 +1,90 @@
+package firecracker
+
+import (
+	"fmt"
+	"strings"
+
+	sdk "github.com/firecracker-microvm/firecracker-go-sdk"
+	"github.com/sirupsen/logrus"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// kernelArgKey returns the name a kernel argument is set under: the part before '=', or the whole flag
+func kernelArgKey(arg string) string {
+	key, _, _ := strings.Cut(arg, "=")
+	return key
+}
+
+// MergeKernelArgs adds extra arguments to a default kernel command line
+// An extra argument replaces every default with the same key (console=, init=, ...), so user values win and
+// each key appears once; the rest keep their order, defaults first. Anything after a "--" in extra is
+// passed to init and goes last, unchanged
+func MergeKernelArgs(defaults, extra string) string {
+	extraArgs, initArgs := splitInitArgs(strings.Fields(extra))
+	defaultArgs, defaultInitArgs := splitInitArgs(strings.Fields(defaults))
+
+	// A repeated key in extra also keeps only its last value
+	last := make(map[string]int, len(extraArgs))
+	for i, arg := range extraArgs {
+		last[kernelArgKey(arg)] = i
+	}
+
+	var merged []string
+	for _, arg := range defaultArgs {
+		if _, replaced := last[kernelArgKey(arg)]; !replaced {
+			merged = append(merged, arg)
+		}
+	}
+	for i, arg := range extraArgs {
+		if last[kernelArgKey(arg)] == i {
+			merged = append(merged, arg)
+		}
+	}
+	if initArgs = append(defaultInitArgs, initArgs...); len(initArgs) > 0 {
+		merged = append(append(merged, "--"), initArgs...)
+	}
+	return strings.Join(merged, " ")
+}
+
+// splitInitArgs splits kernel command line fields at the "--" that starts init's arguments
+func splitInitArgs(fields []string) (kernel, init []string) {
+	for i, field := range fields {
+		if field == "--" {
+			return fields[:i], fields[i+1:]
+		}
+	}
+	return fields, nil
+}
+
+// GetKernelArgs returns the kernel command line a VM was booted with
+// StartVM's result records it and the caller stores it on the VM; for a running VM without it,
+// such as one started by an older vmm, the boot source is read back from the Firecracker API.
+// An empty string means it isn't known
+func (c *Client) GetKernelArgs(v *vm.VM) string {
+	if v.KernelArgs != "" {
+		return v.KernelArgs
+	}
+	if !c.IsRunning(v.SocketPath, v.PID) {
+		return ""
+	}
+	args, err := c.queryKernelArgs(v.SocketPath)
+	if err != nil {
+		c.Logger.Debugf("Failed to read kernel args of VM '%s': %v", v.Name, err)
+		return ""
+	}
+	return args
+}
+
+// queryKernelArgs reads the boot args from a running VMM's exported configuration
+func (c *Client) queryKernelArgs(socketPath string) (string, error) {
+	client := sdk.NewClient(socketPath, logrus.NewEntry(c.Logger), false)
+	resp, err := client.GetExportVMConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to get VM config: %w", err)
+	}
+	if resp.Payload == nil || resp.Payload.BootSource == nil {
+		return "", fmt.Errorf("VM config has no boot source")
+	}
+	return resp.Payload.BootSource.BootArgs, nil
+}
@@ -0,0 +1,45 @@
+package firecracker
+
+import "testing"
+
+func TestMergeKernelArgs(t *testing.T) {
+	defaults := "console=ttyS0 reboot=k panic=1 pci=off ip=172.16.0.2::172.16.0.1:255.255.0.0::eth0:off"
+	tests := []struct {
+		name  string
+		extra string
+		want  string
+	}{
+		{
+			name:  "additions go after the defaults",
+			extra: "quiet systemd.unit=rescue.target",
+			want:  defaults + " quiet systemd.unit=rescue.target",
+		},
+		{
+			name:  "user values replace defaults with the same key",
+			extra: "panic=10 console=hvc0",
+			want:  "reboot=k pci=off ip=172.16.0.2::172.16.0.1:255.255.0.0::eth0:off panic=10 console=hvc0",
+		},
+		{
+			name:  "the last of a repeated key wins",
+			extra: "loglevel=3 loglevel=7",
+			want:  defaults + " loglevel=7",
+		},
+		{
+			name:  "init arguments stay last",
+			extra: "quiet -- single",
+			want:  defaults + " quiet -- single",
+		},
+		{
+			name:  "no additions",
+			extra: "",
+			want:  defaults,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MergeKernelArgs(defaults, tt.extra); got != tt.want {
+				t.Errorf("MergeKernelArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
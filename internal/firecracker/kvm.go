@@ -0,0 +1,81 @@
+package firecracker
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// accessReadWrite is the R_OK|W_OK mode for access(2)
+const accessReadWrite = 0x6
+
+// CheckKVM verifies that /dev/kvm exists and that this process can open it for reading and writing
+// The error says what to do about it: load the module, enable virtualization or join the device's group
+func CheckKVM() error {
+	info, err := os.Stat(kvmDevice)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s not found: %s", ErrKVMUnavailable, kvmDevice, kvmMissingHint())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", kvmDevice, err)
+	}
+	if info.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("%w: %s is not a character device", ErrKVMUnavailable, kvmDevice)
+	}
+
+	if err := syscall.Access(kvmDevice, accessReadWrite); err != nil {
+		hint := "run vmm as root (e.g. with sudo)"
+		if group := deviceGroup(info); group != "" && group != "root" {
+			hint = fmt.Sprintf("add your user to the %s group ('sudo usermod -aG %s $USER', then log in again) or %s", group, group, hint)
+		}
+		return fmt.Errorf("%w: no read/write access to %s: %s", ErrKVMUnavailable, kvmDevice, hint)
+	}
+	return nil
+}
+
+// kvmMissingHint explains why /dev/kvm may be missing, from the CPU flags in /proc/cpuinfo
+func kvmMissingHint() string {
+	data, err := os.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return "KVM is not available on this host"
+	}
+
+	flags := make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(key) != "flags" {
+			continue
+		}
+		for _, flag := range strings.Fields(value) {
+			flags[flag] = true
+		}
+		break
+	}
+
+	switch {
+	case flags["vmx"]:
+		return "the CPU supports virtualization, so load the KVM module: 'sudo modprobe kvm_intel'"
+	case flags["svm"]:
+		return "the CPU supports virtualization, so load the KVM module: 'sudo modprobe kvm_amd'"
+	case flags["hypervisor"]:
+		return "this host is itself a VM without nested virtualization; enable nested virtualization on its hypervisor"
+	default:
+		return "the CPU does not report virtualization support; enable Intel VT-x or AMD-V in the BIOS/UEFI settings"
+	}
+}
+
+// deviceGroup returns the name of the group that owns a file, or "" if it can't be determined
+func deviceGroup(info os.FileInfo) string {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	group, err := user.LookupGroupId(strconv.FormatUint(uint64(stat.Gid), 10))
+	if err != nil {
+		return ""
+	}
+	return group.Name
+}
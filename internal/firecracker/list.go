@@ -0,0 +1,71 @@
+package firecracker
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// StateFilter selects VMs by state in ListVMs
+type StateFilter string
+
+const (
+	FilterAll       StateFilter = ""          // Every VM
+	FilterRunning   StateFilter = "running"   // VMs whose Firecracker process is running
+	FilterStopped   StateFilter = "stopped"   // VMs that are not running and have no saved memory
+	FilterSuspended StateFilter = "suspended" // VMs stopped by 'vmm suspend' that resume on start
+)
+
+// ParseStateFilter converts a state name to a StateFilter; "paused" is accepted for suspended VMs
+func ParseStateFilter(name string) (StateFilter, error) {
+	switch name {
+	case "", "all":
+		return FilterAll, nil
+	case string(FilterRunning):
+		return FilterRunning, nil
+	case string(FilterStopped):
+		return FilterStopped, nil
+	case string(FilterSuspended), "paused":
+		return FilterSuspended, nil
+	default:
+		return "", fmt.Errorf("invalid state '%s': expected running, stopped or suspended", name)
+	}
+}
+
+// Matches reports whether a VM passes the filter
+func (f StateFilter) Matches(v *vm.VM) bool {
+	running := v.State == vm.StateRunning
+	switch f {
+	case FilterRunning:
+		return running
+	case FilterStopped:
+		return !running && v.MemSnapshot == ""
+	case FilterSuspended:
+		return !running && v.MemSnapshot != ""
+	default:
+		return true
+	}
+}
+
+// ListVMs returns the VMs saved in vmDir that match filter, sorted by name
+// Unless skipRefresh is set, each VM's state is first checked against its Firecracker process,
+// since the saved state goes stale when a VM exits on its own
+func (c *Client) ListVMs(vmDir string, filter StateFilter, skipRefresh bool) ([]*vm.VM, error) {
+	vms, err := vm.List(vmDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list VMs: %w", err)
+	}
+
+	var matched []*vm.VM
+	for _, v := range vms {
+		if !skipRefresh {
+			c.UpdateVMState(v)
+		}
+		if filter.Matches(v) {
+			matched = append(matched, v)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].Name < matched[j].Name })
+	return matched, nil
+}
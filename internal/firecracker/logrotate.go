@@ -0,0 +1,79 @@
+package firecracker
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// DefaultLogMaxSizeMB is the size at which a VM log is rotated when the config doesn't set one
+	DefaultLogMaxSizeMB = 10
+	// DefaultLogRetention is the number of rotated logs kept when the config doesn't set one
+	DefaultLogRetention = 5
+)
+
+// logStartMarker ends the line openLog writes each time Firecracker is started
+const logStartMarker = ": starting Firecracker ==="
+
+// LogRotation controls how a VM log file is rotated before it is reopened
+// The zero value never rotates
+type LogRotation struct {
+	MaxSizeBytes int64 // Rotate once the log reaches this size
+	Keep         int   // Rotated generations kept as <log>.1 (newest) to <log>.<Keep>
+}
+
+// NewLogRotation builds a rotation policy, using the defaults for values that aren't positive
+func NewLogRotation(maxSizeMB, keep int) LogRotation {
+	if maxSizeMB <= 0 {
+		maxSizeMB = DefaultLogMaxSizeMB
+	}
+	if keep <= 0 {
+		keep = DefaultLogRetention
+	}
+	return LogRotation{MaxSizeBytes: int64(maxSizeMB) * 1024 * 1024, Keep: keep}
+}
+
+// rotateLog moves path to path.1, shifting older generations up and dropping the oldest,
+// if path has reached the maximum size
+func rotateLog(path string, rotation LogRotation) error {
+	if rotation.MaxSizeBytes <= 0 || rotation.Keep <= 0 {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() < rotation.MaxSizeBytes {
+		return nil
+	}
+
+	os.Remove(generation(path, rotation.Keep))
+	for i := rotation.Keep - 1; i >= 1; i-- {
+		if err := os.Rename(generation(path, i), generation(path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(path, generation(path, 1))
+}
+
+// generation returns the file name of the nth rotated copy of a log
+func generation(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// openLog rotates a log if needed and opens it for appending, marking where this run starts
+func openLog(path string, rotation LogRotation) (*os.File, error) {
+	if err := rotateLog(path, rotation); err != nil {
+		return nil, fmt.Errorf("failed to rotate log %s: %w", path, err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log %s: %w", path, err)
+	}
+	fmt.Fprintf(f, "=== %s%s\n", time.Now().Format(time.RFC3339), logStartMarker)
+	return f, nil
+}
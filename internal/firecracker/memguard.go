@@ -0,0 +1,209 @@
+package firecracker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// DefaultMemoryGuardInterval is how often the memory guard checks host memory
+const DefaultMemoryGuardInterval = 5 * time.Second
+
+// MemoryAction is a memory policy's decision to pause or resume one VM
+type MemoryAction struct {
+	VM     *vm.VM
+	Pause  bool   // Pause the VM; false resumes it
+	Reason string // Why, for the log
+}
+
+// MemoryPolicy decides which VMs the memory guard pauses and resumes
+type MemoryPolicy interface {
+	// Decide is given the host's available memory, the running VMs the guard hasn't paused and
+	// the ones it has, and returns what to pause and resume
+	Decide(availableMB int, running, paused []*vm.VM) []MemoryAction
+}
+
+// ThresholdPolicy pauses the lowest-priority running VM while host available memory is below
+// PauseBelowMB and resumes the highest-priority paused VM once it is above ResumeAboveMB. One VM
+// changes per check, so each decision sees the effect of the last; the gap between the two
+// thresholds keeps a VM from being paused and resumed over and over
+type ThresholdPolicy struct {
+	PauseBelowMB  int
+	ResumeAboveMB int
+}
+
+// Validate checks the thresholds are usable
+func (p ThresholdPolicy) Validate() error {
+	if p.PauseBelowMB <= 0 {
+		return fmt.Errorf("pause threshold must be a positive number of MB")
+	}
+	if p.ResumeAboveMB <= p.PauseBelowMB {
+		return fmt.Errorf("resume threshold (%d MB) must be above the pause threshold (%d MB)", p.ResumeAboveMB, p.PauseBelowMB)
+	}
+	return nil
+}
+
+// Decide implements MemoryPolicy
+func (p ThresholdPolicy) Decide(availableMB int, running, paused []*vm.VM) []MemoryAction {
+	switch {
+	case availableMB < p.PauseBelowMB && len(running) > 0:
+		victim := byPriority(running)[0]
+		return []MemoryAction{{VM: victim, Pause: true,
+			Reason: fmt.Sprintf("host has %d MB available, below %d MB", availableMB, p.PauseBelowMB)}}
+	case availableMB > p.ResumeAboveMB && len(paused) > 0:
+		sorted := byPriority(paused)
+		return []MemoryAction{{VM: sorted[len(sorted)-1], Pause: false,
+			Reason: fmt.Sprintf("host has %d MB available, above %d MB", availableMB, p.ResumeAboveMB)}}
+	}
+	return nil
+}
+
+// byPriority returns VMs lowest priority first; among equals the larger VM comes first, as pausing it stops more memory growth
+func byPriority(vms []*vm.VM) []*vm.VM {
+	sorted := append([]*vm.VM(nil), vms...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Priority != sorted[j].Priority {
+			return sorted[i].Priority < sorted[j].Priority
+		}
+		if sorted[i].MemoryMB != sorted[j].MemoryMB {
+			return sorted[i].MemoryMB > sorted[j].MemoryMB
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted
+}
+
+// HostAvailableMemoryMB returns the host's MemAvailable from /proc/meminfo
+func HostAvailableMemoryMB() (int, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read host memory: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// e.g. "MemAvailable:    8123456 kB"
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return 0, fmt.Errorf("malformed MemAvailable in /proc/meminfo: %w", err)
+			}
+			return kb / 1024, nil
+		}
+	}
+	return 0, fmt.Errorf("no MemAvailable in /proc/meminfo")
+}
+
+// MemoryGuard pauses VMs when host memory runs low and resumes them when it recovers, as its
+// policy decides. Pausing stops a guest from touching more memory, which keeps the host from
+// OOM-killing Firecracker processes outright. Only VMs the guard paused are resumed by it
+type MemoryGuard struct {
+	Client   *Client
+	VMDir    string
+	Policy   MemoryPolicy
+	Interval time.Duration // Between checks (0 = DefaultMemoryGuardInterval)
+
+	paused map[string]*vm.VM // By VM name
+}
+
+// NewMemoryGuard creates a memory guard for the VMs saved in vmDir
+func NewMemoryGuard(client *Client, vmDir string, policy MemoryPolicy) *MemoryGuard {
+	return &MemoryGuard{
+		Client:   client,
+		VMDir:    vmDir,
+		Policy:   policy,
+		Interval: DefaultMemoryGuardInterval,
+		paused:   make(map[string]*vm.VM),
+	}
+}
+
+// Run checks host memory every Interval until ctx is cancelled, then resumes the VMs it paused
+func (g *MemoryGuard) Run(ctx context.Context) error {
+	interval := g.Interval
+	if interval <= 0 {
+		interval = DefaultMemoryGuardInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := g.Check(ctx); err != nil {
+			g.Client.Logger.Warnf("Memory check failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			g.resumeAll()
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check reads host memory once and applies the policy's decisions, logging each one
+func (g *MemoryGuard) Check(ctx context.Context) error {
+	availableMB, err := HostAvailableMemoryMB()
+	if err != nil {
+		return err
+	}
+	vms, err := g.Client.ListVMs(g.VMDir, FilterRunning, false)
+	if err != nil {
+		return err
+	}
+
+	// A paused VM that has since stopped is no longer the guard's to resume
+	alive := make(map[string]bool)
+	var running, paused []*vm.VM
+	for _, v := range vms {
+		alive[v.Name] = true
+		if _, ok := g.paused[v.Name]; ok {
+			paused = append(paused, v)
+		} else {
+			running = append(running, v)
+		}
+	}
+	for name := range g.paused {
+		if !alive[name] {
+			delete(g.paused, name)
+		}
+	}
+
+	for _, action := range g.Policy.Decide(availableMB, running, paused) {
+		v := action.VM
+		if action.Pause {
+			g.Client.Logger.Infof("Pausing VM '%s' (priority %d): %s", v.Name, v.Priority, action.Reason)
+			if err := g.Client.PauseVM(ctx, v.SocketPath); err != nil {
+				g.Client.Logger.Warnf("Failed to pause VM '%s': %v", v.Name, err)
+				continue
+			}
+			g.paused[v.Name] = v
+		} else {
+			g.Client.Logger.Infof("Resuming VM '%s' (priority %d): %s", v.Name, v.Priority, action.Reason)
+			if err := g.Client.ResumeVM(ctx, v.SocketPath); err != nil {
+				g.Client.Logger.Warnf("Failed to resume VM '%s': %v", v.Name, err)
+				continue
+			}
+			delete(g.paused, v.Name)
+		}
+	}
+	return nil
+}
+
+// resumeAll resumes every VM the guard paused, so stopping the guard never leaves VMs frozen
+func (g *MemoryGuard) resumeAll() {
+	for name, v := range g.paused {
+		g.Client.Logger.Infof("Resuming VM '%s': memory guard stopping", name)
+		if err := g.Client.ResumeVM(context.Background(), v.SocketPath); err != nil {
+			g.Client.Logger.Warnf("Failed to resume VM '%s': %v", name, err)
+		}
+		delete(g.paused, name)
+	}
+}
@@ -0,0 +1,34 @@
+package firecracker
+
+import (
+	"testing"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+func TestThresholdPolicyDecide(t *testing.T) {
+	policy := ThresholdPolicy{PauseBelowMB: 512, ResumeAboveMB: 2048}
+	batch := &vm.VM{Name: "batch", Priority: -1, MemoryMB: 1024}
+	web := &vm.VM{Name: "web", Priority: 10, MemoryMB: 512}
+	big := &vm.VM{Name: "big", MemoryMB: 4096}
+	small := &vm.VM{Name: "small", MemoryMB: 256}
+
+	actions := policy.Decide(256, []*vm.VM{web, batch, small, big}, nil)
+	if len(actions) != 1 || !actions[0].Pause || actions[0].VM != batch {
+		t.Fatalf("low memory: got %+v, want batch paused", actions)
+	}
+
+	actions = policy.Decide(256, []*vm.VM{web, small, big}, nil)
+	if len(actions) != 1 || actions[0].VM != big {
+		t.Fatalf("equal priority: got %+v, want the larger VM paused", actions)
+	}
+
+	if actions := policy.Decide(1024, []*vm.VM{web}, []*vm.VM{batch}); len(actions) != 0 {
+		t.Fatalf("between thresholds: got %+v, want no change", actions)
+	}
+
+	actions = policy.Decide(4096, []*vm.VM{small}, []*vm.VM{batch, web})
+	if len(actions) != 1 || actions[0].Pause || actions[0].VM != web {
+		t.Fatalf("memory recovered: got %+v, want web resumed", actions)
+	}
+}
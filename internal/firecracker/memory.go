@@ -0,0 +1,103 @@
+package firecracker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// MemBackendType selects where guest memory lives
+type MemBackendType string
+
+const (
+	// MemBackendAnonymous keeps guest memory in anonymous host memory (the Firecracker default)
+	MemBackendAnonymous MemBackendType = "anonymous"
+	// MemBackendFile restores guest memory from a file written by SnapshotVM
+	MemBackendFile MemBackendType = "file"
+)
+
+// restoresFromMemFile reports whether StartVM restores the VM from its memory file instead of booting
+func (cfg *VMConfig) restoresFromMemFile() bool {
+	return cfg.MemBackendType == MemBackendFile
+}
+
+// validateMemBackend checks the memory backend settings before the VM is started
+// The file backend resumes a VM saved by SnapshotVM, so its memory and VM state files must already exist
+func validateMemBackend(cfg *VMConfig) error {
+	switch cfg.MemBackendType {
+	case "", MemBackendAnonymous:
+		if cfg.MemBackendPath != "" {
+			return fmt.Errorf("memory backend path %s requires the '%s' backend", cfg.MemBackendPath, MemBackendFile)
+		}
+		return nil
+	case MemBackendFile:
+	default:
+		return fmt.Errorf("invalid memory backend '%s': expected 'anonymous' or 'file'", cfg.MemBackendType)
+	}
+
+	if cfg.MemBackendPath == "" {
+		return fmt.Errorf("memory backend '%s' requires a memory file path", MemBackendFile)
+	}
+	if cfg.SnapshotPath == "" {
+		return fmt.Errorf("memory backend '%s' requires a VM state file path", MemBackendFile)
+	}
+	if _, err := os.Stat(cfg.MemBackendPath); err != nil {
+		return fmt.Errorf("memory file %s not found (it is written when the VM is snapshotted): %w", cfg.MemBackendPath, err)
+	}
+	if _, err := os.Stat(cfg.SnapshotPath); err != nil {
+		return fmt.Errorf("VM state file %s not found (it is written when the VM is snapshotted): %w", cfg.SnapshotPath, err)
+	}
+	return nil
+}
+
+// SnapshotVM pauses a running VM and writes its guest memory and device state to memPath and statePath
+// The VM is left paused; the caller stops the Firecracker process. Starting the VM with MemBackendFile
+// and the same paths resumes it where it left off. On failure the VM is resumed
+func (c *Client) SnapshotVM(ctx context.Context, socketPath string, memoryMB int, memPath, statePath string) error {
+	if err := checkMemFileSpace(filepath.Dir(memPath), memoryMB); err != nil {
+		return err
+	}
+
+	machine, err := c.connectToMachine(ctx, socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to connect to VM: %w", err)
+	}
+
+	if err := machine.PauseVM(ctx); err != nil {
+		return fmt.Errorf("failed to pause VM: %w", err)
+	}
+	if err := machine.CreateSnapshot(ctx, memPath, statePath); err != nil {
+		os.Remove(memPath)
+		os.Remove(statePath)
+		if resumeErr := machine.ResumeVM(ctx); resumeErr != nil {
+			c.Logger.Warnf("Failed to resume VM after snapshot error: %v", resumeErr)
+		}
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	return nil
+}
+
+// checkMemFileSpace checks that dir exists and has room for a memory file of the whole guest memory
+func checkMemFileSpace(dir string, memoryMB int) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("memory file directory %s not found: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("memory file directory %s is not a directory", dir)
+	}
+
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return fmt.Errorf("failed to check free space in %s: %w", dir, err)
+	}
+	available := stat.Bavail * uint64(stat.Bsize)
+	required := uint64(memoryMB) * 1024 * 1024
+	if available < required {
+		return fmt.Errorf("not enough space in %s for a %d MB memory file (%d MB available)",
+			dir, memoryMB, available/(1024*1024))
+	}
+	return nil
+}
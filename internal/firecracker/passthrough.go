@@ -0,0 +1,93 @@
+package firecracker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrPassthroughUnsupported is returned when a VM asks for PCI passthrough, which this Firecracker build lacks
+var ErrPassthroughUnsupported = errors.New("PCI passthrough is unsupported by this firecracker version")
+
+// pciDevicesDir lists the host's PCI devices by address
+const pciDevicesDir = "/sys/bus/pci/devices"
+
+// NormalizePCIAddress checks a PCI address in [domain:]bus:device.function form and returns it with the domain
+// Hex digits are lowercased to match the names under /sys/bus/pci/devices
+func NormalizePCIAddress(addr string) (string, error) {
+	invalid := fmt.Errorf("invalid PCI address '%s': expected [dddd:]bb:dd.f", addr)
+
+	parts := strings.Split(strings.ToLower(addr), ":")
+	if len(parts) == 2 {
+		parts = append([]string{"0000"}, parts...)
+	}
+	if len(parts) != 3 {
+		return "", invalid
+	}
+	slot, function, ok := strings.Cut(parts[2], ".")
+	if !ok {
+		return "", invalid
+	}
+
+	fields := []struct {
+		value  string
+		digits int
+		max    uint64
+	}{
+		{parts[0], 4, 0xffff},
+		{parts[1], 2, 0xff},
+		{slot, 2, 0x1f},
+		{function, 1, 7},
+	}
+	for _, f := range fields {
+		if len(f.value) != f.digits {
+			return "", invalid
+		}
+		n, err := strconv.ParseUint(f.value, 16, 16)
+		if err != nil || n > f.max {
+			return "", invalid
+		}
+	}
+	return strings.Join(parts[:2], ":") + ":" + slot + "." + function, nil
+}
+
+// validatePassthroughDevices checks that each PCI device exists on the host and is bound to vfio-pci
+func validatePassthroughDevices(addrs []string) error {
+	seen := make(map[string]bool)
+	for _, addr := range addrs {
+		normalized, err := NormalizePCIAddress(addr)
+		if err != nil {
+			return err
+		}
+		if seen[normalized] {
+			return fmt.Errorf("PCI device %s is listed more than once", normalized)
+		}
+		seen[normalized] = true
+
+		devicePath := filepath.Join(pciDevicesDir, normalized)
+		if _, err := os.Stat(devicePath); err != nil {
+			return fmt.Errorf("PCI device %s not found on this host", normalized)
+		}
+		driver, err := os.Readlink(filepath.Join(devicePath, "driver"))
+		if err != nil || filepath.Base(driver) != "vfio-pci" {
+			return fmt.Errorf("PCI device %s must be bound to the vfio-pci driver before it can be passed through", normalized)
+		}
+	}
+	return nil
+}
+
+// configurePassthrough validates the requested PCI devices and attaches them to the VM
+// No Firecracker release exposes VFIO devices through its API yet, so any request is refused
+// once the devices have been checked; this is where a supporting build would be configured
+func configurePassthrough(addrs []string) error {
+	if len(addrs) == 0 {
+		return nil
+	}
+	if err := validatePassthroughDevices(addrs); err != nil {
+		return err
+	}
+	return ErrPassthroughUnsupported
+}
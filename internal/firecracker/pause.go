@@ -0,0 +1,30 @@
+package firecracker
+
+import (
+	"context"
+	"fmt"
+)
+
+// PauseVM stops a running VM's vCPUs; its memory stays allocated and ResumeVM continues it
+func (c *Client) PauseVM(ctx context.Context, socketPath string) error {
+	machine, err := c.connectToMachine(ctx, socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to connect to VM: %w", err)
+	}
+	if err := machine.PauseVM(ctx); err != nil {
+		return fmt.Errorf("failed to pause VM: %w", err)
+	}
+	return nil
+}
+
+// ResumeVM continues a VM paused by PauseVM
+func (c *Client) ResumeVM(ctx context.Context, socketPath string) error {
+	machine, err := c.connectToMachine(ctx, socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to connect to VM: %w", err)
+	}
+	if err := machine.ResumeVM(ctx); err != nil {
+		return fmt.Errorf("failed to resume VM: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package firecracker
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Linux capability bits checked by CheckPrerequisites (see capabilities(7))
+const (
+	capNetAdmin = 12
+	capSysAdmin = 21
+)
+
+// kvmDevice is the device Firecracker opens to create VMs
+const kvmDevice = "/dev/kvm"
+
+// hostTools lists the commands vmm runs on the host and what each is needed for
+var hostTools = []struct {
+	name    string
+	purpose string
+}{
+	{"ip", "creating the bridge and TAP devices"},
+	{"iptables", "NAT and port forwarding"},
+	{"sysctl", "enabling IP forwarding"},
+	{"mount", "mounting the rootfs and mount images"},
+	{"umount", "unmounting the rootfs and mount images"},
+	{"truncate", "creating sparse images"},
+	{"mkfs.ext4", "formatting mount images"},
+	{"e2fsck", "checking images before they are resized"},
+	{"resize2fs", "growing images"},
+	{"tar", "copying files into mount images"},
+}
+
+// CheckPrerequisites reports everything this host is missing to run VMs, instead of stopping at the first problem
+// It checks the Firecracker binary, the host tools vmm runs, the process's capabilities and access to /dev/kvm
+func (c *Client) CheckPrerequisites() []error {
+	var errs []error
+
+	if _, err := c.firecrackerBinary(); err != nil {
+		errs = append(errs, err)
+	}
+	for _, tool := range hostTools {
+		if _, err := exec.LookPath(tool.name); err != nil {
+			errs = append(errs, fmt.Errorf("%s not found in PATH (needed for %s)", tool.name, tool.purpose))
+		}
+	}
+
+	caps, err := effectiveCapabilities()
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		if caps&(1<<capSysAdmin) == 0 {
+			errs = append(errs, fmt.Errorf("missing CAP_SYS_ADMIN, needed to mount images: run vmm as root (e.g. with sudo)"))
+		}
+		if caps&(1<<capNetAdmin) == 0 {
+			errs = append(errs, fmt.Errorf("missing CAP_NET_ADMIN, needed to create TAP devices: run vmm as root (e.g. with sudo)"))
+		}
+	}
+
+	if err := CheckKVM(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// effectiveCapabilities returns the capability set the process can use, from /proc/self/status
+func effectiveCapabilities() (uint64, error) {
+	file, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read process capabilities: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "CapEff:")
+		if !ok {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		if err != nil {
+			return 0, fmt.Errorf("malformed CapEff in /proc/self/status: %w", err)
+		}
+		return caps, nil
+	}
+	return 0, fmt.Errorf("no CapEff in /proc/self/status")
+}
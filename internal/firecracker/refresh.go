@@ -0,0 +1,56 @@
+package firecracker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// RefreshMountDrive points a running VM's mount drive at imagePath through the drive patch API, so
+// the guest's block device reads an image that a sync replaced. The guest's filesystem caches the
+// old image, so it has to be remounted: with a guest agent, the mount is unmounted before the patch
+// and mounted again after it, and remounted is true. Otherwise the guest must remount it itself
+func (c *Client) RefreshMountDrive(ctx context.Context, v *vm.VM, m *vm.Mount, imagePath string) (remounted bool, err error) {
+	driveID, ok := v.MountDriveIDs[m.GuestTag]
+	if !ok {
+		return false, fmt.Errorf("no drive is recorded for mount '%s'; restart the VM to attach the new image", m.GuestTag)
+	}
+	machine, err := c.connectToMachine(ctx, v.SocketPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to VM: %w", err)
+	}
+
+	useAgent := v.GuestAgent && v.VsockPath != ""
+	if useAgent {
+		if err := c.mountCommand(ctx, v.VsockPath, "unmount", m); err != nil {
+			// Still patch the drive; the guest can remount by hand
+			c.Logger.Warnf("Guest agent could not unmount %s: %v", m.MountPath(), err)
+			useAgent = false
+		}
+	}
+
+	if err := machine.UpdateGuestDrive(ctx, driveID, imagePath); err != nil {
+		if useAgent {
+			// Give the guest its old mount back
+			c.mountCommand(ctx, v.VsockPath, "mount", m)
+		}
+		return false, fmt.Errorf("failed to update drive '%s': %w", driveID, err)
+	}
+
+	if !useAgent {
+		return false, nil
+	}
+	if err := c.mountCommand(ctx, v.VsockPath, "mount", m); err != nil {
+		return false, fmt.Errorf("drive updated, but the guest agent could not mount %s again: %w", m.MountPath(), err)
+	}
+	return true, nil
+}
+
+// mountCommand sends a guest agent a mount or unmount request for a mount
+func (c *Client) mountCommand(ctx context.Context, vsockPath, command string, m *vm.Mount) error {
+	agentCtx, cancel := context.WithTimeout(ctx, DefaultAgentTimeout)
+	defer cancel()
+	_, err := sendAgentRequest(agentCtx, vsockPath, AgentRequest{Command: command, Tag: m.GuestTag, Path: m.MountPath()})
+	return err
+}
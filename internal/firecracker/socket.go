@@ -0,0 +1,52 @@
+package firecracker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// socketExitTimeout bounds how long a stop waits for the VMM to exit before leaving its socket in place
+const socketExitTimeout = 5 * time.Second
+
+// socketListening reports whether a Firecracker API socket may still have a VMM behind it
+// A socket file left by an exited VMM refuses connections. Other dial errors, such as
+// EACCES for a non-root caller, can't tell, so the socket is assumed to be live
+func socketListening(socketPath string) bool {
+	conn, err := net.DialTimeout("unix", socketPath, time.Second)
+	if err == nil {
+		conn.Close()
+		return true
+	}
+	return !errors.Is(err, syscall.ECONNREFUSED) && !errors.Is(err, syscall.ENOENT)
+}
+
+// vmmExited reports whether a VMM is gone: by its PID when known, otherwise by its API socket
+func vmmExited(socketPath string, pid int) bool {
+	if pid > 0 {
+		return !processAlive(pid)
+	}
+	return !apiSocketAlive(socketPath)
+}
+
+// removeSocketAfterExit waits up to timeout for a stopped VMM to exit and then removes its API socket
+// If the VMM is still running the socket is left, so the VM can still be managed. It reports whether the VMM exited
+func (c *Client) removeSocketAfterExit(ctx context.Context, socketPath string, pid int, timeout time.Duration) bool {
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for !vmmExited(socketPath, pid) {
+		select {
+		case <-waitCtx.Done():
+			c.Logger.Warnf("VMM did not exit within %s; leaving its socket %s", timeout, socketPath)
+			return false
+		case <-time.After(waitPollInterval):
+		}
+	}
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		c.Logger.Warnf("Failed to remove socket %s: %v", socketPath, err)
+	}
+	return true
+}
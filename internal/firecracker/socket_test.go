@@ -0,0 +1,89 @@
+package firecracker
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// staleSocket leaves a socket file behind with nothing listening on it, as an exited VMM does
+func staleSocket(t *testing.T) string {
+	t.Helper()
+	socketPath := filepath.Join(t.TempDir(), "vm.sock")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		t.Fatalf("failed to listen on %s: %v", socketPath, err)
+	}
+	listener.SetUnlinkOnClose(false)
+	listener.Close()
+	return socketPath
+}
+
+// exitedPID returns the PID of a process that has exited and been reaped
+func exitedPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("failed to run true: %v", err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestIsRunningIgnoresStaleSocket(t *testing.T) {
+	c := NewClient()
+	socketPath := staleSocket(t)
+
+	if c.IsRunning(socketPath, 0) {
+		t.Error("IsRunning reported a VM for a stale socket without a PID")
+	}
+	if c.IsRunning(socketPath, exitedPID(t)) {
+		t.Error("IsRunning reported a VM for a stale socket whose process has exited")
+	}
+}
+
+func TestIsRunningWithLiveListener(t *testing.T) {
+	c := NewClient()
+	socketPath := filepath.Join(t.TempDir(), "vm.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to listen on %s: %v", socketPath, err)
+	}
+	defer listener.Close()
+
+	if !c.IsRunning(socketPath, 0) {
+		t.Error("IsRunning did not report a VM for a socket that accepts connections")
+	}
+}
+
+func TestRemoveSocketAfterExit(t *testing.T) {
+	c := NewClient()
+	socketPath := staleSocket(t)
+
+	if !c.removeSocketAfterExit(t.Context(), socketPath, exitedPID(t), socketExitTimeout) {
+		t.Error("removeSocketAfterExit did not report an exited VMM")
+	}
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Errorf("socket of an exited VMM was not removed: %v", err)
+	}
+}
+
+func TestRunPostStopHook(t *testing.T) {
+	c := NewClient()
+	c.RunPostStopHook(&vm.VM{Name: "test"})
+
+	var called *vm.VM
+	c.PostStopHook = func(v *vm.VM) error {
+		called = v
+		return errors.New("hook failed")
+	}
+	v := &vm.VM{Name: "test"}
+	c.RunPostStopHook(v)
+	if called != v {
+		t.Error("RunPostStopHook did not call the hook with the VM")
+	}
+}
@@ -0,0 +1,198 @@
+package firecracker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// clockTicksPerSecond is the USER_HZ value used for times in /proc/<pid>/stat (100 on all common Linux platforms)
+const clockTicksPerSecond = 100
+
+// VMStatus is a point-in-time view of a VM's configuration and its Firecracker process
+type VMStatus struct {
+	Name          string        `json:"name"`
+	ID            string        `json:"id"`
+	State         vm.State      `json:"state"`
+	PID           int           `json:"pid,omitempty"`
+	ProcessAlive  bool          `json:"process_alive"`
+	IPAddress     string        `json:"ip_address,omitempty"`
+	SocketPath    string        `json:"socket_path"`
+	CPUs          int           `json:"cpus"`
+	MemoryMB      int           `json:"memory_mb"`
+	StartedAt     time.Time     `json:"started_at,omitempty"`
+	UptimeSeconds int64         `json:"uptime_seconds,omitempty"`
+	KernelArgs    string        `json:"kernel_args,omitempty"`
+	Resources     *ProcessUsage `json:"resources,omitempty"`
+	Mounts        []MountStatus `json:"mounts,omitempty"`
+	Instance      *InstanceInfo `json:"instance,omitempty"` // From the VMM's API; nil if it didn't answer
+}
+
+// ProcessUsage holds host resource usage of the Firecracker process
+type ProcessUsage struct {
+	CPUSeconds float64 `json:"cpu_seconds"` // User plus system CPU time
+	RSSBytes   int64   `json:"rss_bytes"`   // Resident memory, including guest memory that has been touched
+}
+
+// MountStatus describes one of a VM's mounts and its image on the host
+type MountStatus struct {
+	GuestTag    string   `json:"guest_tag"`
+	GuestPath   string   `json:"guest_path"`
+	Mode        string   `json:"mode"`
+	HostPaths   []string `json:"host_paths"`
+	ArchivePath string   `json:"archive_path,omitempty"`
+	ReadOnly    bool     `json:"read_only"`
+	Encrypted   bool     `json:"encrypted,omitempty"`
+	ImagePath   string   `json:"image_path,omitempty"`
+	ImageBytes  int64    `json:"image_bytes,omitempty"` // Space used on the host by the sparse image
+	DriveID     string   `json:"drive_id,omitempty"`
+}
+
+// Status refreshes a VM's state and gathers its process details and mounts into one snapshot
+func (c *Client) Status(v *vm.VM) (*VMStatus, error) {
+	c.UpdateVMState(v)
+
+	status := &VMStatus{
+		Name:       v.Name,
+		ID:         v.ID,
+		State:      v.State,
+		PID:        v.PID,
+		IPAddress:  v.IPAddress,
+		SocketPath: v.SocketPath,
+		CPUs:       v.CPUs,
+		MemoryMB:   v.MemoryMB,
+	}
+
+	for _, m := range v.Mounts {
+		mountStatus := MountStatus{
+			GuestTag:    m.GuestTag,
+			GuestPath:   m.MountPath(),
+			Mode:        m.EffectiveMode(),
+			HostPaths:   m.SourcePaths(),
+			ArchivePath: m.ArchivePath,
+			ReadOnly:    m.ReadOnly,
+			Encrypted:   m.Encrypted,
+			ImagePath:   m.ImagePath,
+			DriveID:     v.MountDriveIDs[m.GuestTag],
+		}
+		if m.ImagePath != "" {
+			if usage, err := diskUsage(m.ImagePath); err == nil {
+				mountStatus.ImageBytes = usage
+			}
+		}
+		status.Mounts = append(status.Mounts, mountStatus)
+	}
+
+	if v.State != vm.StateRunning {
+		return status, nil
+	}
+	status.StartedAt = v.StartedAt
+	status.KernelArgs = c.GetKernelArgs(v)
+	if info, err := c.InstanceInfo(context.Background(), v.SocketPath); err == nil {
+		status.Instance = info
+	} else {
+		c.Logger.Debugf("Failed to read instance info of VM '%s': %v", v.Name, err)
+	}
+
+	if v.PID <= 0 {
+		return status, nil
+	}
+	stat, err := readProcStat(v.PID)
+	if err != nil {
+		// The process has just exited; report what we know
+		return status, nil
+	}
+	status.ProcessAlive = true
+
+	uptime, err := processUptime(stat)
+	if err != nil {
+		return nil, err
+	}
+	status.UptimeSeconds = int64(uptime.Seconds())
+	status.Resources = &ProcessUsage{
+		CPUSeconds: float64(stat.utime+stat.stime) / clockTicksPerSecond,
+		RSSBytes:   stat.rssPages * int64(os.Getpagesize()),
+	}
+	return status, nil
+}
+
+// procStat holds the fields of /proc/<pid>/stat used for status reporting
+type procStat struct {
+	utime     uint64 // CPU time in user mode, in clock ticks
+	stime     uint64 // CPU time in kernel mode, in clock ticks
+	startTime uint64 // Process start time after boot, in clock ticks
+	rssPages  int64
+}
+
+// readProcStat parses /proc/<pid>/stat
+func readProcStat(pid int) (*procStat, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	// The command name is in parentheses and may contain spaces, so parse from the last ')'
+	content := string(data)
+	end := strings.LastIndex(content, ")")
+	if end < 0 {
+		return nil, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+	// fields[0] is the state, which is field 3 in proc(5)
+	fields := strings.Fields(content[end+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("malformed /proc/%d/stat", pid)
+	}
+
+	var stat procStat
+	var errs [4]error
+	stat.utime, errs[0] = strconv.ParseUint(fields[11], 10, 64)
+	stat.stime, errs[1] = strconv.ParseUint(fields[12], 10, 64)
+	stat.startTime, errs[2] = strconv.ParseUint(fields[19], 10, 64)
+	stat.rssPages, errs[3] = strconv.ParseInt(fields[21], 10, 64)
+	for _, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("malformed /proc/%d/stat: %w", pid, err)
+		}
+	}
+	return &stat, nil
+}
+
+// processUptime returns how long a process has been running, from its start time and the system uptime
+func processUptime(stat *procStat) (time.Duration, error) {
+	data, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read system uptime: %w", err)
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("malformed /proc/uptime")
+	}
+	systemUptime, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("malformed /proc/uptime: %w", err)
+	}
+
+	seconds := systemUptime - float64(stat.startTime)/clockTicksPerSecond
+	if seconds < 0 {
+		seconds = 0
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// diskUsage returns the bytes actually allocated to a (possibly sparse) file
+func diskUsage(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	if sys, ok := info.Sys().(*syscall.Stat_t); ok {
+		return sys.Blocks * 512, nil
+	}
+	return info.Size(), nil
+}
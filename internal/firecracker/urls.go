@@ -0,0 +1,34 @@
+package firecracker
+
+import (
+	"fmt"
+
+	"github.com/raesene/baremetalvmm/internal/image"
+)
+
+// ResolveURLs fetches the kernel and rootfs a config names by URL through the image manager's
+// URL cache and points KernelPath and RootfsPath at them; call it before StartVM. The cached
+// rootfs is shared, so it is never written: with RootfsPath empty the VM boots it read-only,
+// otherwise it is copied to RootfsPath (unless that exists already) for the VM to write
+func ResolveURLs(cfg *VMConfig, images *image.Manager) error {
+	if cfg.KernelURL != "" {
+		kernelPath, err := images.FetchURL(cfg.KernelURL, cfg.KernelSHA256)
+		if err != nil {
+			return fmt.Errorf("failed to fetch kernel: %w", err)
+		}
+		cfg.KernelPath = kernelPath
+	}
+	if cfg.RootfsURL == "" {
+		return nil
+	}
+	cachedRootfs, err := images.FetchURL(cfg.RootfsURL, cfg.RootfsSHA256)
+	if err != nil {
+		return fmt.Errorf("failed to fetch rootfs: %w", err)
+	}
+	if cfg.RootfsPath == "" {
+		cfg.RootfsPath = cachedRootfs
+		cfg.RootfsReadOnly = true
+		return nil
+	}
+	return images.CreateRootfsCopy(cachedRootfs, cfg.RootfsPath, 0)
+}
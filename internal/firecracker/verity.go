@@ -0,0 +1,54 @@
+package firecracker
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/raesene/baremetalvmm/internal/image"
+)
+
+// verityDriveID is the drive ID of the rootfs's dm-verity hash tree
+const verityDriveID = "verity"
+
+// verityRootDevice is the device-mapper device the guest kernel creates for a verity rootfs
+const verityRootDevice = "/dev/dm-0"
+
+// rootHashPattern matches a hex SHA-256 root hash
+var rootHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// validateVerity checks a config's verity hash tree and root hash
+func validateVerity(cfg *VMConfig) error {
+	if cfg.VerityHashTreePath == "" {
+		if cfg.VerityRootHash != "" {
+			return fmt.Errorf("verity root hash given without a hash tree")
+		}
+		return nil
+	}
+	if !rootHashPattern.MatchString(cfg.VerityRootHash) {
+		return fmt.Errorf("invalid verity root hash '%s': expected 64 lowercase hex digits", cfg.VerityRootHash)
+	}
+	if _, err := os.Stat(cfg.VerityHashTreePath); err != nil {
+		return fmt.Errorf("verity hash tree not found at %s: %w", cfg.VerityHashTreePath, err)
+	}
+	return nil
+}
+
+// verityKernelArgs returns the kernel arguments that make the guest kernel set up the rootfs as
+// a dm-verity device at boot and mount it read-only as the root filesystem. It needs a guest
+// kernel built with CONFIG_DM_VERITY and CONFIG_DM_INIT (for dm-mod.create)
+func verityKernelArgs(dataDevice, hashDevice string, dataBytes int64, rootHash string) string {
+	table := fmt.Sprintf("0 %d verity 1 %s %s %d %d %d 0 sha256 %s -",
+		dataBytes/512, dataDevice, hashDevice, image.VerityBlockSize, image.VerityBlockSize,
+		dataBytes/image.VerityBlockSize, rootHash)
+	return fmt.Sprintf(`dm-mod.create="vroot,,,ro,%s" root=%s ro`, table, verityRootDevice)
+}
+
+// insertKernelArgs adds arguments to a kernel command line ahead of any "--" that starts init's arguments
+func insertKernelArgs(cmdline, args string) string {
+	if i := strings.Index(" "+cmdline+" ", " -- "); i >= 0 {
+		return strings.TrimSpace(strings.TrimSpace(cmdline[:i]) + " " + args + " " + cmdline[i:])
+	}
+	return cmdline + " " + args
+}
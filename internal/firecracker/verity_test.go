@@ -0,0 +1,39 @@
+package firecracker
+
+import "testing"
+
+func TestVerityKernelArgs(t *testing.T) {
+	hash := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	got := verityKernelArgs("/dev/vda", "/dev/vdc", 1<<30, hash)
+	want := `dm-mod.create="vroot,,,ro,0 2097152 verity 1 /dev/vda /dev/vdc 4096 4096 262144 0 sha256 ` + hash + ` -" root=/dev/dm-0 ro`
+	if got != want {
+		t.Errorf("verityKernelArgs = %q, want %q", got, want)
+	}
+}
+
+func TestInsertKernelArgs(t *testing.T) {
+	tests := map[string]string{
+		"console=ttyS0":             "console=ttyS0 root=x",
+		"console=ttyS0 -- --single": "console=ttyS0 root=x -- --single",
+	}
+	for cmdline, want := range tests {
+		if got := insertKernelArgs(cmdline, "root=x"); got != want {
+			t.Errorf("insertKernelArgs(%q) = %q, want %q", cmdline, got, want)
+		}
+	}
+}
+
+func TestDriveLayoutVerity(t *testing.T) {
+	cfg := &VMConfig{RootfsPath: "/r.ext4", VerityHashTreePath: "/r.ext4.verity", ExtraDrives: []DriveSpec{{Path: "/x.img"}}}
+	layout := cfg.DriveLayout()
+	if len(layout) != 3 {
+		t.Fatalf("got %d drives, want 3", len(layout))
+	}
+	if !layout[0].ReadOnly {
+		t.Error("verity rootfs not read-only")
+	}
+	last := layout[2]
+	if last.Kind != DriveKindVerity || last.Device != "/dev/vdc" || !last.ReadOnly {
+		t.Errorf("verity drive = %+v", last)
+	}
+}
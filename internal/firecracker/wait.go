@@ -0,0 +1,100 @@
+package firecracker
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// ErrExitCodeUnknown is returned by Wait when the VM has exited but its exit code can't be recovered
+var ErrExitCodeUnknown = errors.New("VM exited but its exit code is unknown")
+
+// waitPollInterval is how often Wait checks whether the Firecracker process has exited
+const waitPollInterval = 200 * time.Millisecond
+
+// exitCodePattern matches the line Firecracker logs as it exits
+var exitCodePattern = regexp.MustCompile(`Firecracker exit(?:ing|ed).*exit_code=(\d+)`)
+
+// Wait blocks until the VM's Firecracker process exits and returns its exit code
+// 0 means Firecracker shut down cleanly, which includes a guest reboot or poweroff.
+// A process killed by a signal returns 128+signal and an error describing the crash.
+// When this process didn't start the VM, the code is read from the VM log; if it isn't
+// there, -1 and ErrExitCodeUnknown are returned. Cancelling ctx stops waiting, not the VM.
+func (c *Client) Wait(ctx context.Context, v *vm.VM) (int, error) {
+	if v.PID <= 0 {
+		return -1, fmt.Errorf("VM '%s' is not running", v.Name)
+	}
+
+	ticker := time.NewTicker(waitPollInterval)
+	defer ticker.Stop()
+	for {
+		var status syscall.WaitStatus
+		pid, err := syscall.Wait4(v.PID, &status, syscall.WNOHANG, nil)
+		switch {
+		case err == nil && pid == v.PID:
+			if status.Signaled() {
+				return 128 + int(status.Signal()), fmt.Errorf("firecracker for VM '%s' was killed by %s", v.Name, status.Signal())
+			}
+			return status.ExitStatus(), nil
+		case errors.Is(err, syscall.ECHILD):
+			// Not our child, e.g. started by an earlier 'vmm start'; all we can see is whether it is alive
+			if !processAlive(v.PID) {
+				if code, ok := exitCodeFromLog(v.LogPath); ok {
+					return code, nil
+				}
+				return -1, ErrExitCodeUnknown
+			}
+		case err != nil:
+			return -1, fmt.Errorf("failed to wait for VM '%s': %w", v.Name, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return -1, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// processAlive reports whether a process exists
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// exitCodeFromLog finds the exit code Firecracker last logged to a VM log
+func exitCodeFromLog(logPath string) (int, bool) {
+	if logPath == "" {
+		return 0, false
+	}
+	f, err := os.Open(logPath)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	code, found := 0, false
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		// Only the run after the last start marker written by openLog counts
+		if strings.HasSuffix(scanner.Text(), logStartMarker) {
+			code, found = 0, false
+			continue
+		}
+		if m := exitCodePattern.FindStringSubmatch(scanner.Text()); m != nil {
+			code, _ = strconv.Atoi(m[1])
+			found = true
+		}
+	}
+	return code, found
+}
@@ -0,0 +1,75 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// clockStampPath is where the host time is written in the guest rootfs
+const clockStampPath = "etc/vmm/host-time"
+
+// clockUnit sets the guest clock from the host stamp early in boot ($$ and %% are systemd escapes)
+// The stamp plus the guest's uptime approximates the current time; the clock is only moved forwards,
+// so a guest whose clock is already right (or a stamp left from an earlier start) changes nothing
+const clockUnit = `[Unit]
+Description=Set the clock from the host time written by vmm
+DefaultDependencies=no
+Before=sysinit.target time-set.target
+Wants=time-set.target
+ConditionPathExists=/etc/vmm/host-time
+
+[Service]
+Type=oneshot
+ExecStart=/bin/sh -c 'stamp=$$(cat /etc/vmm/host-time); up=$$(cut -d. -f1 /proc/uptime); now=$$((stamp + up)); [ "$$(date +%%s)" -lt "$$now" ] && date -s "@$$now" || true'
+
+[Install]
+WantedBy=sysinit.target
+`
+
+// InjectClockSync writes the current host time into a rootfs image along with a systemd unit
+// that sets the guest clock from it at boot
+// Call it as late as possible before the VM starts: the guest clock ends up behind by the time in between
+func InjectClockSync(rootfsPath string) error {
+	mountPoint, err := os.MkdirTemp("", "vmm-rootfs-*")
+	if err != nil {
+		return fmt.Errorf("failed to create mount point: %w", err)
+	}
+	defer os.RemoveAll(mountPoint)
+
+	if output, err := exec.Command("mount", "-o", "loop", rootfsPath, mountPoint).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to mount rootfs: %w: %s", err, string(output))
+	}
+	defer exec.Command("umount", mountPoint).Run()
+
+	unitPath := filepath.Join(mountPoint, "etc", "systemd", "system", "vmm-clock.service")
+	if err := os.MkdirAll(filepath.Dir(unitPath), 0755); err != nil {
+		return fmt.Errorf("failed to create systemd directory: %w", err)
+	}
+	if err := os.WriteFile(unitPath, []byte(clockUnit), 0644); err != nil {
+		return fmt.Errorf("failed to write clock unit: %w", err)
+	}
+	wantsDir := filepath.Join(mountPoint, "etc", "systemd", "system", "sysinit.target.wants")
+	if err := os.MkdirAll(wantsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create systemd directory: %w", err)
+	}
+	link := filepath.Join(wantsDir, "vmm-clock.service")
+	if _, err := os.Lstat(link); os.IsNotExist(err) {
+		if err := os.Symlink("/etc/systemd/system/vmm-clock.service", link); err != nil {
+			return fmt.Errorf("failed to enable clock unit: %w", err)
+		}
+	}
+
+	stampPath := filepath.Join(mountPoint, clockStampPath)
+	if err := os.MkdirAll(filepath.Dir(stampPath), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(clockStampPath), err)
+	}
+	// Taken last so the time spent mounting the rootfs doesn't count against the guest
+	stamp := fmt.Sprintf("%d\n", time.Now().Unix())
+	if err := os.WriteFile(stampPath, []byte(stamp), 0644); err != nil {
+		return fmt.Errorf("failed to write host time: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/raesene/baremetalvmm/internal/progress"
+)
+
+// CompactRootfs rewrites a VM's rootfs so that only blocks holding data occupy host disk
+// The filesystem is checked and its free blocks discarded with TrimImage, then the image is
+// copied with a sparse-aware cp, which also leaves out allocated blocks that are all zeros,
+// and the copy replaces the original. The VM must be stopped.
+func (m *Manager) CompactRootfs(vmName, vmDir string) error {
+	path := filepath.Join(vmDir, vmName+".ext4")
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("rootfs for VM '%s' not found: %w", vmName, err)
+	}
+
+	return progress.Run(m.progress(), fmt.Sprintf("Compacting rootfs for VM '%s'", vmName), func() error {
+		if err := progress.Run(m.progress(), "Checking filesystem", func() error {
+			return checkFilesystem(path)
+		}); err != nil {
+			return err
+		}
+		if err := progress.Run(m.progress(), "Discarding free blocks", func() error {
+			return TrimImage(path)
+		}); err != nil {
+			return err
+		}
+		return progress.Run(m.progress(), "Rewriting image", func() error {
+			return sparseRewrite(path)
+		})
+	})
+}
+
+// checkFilesystem runs e2fsck on an image, repairing what it can
+func checkFilesystem(path string) error {
+	output, err := exec.Command("e2fsck", "-f", "-y", path).CombinedOutput()
+	var exitErr *exec.ExitError
+	// Exit codes 1 and 2 mean errors were found and corrected
+	if errors.As(err, &exitErr) && exitErr.ExitCode() < 4 {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("filesystem check of %s failed: %w: %s", path, err, string(output))
+	}
+	return nil
+}
+
+// sparseRewrite replaces a file with a copy that has holes wherever the original is zero
+// The copy is written beside the original and renamed over it, so a failure leaves the original
+func sparseRewrite(path string) error {
+	tmpPath := path + ".compact"
+	os.Remove(tmpPath)
+	if output, err := exec.Command("cp", "--sparse=always", "--preserve=mode,ownership,timestamps", path, tmpPath).CombinedOutput(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to copy %s: %w: %s", path, err, string(output))
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// digestSuffix names the sidecar file caching an image's SHA256 digest
+const digestSuffix = ".sha256"
+
+// isDigestFile reports whether a file in the image directories is a digest sidecar
+func isDigestFile(name string) bool {
+	return strings.HasSuffix(name, digestSuffix)
+}
+
+// imageDigest returns the hex SHA256 digest of a kernel or rootfs image. The digest is cached in
+// <image>.sha256, in sha256sum format so 'sha256sum -c' can check it, with the image's mtime
+// given to the sidecar; it is only recomputed once the image's mtime differs from the sidecar's
+func imageDigest(path string, info os.FileInfo) (string, error) {
+	sidecar := path + digestSuffix
+	if cached, err := os.Stat(sidecar); err == nil && cached.ModTime().Equal(info.ModTime()) {
+		if data, err := os.ReadFile(sidecar); err == nil {
+			if digest, _, ok := strings.Cut(string(data), " "); ok && len(digest) == 64 {
+				return digest, nil
+			}
+		}
+	}
+
+	digest, err := fileSHA256(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	// The digest is still returned if it can't be cached, for example on a read-only directory
+	line := fmt.Sprintf("%s  %s\n", digest, filepath.Base(path))
+	if err := os.WriteFile(sidecar, []byte(line), 0644); err == nil {
+		os.Chtimes(sidecar, info.ModTime(), info.ModTime())
+	}
+	return digest, nil
+}
+
+// removeImageFile deletes a kernel or rootfs image and its cached digest
+func removeImageFile(path string) error {
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	os.Remove(path + digestSuffix)
+	return nil
+}
@@ -0,0 +1,124 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// envBlockStart and envBlockEnd delimit the variables vmm manages in the guest's /etc/environment
+	envBlockStart = "# BEGIN vmm environment"
+	envBlockEnd   = "# END vmm environment"
+)
+
+// envNamePattern matches a valid environment variable name
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envEscaper escapes the characters that are special inside double quotes
+var envEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "`", "\\`")
+
+// ValidateEnvVars checks environment variable names and values
+// Values may hold anything but newlines and NUL bytes, which no environment file format can carry
+func ValidateEnvVars(env map[string]string) error {
+	for name, value := range env {
+		if !envNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid environment variable name '%s': use letters, digits and underscores, not starting with a digit", name)
+		}
+		if strings.ContainsAny(value, "\n\r\x00") {
+			return fmt.Errorf("value of environment variable '%s' contains a newline or NUL byte", name)
+		}
+	}
+	return nil
+}
+
+// ParseEnvVar parses a NAME=VALUE assignment as given to --env
+func ParseEnvVar(assignment string) (string, string, error) {
+	name, value, ok := strings.Cut(assignment, "=")
+	if !ok {
+		return "", "", fmt.Errorf("invalid environment variable '%s': expected NAME=VALUE", assignment)
+	}
+	if err := ValidateEnvVars(map[string]string{name: value}); err != nil {
+		return "", "", err
+	}
+	return name, value, nil
+}
+
+// InjectEnvironment writes a VM's environment variables into the rootfs's /etc/environment
+// The variables replace the block vmm wrote before, so removed variables disappear; the rest of
+// the file is kept. Values are double-quoted with backslash escapes, which pam_env, systemd's
+// EnvironmentFile= and a POSIX shell all read
+func InjectEnvironment(rootfsPath string, env map[string]string) error {
+	if err := ValidateEnvVars(env); err != nil {
+		return err
+	}
+
+	mountPoint, err := os.MkdirTemp("", "vmm-rootfs-*")
+	if err != nil {
+		return fmt.Errorf("failed to create mount point: %w", err)
+	}
+	defer os.RemoveAll(mountPoint)
+
+	if output, err := exec.Command("mount", "-o", "loop", rootfsPath, mountPoint).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to mount rootfs: %w: %s", err, string(output))
+	}
+	defer exec.Command("umount", mountPoint).Run()
+
+	envPath := filepath.Join(mountPoint, "etc", "environment")
+	existing, err := os.ReadFile(envPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read /etc/environment: %w", err)
+	}
+	if len(env) == 0 && !strings.Contains(string(existing), envBlockStart) {
+		return nil
+	}
+
+	content := withEnvBlock(string(existing), env)
+	if err := os.WriteFile(envPath, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write /etc/environment: %w", err)
+	}
+	return nil
+}
+
+// withEnvBlock returns an environment file with vmm's block replaced by the given variables
+func withEnvBlock(existing string, env map[string]string) string {
+	var kept []string
+	inBlock := false
+	for _, line := range strings.Split(existing, "\n") {
+		switch {
+		case line == envBlockStart:
+			inBlock = true
+		case line == envBlockEnd:
+			inBlock = false
+		case !inBlock:
+			kept = append(kept, line)
+		}
+	}
+	for len(kept) > 0 && kept[len(kept)-1] == "" {
+		kept = kept[:len(kept)-1]
+	}
+
+	var b strings.Builder
+	for _, line := range kept {
+		b.WriteString(line + "\n")
+	}
+	if len(env) == 0 {
+		return b.String()
+	}
+
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	b.WriteString(envBlockStart + "\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "%s=\"%s\"\n", name, envEscaper.Replace(env[name]))
+	}
+	b.WriteString(envBlockEnd + "\n")
+	return b.String()
+}
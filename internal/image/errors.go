@@ -0,0 +1,13 @@
+package image
+
+import "errors"
+
+// Errors callers can test for with errors.Is; they are returned wrapped with the details
+var (
+	// ErrImageNotFound is returned when a named rootfs image isn't present
+	ErrImageNotFound = errors.New("image not found")
+	// ErrKernelNotFound is returned when a named kernel isn't present
+	ErrKernelNotFound = errors.New("kernel not found")
+	// ErrChecksumMismatch is returned when a download doesn't match its expected digest
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
@@ -0,0 +1,311 @@
+package image
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/raesene/baremetalvmm/internal/progress"
+)
+
+// fetchAttempts is how many times a fetch goes through its URLs before giving up
+const fetchAttempts = 3
+
+// fetchRetryDelay is the wait before the second pass over a fetch's URLs, doubling for each later one
+const fetchRetryDelay = 2 * time.Second
+
+// partSuffix marks the raw bytes of an unfinished download; they are kept after a failure so a
+// later attempt can resume them
+const partSuffix = ".part"
+
+// partSourceSuffix marks the file recording which URL a partial download came from
+const partSourceSuffix = ".src"
+
+// Stages a download can fail in, reported by FetchError
+const (
+	FetchStageRequest    = "request"
+	FetchStageTransfer   = "transfer"
+	FetchStageVerify     = "verify"
+	FetchStageDecompress = "decompress"
+	FetchStageInstall    = "install"
+)
+
+// FetchError is a failed download from one URL, with the stage it failed in
+type FetchError struct {
+	URL   string
+	Stage string
+	Err   error
+}
+
+func (e *FetchError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Stage, e.URL, e.Err)
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// RateLimiter caps the combined throughput of the downloads that share it
+type RateLimiter struct {
+	bytesPerSec int64
+
+	mu   sync.Mutex
+	next time.Time // When the bytes let through so far have been paid for
+}
+
+// NewRateLimiter creates a limiter allowing bytesPerSec bytes a second across all its readers
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	return &RateLimiter{bytesPerSec: bytesPerSec}
+}
+
+// wait blocks until n bytes just read fit under the limit
+func (l *RateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / float64(l.bytesPerSec) * float64(time.Second)))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
+
+// limitedReader reads through a RateLimiter in chunks of at most a tenth of a second's allowance
+type limitedReader struct {
+	r       io.Reader
+	limiter *RateLimiter
+}
+
+func (r *limitedReader) Read(buf []byte) (int, error) {
+	if chunk := int(max(r.limiter.bytesPerSec/10, 1)); len(buf) > chunk {
+		buf = buf[:chunk]
+	}
+	n, err := r.r.Read(buf)
+	if n > 0 {
+		r.limiter.wait(n)
+	}
+	return n, err
+}
+
+// FetchImage downloads the image an ImageSpec describes, unless it is already present, and returns
+// its path. The spec's URL is tried first and then each mirror in order; a pass over all of them is
+// repeated with a growing delay if every one fails. An interrupted download is resumed. The
+// download is checked against the spec's SHA256, if any, before it is decompressed or put in place.
+// The error joins a FetchError for each URL tried in the last pass
+func (m *Manager) FetchImage(spec ImageSpec) (string, error) {
+	if err := spec.Validate(); err != nil {
+		return "", err
+	}
+	path := m.specPath(spec)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := m.fetch(spec.URLs(), path, spec.SHA256); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// fetch downloads the first of urls that works to destPath, going through them up to fetchAttempts times
+func (m *Manager) fetch(urls []string, destPath, sha256sum string) error {
+	delay := fetchRetryDelay
+	var err error
+	for attempt := 1; attempt <= fetchAttempts; attempt++ {
+		var errs []error
+		for _, url := range urls {
+			fetchErr := m.fetchURL(url, destPath, sha256sum)
+			if fetchErr == nil {
+				return nil
+			}
+			errs = append(errs, fetchErr)
+		}
+		err = errors.Join(errs...)
+		if attempt < fetchAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	return fmt.Errorf("failed after %d attempts: %w", fetchAttempts, err)
+}
+
+// fetchURL downloads url to destPath, gunzipping it if the URL ends in .gz, and checks the raw
+// download against a hex SHA-256 digest when sha256sum is not empty. The raw bytes go to
+// destPath.part first and are resumed with a Range request if a partial download is there: from
+// any URL when a digest guards the result, otherwise only from the URL it came from. A download
+// failing its checksum or decompression is discarded; destPath is only ever replaced by a complete one.
+// The transfer is reported as a step sized by the response's Content-Length
+func (m *Manager) fetchURL(url, destPath, sha256sum string) error {
+	name := "Fetching " + url
+	partPath := destPath + partSuffix
+	sourcePath := partPath + partSourceSuffix
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return &FetchError{url, FetchStageInstall, err}
+	}
+
+	var offset int64
+	if info, err := os.Stat(partPath); err == nil {
+		recorded, _ := os.ReadFile(sourcePath)
+		if sha256sum != "" || strings.TrimSpace(string(recorded)) == url {
+			offset = info.Size()
+		}
+	}
+
+	resp, err := m.get(url, offset)
+	if err == nil && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			// The partial download doesn't fit what the server has; start over next time
+			discardPart(partPath)
+		}
+		err = fmt.Errorf("bad status: %s", resp.Status)
+	}
+	if err != nil {
+		// Report the failed step too, so a fallback to another URL is explained
+		m.progress().Start(name, 0)
+		m.progress().Done(name, err)
+		return &FetchError{url, FetchStageRequest, err}
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if resp.StatusCode == http.StatusOK {
+		// The server sent the whole file, whether or not a range was asked for
+		offset = 0
+		flags = os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	}
+
+	m.progress().Start(name, max(offset+resp.ContentLength, 0))
+	err = m.receive(resp.Body, url, partPath, sourcePath, flags, offset)
+	if err == nil {
+		err = installDownload(url, partPath, destPath, sha256sum)
+	}
+	m.progress().Done(name, err)
+	return err
+}
+
+// receive appends a response body to a partial download, recording the URL it comes from
+func (m *Manager) receive(body io.Reader, url, partPath, sourcePath string, flags int, offset int64) error {
+	if err := os.WriteFile(sourcePath, []byte(url+"\n"), 0644); err != nil {
+		return &FetchError{url, FetchStageTransfer, err}
+	}
+	out, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return &FetchError{url, FetchStageTransfer, err}
+	}
+	if m.RateLimit != nil {
+		body = &limitedReader{r: body, limiter: m.RateLimit}
+	}
+	if _, err := io.Copy(out, &resumedReader{r: body, p: m.progress(), read: offset}); err != nil {
+		out.Close()
+		return &FetchError{url, FetchStageTransfer, err}
+	}
+	if err := out.Close(); err != nil {
+		return &FetchError{url, FetchStageTransfer, err}
+	}
+	return nil
+}
+
+// resumedReader reports a transfer's progress counting the bytes a resumed download already had
+type resumedReader struct {
+	r    io.Reader
+	p    progress.Progress
+	read int64
+}
+
+func (r *resumedReader) Read(buf []byte) (int, error) {
+	n, err := r.r.Read(buf)
+	if n > 0 {
+		r.read += int64(n)
+		r.p.Update(r.read)
+	}
+	return n, err
+}
+
+// installDownload checks a complete raw download and moves it, decompressed if the URL ends in .gz,
+// to destPath
+func installDownload(url, partPath, destPath, sha256sum string) error {
+	if sha256sum != "" {
+		got, err := fileSHA256(partPath)
+		if err != nil {
+			return &FetchError{url, FetchStageVerify, err}
+		}
+		if !strings.EqualFold(got, sha256sum) {
+			discardPart(partPath)
+			return &FetchError{url, FetchStageVerify, fmt.Errorf("%w: got sha256 %s, want %s", ErrChecksumMismatch, got, sha256sum)}
+		}
+	}
+
+	if !strings.HasSuffix(urlPath(url), ".gz") {
+		if err := os.Rename(partPath, destPath); err != nil {
+			return &FetchError{url, FetchStageInstall, err}
+		}
+		os.Remove(partPath + partSourceSuffix)
+		return nil
+	}
+
+	tmpPath := destPath + ".tmp"
+	if err := gunzipFile(partPath, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		discardPart(partPath)
+		return &FetchError{url, FetchStageDecompress, err}
+	}
+	if err := os.Rename(tmpPath, destPath); err != nil {
+		os.Remove(tmpPath)
+		return &FetchError{url, FetchStageInstall, err}
+	}
+	discardPart(partPath)
+	return nil
+}
+
+// discardPart removes a partial download and the record of its URL
+func discardPart(partPath string) {
+	os.Remove(partPath)
+	os.Remove(partPath + partSourceSuffix)
+}
+
+// fileSHA256 returns the hex SHA-256 digest of a file
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// gunzipFile decompresses srcPath to dstPath
+func gunzipFile(srcPath, dstPath string) error {
+	in, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	gzReader, err := gzip.NewReader(in)
+	if err != nil {
+		return err
+	}
+	defer gzReader.Close()
+	out, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, gzReader); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,96 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// GCImages removes the kernels and rootfs images that no VM refers to, returning the paths it
+// removed, or with dryRun the paths it would remove. The default kernel and rootfs are always
+// kept, as are images any process has open, which covers running VMs whose records have changed
+// since they started. Partial downloads and digest sidecars are not images; a removed image's
+// sidecar goes with it
+func (m *Manager) GCImages(vms []*vm.VM, dryRun bool) ([]string, error) {
+	inUse := map[string]bool{
+		filepath.Clean(m.GetDefaultKernelPath()): true,
+		filepath.Clean(m.GetDefaultRootfsPath()): true,
+	}
+	for _, v := range vms {
+		for _, path := range m.vmImagePaths(v) {
+			inUse[filepath.Clean(path)] = true
+		}
+	}
+	open := openFiles()
+
+	var removed []string
+	for _, dir := range []string{m.KernelDir, m.RootfsDir} {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return removed, fmt.Errorf("failed to read %s: %w", dir, err)
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || isDigestFile(name) || strings.HasSuffix(name, partSuffix) || strings.HasSuffix(name, partSuffix+partSourceSuffix) {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			if inUse[path] || open[path] {
+				continue
+			}
+			if real, err := filepath.EvalSymlinks(path); err == nil && (inUse[real] || open[real]) {
+				continue
+			}
+			if !dryRun {
+				if err := removeImageFile(path); err != nil {
+					return removed, fmt.Errorf("failed to remove %s: %w", path, err)
+				}
+			}
+			removed = append(removed, path)
+		}
+	}
+	return removed, nil
+}
+
+// vmImagePaths returns the kernel and image files a VM refers to, by name and by path
+func (m *Manager) vmImagePaths(v *vm.VM) []string {
+	paths := []string{m.GetKernelPath(v.Kernel), v.KernelPath, v.RootfsPath, v.InitrdPath, v.ModulesImage}
+	if v.Image != "" {
+		paths = append(paths, m.GetImagePath(v.Image))
+	}
+	var refs []string
+	for _, path := range paths {
+		if path != "" {
+			refs = append(refs, path)
+		}
+	}
+	return refs
+}
+
+// openFiles returns the files open in any process, read from /proc/<pid>/fd
+func openFiles() map[string]bool {
+	open := make(map[string]bool)
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return open
+	}
+	for _, proc := range procs {
+		fdDir := filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			if link, err := os.Readlink(filepath.Join(fdDir, fd.Name())); err == nil {
+				open[link] = true
+			}
+		}
+	}
+	return open
+}
@@ -0,0 +1,68 @@
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// GrowfsDisableArg is the kernel argument that stops a guest growing its root filesystem at boot
+const GrowfsDisableArg = "vmm.growfs=0"
+
+// growfsUnit grows the root filesystem to fill its device at boot ($$ is a systemd escape)
+// resize2fs grows a mounted ext4 filesystem online and does nothing when it already fills the
+// device, so the unit is safe to run on every boot
+const growfsUnit = `[Unit]
+Description=Grow the root filesystem to fill its disk
+DefaultDependencies=no
+After=local-fs.target
+Before=sysinit.target
+ConditionKernelCommandLine=!` + GrowfsDisableArg + `
+
+[Service]
+Type=oneshot
+ExecStart=/bin/sh -c 'dev=$$(findmnt -n -o SOURCE /); resize2fs "$${dev:-/dev/vda}"'
+
+[Install]
+WantedBy=sysinit.target
+`
+
+// InjectGrowfs installs a systemd unit in a rootfs image that grows the root filesystem to fill
+// the disk at boot, so a guest uses the space when its rootfs file has been grown without the
+// filesystem being resized on the host. A rootfs that already has the unit is left unchanged
+func InjectGrowfs(rootfsPath string) error {
+	mountPoint, err := os.MkdirTemp("", "vmm-rootfs-*")
+	if err != nil {
+		return fmt.Errorf("failed to create mount point: %w", err)
+	}
+	defer os.RemoveAll(mountPoint)
+
+	if output, err := exec.Command("mount", "-o", "loop", rootfsPath, mountPoint).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to mount rootfs: %w: %s", err, string(output))
+	}
+	defer exec.Command("umount", mountPoint).Run()
+
+	unitPath := filepath.Join(mountPoint, "etc", "systemd", "system", "vmm-growfs.service")
+	if current, err := os.ReadFile(unitPath); err == nil && bytes.Equal(current, []byte(growfsUnit)) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(unitPath), 0755); err != nil {
+		return fmt.Errorf("failed to create systemd directory: %w", err)
+	}
+	if err := os.WriteFile(unitPath, []byte(growfsUnit), 0644); err != nil {
+		return fmt.Errorf("failed to write growfs unit: %w", err)
+	}
+	wantsDir := filepath.Join(mountPoint, "etc", "systemd", "system", "sysinit.target.wants")
+	if err := os.MkdirAll(wantsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create systemd directory: %w", err)
+	}
+	link := filepath.Join(wantsDir, "vmm-growfs.service")
+	if _, err := os.Lstat(link); os.IsNotExist(err) {
+		if err := os.Symlink("/etc/systemd/system/vmm-growfs.service", link); err != nil {
+			return fmt.Errorf("failed to enable growfs unit: %w", err)
+		}
+	}
+	return nil
+}
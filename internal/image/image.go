@@ -1,11 +1,11 @@
 package image
 
 import (
-	"compress/gzip"
 	"debug/elf"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -13,6 +13,8 @@ import (
 	"runtime"
 	"strings"
 	"time"
+
+	"github.com/raesene/baremetalvmm/internal/progress"
 )
 
 // ImportDockerImage imports a Docker image as a VMM rootfs
@@ -29,8 +31,14 @@ func (m *Manager) ImportDockerImage(dockerImage, imageName string, sizeMB int) e
 		return fmt.Errorf("image '%s' already exists at %s", imageName, destPath)
 	}
 
-	fmt.Printf("Importing Docker image '%s' as '%s'...\n", dockerImage, imageName)
+	name := fmt.Sprintf("Importing Docker image '%s' as '%s'", dockerImage, imageName)
+	return progress.Run(m.progress(), name, func() error {
+		return m.importDockerImage(dockerImage, destPath, sizeMB)
+	})
+}
 
+// importDockerImage exports a Docker image and builds destPath from it
+func (m *Manager) importDockerImage(dockerImage, destPath string, sizeMB int) error {
 	// Create a temporary directory for the export
 	tmpDir, err := os.MkdirTemp("", "vmm-import-*")
 	if err != nil {
@@ -44,7 +52,30 @@ func (m *Manager) ImportDockerImage(dockerImage, imageName string, sizeMB int) e
 	}
 
 	// Step 1: Create a container from the image and export it
-	fmt.Println("  Exporting Docker image...")
+	if err := progress.Run(m.progress(), "Exporting Docker image", func() error {
+		return exportDockerImage(dockerImage, exportDir)
+	}); err != nil {
+		return err
+	}
+
+	// Step 2: Install systemd and SSH if not present
+	if err := progress.Run(m.progress(), "Configuring rootfs for Firecracker (this may take a while)", func() error {
+		return configureRootfsForFirecracker(exportDir)
+	}); err != nil {
+		return fmt.Errorf("failed to configure rootfs: %w", err)
+	}
+
+	// Step 3: Create the ext4 image
+	if err := progress.Run(m.progress(), fmt.Sprintf("Creating %dMB ext4 image", sizeMB), func() error {
+		return createExt4Image(destPath, exportDir, sizeMB)
+	}); err != nil {
+		return fmt.Errorf("failed to create ext4 image: %w", err)
+	}
+	return nil
+}
+
+// exportDockerImage extracts the filesystem of a Docker image into exportDir
+func exportDockerImage(dockerImage, exportDir string) error {
 	containerID, err := runCmdOutput("docker", "create", dockerImage)
 	if err != nil {
 		return fmt.Errorf("failed to create container from image: %w", err)
@@ -67,21 +98,6 @@ func (m *Manager) ImportDockerImage(dockerImage, imageName string, sizeMB int) e
 	if err := tarCmd.Wait(); err != nil {
 		return fmt.Errorf("failed to extract export: %w", err)
 	}
-
-	// Step 2: Install systemd and SSH if not present
-	fmt.Println("  Configuring rootfs for Firecracker...")
-	if err := configureRootfsForFirecracker(exportDir); err != nil {
-		return fmt.Errorf("failed to configure rootfs: %w", err)
-	}
-
-	// Step 3: Create the ext4 image
-	fmt.Printf("  Creating %dMB ext4 image...\n", sizeMB)
-	if err := createExt4Image(destPath, exportDir, sizeMB); err != nil {
-		return fmt.Errorf("failed to create ext4 image: %w", err)
-	}
-
-	fmt.Printf("Successfully imported '%s' as '%s'\n", dockerImage, imageName)
-	fmt.Printf("  Image path: %s\n", destPath)
 	return nil
 }
 
@@ -144,14 +160,12 @@ func configureRootfsForFirecracker(rootfsDir string) error {
 	// Copy resolv.conf for DNS during package installation
 	resolvConf := filepath.Join(rootfsDir, "etc", "resolv.conf")
 	os.Remove(resolvConf) // Remove if it's a symlink
-	if err := copyFile("/etc/resolv.conf", resolvConf); err != nil {
+	if err := copyFile("/etc/resolv.conf", resolvConf, progress.Discard); err != nil {
 		// Create a basic one if copy fails
 		os.WriteFile(resolvConf, []byte("nameserver 8.8.8.8\n"), 0644)
 	}
 
 	// Update package lists and install required packages
-	fmt.Println("  Installing systemd and openssh-server (this may take a while)...")
-
 	// Set DEBIAN_FRONTEND to avoid interactive prompts
 	env := []string{
 		"DEBIAN_FRONTEND=noninteractive",
@@ -300,7 +314,8 @@ func createExt4Image(imagePath, sourceDir string, sizeMB int) error {
 		return fmt.Errorf("failed to create image file: %w", err)
 	}
 
-	// Create ext4 filesystem
+	// Create ext4 filesystem, keeping mkfs.ext4's default 5% root reserve: a rootfs is a system
+	// disk, and the reserve lets root processes keep working when users fill it
 	mkfsCmd := exec.Command("mkfs.ext4", "-F", "-L", "rootfs", imagePath)
 	if output, err := mkfsCmd.CombinedOutput(); err != nil {
 		os.Remove(imagePath)
@@ -369,9 +384,9 @@ func (m *Manager) ImageExists(imageName string) bool {
 func (m *Manager) DeleteImage(imageName string) error {
 	path := m.GetImagePath(imageName)
 	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return fmt.Errorf("image '%s' not found", imageName)
+		return fmt.Errorf("%w: '%s'", ErrImageNotFound, imageName)
 	}
-	return os.Remove(path)
+	return removeImageFile(path)
 }
 
 const (
@@ -471,57 +486,52 @@ func findLatestRootfsURL() string {
 	return ""
 }
 
-// downloadAndDecompressGzip downloads a gzipped file and decompresses it to destPath
-func (m *Manager) downloadAndDecompressGzip(url, destPath string) error {
-	// Ensure directory exists
-	dir := filepath.Dir(destPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
-	}
-
-	// Create temp file
-	tmpPath := destPath + ".tmp"
-	out, err := os.Create(tmpPath)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		out.Close()
-		os.Remove(tmpPath) // Clean up temp file on error
-	}()
-
-	// Download
-	resp, err := http.Get(url)
+// get requests url with the manager's client and credentials, asking for the bytes from offset on
+// when it is not zero. The credentials never appear in errors: only the URL and status are reported
+func (m *Manager) get(url string, offset int64) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("bad status: %s", resp.Status)
+	switch {
+	case m.AuthHeader != "":
+		req.Header.Set("Authorization", m.AuthHeader)
+	case m.BasicAuth != nil:
+		req.SetBasicAuth(m.BasicAuth.User, m.BasicAuth.Pass)
 	}
-
-	// Decompress gzip stream
-	gzReader, err := gzip.NewReader(resp.Body)
-	if err != nil {
-		return fmt.Errorf("failed to create gzip reader: %w", err)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
 	}
-	defer gzReader.Close()
 
-	if _, err := io.Copy(out, gzReader); err != nil {
-		return fmt.Errorf("failed to decompress: %w", err)
+	client := m.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
 	}
-
-	out.Close()
-
-	// Rename to final path
-	return os.Rename(tmpPath, destPath)
+	return client.Do(req)
 }
 
 // Manager handles kernel and rootfs image management
 type Manager struct {
 	KernelDir string
 	RootfsDir string
+	Progress  progress.Progress // Receives progress of downloads, imports and rootfs copies (nil discards it)
+
+	// HTTPClient makes the downloads (nil = http.DefaultClient); set its transport for mTLS or proxies
+	HTTPClient *http.Client
+	// AuthHeader, if set, is sent as the Authorization header of downloads, e.g. "Bearer <token>"
+	AuthHeader string
+	// BasicAuth, if set and AuthHeader is not, authenticates downloads with HTTP basic auth
+	BasicAuth *BasicAuth
+	// CheckQuota, if set, is called with the bytes a new VM rootfs is expected to allocate and refuses it with an error
+	CheckQuota func(need int64) error
+	// RateLimit, if set, caps the combined bandwidth of downloads; share one between managers for a host-wide cap
+	RateLimit *RateLimiter
+}
+
+// BasicAuth holds HTTP basic auth credentials
+type BasicAuth struct {
+	User string
+	Pass string
 }
 
 // NewManager creates a new image manager
@@ -529,9 +539,18 @@ func NewManager(kernelDir, rootfsDir string) *Manager {
 	return &Manager{
 		KernelDir: kernelDir,
 		RootfsDir: rootfsDir,
+		Progress:  progress.NewStdout(0),
 	}
 }
 
+// progress returns the manager's progress sink
+func (m *Manager) progress() progress.Progress {
+	if m.Progress == nil {
+		return progress.Discard
+	}
+	return m.Progress
+}
+
 // EnsureDefaultImages downloads default kernel and rootfs if not present
 func (m *Manager) EnsureDefaultImages() error {
 	kernelPath := filepath.Join(m.KernelDir, DefaultKernelName)
@@ -539,45 +558,30 @@ func (m *Manager) EnsureDefaultImages() error {
 
 	// Download kernel if not exists
 	if _, err := os.Stat(kernelPath); os.IsNotExist(err) {
-		fmt.Println("Downloading default kernel...")
-
-		// Try GitHub releases first, fall back to static URL
-		kernelURL := findLatestKernelURL()
-		if kernelURL != "" {
-			fmt.Println("  Found kernel in GitHub releases")
-		} else {
-			fmt.Println("  GitHub releases unavailable, using fallback URL")
-			kernelURL = FallbackKernelURL
-		}
-
-		if err := m.downloadFile(kernelURL, kernelPath); err != nil {
+		if err := progress.Run(m.progress(), "Downloading default kernel", func() error {
+			// Try GitHub releases first, fall back to static URL
+			kernelURL := findLatestKernelURL()
+			if kernelURL == "" {
+				kernelURL = FallbackKernelURL
+			}
+			return m.fetch([]string{kernelURL}, kernelPath, "")
+		}); err != nil {
 			return fmt.Errorf("failed to download kernel: %w", err)
 		}
-		fmt.Println("Kernel downloaded successfully")
 	}
 
 	// Download rootfs if not exists
 	if _, err := os.Stat(rootfsPath); os.IsNotExist(err) {
-		fmt.Println("Downloading default rootfs (this may take a while)...")
-
-		// Try GitHub releases first (gzipped), fall back to S3 URL
-		rootfsURL := findLatestRootfsURL()
-		if rootfsURL != "" {
-			fmt.Println("  Found rootfs in GitHub releases")
-			if err := m.downloadAndDecompressGzip(rootfsURL, rootfsPath); err != nil {
-				fmt.Printf("  GitHub download failed (%v), trying fallback URL\n", err)
-				rootfsURL = ""
+		if err := progress.Run(m.progress(), "Downloading default rootfs (this may take a while)", func() error {
+			// Try GitHub releases first (gzipped), fall back to S3 URL; a failed step is reported
+			urls := []string{FallbackRootfsURL}
+			if rootfsURL := findLatestRootfsURL(); rootfsURL != "" {
+				urls = append([]string{rootfsURL}, urls...)
 			}
+			return m.fetch(urls, rootfsPath, "")
+		}); err != nil {
+			return fmt.Errorf("failed to download rootfs: %w", err)
 		}
-
-		if rootfsURL == "" {
-			fmt.Println("  Using fallback URL")
-			if err := m.downloadFile(FallbackRootfsURL, rootfsPath); err != nil {
-				return fmt.Errorf("failed to download rootfs: %w", err)
-			}
-		}
-
-		fmt.Println("Rootfs downloaded successfully")
 	}
 
 	return nil
@@ -610,52 +614,91 @@ func (m *Manager) CreateVMRootfs(vmName string, vmDir string, diskSizeMB int, im
 	}
 
 	// Check if source exists
-	if _, err := os.Stat(srcPath); err != nil {
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
 		if imageName != "" {
-			return "", fmt.Errorf("image '%s' not found at %s: %w", imageName, srcPath, err)
+			return "", fmt.Errorf("%w: '%s' at %s: %w", ErrImageNotFound, imageName, srcPath, err)
 		}
-		return "", fmt.Errorf("default rootfs not found at %s: %w", srcPath, err)
+		return "", fmt.Errorf("%w: default rootfs at %s: %w", ErrImageNotFound, srcPath, err)
 	}
 
-	// Copy the rootfs
+	// The copy allocates what the source does; growing the filesystem adds little until the guest writes
+	need, err := AllocatedBytes(srcPath)
+	if err != nil {
+		need = srcInfo.Size()
+	}
+	if err := m.checkQuota(need); err != nil {
+		return "", fmt.Errorf("cannot create rootfs for VM '%s': %w", vmName, err)
+	}
+
+	name := fmt.Sprintf("Creating rootfs for VM '%s'", vmName)
 	if imageName != "" {
-		fmt.Printf("Creating rootfs for VM '%s' from image '%s'...\n", vmName, imageName)
-	} else {
-		fmt.Printf("Creating rootfs for VM '%s'...\n", vmName)
+		name += fmt.Sprintf(" from image '%s'", imageName)
+	}
+	m.progress().Start(name, srcInfo.Size())
+	err = m.writeVMRootfs(srcPath, dstPath, diskSizeMB)
+	m.progress().Done(name, err)
+	if err != nil {
+		return "", err
+	}
+	return dstPath, nil
+}
+
+// checkQuota applies the manager's disk quota, if any, to a rootfs expected to allocate need bytes
+func (m *Manager) checkQuota(need int64) error {
+	if m.CheckQuota == nil {
+		return nil
 	}
-	if err := copyFile(srcPath, dstPath); err != nil {
-		return "", fmt.Errorf("failed to copy rootfs: %w", err)
+	return m.CheckQuota(need)
+}
+
+// writeVMRootfs copies the rootfs to dstPath and grows it to diskSizeMB
+func (m *Manager) writeVMRootfs(srcPath, dstPath string, diskSizeMB int) error {
+	// Copy the rootfs
+	if err := copyFile(srcPath, dstPath, m.progress()); err != nil {
+		return fmt.Errorf("failed to copy rootfs: %w", err)
 	}
+	return m.resizeVMRootfs(dstPath, diskSizeMB)
+}
 
+// resizeVMRootfs grows a VM's rootfs file and filesystem to diskSizeMB and installs the guest's
+// growfs unit; it never shrinks
+func (m *Manager) resizeVMRootfs(dstPath string, diskSizeMB int) error {
 	// Resize the rootfs if a size was specified
-	if diskSizeMB > 0 {
-		// Get current file size
-		info, _ := os.Stat(dstPath)
-		currentSizeMB := int(info.Size() / (1024 * 1024))
-
-		// Only resize if requested size is larger than current
-		if diskSizeMB > currentSizeMB {
-			fmt.Printf("Resizing rootfs to %d MB...\n", diskSizeMB)
-
-			// Expand the file to the desired size
-			truncateCmd := exec.Command("truncate", "-s", fmt.Sprintf("%dM", diskSizeMB), dstPath)
-			if output, err := truncateCmd.CombinedOutput(); err != nil {
-				return "", fmt.Errorf("failed to expand rootfs file: %w: %s", err, string(output))
-			}
+	if diskSizeMB <= 0 {
+		return nil
+	}
+	// Get current file size
+	info, _ := os.Stat(dstPath)
+	currentSizeMB := int(info.Size() / (1024 * 1024))
+
+	// Only resize if requested size is larger than current
+	if diskSizeMB <= currentSizeMB {
+		return nil
+	}
+	return progress.Run(m.progress(), fmt.Sprintf("Resizing rootfs to %d MB", diskSizeMB), func() error {
+		// Expand the file to the desired size
+		truncateCmd := exec.Command("truncate", "-s", fmt.Sprintf("%dM", diskSizeMB), dstPath)
+		if output, err := truncateCmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to expand rootfs file: %w: %s", err, string(output))
+		}
 
-			// Check the filesystem before resizing
-			e2fsckCmd := exec.Command("e2fsck", "-f", "-y", dstPath)
-			e2fsckCmd.Run() // Best effort, ignore errors
+		// Check the filesystem before resizing
+		e2fsckCmd := exec.Command("e2fsck", "-f", "-y", dstPath)
+		e2fsckCmd.Run() // Best effort, ignore errors
 
-			// Resize the ext4 filesystem to fill the file
-			resize2fsCmd := exec.Command("resize2fs", dstPath)
-			if output, err := resize2fsCmd.CombinedOutput(); err != nil {
-				return "", fmt.Errorf("failed to resize filesystem: %w: %s", err, string(output))
-			}
+		// Resize the ext4 filesystem to fill the file
+		resize2fsCmd := exec.Command("resize2fs", dstPath)
+		if output, err := resize2fsCmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to resize filesystem: %w: %s", err, string(output))
 		}
-	}
 
-	return dstPath, nil
+		// The guest grows the filesystem again at boot, covering any later growth of the file
+		if err := InjectGrowfs(dstPath); err != nil {
+			return fmt.Errorf("failed to install growfs unit: %w", err)
+		}
+		return nil
+	})
 }
 
 // DeleteVMRootfs removes a VM's rootfs
@@ -677,48 +720,8 @@ func (m *Manager) ListRootfs() ([]string, error) {
 	return listFiles(m.RootfsDir)
 }
 
-// downloadFile downloads a file from URL to the specified path
-func (m *Manager) downloadFile(url, destPath string) error {
-	// Ensure directory exists
-	dir := filepath.Dir(destPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
-	}
-
-	// Create temp file
-	tmpPath := destPath + ".tmp"
-	out, err := os.Create(tmpPath)
-	if err != nil {
-		return err
-	}
-	defer out.Close()
-
-	// Download
-	resp, err := http.Get(url)
-	if err != nil {
-		os.Remove(tmpPath)
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		os.Remove(tmpPath)
-		return fmt.Errorf("bad status: %s", resp.Status)
-	}
-
-	// Copy with progress (simple version)
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		os.Remove(tmpPath)
-		return err
-	}
-
-	// Rename to final path
-	return os.Rename(tmpPath, destPath)
-}
-
-// copyFile copies a file from src to dst
-func copyFile(src, dst string) error {
+// copyFile copies a file from src to dst, reporting the bytes copied as the current operation's progress
+func copyFile(src, dst string, p progress.Progress) error {
 	srcFile, err := os.Open(src)
 	if err != nil {
 		return err
@@ -731,11 +734,11 @@ func copyFile(src, dst string) error {
 	}
 	defer dstFile.Close()
 
-	_, err = io.Copy(dstFile, srcFile)
+	_, err = io.Copy(dstFile, progress.NewReader(srcFile, p))
 	return err
 }
 
-// listFiles returns all files in a directory
+// listFiles returns all files in a directory, leaving out digest sidecars
 func listFiles(dir string) ([]string, error) {
 	entries, err := os.ReadDir(dir)
 	if err != nil {
@@ -747,7 +750,7 @@ func listFiles(dir string) ([]string, error) {
 
 	var files []string
 	for _, entry := range entries {
-		if !entry.IsDir() {
+		if !entry.IsDir() && !isDigestFile(entry.Name()) {
 			files = append(files, entry.Name())
 		}
 	}
@@ -757,14 +760,30 @@ func listFiles(dir string) ([]string, error) {
 // DefaultDNSServers are used when no custom DNS servers are specified
 var DefaultDNSServers = []string{"8.8.8.8", "8.8.4.4", "1.1.1.1"}
 
+// ValidateDNSServers checks that each DNS server entry is a valid IP address
+func ValidateDNSServers(dnsServers []string) error {
+	for _, server := range dnsServers {
+		if net.ParseIP(server) == nil {
+			return fmt.Errorf("invalid DNS server '%s': must be an IP address", server)
+		}
+	}
+	return nil
+}
+
 // InjectDNSConfig injects DNS configuration into a rootfs image
-// This mounts the ext4 image and writes /etc/resolv.conf
+// This mounts the ext4 image and writes /etc/resolv.conf, with a search line for any search domains
 // If dnsServers is empty, default public DNS servers are used
-func InjectDNSConfig(rootfsPath string, dnsServers []string) error {
+func InjectDNSConfig(rootfsPath string, dnsServers, searchDomains []string) error {
 	// Use defaults if no custom servers specified
 	if len(dnsServers) == 0 {
 		dnsServers = DefaultDNSServers
 	}
+	if err := ValidateDNSServers(dnsServers); err != nil {
+		return err
+	}
+	if err := ValidateSearchDomains(searchDomains); err != nil {
+		return err
+	}
 
 	// Create a temporary mount point
 	mountPoint, err := os.MkdirTemp("", "vmm-rootfs-*")
@@ -788,6 +807,9 @@ func InjectDNSConfig(rootfsPath string, dnsServers []string) error {
 	// Build resolv.conf content
 	var resolvConf strings.Builder
 	resolvConf.WriteString("# Generated by vmm\n")
+	if len(searchDomains) > 0 {
+		resolvConf.WriteString(fmt.Sprintf("search %s\n", strings.Join(searchDomains, " ")))
+	}
 	for _, server := range dnsServers {
 		resolvConf.WriteString(fmt.Sprintf("nameserver %s\n", server))
 	}
@@ -802,9 +824,12 @@ func InjectDNSConfig(rootfsPath string, dnsServers []string) error {
 
 // MountEntry represents a mount point to add to fstab
 type MountEntry struct {
-	Device    string // e.g., /dev/vdb
+	Device    string // e.g., /dev/vdb (ignored for tmpfs)
 	MountPath string // e.g., /mnt/code
 	ReadOnly  bool
+	Tmpfs     bool   // Mount a tmpfs instead of a block device
+	SizeMB    int    // Size of the tmpfs
+	FSType    string // Filesystem on the device (default ext4)
 }
 
 // InjectMountFstab adds mount entries to /etc/fstab in a rootfs image
@@ -862,13 +887,25 @@ func InjectMountFstab(rootfsPath string, mounts []MountEntry) error {
 
 	// Add mount entries
 	for _, mount := range mounts {
-		options := "defaults,nofail"
-		if mount.ReadOnly {
-			options = "defaults,nofail,ro"
-		}
 		// Add fstab entry with vmm-mount marker
-		newFstab.WriteString(fmt.Sprintf("%s %s ext4 %s 0 2 # vmm-mount\n",
-			mount.Device, mount.MountPath, options))
+		if mount.Tmpfs {
+			newFstab.WriteString(fmt.Sprintf("tmpfs %s tmpfs defaults,nofail,size=%dM,mode=1777 0 0 # vmm-mount\n",
+				mount.MountPath, mount.SizeMB))
+		} else {
+			options := "defaults,nofail"
+			if mount.ReadOnly {
+				options = "defaults,nofail,ro"
+			}
+			fsType, passno := mount.FSType, 2
+			if fsType == "" {
+				fsType = "ext4"
+			} else if fsType != "ext4" {
+				// Guests may not have a checker for other filesystems; a failed fsck would stop boot
+				passno = 0
+			}
+			newFstab.WriteString(fmt.Sprintf("%s %s %s %s 0 %d # vmm-mount\n",
+				mount.Device, mount.MountPath, fsType, options, passno))
+		}
 
 		// Create mount directory
 		mountDir := filepath.Join(mountPoint, mount.MountPath)
@@ -944,6 +981,17 @@ type KernelInfo struct {
 	Size      int64     // Size in bytes
 	ModTime   time.Time // Last modification time
 	IsDefault bool      // Whether this is the default kernel
+	SHA256    string    // Hex digest of the file, when listed with digests
+}
+
+// RootfsInfo contains information about a rootfs image
+type RootfsInfo struct {
+	Name      string    // Image name (filename without .ext4)
+	Path      string    // Full path to the image
+	Size      int64     // Size in bytes
+	ModTime   time.Time // Last modification time
+	IsDefault bool      // Whether this is the default rootfs
+	SHA256    string    // Hex digest of the file, when listed with digests
 }
 
 // ImportKernel imports a custom kernel binary
@@ -968,21 +1016,20 @@ func (m *Manager) ImportKernel(srcPath, name string, force bool) error {
 		return fmt.Errorf("failed to create kernel directory: %w", err)
 	}
 
-	// Copy the kernel
-	fmt.Printf("Importing kernel '%s' from %s...\n", name, srcPath)
-	if err := copyFile(srcPath, destPath); err != nil {
-		return fmt.Errorf("failed to copy kernel: %w", err)
-	}
-
 	// Get file info for size
-	info, err := os.Stat(destPath)
+	info, err := os.Stat(srcPath)
 	if err != nil {
 		return fmt.Errorf("failed to stat kernel: %w", err)
 	}
 
-	fmt.Printf("Successfully imported kernel '%s'\n", name)
-	fmt.Printf("  Path: %s\n", destPath)
-	fmt.Printf("  Size: %.2f MB\n", float64(info.Size())/(1024*1024))
+	// Copy the kernel
+	opName := fmt.Sprintf("Importing kernel '%s' from %s", name, srcPath)
+	m.progress().Start(opName, info.Size())
+	err = copyFile(srcPath, destPath, m.progress())
+	m.progress().Done(opName, err)
+	if err != nil {
+		return fmt.Errorf("failed to copy kernel: %w", err)
+	}
 	return nil
 }
 
@@ -1027,10 +1074,10 @@ func (m *Manager) DeleteKernel(name string) error {
 
 	path := filepath.Join(m.KernelDir, name)
 	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return fmt.Errorf("kernel '%s' not found", name)
+		return fmt.Errorf("%w: '%s'", ErrKernelNotFound, name)
 	}
 
-	return os.Remove(path)
+	return removeImageFile(path)
 }
 
 // KernelExists checks if a kernel with the given name exists
@@ -1050,7 +1097,8 @@ func (m *Manager) GetKernelPath(name string) string {
 }
 
 // ListKernelsWithInfo returns detailed information about all available kernels
-func (m *Manager) ListKernelsWithInfo() ([]KernelInfo, error) {
+// With digests each kernel's SHA256 is included, read from its cached digest when the kernel is unchanged
+func (m *Manager) ListKernelsWithInfo(digests bool) ([]KernelInfo, error) {
 	entries, err := os.ReadDir(m.KernelDir)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -1061,7 +1109,7 @@ func (m *Manager) ListKernelsWithInfo() ([]KernelInfo, error) {
 
 	var kernels []KernelInfo
 	for _, entry := range entries {
-		if entry.IsDir() {
+		if entry.IsDir() || isDigestFile(entry.Name()) {
 			continue
 		}
 
@@ -1070,14 +1118,60 @@ func (m *Manager) ListKernelsWithInfo() ([]KernelInfo, error) {
 			continue
 		}
 
-		kernels = append(kernels, KernelInfo{
+		kernel := KernelInfo{
 			Name:      entry.Name(),
 			Path:      filepath.Join(m.KernelDir, entry.Name()),
 			Size:      info.Size(),
 			ModTime:   info.ModTime(),
 			IsDefault: entry.Name() == DefaultKernelName,
-		})
+		}
+		if digests {
+			if kernel.SHA256, err = imageDigest(kernel.Path, info); err != nil {
+				return nil, err
+			}
+		}
+		kernels = append(kernels, kernel)
 	}
 
 	return kernels, nil
 }
+
+// ListRootfsWithInfo returns detailed information about all available rootfs images
+// With digests each image's SHA256 is included, read from its cached digest when the image is unchanged
+func (m *Manager) ListRootfsWithInfo(digests bool) ([]RootfsInfo, error) {
+	entries, err := os.ReadDir(m.RootfsDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []RootfsInfo{}, nil
+		}
+		return nil, err
+	}
+
+	var images []RootfsInfo
+	for _, entry := range entries {
+		if entry.IsDir() || isDigestFile(entry.Name()) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		rootfs := RootfsInfo{
+			Name:      strings.TrimSuffix(entry.Name(), ".ext4"),
+			Path:      filepath.Join(m.RootfsDir, entry.Name()),
+			Size:      info.Size(),
+			ModTime:   info.ModTime(),
+			IsDefault: entry.Name() == DefaultRootfsName,
+		}
+		if digests {
+			if rootfs.SHA256, err = imageDigest(rootfs.Path, info); err != nil {
+				return nil, err
+			}
+		}
+		images = append(images, rootfs)
+	}
+
+	return images, nil
+}
@@ -0,0 +1,163 @@
+package image
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// kernelNameVersionPattern finds a dotted version such as 6.1 or 5.10.225 in a kernel file name
+var kernelNameVersionPattern = regexp.MustCompile(`\d+(\.\d+)+`)
+
+// KernelNameVersion parses the version embedded in a kernel file name such as vmlinux-6.1.bin
+// It returns nil if the name has no dotted version
+func KernelNameVersion(name string) []int {
+	match := kernelNameVersionPattern.FindString(name)
+	if match == "" {
+		return nil
+	}
+	version, err := parseVersion(match)
+	if err != nil {
+		return nil
+	}
+	return version
+}
+
+// parseVersion parses a dotted version such as 5.10 into its numbers
+func parseVersion(s string) ([]int, error) {
+	parts := strings.Split(s, ".")
+	version := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid version '%s'", s)
+		}
+		version[i] = n
+	}
+	return version, nil
+}
+
+// compareVersions compares two versions number by number, missing numbers counting as 0
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// kernelConstraint is a parsed version constraint such as >=5.10
+type kernelConstraint struct {
+	op      string
+	version []int
+}
+
+// constraintOps are the operators a constraint may start with, longest first
+var constraintOps = []string{">=", "<=", "==", ">", "<", "="}
+
+// IsKernelConstraint reports whether a --kernel value is a version constraint rather than a kernel name
+func IsKernelConstraint(s string) bool {
+	for _, op := range constraintOps {
+		if strings.HasPrefix(s, op) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseKernelConstraint parses a constraint; a bare version means an exact match
+func parseKernelConstraint(s string) (*kernelConstraint, error) {
+	s = strings.TrimSpace(s)
+	op := "="
+	for _, candidate := range constraintOps {
+		if strings.HasPrefix(s, candidate) {
+			op = candidate
+			s = strings.TrimSpace(s[len(candidate):])
+			break
+		}
+	}
+	version, err := parseVersion(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kernel constraint: %w", err)
+	}
+	return &kernelConstraint{op: op, version: version}, nil
+}
+
+// matches reports whether a kernel version satisfies the constraint
+// An exact match only compares the numbers the constraint gives, so =6.1 matches 6.1.102
+func (c *kernelConstraint) matches(version []int) bool {
+	if c.op == "=" || c.op == "==" {
+		return len(version) >= len(c.version) && compareVersions(version[:len(c.version)], c.version) == 0
+	}
+	cmp := compareVersions(version, c.version)
+	switch c.op {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	default:
+		return cmp < 0
+	}
+}
+
+// sortKernelNames orders kernel names oldest first: by embedded version, then by name
+// Names without a version sort before the versioned ones, in lexical order
+func sortKernelNames(names []string) {
+	sort.SliceStable(names, func(i, j int) bool {
+		vi, vj := KernelNameVersion(names[i]), KernelNameVersion(names[j])
+		switch {
+		case vi == nil && vj != nil:
+			return true
+		case vi != nil && vj == nil:
+			return false
+		case vi != nil:
+			if cmp := compareVersions(vi, vj); cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return names[i] < names[j]
+	})
+}
+
+// SelectKernel returns the name of the newest kernel satisfying a constraint such as >=5.10
+// Versions are parsed from kernel file names. An empty constraint selects the newest kernel,
+// falling back to the lexically last name when no name has a version
+func (m *Manager) SelectKernel(constraint string) (string, error) {
+	names, err := m.ListKernels()
+	if err != nil {
+		return "", fmt.Errorf("failed to list kernels: %w", err)
+	}
+	if len(names) == 0 {
+		return "", fmt.Errorf("no kernels found")
+	}
+	sortKernelNames(names)
+
+	if strings.TrimSpace(constraint) == "" {
+		return names[len(names)-1], nil
+	}
+	c, err := parseKernelConstraint(constraint)
+	if err != nil {
+		return "", err
+	}
+	for i := len(names) - 1; i >= 0; i-- {
+		if version := KernelNameVersion(names[i]); version != nil && c.matches(version) {
+			return names[i], nil
+		}
+	}
+	return "", fmt.Errorf("no kernel matches '%s'", constraint)
+}
@@ -0,0 +1,118 @@
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// ModulesMountPath is where the guest mounts a kernel modules image
+// The image holds a single <version> directory, so the guest sees /lib/modules/<version>
+const ModulesMountPath = "/lib/modules"
+
+// KernelVersion reads the release string (as uname -r reports it) from a vmlinux binary
+func KernelVersion(kernelPath string) (string, error) {
+	data, err := os.ReadFile(kernelPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read kernel: %w", err)
+	}
+	marker := []byte("Linux version ")
+	i := bytes.Index(data, marker)
+	if i < 0 {
+		return "", fmt.Errorf("no version string found in kernel %s", kernelPath)
+	}
+	rest := data[i+len(marker):]
+	end := bytes.IndexAny(rest, " \x00\n")
+	if end <= 0 {
+		return "", fmt.Errorf("malformed version string in kernel %s", kernelPath)
+	}
+	return string(rest[:end]), nil
+}
+
+// BuildModulesImage builds an ext4 image of a host modules directory such as /lib/modules/6.1.102
+// The directory's name is taken as the kernel version and returned
+func BuildModulesImage(modulesDir, imagePath string) (string, error) {
+	info, err := os.Stat(modulesDir)
+	if err != nil || !info.IsDir() {
+		return "", fmt.Errorf("modules directory %s does not exist", modulesDir)
+	}
+	if _, err := os.Stat(filepath.Join(modulesDir, "modules.dep")); err != nil {
+		return "", fmt.Errorf("%s has no modules.dep; run depmod for that kernel first", modulesDir)
+	}
+	version := filepath.Base(filepath.Clean(modulesDir))
+
+	output, err := exec.Command("du", "-sm", modulesDir).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to size %s: %w", modulesDir, err)
+	}
+	var usedMB int
+	fmt.Sscanf(string(output), "%d", &usedMB)
+	// Leave room for filesystem metadata
+	sizeMB := usedMB + usedMB/5 + 16
+
+	// Stage the directory under its version so the image root holds <version>/
+	stage, err := os.MkdirTemp("", "vmm-modules-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer os.RemoveAll(stage)
+	if output, err := exec.Command("cp", "-a", modulesDir, filepath.Join(stage, version)).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to copy modules: %w: %s", err, string(output))
+	}
+
+	os.Remove(imagePath)
+	if err := createExt4Image(imagePath, stage, sizeMB); err != nil {
+		return "", err
+	}
+	return version, nil
+}
+
+// ModulesImageVersion returns the kernel version of the modules in an image built by BuildModulesImage
+func ModulesImageVersion(imagePath string) (string, error) {
+	mountPoint, err := os.MkdirTemp("", "vmm-modules-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create mount point: %w", err)
+	}
+	defer os.RemoveAll(mountPoint)
+
+	if output, err := exec.Command("mount", "-o", "loop,ro", imagePath, mountPoint).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to mount modules image: %w: %s", err, string(output))
+	}
+	defer exec.Command("umount", mountPoint).Run()
+
+	entries, err := os.ReadDir(mountPoint)
+	if err != nil {
+		return "", fmt.Errorf("failed to read modules image: %w", err)
+	}
+	var versions []string
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != "lost+found" {
+			versions = append(versions, entry.Name())
+		}
+	}
+	if len(versions) != 1 {
+		return "", fmt.Errorf("modules image %s should hold one kernel version directory, found %d", imagePath, len(versions))
+	}
+	return versions[0], nil
+}
+
+// ValidateModulesImage checks that a modules image was built for the given kernel
+func ValidateModulesImage(imagePath, kernelPath string) error {
+	if _, err := os.Stat(imagePath); err != nil {
+		return fmt.Errorf("modules image not found at %s", imagePath)
+	}
+	want, err := KernelVersion(kernelPath)
+	if err != nil {
+		return err
+	}
+	got, err := ModulesImageVersion(imagePath)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("modules image %s is for kernel %s, but the VM boots %s", imagePath, got, want)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package image
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// MaxSearchDomains is how many search domains older resolvers read from resolv.conf
+const MaxSearchDomains = 6
+
+// hostnameLabel matches one label of a DNS name
+var hostnameLabel = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// validHostname reports whether name is a DNS name: dot-separated labels of letters, digits and
+// inner hyphens, at most 253 characters
+func validHostname(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if !hostnameLabel.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// ValidateSearchDomains checks that each search domain is a DNS name and that there are no more
+// than MaxSearchDomains
+func ValidateSearchDomains(domains []string) error {
+	if len(domains) > MaxSearchDomains {
+		return fmt.Errorf("too many DNS search domains: %d (at most %d)", len(domains), MaxSearchDomains)
+	}
+	for _, domain := range domains {
+		if !validHostname(domain) {
+			return fmt.Errorf("invalid DNS search domain '%s': must be a domain name such as corp.example.com", domain)
+		}
+	}
+	return nil
+}
+
+// ValidateNTPServers checks that each NTP server is an IP address or a host name
+func ValidateNTPServers(servers []string) error {
+	for _, server := range servers {
+		if net.ParseIP(server) == nil && !validHostname(server) {
+			return fmt.Errorf("invalid NTP server '%s': must be an IP address or host name", server)
+		}
+	}
+	return nil
+}
+
+// SeedVendorData returns NoCloud vendor-data that has cloud-init set the guest's search domains
+// (in resolv.conf, alongside the DNS servers) and NTP servers, or "" when there are neither.
+// cloud-init merges it under the user-data, so user-data settings of the same modules win
+func SeedVendorData(dnsServers, searchDomains, ntpServers []string) string {
+	if len(searchDomains) == 0 && len(ntpServers) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("#cloud-config\n")
+	writeList := func(indent, key string, values []string) {
+		fmt.Fprintf(&b, "%s%s:\n", indent, key)
+		for _, v := range values {
+			fmt.Fprintf(&b, "%s  - %q\n", indent, v)
+		}
+	}
+	if len(searchDomains) > 0 {
+		if len(dnsServers) == 0 {
+			dnsServers = DefaultDNSServers
+		}
+		b.WriteString("manage_resolv_conf: true\nresolv_conf:\n")
+		writeList("  ", "nameservers", dnsServers)
+		writeList("  ", "searchdomains", searchDomains)
+	}
+	if len(ntpServers) > 0 {
+		b.WriteString("ntp:\n  enabled: true\n")
+		writeList("  ", "servers", ntpServers)
+	}
+	return b.String()
+}
@@ -0,0 +1,117 @@
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// ScratchLabel is the ext4 label the overlay init looks for to find a VM's scratch drive
+const ScratchLabel = "vmm-scratch"
+
+// DefaultScratchSizeMB is the size of a new scratch image; it is sparse, so it allocates only what the guest writes
+const DefaultScratchSizeMB = 4096
+
+// OverlayInitPath is where the overlay init is installed in a base image
+const OverlayInitPath = "/sbin/overlay-init"
+
+// OverlayKernelArgs boots a guest through the overlay init; add them to the VM's kernel args
+const OverlayKernelArgs = "init=" + OverlayInitPath
+
+// overlayInitScript mounts the scratch drive, lays a writable overlayfs over the read-only
+// rootfs with its upper and work directories on the scratch, and starts the real init in it
+const overlayInitScript = `#!/bin/sh
+# Installed by vmm: runs the read-only rootfs with a writable overlay on the scratch drive
+set -e
+mount -t proc proc /proc
+mount -t devtmpfs devtmpfs /dev
+mount -t tmpfs -o size=1m tmpfs /mnt
+mkdir /mnt/scratch /mnt/root
+mount -t ext4 "$(blkid -L ` + ScratchLabel + `)" /mnt/scratch
+mkdir -p /mnt/scratch/upper /mnt/scratch/work
+mount -t overlay overlay -o lowerdir=/,upperdir=/mnt/scratch/upper,workdir=/mnt/scratch/work /mnt/root
+mkdir -p /mnt/root/mnt/rom
+cd /mnt/root
+pivot_root . mnt/rom
+exec chroot . /sbin/init "$@" <dev/console >dev/console 2>&1
+`
+
+// CreateOverlayRootfs prepares a thin rootfs for a VM over the default rootfs
+// See CreateOverlayRootfsFromImage
+func (m *Manager) CreateOverlayRootfs(vmName, vmDir string) (base, scratch string, err error) {
+	return m.CreateOverlayRootfsFromImage(vmName, vmDir, "")
+}
+
+// CreateOverlayRootfsFromImage prepares a thin rootfs for a VM: instead of copying the image, the
+// VM boots the shared image read-only and keeps its changes on a sparse per-VM scratch image.
+// It returns the two paths to attach: base as the read-only rootfs and scratch as a writable
+// drive, booted with OverlayKernelArgs. If imageName is empty the default rootfs is the base.
+// An existing scratch image is kept, so the VM's changes survive restarts
+func (m *Manager) CreateOverlayRootfsFromImage(vmName, vmDir, imageName string) (base, scratch string, err error) {
+	base = m.GetDefaultRootfsPath()
+	if imageName != "" {
+		base = m.GetImagePath(imageName)
+	}
+	if _, err := os.Stat(base); err != nil {
+		return "", "", fmt.Errorf("%w: base rootfs at %s: %w", ErrImageNotFound, base, err)
+	}
+	if err := installOverlayInit(base); err != nil {
+		return "", "", err
+	}
+
+	scratch = filepath.Join(vmDir, vmName+".scratch.ext4")
+	if _, err := os.Stat(scratch); err == nil {
+		return base, scratch, nil
+	}
+	if err := m.checkQuota(0); err != nil {
+		return "", "", fmt.Errorf("cannot create scratch image for VM '%s': %w", vmName, err)
+	}
+	if err := createScratchImage(scratch, DefaultScratchSizeMB); err != nil {
+		return "", "", err
+	}
+	return base, scratch, nil
+}
+
+// installOverlayInit writes the overlay init into a base image if it isn't there already
+// A base image is only modified once, before any VM boots from it: VMs attach it read-only
+// and would see it change underneath them
+func installOverlayInit(basePath string) error {
+	mountPoint, err := os.MkdirTemp("", "vmm-rootfs-*")
+	if err != nil {
+		return fmt.Errorf("failed to create mount point: %w", err)
+	}
+	defer os.RemoveAll(mountPoint)
+
+	if output, err := exec.Command("mount", "-o", "loop,ro", basePath, mountPoint).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to mount base rootfs: %w: %s", err, string(output))
+	}
+	current, _ := os.ReadFile(filepath.Join(mountPoint, OverlayInitPath))
+	exec.Command("umount", mountPoint).Run()
+	if bytes.Equal(current, []byte(overlayInitScript)) {
+		return nil
+	}
+
+	if output, err := exec.Command("mount", "-o", "loop", basePath, mountPoint).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to mount base rootfs: %w: %s", err, string(output))
+	}
+	defer exec.Command("umount", mountPoint).Run()
+	if err := os.WriteFile(filepath.Join(mountPoint, OverlayInitPath), []byte(overlayInitScript), 0755); err != nil {
+		return fmt.Errorf("failed to install overlay init: %w", err)
+	}
+	return nil
+}
+
+// createScratchImage creates an empty sparse ext4 image labelled for the overlay init
+func createScratchImage(imagePath string, sizeMB int) error {
+	tmpPath := imagePath + ".tmp"
+	if err := exec.Command("truncate", "-s", fmt.Sprintf("%dM", sizeMB), tmpPath).Run(); err != nil {
+		return fmt.Errorf("failed to create scratch image: %w", err)
+	}
+	if output, err := exec.Command("mkfs.ext4", "-F", "-q", "-L", ScratchLabel, tmpPath).CombinedOutput(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to create ext4 filesystem: %w: %s", err, string(output))
+	}
+	return os.Rename(tmpPath, imagePath)
+}
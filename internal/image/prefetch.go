@@ -0,0 +1,134 @@
+package image
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/raesene/baremetalvmm/internal/progress"
+)
+
+// Image kinds a prefetch can download
+const (
+	ImageKindKernel = "kernel"
+	ImageKindRootfs = "rootfs"
+)
+
+// prefetchConcurrency is how many images a prefetch downloads at once
+const prefetchConcurrency = 4
+
+// ImageSpec names an image to prefetch and where to download it from
+type ImageSpec struct {
+	Kind   string `json:"kind"`             // kernel or rootfs
+	Name   string `json:"name"`             // Kernel file name, or rootfs name as given to --image
+	URL    string `json:"url"`              // A URL ending in .gz is decompressed after download
+	SHA256 string `json:"sha256,omitempty"` // Hex digest of the downloaded file, before decompression
+
+	// Mirrors serve the same file as URL and are tried in order when it fails
+	Mirrors []string `json:"mirrors,omitempty"`
+}
+
+// URLs returns where the image can be downloaded from, in the order to try them
+func (s ImageSpec) URLs() []string {
+	return append([]string{s.URL}, s.Mirrors...)
+}
+
+// Validate checks an image spec is complete
+func (s ImageSpec) Validate() error {
+	if s.Kind != ImageKindKernel && s.Kind != ImageKindRootfs {
+		return fmt.Errorf("image '%s': kind must be %s or %s, not '%s'", s.Name, ImageKindKernel, ImageKindRootfs, s.Kind)
+	}
+	if s.Name == "" || s.Name != filepath.Base(s.Name) || strings.HasPrefix(s.Name, ".") {
+		return fmt.Errorf("invalid image name '%s'", s.Name)
+	}
+	if s.URL == "" {
+		return fmt.Errorf("image '%s': url is required", s.Name)
+	}
+	for _, mirror := range s.Mirrors {
+		if mirror == "" {
+			return fmt.Errorf("image '%s': mirrors may not be empty", s.Name)
+		}
+		if strings.HasSuffix(urlPath(mirror), ".gz") != strings.HasSuffix(urlPath(s.URL), ".gz") {
+			return fmt.Errorf("image '%s': mirror %s must be compressed like %s", s.Name, mirror, s.URL)
+		}
+	}
+	if s.SHA256 != "" {
+		if sum, err := hex.DecodeString(s.SHA256); err != nil || len(sum) != 32 {
+			return fmt.Errorf("image '%s': sha256 must be 64 hex digits", s.Name)
+		}
+	}
+	return nil
+}
+
+// specPath returns where an image spec is stored
+func (m *Manager) specPath(spec ImageSpec) string {
+	if spec.Kind == ImageKindKernel {
+		return filepath.Join(m.KernelDir, spec.Name)
+	}
+	return m.GetImagePath(spec.Name)
+}
+
+// Prefetch downloads every image in specs that is not already present, several at once, so VMs can
+// later be created without waiting on the network. Each image is fetched with FetchImage, so mirrors,
+// resuming, checksums and the manager's RateLimit, shared by all the downloads, apply. One line is
+// reported per image and a summary at the end; the error joins the failures of every image that
+// could not be fetched
+func (m *Manager) Prefetch(specs []ImageSpec) error {
+	seen := make(map[string]bool)
+	var missing []ImageSpec
+	for _, spec := range specs {
+		if err := spec.Validate(); err != nil {
+			return err
+		}
+		path := m.specPath(spec)
+		if seen[path] {
+			return fmt.Errorf("%s '%s' is listed more than once", spec.Kind, spec.Name)
+		}
+		seen[path] = true
+		if _, err := os.Stat(path); err == nil {
+			continue
+		}
+		missing = append(missing, spec)
+	}
+	present := len(specs) - len(missing)
+
+	// Concurrent downloads can't share the nested progress steps, so each reports only its outcome
+	worker := *m
+	worker.Progress = progress.Discard
+
+	var (
+		mu   sync.Mutex
+		errs []error
+		wg   sync.WaitGroup
+	)
+	slots := make(chan struct{}, prefetchConcurrency)
+	for _, spec := range missing {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			_, err := worker.FetchImage(spec)
+
+			mu.Lock()
+			defer mu.Unlock()
+			name := fmt.Sprintf("Fetching %s %s", spec.Kind, spec.Name)
+			m.progress().Start(name, 0)
+			m.progress().Done(name, err)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s '%s': %w", spec.Kind, spec.Name, err))
+			}
+		}()
+	}
+	wg.Wait()
+
+	summary := fmt.Sprintf("Prefetched %d images, %d already present, %d failed", len(missing)-len(errs), present, len(errs))
+	m.progress().Start(summary, 0)
+	m.progress().Done(summary, nil)
+	return errors.Join(errs...)
+}
@@ -0,0 +1,68 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// SeedISOLabel is the volume label cloud-init's NoCloud datasource looks for
+const SeedISOLabel = "cidata"
+
+// SeedMetaData returns NoCloud meta-data giving the instance ID and hostname
+// cloud-init runs its per-instance modules again when the instance ID changes
+func SeedMetaData(instanceID, hostname string) string {
+	return fmt.Sprintf("instance-id: %s\nlocal-hostname: %s\n", instanceID, hostname)
+}
+
+// seedISOCommand returns the command that builds an ISO9660 image of dir at outPath,
+// using genisoimage if installed and xorriso otherwise
+func seedISOCommand(outPath, dir string) (*exec.Cmd, error) {
+	args := []string{"-output", outPath, "-volid", SeedISOLabel, "-joliet", "-rock", dir}
+	if path, err := exec.LookPath("genisoimage"); err == nil {
+		return exec.Command(path, args...), nil
+	}
+	if path, err := exec.LookPath("xorriso"); err == nil {
+		return exec.Command(path, append([]string{"-as", "mkisofs"}, args...)...), nil
+	}
+	return nil, fmt.Errorf("building a cloud-init seed ISO needs genisoimage or xorriso")
+}
+
+// CreateSeedISO builds a cloud-init NoCloud seed: an ISO9660 image labelled cidata holding
+// user-data, meta-data and, if not empty, network-config and vendor-data. The image is
+// written through a temporary file, so outPath is replaced only by a complete seed
+func CreateSeedISO(userData, metaData, networkConfig, vendorData string, outPath string) error {
+	dir, err := os.MkdirTemp("", "vmm-seed-*")
+	if err != nil {
+		return fmt.Errorf("failed to create seed directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"user-data": userData,
+		"meta-data": metaData,
+	}
+	if networkConfig != "" {
+		files["network-config"] = networkConfig
+	}
+	if vendorData != "" {
+		files["vendor-data"] = vendorData
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+	}
+
+	tmpPath := outPath + ".tmp"
+	cmd, err := seedISOCommand(tmpPath, dir)
+	if err != nil {
+		return err
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to build seed ISO: %w: %s", err, string(output))
+	}
+	return os.Rename(tmpPath, outPath)
+}
@@ -0,0 +1,46 @@
+package image
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// TrimImage returns the blocks freed inside an ext4 image to the host
+// The image is loop-mounted and fstrim'd; the loop driver punches holes in the image file
+// for the discarded ranges, so the sparse file shrinks. The image must not be in use by a VM.
+func TrimImage(path string) error {
+	if _, err := exec.LookPath("fstrim"); err != nil {
+		return fmt.Errorf("fstrim is not installed")
+	}
+
+	mountPoint, err := os.MkdirTemp("", "vmm-trim-*")
+	if err != nil {
+		return fmt.Errorf("failed to create mount point: %w", err)
+	}
+	defer os.RemoveAll(mountPoint)
+
+	if output, err := exec.Command("mount", "-o", "loop", path, mountPoint).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to mount %s: %w: %s", path, err, string(output))
+	}
+	defer exec.Command("umount", mountPoint).Run()
+
+	if output, err := exec.Command("fstrim", mountPoint).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to trim %s: %w: %s", path, err, string(output))
+	}
+	return nil
+}
+
+// AllocatedBytes returns the host disk space a (possibly sparse) file occupies
+func AllocatedBytes(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return info.Size(), nil
+	}
+	return stat.Blocks * 512, nil
+}
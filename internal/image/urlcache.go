@@ -0,0 +1,92 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// urlCacheDirName is the directory beside the rootfs directory that holds images fetched by URL
+const urlCacheDirName = "url-cache"
+
+// URLCacheDir returns where images fetched by URL are kept
+func (m *Manager) URLCacheDir() string {
+	return filepath.Join(filepath.Dir(m.RootfsDir), urlCacheDirName)
+}
+
+// urlCachePath names the cached copy of url after a hash of the URL, keeping the file's name for readability
+func (m *Manager) urlCachePath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	name := strings.TrimSuffix(path.Base(urlPath(url)), ".gz")
+	return filepath.Join(m.URLCacheDir(), hex.EncodeToString(sum[:8])+"-"+name)
+}
+
+// urlPath returns a URL without its query string
+func urlPath(url string) string {
+	p, _, _ := strings.Cut(url, "?")
+	return p
+}
+
+// FetchURL returns a local copy of the kernel or rootfs at url, downloading it into the URL cache
+// unless it is already there. A URL ending in .gz is decompressed. With sha256sum the download
+// (before any gunzip) must match it; the digest a copy was verified with is kept beside it, and a
+// cached copy not verified with the same digest is fetched again
+func (m *Manager) FetchURL(url, sha256sum string) (string, error) {
+	cachePath := m.urlCachePath(url)
+	sumPath := cachePath + ".sha256"
+	if _, err := os.Stat(cachePath); err == nil {
+		if sha256sum == "" {
+			return cachePath, nil
+		}
+		if recorded, err := os.ReadFile(sumPath); err == nil && strings.EqualFold(strings.TrimSpace(string(recorded)), sha256sum) {
+			return cachePath, nil
+		}
+	}
+
+	if err := os.MkdirAll(m.URLCacheDir(), 0755); err != nil {
+		return "", fmt.Errorf("failed to create URL cache directory: %w", err)
+	}
+	// A copy being replaced must not keep a digest it no longer matches
+	os.Remove(sumPath)
+	if err := m.fetch([]string{url}, cachePath, sha256sum); err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %w", url, err)
+	}
+	if sha256sum != "" {
+		if err := os.WriteFile(sumPath, []byte(strings.ToLower(sha256sum)+"\n"), 0644); err != nil {
+			return "", fmt.Errorf("failed to record checksum: %w", err)
+		}
+	}
+	return cachePath, nil
+}
+
+// CreateRootfsCopy creates a VM rootfs at dstPath from the image at srcPath, grown to diskSizeMB,
+// unless dstPath already exists
+func (m *Manager) CreateRootfsCopy(srcPath, dstPath string, diskSizeMB int) error {
+	if _, err := os.Stat(dstPath); err == nil {
+		return nil
+	}
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrImageNotFound, srcPath, err)
+	}
+	need, err := AllocatedBytes(srcPath)
+	if err != nil {
+		need = srcInfo.Size()
+	}
+	if err := m.checkQuota(need); err != nil {
+		return fmt.Errorf("cannot create rootfs %s: %w", dstPath, err)
+	}
+
+	name := fmt.Sprintf("Creating rootfs %s", dstPath)
+	m.progress().Start(name, srcInfo.Size())
+	err = m.writeVMRootfs(srcPath, dstPath, diskSizeMB)
+	m.progress().Done(name, err)
+	if err != nil {
+		os.Remove(dstPath)
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+package mount
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// ErrImageAttached is returned when a read-write mount image is open in a running VM and can't be synced
+// It wraps ErrImageBusy
+var ErrImageAttached = fmt.Errorf("%w: attached to a running VM", ErrImageBusy)
+
+// imageHolder returns the PID and command name of a process that has imagePath open, or 0 if none does
+// A running VM's Firecracker process holds its drive files open, so this finds VMs using the image
+// whatever state their records are in. Processes that can't be inspected are skipped
+func imageHolder(imagePath string) (int, string) {
+	target, err := filepath.EvalSymlinks(imagePath)
+	if err != nil {
+		return 0, ""
+	}
+	target, err = filepath.Abs(target)
+	if err != nil {
+		return 0, ""
+	}
+
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, ""
+	}
+	self := os.Getpid()
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		fdDir := filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			// An image replaced by a sync reads as "<path> (deleted)" and no longer matches
+			if link, err := os.Readlink(filepath.Join(fdDir, fd.Name())); err == nil && link == target {
+				comm, _ := os.ReadFile(filepath.Join("/proc", proc.Name(), "comm"))
+				return pid, strings.TrimSpace(string(comm))
+			}
+		}
+	}
+	return 0, ""
+}
+
+// checkNotAttached refuses to modify a read-write mount's image while a process, normally the VM's
+// Firecracker, has it open: the host and guest writing the same ext4 at once would corrupt it.
+// A read-only mount is safe to sync, since the guest never writes and keeps the image it opened
+func checkNotAttached(mount *vm.Mount) error {
+	if mount.ReadOnly || mount.ImagePath == "" {
+		return nil
+	}
+	if pid, comm := imageHolder(mount.ImagePath); pid != 0 {
+		return fmt.Errorf("%w: mount '%s' is read-write and %s is open in %s (PID %d); stop the VM before syncing it",
+			ErrImageAttached, mount.GuestTag, mount.ImagePath, comm, pid)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package mount
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+func TestCheckNotAttached(t *testing.T) {
+	imagePath := filepath.Join(t.TempDir(), "data.ext4")
+	if err := os.WriteFile(imagePath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	mount := &vm.Mount{GuestTag: "data", ImagePath: imagePath}
+	if err := checkNotAttached(mount); err != nil {
+		t.Fatalf("unopened image: got %v, want nil", err)
+	}
+
+	// Hold the image open in another process, as a VM's Firecracker does
+	image, err := os.Open(imagePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer image.Close()
+	holder := exec.Command("sleep", "30")
+	holder.ExtraFiles = []*os.File{image}
+	if err := holder.Start(); err != nil {
+		t.Skipf("cannot start holder process: %v", err)
+	}
+	defer func() {
+		holder.Process.Kill()
+		holder.Wait()
+	}()
+
+	if err := checkNotAttached(mount); !errors.Is(err, ErrImageAttached) {
+		t.Errorf("read-write mount: got %v, want ErrImageAttached", err)
+	}
+	mount.ReadOnly = true
+	if err := checkNotAttached(mount); err != nil {
+		t.Errorf("read-only mount: got %v, want nil", err)
+	}
+}
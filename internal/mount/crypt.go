@@ -0,0 +1,147 @@
+package mount
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// MountKeyEnv names the environment variable read for the passphrase of an encrypted mount without a key file
+const MountKeyEnv = "VMM_MOUNT_KEY"
+
+// luksHeaderMB is the space reserved at the start of an encrypted image for the LUKS2 header
+const luksHeaderMB = 16
+
+// CheckEncryption verifies that an encrypted mount can be formatted and opened on this host
+func CheckEncryption(mount *vm.Mount) error {
+	if !mount.Encrypted {
+		return nil
+	}
+	if mount.IsTmpfs() || mount.IsArchive() {
+		return fmt.Errorf("mount '%s': only host directory mounts can be encrypted", mount.GuestTag)
+	}
+	if _, err := exec.LookPath("cryptsetup"); err != nil {
+		return fmt.Errorf("mount '%s' is encrypted but cryptsetup is not installed", mount.GuestTag)
+	}
+	_, err := mountKey(mount)
+	return err
+}
+
+// MapperPath returns the device an encrypted mount image is exposed as while it is open
+func MapperPath(imagePath string) string {
+	return filepath.Join("/dev/mapper", mapperName(imagePath))
+}
+
+// mapperName returns the device-mapper name for an image, unique within the mounts directory
+func mapperName(imagePath string) string {
+	return "vmm-" + strings.TrimSuffix(filepath.Base(imagePath), filepath.Ext(imagePath))
+}
+
+// mountKey returns the passphrase for an encrypted mount, from its key file or MountKeyEnv
+// Key file contents are used byte for byte, as cryptsetup --key-file does
+func mountKey(mount *vm.Mount) ([]byte, error) {
+	if mount.KeyFile != "" {
+		key, err := os.ReadFile(mount.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file for mount '%s': %w", mount.GuestTag, err)
+		}
+		if len(key) == 0 {
+			return nil, fmt.Errorf("key file %s for mount '%s' is empty", mount.KeyFile, mount.GuestTag)
+		}
+		return key, nil
+	}
+	if key := os.Getenv(MountKeyEnv); key != "" {
+		return []byte(key), nil
+	}
+	return nil, fmt.Errorf("mount '%s' is encrypted: set %s or give it a key file", mount.GuestTag, MountKeyEnv)
+}
+
+// formatEncrypted writes a LUKS2 header to an image file
+func formatEncrypted(mount *vm.Mount, imagePath string) error {
+	key, err := mountKey(mount)
+	if err != nil {
+		return err
+	}
+	return runCryptsetup(key, "luksFormat", "--batch-mode", "--type", "luks2", "--key-file=-", imagePath)
+}
+
+// imageDevice returns the block device holding a mount image's filesystem, opening it first if it is encrypted
+// The returned function closes what was opened and is safe to call more than once
+func imageDevice(mount *vm.Mount, imagePath string) (string, func(), error) {
+	if !mount.Encrypted {
+		return imagePath, func() {}, nil
+	}
+	device, err := openEncrypted(mount, imagePath)
+	if err != nil {
+		return "", func() {}, err
+	}
+	return device, func() { closeEncrypted(imagePath) }, nil
+}
+
+// openEncrypted maps an encrypted image to a block device, reusing a mapping that is already open
+func openEncrypted(mount *vm.Mount, imagePath string) (string, error) {
+	device := MapperPath(imagePath)
+	if _, err := os.Stat(device); err == nil {
+		return device, nil
+	}
+	key, err := mountKey(mount)
+	if err != nil {
+		return "", err
+	}
+	if err := runCryptsetup(key, "open", "--type", "luks2", "--key-file=-", imagePath, mapperName(imagePath)); err != nil {
+		return "", fmt.Errorf("failed to open encrypted image for '%s': %w", mount.GuestTag, err)
+	}
+	return device, nil
+}
+
+// closeEncrypted removes an image's device mapping; it is a no-op if the image isn't open
+func closeEncrypted(imagePath string) error {
+	if _, err := os.Stat(MapperPath(imagePath)); os.IsNotExist(err) {
+		return nil
+	}
+	if err := runCryptsetup(nil, "close", mapperName(imagePath)); err != nil {
+		return fmt.Errorf("failed to close encrypted image %s: %w", imagePath, err)
+	}
+	return nil
+}
+
+// OpenMountDevice returns the path Firecracker should attach for a mount
+// An encrypted image is opened on the host and its mapped device returned; a plain image is attached as is
+func (m *Manager) OpenMountDevice(mount *vm.Mount) (string, error) {
+	if !mount.Encrypted {
+		return mount.ImagePath, nil
+	}
+	return openEncrypted(mount, mount.ImagePath)
+}
+
+// CloseMountDevices closes the mapped devices of a VM's encrypted mounts once it has stopped
+func (m *Manager) CloseMountDevices(vmName string, mounts []vm.Mount) error {
+	var errs []error
+	for _, mount := range mounts {
+		if !mount.Encrypted {
+			continue
+		}
+		if err := closeEncrypted(m.GetMountImagePath(vmName, mount.GuestTag)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runCryptsetup runs cryptsetup, passing key on stdin when it is set
+func runCryptsetup(key []byte, args ...string) error {
+	cmd := exec.Command("cryptsetup", args...)
+	if key != nil {
+		cmd.Stdin = bytes.NewReader(key)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("cryptsetup %s failed: %w: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
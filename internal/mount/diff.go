@@ -0,0 +1,171 @@
+package mount
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Kinds of difference DiffImages reports
+const (
+	DiffAdded   = "added"   // Only in the second image
+	DiffRemoved = "removed" // Only in the first image
+	DiffChanged = "changed" // In both, but different
+)
+
+// FileDiff is a path that differs between two images
+type FileDiff struct {
+	Path   string // Relative to the image root
+	Change string // DiffAdded, DiffRemoved or DiffChanged
+	Reason string // For DiffChanged: what differs (type, size, mtime, content or link)
+}
+
+// imageEntry is what DiffImages compares of one path in an image
+type imageEntry struct {
+	info os.FileInfo
+	link string // Symlink target
+}
+
+// DiffImages loop-mounts two ext4 images read-only and reports the files added, removed and changed
+// from a to b, sorted by path: for example a base image against a VM's copy, or two mount images.
+// Files are compared by type, size and mtime, or with DiffContents by type, size and SHA-256 of
+// their contents so that a touched but identical file isn't reported. Directory mtimes and the
+// top-level lost+found are ignored. Neither image may be open in a running VM
+func (m *Manager) DiffImages(a, b string) ([]FileDiff, error) {
+	for _, imagePath := range []string{a, b} {
+		if pid, comm := imageHolder(imagePath); pid != 0 {
+			return nil, fmt.Errorf("%w: %s is open in %s (PID %d); stop the VM using it first", ErrImageBusy, imagePath, comm, pid)
+		}
+	}
+
+	var diffs []FileDiff
+	err := m.withLoopMount(a, true, func(rootA string) error {
+		return m.withLoopMount(b, true, func(rootB string) error {
+			var err error
+			diffs, err = m.diffTrees(rootA, rootB)
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return diffs, nil
+}
+
+// diffTrees compares two directory trees as DiffImages does
+func (m *Manager) diffTrees(rootA, rootB string) ([]FileDiff, error) {
+	var diffs []FileDiff
+	entriesA, err := listEntries(rootA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", rootA, err)
+	}
+	entriesB, err := listEntries(rootB)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", rootB, err)
+	}
+	for rel, entryA := range entriesA {
+		entryB, ok := entriesB[rel]
+		if !ok {
+			diffs = append(diffs, FileDiff{Path: rel, Change: DiffRemoved})
+			continue
+		}
+		reason, err := m.entryChange(entryA, entryB, filepath.Join(rootA, rel), filepath.Join(rootB, rel))
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			diffs = append(diffs, FileDiff{Path: rel, Change: DiffChanged, Reason: reason})
+		}
+	}
+	for rel := range entriesB {
+		if _, ok := entriesA[rel]; !ok {
+			diffs = append(diffs, FileDiff{Path: rel, Change: DiffAdded})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Path < diffs[j].Path })
+	return diffs, nil
+}
+
+// entryChange returns what differs between one path in two images, or "" if nothing does
+func (m *Manager) entryChange(a, b imageEntry, pathA, pathB string) (string, error) {
+	switch {
+	case a.info.Mode().Type() != b.info.Mode().Type():
+		return "type", nil
+	case a.info.IsDir():
+		return "", nil
+	case a.link != b.link:
+		return "link", nil
+	case a.info.Size() != b.info.Size():
+		return "size", nil
+	case !a.info.Mode().IsRegular():
+		return "", nil
+	case !m.DiffContents:
+		if !a.info.ModTime().Equal(b.info.ModTime()) {
+			return "mtime", nil
+		}
+		return "", nil
+	}
+	sumA, err := fileSum(pathA)
+	if err != nil {
+		return "", err
+	}
+	sumB, err := fileSum(pathB)
+	if err != nil {
+		return "", err
+	}
+	if sumA != sumB {
+		return "content", nil
+	}
+	return "", nil
+}
+
+// listEntries returns every entry under root, keyed by relative path, skipping the top-level lost+found
+func listEntries(root string) (map[string]imageEntry, error) {
+	entries := make(map[string]imageEntry)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if info.IsDir() && rel == lostAndFound {
+			return filepath.SkipDir
+		}
+		entry := imageEntry{info: info}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if entry.link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		entries[rel] = entry
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// fileSum returns the SHA-256 of a file's contents
+func fileSum(path string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return sum, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	copy(sum[:], hash.Sum(nil))
+	return sum, nil
+}
@@ -0,0 +1,85 @@
+package mount
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDiffTrees(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	write := func(root, name, content string, mtime time.Time) {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old, later := time.Unix(1000, 0), time.Unix(2000, 0)
+	write(a, "same", "x", old)
+	write(b, "same", "x", old)
+	write(a, "touched", "x", old)
+	write(b, "touched", "x", later)
+	write(a, "edited", "x", old)
+	write(b, "edited", "y", old)
+	write(a, "grown", "x", old)
+	write(b, "grown", "xx", old)
+	write(a, "gone", "x", old)
+	write(b, "etc/new", "x", old)
+	os.Mkdir(filepath.Join(a, lostAndFound), 0700)
+	write(b, lostAndFound+"/#12", "x", old)
+
+	m := NewManager(t.TempDir())
+	got := func() map[string]string {
+		diffs, err := m.diffTrees(a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := make(map[string]string)
+		for _, d := range diffs {
+			result[d.Path] = d.Change + " " + d.Reason
+		}
+		return result
+	}
+
+	want := map[string]string{
+		"touched": "changed mtime",
+		"grown":   "changed size",
+		"gone":    "removed ",
+		"etc":     "added ",
+		"etc/new": "added ",
+	}
+	if diffs := got(); !equalDiffs(diffs, want) {
+		t.Errorf("metadata diff = %v, want %v", diffs, want)
+	}
+
+	m.DiffContents = true
+	want = map[string]string{
+		"edited":  "changed content",
+		"grown":   "changed size",
+		"gone":    "removed ",
+		"etc":     "added ",
+		"etc/new": "added ",
+	}
+	if diffs := got(); !equalDiffs(diffs, want) {
+		t.Errorf("content diff = %v, want %v", diffs, want)
+	}
+}
+
+func equalDiffs(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,19 @@
+package mount
+
+import "errors"
+
+// Errors callers can test for with errors.Is; they are returned wrapped with the details
+var (
+	// ErrHostPathMissing is returned when a mount's host directory does not exist
+	ErrHostPathMissing = errors.New("host path does not exist")
+	// ErrMountImageNotFound is returned when an operation needs a mount image that hasn't been created
+	ErrMountImageNotFound = errors.New("mount image not found")
+	// ErrMkfsFailed is returned when mkfs.ext4 or mkfs.vfat fails to format a mount image
+	ErrMkfsFailed = errors.New("failed to create filesystem")
+	// ErrImageBusy is returned when a mount image is in use and can't be modified now
+	ErrImageBusy = errors.New("mount image is busy")
+	// ErrFSCommandTimeout is returned when mkfs.ext4, e2fsck or resize2fs runs past the manager's timeout
+	ErrFSCommandTimeout = errors.New("filesystem command timed out")
+	// ErrSyncTimeout is returned when creating or syncing an image runs past the manager's MaxSyncDuration
+	ErrSyncTimeout = errors.New("mount image sync timed out")
+)
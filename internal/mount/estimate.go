@@ -0,0 +1,54 @@
+package mount
+
+import (
+	"fmt"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// EstimateMountSize returns the size in MB of the image CreateMountImage would build for a host directory
+func (m *Manager) EstimateMountSize(hostPath string) (int, error) {
+	return m.EstimateMountImageSize(&vm.Mount{HostPath: hostPath})
+}
+
+// EstimateMountImageSize returns the size in MB of the image CreateMountImage would build for a mount,
+// counting its overlay paths, inode ratio and encryption, without creating anything
+func (m *Manager) EstimateMountImageSize(mount *vm.Mount) (int, error) {
+	if mount.IsTmpfs() {
+		return 0, errTmpfsHasNoImage(mount)
+	}
+	if mount.IsArchive() {
+		info, compression, err := inspectArchive(mount.ArchivePath)
+		if err != nil {
+			return 0, err
+		}
+		return archiveImageSizeMB(mount, info.Size(), compression)
+	}
+	layers, err := m.sourceLayers(mount)
+	if err != nil {
+		return 0, err
+	}
+	return mountImageSizeMB(mount, totalLayerBytes(layers)), nil
+}
+
+// mountImageSizeMB returns the size of a mount's image holding contentBytes of files,
+// with room for the LUKS header of an encrypted mount
+func mountImageSizeMB(mount *vm.Mount, contentBytes int64) int {
+	sizeMB := imageSizeMB(mount, contentBytes)
+	if mount.Encrypted {
+		sizeMB += luksHeaderMB
+	}
+	return sizeMB
+}
+
+// archiveImageSizeMB returns the size of the image an archive is extracted into: the mount's
+// ArchiveSizeMB if set, otherwise sized for the archive, which only works uncompressed
+func archiveImageSizeMB(mount *vm.Mount, archiveBytes int64, compression tarCompression) (int, error) {
+	if mount.ArchiveSizeMB > 0 {
+		return mount.ArchiveSizeMB, nil
+	}
+	if compression != compressionNone {
+		return 0, fmt.Errorf("an image size is required for %s compressed archives", compression.name)
+	}
+	return imageSizeMB(mount, archiveBytes), nil
+}
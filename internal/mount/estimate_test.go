@@ -0,0 +1,40 @@
+package mount
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+func TestEstimateMountSize(t *testing.T) {
+	m := NewManager(t.TempDir())
+	src := t.TempDir()
+
+	if got, err := m.EstimateMountSize(src); err != nil || got != 16 {
+		t.Fatalf("empty directory: got %d, %v; want the 16 MB floor", got, err)
+	}
+
+	// A sparse file counts at its apparent size, as when an image is created
+	f, err := os.Create(filepath.Join(src, "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := os.Truncate(filepath.Join(src, "data"), 100<<20); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := m.EstimateMountSize(src); err != nil || got != 120 {
+		t.Errorf("100 MB of files: got %d, %v; want 120", got, err)
+	}
+
+	encrypted := &vm.Mount{HostPath: src, Encrypted: true}
+	if got, err := m.EstimateMountImageSize(encrypted); err != nil || got != 120+luksHeaderMB {
+		t.Errorf("encrypted: got %d, %v; want %d", got, err, 120+luksHeaderMB)
+	}
+
+	if _, err := m.EstimateMountSize(filepath.Join(src, "missing")); err == nil {
+		t.Error("missing host path: got nil error")
+	}
+}
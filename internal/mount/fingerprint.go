@@ -0,0 +1,105 @@
+package mount
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// fingerprintSuffix is appended to an image path to name the file holding its last sync fingerprint
+const fingerprintSuffix = ".fingerprint"
+
+// syncFingerprint is what a sync recorded about its sources and the image it left behind
+type syncFingerprint struct {
+	Sources      string // Hash of the source layers and sync mode
+	ImageModTime int64  // Image mtime in nanoseconds once the sync finished
+}
+
+// sourceFingerprint hashes the path, type, size and mtime of every file in the source layers
+// Any file added, removed, replaced or modified on the host changes the result; file contents
+// are not read, so computing it costs a directory walk rather than a copy
+func sourceFingerprint(layers []sourceLayer, mode SyncMode) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "mode %s\n", mode)
+	for i, layer := range layers {
+		fmt.Fprintf(h, "layer %d %s\n", i, layer.Path)
+		if err := hashTree(h, layer.Path); err != nil {
+			return "", fmt.Errorf("failed to fingerprint '%s': %w", layer.Path, err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashTree writes the metadata of every entry under root to h in walk order
+func hashTree(h hash.Hash, root string) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%q %o %d %d\n", rel, info.Mode(), info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+}
+
+// imageUnchangedSince reports whether an image is as a sync with the given source fingerprint left it
+// The image's mtime catches writes made by the guest since then
+func imageUnchangedSince(imagePath, sources string) bool {
+	recorded, err := readFingerprint(imagePath)
+	if err != nil || recorded.Sources != sources {
+		return false
+	}
+	info, err := os.Stat(imagePath)
+	if err != nil {
+		return false
+	}
+	return info.ModTime().UnixNano() == recorded.ImageModTime
+}
+
+// readFingerprint loads the fingerprint stored next to an image
+func readFingerprint(imagePath string) (*syncFingerprint, error) {
+	data, err := os.ReadFile(imagePath + fingerprintSuffix)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("malformed fingerprint for %s", imagePath)
+	}
+	modTime, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed fingerprint for %s: %w", imagePath, err)
+	}
+	return &syncFingerprint{Sources: fields[0], ImageModTime: modTime}, nil
+}
+
+// writeFingerprint records the source fingerprint and current mtime of a freshly synced image
+func writeFingerprint(imagePath, sources string) error {
+	info, err := os.Stat(imagePath)
+	if err != nil {
+		return err
+	}
+	data := fmt.Sprintf("%s %d\n", sources, info.ModTime().UnixNano())
+	return os.WriteFile(imagePath+fingerprintSuffix, []byte(data), 0644)
+}
+
+// removeFingerprint deletes an image's fingerprint so its next sync does the full copy
+func removeFingerprint(imagePath string) {
+	os.Remove(imagePath + fingerprintSuffix)
+}
+
+// recordSync stores the fingerprint of a completed sync
+// Failing to only costs the next sync its fast path, so it is reported rather than returned
+func recordSync(imagePath, sources string) {
+	if err := writeFingerprint(imagePath, sources); err != nil {
+		fmt.Printf("  Warning: failed to record sync fingerprint: %v\n", err)
+	}
+}
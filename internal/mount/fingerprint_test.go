@@ -0,0 +1,78 @@
+package mount
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSourceFingerprintTracksChanges(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, "a", "sub/b")
+	layers := []sourceLayer{{Path: dir}}
+
+	fingerprint := func() string {
+		t.Helper()
+		sum, err := sourceFingerprint(layers, SyncModeMirror)
+		if err != nil {
+			t.Fatalf("sourceFingerprint: %v", err)
+		}
+		return sum
+	}
+
+	original := fingerprint()
+	if again := fingerprint(); again != original {
+		t.Fatal("fingerprint changed without any change to the source")
+	}
+	if merged, _ := sourceFingerprint(layers, SyncModeMerge); merged == original {
+		t.Error("fingerprint does not depend on the sync mode")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "sub/b"), []byte("changed contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	modified := fingerprint()
+	if modified == original {
+		t.Error("fingerprint unchanged after a file was modified")
+	}
+
+	writeFiles(t, dir, "c")
+	if fingerprint() == modified {
+		t.Error("fingerprint unchanged after a file was added")
+	}
+}
+
+func TestImageUnchangedSince(t *testing.T) {
+	imagePath := filepath.Join(t.TempDir(), "vm-data.ext4")
+	if err := os.WriteFile(imagePath, []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if imageUnchangedSince(imagePath, "sources") {
+		t.Fatal("an image with no fingerprint counted as unchanged")
+	}
+	if err := writeFingerprint(imagePath, "sources"); err != nil {
+		t.Fatalf("writeFingerprint: %v", err)
+	}
+	if !imageUnchangedSince(imagePath, "sources") {
+		t.Fatal("image not unchanged right after its fingerprint was written")
+	}
+	if imageUnchangedSince(imagePath, "other") {
+		t.Error("image counted as unchanged for different sources")
+	}
+
+	// A guest write updates the image's mtime
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(imagePath, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if imageUnchangedSince(imagePath, "sources") {
+		t.Error("image counted as unchanged after it was modified")
+	}
+
+	removeFingerprint(imagePath)
+	if _, err := os.Stat(imagePath + fingerprintSuffix); !os.IsNotExist(err) {
+		t.Errorf("fingerprint file still present after removal: %v", err)
+	}
+}
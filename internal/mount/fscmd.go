@@ -0,0 +1,40 @@
+package mount
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// DefaultFSCommandTimeout is how long mkfs.ext4, e2fsck and resize2fs may run on one image
+// before they are killed
+const DefaultFSCommandTimeout = 10 * time.Minute
+
+// fsCommandTimeout returns the manager's filesystem command timeout
+func (m *Manager) fsCommandTimeout() time.Duration {
+	if m.FSCommandTimeout <= 0 {
+		return DefaultFSCommandTimeout
+	}
+	return m.FSCommandTimeout
+}
+
+// runFSCommand runs a filesystem tool and returns its combined output. A tool still running after
+// the manager's FSCommandTimeout, such as mkfs.ext4 stuck on an unresponsive disk, is killed and
+// ErrFSCommandTimeout returned
+func (m *Manager) runFSCommand(name string, args ...string) ([]byte, error) {
+	timeout := m.fsCommandTimeout()
+	ctx, cancel := context.WithTimeout(m.context(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	// Don't wait on children holding the output pipe open once the tool itself is killed
+	cmd.WaitDelay = time.Second
+	output, err := cmd.CombinedOutput()
+	// A kill by the deadline of the whole sync is reported by timed instead
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) && m.context().Err() == nil {
+		return output, fmt.Errorf("%w: %s killed after %s", ErrFSCommandTimeout, name, timeout)
+	}
+	return output, err
+}
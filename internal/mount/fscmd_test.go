@@ -0,0 +1,25 @@
+package mount
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunFSCommandKillsHungCommand(t *testing.T) {
+	m := NewManager(t.TempDir())
+	m.FSCommandTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	_, err := m.runFSCommand("sleep", "10")
+	if !errors.Is(err, ErrFSCommandTimeout) {
+		t.Fatalf("got %v, want ErrFSCommandTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("command ran for %s after the timeout", elapsed)
+	}
+
+	if _, err := m.runFSCommand("true"); err != nil {
+		t.Fatalf("runFSCommand(true): %v", err)
+	}
+}
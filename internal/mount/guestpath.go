@@ -0,0 +1,60 @@
+package mount
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// systemGuestPaths are guest directories a mount may not replace, as the guest needs their contents
+var systemGuestPaths = []string{"/", "/bin", "/boot", "/etc", "/lib", "/sbin", "/usr", "/var"}
+
+// virtualGuestPaths hold the guest's kernel filesystems; nothing may be mounted in them
+var virtualGuestPaths = []string{"/proc", "/sys", "/dev", "/run"}
+
+// ValidateGuestPath checks a mount's guest path: an absolute, clean path without whitespace
+// (it goes into the guest's fstab) that neither replaces a system directory nor lies in /proc,
+// /sys, /dev or /run
+func ValidateGuestPath(guestPath string) error {
+	if guestPath == "" {
+		return nil
+	}
+	if !path.IsAbs(guestPath) {
+		return fmt.Errorf("invalid guest path '%s': must be an absolute path", guestPath)
+	}
+	if path.Clean(guestPath) != guestPath {
+		return fmt.Errorf("invalid guest path '%s': use %s", guestPath, path.Clean(guestPath))
+	}
+	if strings.ContainsAny(guestPath, " \t\n\\#") {
+		return fmt.Errorf("invalid guest path '%s': cannot contain whitespace, '\\' or '#'", guestPath)
+	}
+	for _, system := range systemGuestPaths {
+		if guestPath == system {
+			return fmt.Errorf("invalid guest path '%s': the guest system needs it", guestPath)
+		}
+	}
+	for _, virtual := range virtualGuestPaths {
+		if guestPath == virtual || strings.HasPrefix(guestPath, virtual+"/") {
+			return fmt.Errorf("invalid guest path '%s': %s is a kernel filesystem in the guest", guestPath, virtual)
+		}
+	}
+	return nil
+}
+
+// ValidateGuestPaths checks each mount's guest path and that no two mounts share one
+func ValidateGuestPaths(mounts []vm.Mount) error {
+	used := make(map[string]string)
+	for _, m := range mounts {
+		if err := ValidateGuestPath(m.GuestPath); err != nil {
+			return fmt.Errorf("mount '%s': %w", m.GuestTag, err)
+		}
+		mountPath := m.MountPath()
+		if other, dup := used[mountPath]; dup {
+			return fmt.Errorf("mounts '%s' and '%s' are both mounted at %s", other, m.GuestTag, mountPath)
+		}
+		used[mountPath] = m.GuestTag
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package mount
+
+import (
+	"testing"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+func TestValidateGuestPath(t *testing.T) {
+	valid := []string{"", "/srv/data", "/var/lib/app", "/home/user/code", "/mnt/other"}
+	for _, p := range valid {
+		if err := ValidateGuestPath(p); err != nil {
+			t.Errorf("ValidateGuestPath(%q) = %v, want nil", p, err)
+		}
+	}
+	invalid := []string{"data", "/srv/data/", "/srv/../data", "/srv/my data", "/", "/etc", "/usr", "/proc/x", "/dev", "/run/app"}
+	for _, p := range invalid {
+		if err := ValidateGuestPath(p); err == nil {
+			t.Errorf("ValidateGuestPath(%q) = nil, want an error", p)
+		}
+	}
+}
+
+func TestValidateGuestPathsRejectsSharedPath(t *testing.T) {
+	mounts := []vm.Mount{
+		{GuestTag: "data", GuestPath: "/mnt/code"},
+		{GuestTag: "code"},
+	}
+	if err := ValidateGuestPaths(mounts); err == nil {
+		t.Fatal("two mounts at /mnt/code accepted")
+	}
+	mounts[0].GuestPath = "/srv/data"
+	if err := ValidateGuestPaths(mounts); err != nil {
+		t.Fatalf("ValidateGuestPaths: %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package mount
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// DefaultLockTimeout is how long to wait for another process to release a mount image
+	DefaultLockTimeout = 30 * time.Second
+
+	lockPollInterval = 100 * time.Millisecond
+)
+
+// lockImage takes an exclusive flock on a lock file next to the image
+// It blocks until the lock is acquired or the timeout expires, and returns a function that releases the lock
+// The lock file may be removed by a holder (see removeLockFile); waiters then retry on the new file
+func lockImage(imagePath string, timeout time.Duration) (func(), error) {
+	lockPath := lockFilePath(imagePath)
+	deadline := time.Now().Add(timeout)
+	for {
+		lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open lock file: %w", err)
+		}
+
+		if err := flockUntil(lockFile, imagePath, deadline); err != nil {
+			lockFile.Close()
+			return nil, err
+		}
+
+		// A lock on a file that was unlinked while we waited excludes no one, so start over
+		if isCurrentLockFile(lockFile, lockPath) {
+			return func() {
+				syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+				lockFile.Close()
+			}, nil
+		}
+		lockFile.Close()
+	}
+}
+
+// MountLock is a mount image lock taken with AcquireMountLock and held until Release
+type MountLock struct {
+	imagePath string
+
+	mu         sync.Mutex
+	unlock     func() // nil once released
+	removeFile bool   // The image was deleted or renamed under the lock, so Release removes its lock file
+}
+
+// AcquireMountLock takes the lock the manager's operations take on a VM's mount image, waiting
+// up to timeout for a concurrent operation to finish. A caller can hold it across several steps,
+// such as a sync and an attach, without another sync or recreate coming in between; operations
+// run through WithMountLock use it instead of waiting for it. A timeout returns an error wrapping
+// ErrImageBusy
+func (m *Manager) AcquireMountLock(vmName, tag string, timeout time.Duration) (*MountLock, error) {
+	imagePath := m.GetMountImagePath(vmName, tag)
+	unlock, err := lockImage(imagePath, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &MountLock{imagePath: imagePath, unlock: unlock}, nil
+}
+
+// Release releases the lock; releasing it again does nothing
+func (l *MountLock) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.unlock == nil {
+		return
+	}
+	// Removed before unlocking, so the next locker can't lock the old file after we let go
+	if l.removeFile {
+		removeLockFile(l.imagePath)
+	}
+	l.unlock()
+	l.unlock = nil
+}
+
+// covers reports whether the lock is still held on the image
+func (l *MountLock) covers(imagePath string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.unlock != nil && l.imagePath == imagePath
+}
+
+// WithMountLock returns a copy of the manager whose operations on the locked image run under
+// lock instead of waiting for it. Other images, and the manager itself, are locked as usual
+func (m *Manager) WithMountLock(lock *MountLock) *Manager {
+	op := *m
+	op.held = lock
+	return &op
+}
+
+// holds reports whether the image is locked by the MountLock the manager was given
+func (m *Manager) holds(imagePath string) bool {
+	return m.held != nil && m.held.covers(imagePath)
+}
+
+// lockImage locks an image for one of the manager's operations, unless its MountLock covers it
+func (m *Manager) lockImage(imagePath string) (func(), error) {
+	if m.holds(imagePath) {
+		return func() {}, nil
+	}
+	return lockImage(imagePath, m.LockTimeout)
+}
+
+// lockImages is lockImage for several images
+func (m *Manager) lockImages(imagePaths ...string) (func(), error) {
+	var paths []string
+	for _, path := range imagePaths {
+		if !m.holds(path) {
+			paths = append(paths, path)
+		}
+	}
+	return lockImages(m.LockTimeout, paths...)
+}
+
+// removeLockFile deletes an image's lock file once the operation's lock on it is released. Under a
+// MountLock that is on Release: removed earlier, the next locker would lock a fresh file while the
+// MountLock's holder still believes it excludes everyone
+func (m *Manager) removeLockFile(imagePath string) {
+	if m.held != nil {
+		m.held.mu.Lock()
+		defer m.held.mu.Unlock()
+		if m.held.unlock != nil && m.held.imagePath == imagePath {
+			m.held.removeFile = true
+			return
+		}
+	}
+	removeLockFile(imagePath)
+}
+
+// lockImages locks several images in a fixed order so callers locking overlapping sets can't deadlock
+func lockImages(timeout time.Duration, imagePaths ...string) (func(), error) {
+	sorted := append([]string(nil), imagePaths...)
+	sort.Strings(sorted)
+
+	var unlocks []func()
+	unlockAll := func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+	for _, path := range sorted {
+		unlock, err := lockImage(path, timeout)
+		if err != nil {
+			unlockAll()
+			return nil, err
+		}
+		unlocks = append(unlocks, unlock)
+	}
+	return unlockAll, nil
+}
+
+// removeLockFile deletes an image's lock file; the caller must hold the lock
+// Processes waiting on the old file notice it is gone once they acquire it and lock a fresh one
+func removeLockFile(imagePath string) {
+	os.Remove(lockFilePath(imagePath))
+}
+
+// lockFilePath returns the lock file used for an image
+func lockFilePath(imagePath string) string {
+	return imagePath + ".lock"
+}
+
+// flockUntil polls for an exclusive flock until it is acquired or the deadline passes
+func flockUntil(lockFile *os.File, imagePath string, deadline time.Time) error {
+	for {
+		err := syscall.Flock(int(lockFile.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return nil
+		}
+		if err != syscall.EWOULDBLOCK {
+			return fmt.Errorf("failed to lock mount image: %w", err)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: another operation on %s is in progress", ErrImageBusy, imagePath)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// isCurrentLockFile reports whether an open lock file is still the one at lockPath
+func isCurrentLockFile(lockFile *os.File, lockPath string) bool {
+	held, err := lockFile.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(lockPath)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(held, current)
+}
@@ -0,0 +1,248 @@
+package mount
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+func TestLockImageExcludesConcurrentHolders(t *testing.T) {
+	imagePath := filepath.Join(t.TempDir(), "vm-data.ext4")
+
+	var holders, maxHolders int32
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := lockImage(imagePath, 5*time.Second)
+			if err != nil {
+				t.Errorf("lockImage: %v", err)
+				return
+			}
+			n := atomic.AddInt32(&holders, 1)
+			for {
+				max := atomic.LoadInt32(&maxHolders)
+				if n <= max || atomic.CompareAndSwapInt32(&maxHolders, max, n) {
+					break
+				}
+			}
+			// Hold the lock long enough for the other goroutine to contend for it
+			time.Sleep(3 * lockPollInterval)
+			atomic.AddInt32(&holders, -1)
+			unlock()
+		}()
+	}
+	wg.Wait()
+
+	if maxHolders != 1 {
+		t.Fatalf("lock had %d concurrent holders, want 1", maxHolders)
+	}
+}
+
+func TestLockImageTimesOutWhileHeld(t *testing.T) {
+	imagePath := filepath.Join(t.TempDir(), "vm-data.ext4")
+
+	unlock, err := lockImage(imagePath, time.Second)
+	if err != nil {
+		t.Fatalf("lockImage: %v", err)
+	}
+
+	_, err = lockImage(imagePath, 2*lockPollInterval)
+	if err == nil {
+		t.Fatal("second lockImage succeeded while the lock was held")
+	}
+	if !errors.Is(err, ErrImageBusy) {
+		t.Errorf("second lockImage: got %v, want ErrImageBusy", err)
+	}
+
+	unlock()
+	unlockAgain, err := lockImage(imagePath, time.Second)
+	if err != nil {
+		t.Fatalf("lockImage after release: %v", err)
+	}
+	unlockAgain()
+}
+
+func TestLockImageSurvivesLockFileRemoval(t *testing.T) {
+	imagePath := filepath.Join(t.TempDir(), "vm-data.ext4")
+
+	unlock, err := lockImage(imagePath, time.Second)
+	if err != nil {
+		t.Fatalf("lockImage: %v", err)
+	}
+
+	// A waiter blocks on the current lock file
+	acquired := make(chan func())
+	go func() {
+		waiterUnlock, err := lockImage(imagePath, 5*time.Second)
+		if err != nil {
+			t.Errorf("waiting lockImage: %v", err)
+			close(acquired)
+			return
+		}
+		acquired <- waiterUnlock
+	}()
+	time.Sleep(2 * lockPollInterval)
+
+	// The holder deletes the lock file, as DeleteMountImage does, then releases it
+	removeLockFile(imagePath)
+	unlock()
+
+	waiterUnlock, ok := <-acquired
+	if !ok {
+		t.FailNow()
+	}
+	defer waiterUnlock()
+
+	// The waiter must now hold the lock on the file that new callers use
+	if _, err := lockImage(imagePath, 2*lockPollInterval); err == nil {
+		t.Fatal("a new caller locked the image while the waiter held it")
+	}
+}
+
+func TestLockImagesLocksEveryPath(t *testing.T) {
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "vm-old.ext4")
+	newPath := filepath.Join(dir, "vm-new.ext4")
+
+	unlock, err := lockImages(time.Second, oldPath, newPath)
+	if err != nil {
+		t.Fatalf("lockImages: %v", err)
+	}
+	for _, path := range []string{oldPath, newPath} {
+		if _, err := lockImage(path, 2*lockPollInterval); err == nil {
+			t.Fatalf("%s could be locked while lockImages held it", path)
+		}
+	}
+	unlock()
+
+	for _, path := range []string{oldPath, newPath} {
+		release, err := lockImage(path, time.Second)
+		if err != nil {
+			t.Fatalf("lockImage(%s) after release: %v", path, err)
+		}
+		release()
+	}
+}
+
+func TestAcquireMountLockHeldAcrossOperations(t *testing.T) {
+	m := NewManager(t.TempDir())
+	m.LockTimeout = 2 * lockPollInterval
+	imagePath := m.GetMountImagePath("vm", "data")
+
+	lock, err := m.AcquireMountLock("vm", "data", time.Second)
+	if err != nil {
+		t.Fatalf("AcquireMountLock: %v", err)
+	}
+	if _, err := m.AcquireMountLock("vm", "data", 2*lockPollInterval); !errors.Is(err, ErrImageBusy) {
+		t.Errorf("second AcquireMountLock: got %v, want ErrImageBusy", err)
+	}
+	// Only operations given the lock run under it; the manager itself still waits
+	if _, err := m.lockImage(imagePath); !errors.Is(err, ErrImageBusy) {
+		t.Errorf("manager lockImage while held: got %v, want ErrImageBusy", err)
+	}
+	unlock, err := m.WithMountLock(lock).lockImage(imagePath)
+	if err != nil {
+		t.Fatalf("lockImage with the held lock: %v", err)
+	}
+	unlock()
+
+	lock.Release()
+	lock.Release()
+	unlock, err = m.lockImage(imagePath)
+	if err != nil {
+		t.Fatalf("lockImage after release: %v", err)
+	}
+	unlock()
+}
+
+func TestDeleteUnderMountLockKeepsExcluding(t *testing.T) {
+	m := NewManager(t.TempDir())
+	m.LockTimeout = 2 * lockPollInterval
+	imagePath := m.GetMountImagePath("vm", "data")
+	if err := os.WriteFile(imagePath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lock, err := m.AcquireMountLock("vm", "data", time.Second)
+	if err != nil {
+		t.Fatalf("AcquireMountLock: %v", err)
+	}
+	if err := m.WithMountLock(lock).DeleteMountImage("vm", "data"); err != nil {
+		t.Fatalf("DeleteMountImage: %v", err)
+	}
+	if _, err := os.Stat(imagePath); !os.IsNotExist(err) {
+		t.Errorf("image still exists after delete: %v", err)
+	}
+
+	// The deleted image's lock file must still exclude others until the lock is released
+	if _, err := lockImage(imagePath, 2*lockPollInterval); !errors.Is(err, ErrImageBusy) {
+		t.Fatalf("lockImage after delete under the held lock: got %v, want ErrImageBusy", err)
+	}
+
+	lock.Release()
+	if _, err := os.Stat(lockFilePath(imagePath)); !os.IsNotExist(err) {
+		t.Errorf("lock file left after release: %v", err)
+	}
+	unlock, err := lockImage(imagePath, time.Second)
+	if err != nil {
+		t.Fatalf("lockImage after release: %v", err)
+	}
+	unlock()
+}
+
+// blockingProgress holds up the operation reporting to it at its first step until released
+type blockingProgress struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (p *blockingProgress) Start(name string, total int64) {
+	p.once.Do(func() {
+		close(p.started)
+		<-p.release
+	})
+}
+
+func (p *blockingProgress) Update(n int64)              {}
+func (p *blockingProgress) Done(name string, err error) {}
+
+func TestConcurrentOperationsOnOneImageDontOverlap(t *testing.T) {
+	mountsDir := t.TempDir()
+	mount := vm.Mount{HostPath: t.TempDir(), GuestTag: "data"}
+
+	// A recreate stops at its first progress step, with the image locked
+	recreating := NewManager(mountsDir)
+	blocker := &blockingProgress{started: make(chan struct{}), release: make(chan struct{})}
+	recreating.Progress = blocker
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m := mount
+		recreating.RecreateMountImage(&m, "vm") // Fails without root once released; only the lock matters here
+	}()
+	select {
+	case <-blocker.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("recreate never reached its first step")
+	}
+
+	syncing := NewManager(mountsDir)
+	syncing.Progress = nil
+	syncing.LockTimeout = 2 * lockPollInterval
+	m := mount
+	err := syncing.SyncMountImage(&m, "vm", SyncModeMirror)
+	close(blocker.release)
+	<-done
+	if !errors.Is(err, ErrImageBusy) {
+		t.Fatalf("sync during a recreate: got %v, want ErrImageBusy", err)
+	}
+}
@@ -0,0 +1,214 @@
+package mount
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/raesene/baremetalvmm/internal/progress"
+)
+
+// ErrLoopDevicesExhausted is returned when an image can't be mounted because no loop device is free
+var ErrLoopDevicesExhausted = errors.New("no free loop devices")
+
+// tempMountPrefix is the prefix of the temporary directories images are mounted on
+const tempMountPrefix = "vmm-mount-"
+
+// umountAttempts and umountRetryDelay bound the retries of an unmount that fails because the mount is busy;
+// the delay doubles after each attempt
+const (
+	umountAttempts   = 5
+	umountRetryDelay = 100 * time.Millisecond
+)
+
+// tempDir returns the directory images are temporarily mounted under: TempDir, or os.TempDir() when unset
+func (m *Manager) tempDir() string {
+	if m.TempDir != "" {
+		return m.TempDir
+	}
+	return os.TempDir()
+}
+
+// ValidateTempDir checks that the manager's temporary directory exists and is writable
+func (m *Manager) ValidateTempDir() error {
+	dir := m.tempDir()
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("mount temp directory %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("mount temp directory %s is not a directory", dir)
+	}
+	probe, err := os.MkdirTemp(dir, tempMountPrefix+"probe-*")
+	if err != nil {
+		return fmt.Errorf("mount temp directory %s is not writable: %w", dir, err)
+	}
+	return os.Remove(probe)
+}
+
+// createMountPoint creates a temporary directory to mount an image on
+func (m *Manager) createMountPoint() (string, error) {
+	if err := m.ValidateTempDir(); err != nil {
+		return "", err
+	}
+	mountPoint, err := os.MkdirTemp(m.tempDir(), tempMountPrefix+"*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create mount point: %w", err)
+	}
+	return mountPoint, nil
+}
+
+// withLoopMount mounts an image on a temporary directory, calls fn with it and unmounts the image again
+// The image is unmounted before withLoopMount returns, so fn's writes are on disk; an unmount failure is
+// returned if fn succeeded. The directory is only removed once unmounted, so the image's files are never
+// deleted with it
+func (m *Manager) withLoopMount(imagePath string, readOnly bool, fn func(mountPoint string) error) error {
+	mountPoint, err := m.createMountPoint()
+	if err != nil {
+		return err
+	}
+	if err := m.loopMount(imagePath, mountPoint, readOnly); err != nil {
+		os.Remove(mountPoint)
+		return err
+	}
+
+	err = fn(mountPoint)
+	if umountErr := unmount(mountPoint); umountErr != nil {
+		// Left for CleanupStaleMounts
+		if err == nil {
+			err = umountErr
+		}
+		return err
+	}
+	os.Remove(mountPoint)
+	return err
+}
+
+// unmount unmounts a temporary mount, retrying with a growing delay while it is busy, e.g. because the
+// kernel hasn't released the loop device yet. If it stays busy it is detached with a lazy unmount,
+// which the kernel completes once the last user lets go
+func unmount(mountPoint string) error {
+	var output []byte
+	var err error
+	delay := umountRetryDelay
+	for attempt := 1; attempt <= umountAttempts; attempt++ {
+		if output, err = exec.Command("umount", mountPoint).CombinedOutput(); err == nil {
+			return nil
+		}
+		if !strings.Contains(string(output), "busy") {
+			return fmt.Errorf("failed to unmount image: %w: %s", err, strings.TrimSpace(string(output)))
+		}
+		if attempt < umountAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+
+	if lazyOutput, lazyErr := exec.Command("umount", "-l", mountPoint).CombinedOutput(); lazyErr != nil {
+		return fmt.Errorf("failed to unmount image: %w: %s", err, strings.TrimSpace(string(lazyOutput)))
+	}
+	fmt.Printf("  Warning: %s was still busy after %d unmount attempts; it was detached lazily\n", mountPoint, umountAttempts)
+	return nil
+}
+
+// loopMount mounts an image on mountPoint through a loop device
+// If the mount fails because the loop pool is exhausted, stale mounts are cleaned up and the mount is retried once
+func (m *Manager) loopMount(imagePath, mountPoint string, readOnly bool) error {
+	// An opened encrypted image is already a block device and needs no loop device
+	options := "loop"
+	if info, err := os.Stat(imagePath); err == nil && info.Mode()&os.ModeDevice != 0 {
+		options = "defaults"
+	}
+	if readOnly {
+		options += ",ro"
+	}
+
+	output, err := exec.Command("mount", "-o", options, imagePath, mountPoint).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if !loopDevicesExhausted() {
+		return fmt.Errorf("failed to mount image: %w: %s", err, string(output))
+	}
+
+	var cleaned int
+	progress.Run(m.progress(), "Releasing stale mounts to free loop devices", func() error {
+		var err error
+		cleaned, err = m.CleanupStaleMounts()
+		return err
+	})
+	if cleaned > 0 {
+		if _, err := exec.Command("mount", "-o", options, imagePath, mountPoint).CombinedOutput(); err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("failed to mount image: %w (%d in use): raise the limit with 'modprobe loop max_loop=<n>' "+
+		"(or max_loop=<n> on the kernel command line), or detach unused devices listed by 'losetup -a'",
+		ErrLoopDevicesExhausted, loopDevicesInUse())
+}
+
+// loopDevicesExhausted reports whether the kernel has no free loop device to hand out
+func loopDevicesExhausted() bool {
+	// losetup -f finds (or creates via /dev/loop-control) an unused device and fails if there is none
+	return exec.Command("losetup", "-f").Run() != nil
+}
+
+// loopDevicesInUse counts loop devices that currently have a backing file
+func loopDevicesInUse() int {
+	matches, _ := filepath.Glob("/sys/block/loop*/loop/backing_file")
+	return len(matches)
+}
+
+// CleanupStaleMounts unmounts temporary mounts of this manager's images left behind by interrupted operations
+// A mount is only treated as stale if no other operation holds its image lock; it returns how many were released
+func (m *Manager) CleanupStaleMounts() (int, error) {
+	file, err := os.Open("/proc/mounts")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read mounts: %w", err)
+	}
+	defer file.Close()
+
+	tempPrefix := filepath.Join(m.tempDir(), tempMountPrefix)
+	cleaned := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		device, mountPoint := fields[0], fields[1]
+		if !strings.HasPrefix(device, "/dev/loop") || !strings.HasPrefix(mountPoint, tempPrefix) {
+			continue
+		}
+
+		backing, err := os.ReadFile(filepath.Join("/sys/block", filepath.Base(device), "loop", "backing_file"))
+		if err != nil {
+			continue
+		}
+		imagePath := strings.TrimSpace(string(backing))
+		if filepath.Dir(imagePath) != filepath.Clean(m.MountsDir) {
+			continue
+		}
+
+		// A held lock means the mount belongs to an operation that is still running
+		unlock, err := lockImage(imagePath, 0)
+		if err != nil {
+			continue
+		}
+		if err := exec.Command("umount", mountPoint).Run(); err == nil {
+			os.Remove(mountPoint)
+			cleaned++
+		}
+		unlock()
+	}
+	if err := scanner.Err(); err != nil {
+		return cleaned, fmt.Errorf("failed to read mounts: %w", err)
+	}
+	return cleaned, nil
+}
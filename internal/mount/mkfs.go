@@ -0,0 +1,231 @@
+package mount
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// ext4 inode ratio bounds accepted by mkfs.ext4 (-i)
+const (
+	minInodeRatio = 1024
+	maxInodeRatio = 64 * 1024 * 1024
+)
+
+// defaultInodeSize is the on-disk inode size mkfs.ext4 uses by default
+const defaultInodeSize = 256
+
+// maxReservedBlocksPercent is the largest reserve mkfs.ext4 -m accepts
+const maxReservedBlocksPercent = 50
+
+// maxFATLabel is the longest volume label FAT stores
+const maxFATLabel = 11
+
+// ValidateMkfsOptions checks a mount's filesystem options for values and combinations mkfs.ext4 rejects
+func ValidateMkfsOptions(mount *vm.Mount) error {
+	switch mount.FSType() {
+	case vm.FilesystemExt4:
+	case vm.FilesystemVFAT:
+		return validateVFATOptions(mount)
+	default:
+		return fmt.Errorf("invalid filesystem '%s' for mount '%s': expected %s or %s", mount.Filesystem, mount.GuestTag, vm.FilesystemExt4, vm.FilesystemVFAT)
+	}
+
+	switch mount.BlockSize {
+	case 0, 1024, 2048, 4096:
+	default:
+		return fmt.Errorf("invalid block size %d for mount '%s': expected 1024, 2048 or 4096", mount.BlockSize, mount.GuestTag)
+	}
+
+	if mount.InodeRatio != 0 {
+		if mount.InodeRatio < minInodeRatio || mount.InodeRatio > maxInodeRatio {
+			return fmt.Errorf("invalid inode ratio %d for mount '%s': must be between %d and %d", mount.InodeRatio, mount.GuestTag, minInodeRatio, maxInodeRatio)
+		}
+		// mkfs.ext4 refuses to create more than one inode per block
+		blockSize := mount.BlockSize
+		if blockSize == 0 {
+			blockSize = 4096
+		}
+		if mount.InodeRatio < blockSize {
+			return fmt.Errorf("invalid inode ratio %d for mount '%s': must be at least the block size (%d)", mount.InodeRatio, mount.GuestTag, blockSize)
+		}
+	}
+
+	if mount.ReservedBlocksPercent < 0 || mount.ReservedBlocksPercent > maxReservedBlocksPercent {
+		return fmt.Errorf("invalid reserved blocks %d%% for mount '%s': must be between 0 and %d", mount.ReservedBlocksPercent, mount.GuestTag, maxReservedBlocksPercent)
+	}
+
+	for _, opt := range mount.MkfsOptions {
+		switch {
+		case opt == "":
+			return fmt.Errorf("invalid mkfs option for mount '%s': empty option", mount.GuestTag)
+		case strings.HasPrefix(opt, "-L"):
+			return fmt.Errorf("invalid mkfs option '%s' for mount '%s': the label is always set to the mount tag", opt, mount.GuestTag)
+		case strings.HasPrefix(opt, "-b"):
+			return fmt.Errorf("invalid mkfs option '%s' for mount '%s': use the block size setting instead", opt, mount.GuestTag)
+		case strings.HasPrefix(opt, "-i"):
+			return fmt.Errorf("invalid mkfs option '%s' for mount '%s': use the inode ratio setting instead", opt, mount.GuestTag)
+		}
+	}
+
+	return nil
+}
+
+// validateVFATOptions checks a FAT mount, which has none of the ext4 tuning settings and can only be
+// mirrored, as FAT images aren't grown in place. mkfs.vfat must be installed
+func validateVFATOptions(mount *vm.Mount) error {
+	switch {
+	case mount.BlockSize != 0 || mount.InodeRatio != 0 || mount.ReservedBlocksPercent != 0:
+		return fmt.Errorf("mount '%s' is vfat, which has no block size, inode ratio or reserved blocks settings", mount.GuestTag)
+	case mount.IsArchive():
+		return fmt.Errorf("mount '%s' is extracted from an archive, which needs ext4", mount.GuestTag)
+	case mount.SyncMode == string(SyncModeMerge):
+		return fmt.Errorf("mount '%s' is vfat and can only be synced in %s mode", mount.GuestTag, SyncModeMirror)
+	}
+	for _, opt := range mount.MkfsOptions {
+		switch {
+		case opt == "":
+			return fmt.Errorf("invalid mkfs option for mount '%s': empty option", mount.GuestTag)
+		case strings.HasPrefix(opt, "-n"):
+			return fmt.Errorf("invalid mkfs option '%s' for mount '%s': the label is always set from the mount tag", opt, mount.GuestTag)
+		}
+	}
+	if _, err := exec.LookPath("mkfs.vfat"); err != nil {
+		return fmt.Errorf("mount '%s' is vfat, but mkfs.vfat is not installed (it is in dosfstools)", mount.GuestTag)
+	}
+	return nil
+}
+
+// FATLabel turns a mount tag into a FAT volume label: upper case, at most 11 characters, with
+// characters FAT doesn't allow in labels replaced by underscores
+func FATLabel(tag string) string {
+	label := []byte(strings.ToUpper(tag))
+	if len(label) > maxFATLabel {
+		label = label[:maxFATLabel]
+	}
+	for i, c := range label {
+		if c < 0x20 || c > 0x7e || strings.IndexByte(`"*+,./:;<=>?[\]|`, c) >= 0 {
+			label[i] = '_'
+		}
+	}
+	return string(label)
+}
+
+// mkfsCommand returns the tool and arguments that format a mount image
+func mkfsCommand(mount *vm.Mount, imagePath string) (string, []string) {
+	if mount.IsVFAT() {
+		args := append([]string{"-n", FATLabel(mount.GuestTag)}, mount.MkfsOptions...)
+		return "mkfs.vfat", append(args, imagePath)
+	}
+	return "mkfs.ext4", mkfsArgs(mount, imagePath)
+}
+
+// imageFSType returns the filesystem blkid finds in an image file, or "" if it finds none
+func imageFSType(imagePath string) string {
+	output, err := exec.Command("blkid", "-o", "value", "-s", "TYPE", imagePath).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// makeFilesystem formats the device holding a mount image with the mount's filesystem
+func (m *Manager) makeFilesystem(mount *vm.Mount, device string) error {
+	name, args := mkfsCommand(mount, device)
+	if output, err := m.runFSCommand(name, args...); err != nil {
+		return fmt.Errorf("%w: %w: %s", ErrMkfsFailed, err, string(output))
+	}
+	return nil
+}
+
+// mkfsArgs builds the mkfs.ext4 arguments for a mount image
+func mkfsArgs(mount *vm.Mount, imagePath string) []string {
+	args := []string{"-F", "-L", mount.GuestTag}
+	if mount.BlockSize != 0 {
+		args = append(args, "-b", fmt.Sprintf("%d", mount.BlockSize))
+	}
+	if mount.InodeRatio != 0 {
+		args = append(args, "-i", fmt.Sprintf("%d", mount.InodeRatio))
+	}
+	// Blocks reserved for root only help a system disk; on a data image they are space the guest can't use.
+	// An -m in MkfsOptions comes later and takes precedence
+	args = append(args, "-m", fmt.Sprintf("%d", mount.ReservedBlocksPercent))
+	args = append(args, mount.MkfsOptions...)
+	return append(args, imagePath)
+}
+
+// imageSizeMB returns the image size needed for the given content, including filesystem overhead
+func imageSizeMB(mount *vm.Mount, contentBytes int64) int {
+	// Add 20% overhead for filesystem metadata
+	sizeMB := int(float64(bytesToMB(contentBytes)) * 1.2)
+
+	// A dense inode ratio makes the inode table a large share of the image, so reserve room for it
+	if mount.InodeRatio != 0 && mount.InodeRatio < 16384 {
+		sizeMB += sizeMB * defaultInodeSize / mount.InodeRatio
+	}
+
+	// Minimum 16MB
+	if sizeMB < 16 {
+		sizeMB = 16
+	}
+	return sizeMB
+}
+
+// imageUsedBytes returns the space in use inside an ext4 image, including filesystem metadata
+func imageUsedBytes(imagePath string) (int64, error) {
+	output, err := exec.Command("dumpe2fs", "-h", imagePath).Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read filesystem of %s: %w", imagePath, err)
+	}
+
+	fields := make(map[string]int64)
+	for _, line := range strings.Split(string(output), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Block count", "Free blocks", "Block size":
+			n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("malformed dumpe2fs output for %s: %w", imagePath, err)
+			}
+			fields[key] = n
+		}
+	}
+	if len(fields) != 3 {
+		return 0, fmt.Errorf("malformed dumpe2fs output for %s", imagePath)
+	}
+	return (fields["Block count"] - fields["Free blocks"]) * fields["Block size"], nil
+}
+
+// lostAndFound is the directory mkfs.ext4 creates at the image root for e2fsck to reconnect orphaned files
+const lostAndFound = "lost+found"
+
+// ensureLostAndFound recreates the image's lost+found with mklost+found if it has gone missing
+// mklost+found preallocates blocks so e2fsck never has to grow the directory during a repair
+func ensureLostAndFound(mountPoint string) error {
+	path := filepath.Join(mountPoint, lostAndFound)
+	info, err := os.Lstat(path)
+	if err == nil && info.IsDir() {
+		return nil
+	}
+	if err == nil {
+		// Something other than a directory has taken its place
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+
+	cmd := exec.Command("mklost+found")
+	cmd.Dir = mountPoint
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to recreate %s: %w: %s", lostAndFound, err, string(output))
+	}
+	return nil
+}
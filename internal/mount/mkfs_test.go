@@ -0,0 +1,42 @@
+package mount
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+func TestFATLabel(t *testing.T) {
+	for tag, want := range map[string]string{
+		"code":            "CODE",
+		"my-project-data": "MY-PROJECT-",
+		"a.b:c":           "A_B_C",
+	} {
+		if got := FATLabel(tag); got != want {
+			t.Errorf("FATLabel(%q) = %q, want %q", tag, got, want)
+		}
+	}
+}
+
+func TestValidateVFATRejectsExt4Options(t *testing.T) {
+	for name, m := range map[string]vm.Mount{
+		"inode ratio": {GuestTag: "data", Filesystem: vm.FilesystemVFAT, InodeRatio: 4096},
+		"merge":       {GuestTag: "data", Filesystem: vm.FilesystemVFAT, SyncMode: string(SyncModeMerge)},
+		"label":       {GuestTag: "data", Filesystem: vm.FilesystemVFAT, MkfsOptions: []string{"-n", "X"}},
+		"unknown fs":  {GuestTag: "data", Filesystem: "xfs"},
+	} {
+		if err := ValidateMkfsOptions(&m); err == nil {
+			t.Errorf("%s: ValidateMkfsOptions accepted %+v", name, m)
+		}
+	}
+}
+
+func TestMkfsCommandVFAT(t *testing.T) {
+	m := &vm.Mount{GuestTag: "data", Filesystem: vm.FilesystemVFAT, MkfsOptions: []string{"-F", "32"}}
+	name, args := mkfsCommand(m, "/img")
+	want := []string{"-n", "DATA", "-F", "32", "/img"}
+	if name != "mkfs.vfat" || !slices.Equal(args, want) {
+		t.Fatalf("mkfsCommand = %s %q, want mkfs.vfat %q", name, args, want)
+	}
+}
@@ -1,115 +1,315 @@
 package mount
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/raesene/baremetalvmm/internal/progress"
 	"github.com/raesene/baremetalvmm/internal/vm"
 )
 
+// SyncMode controls how SyncMountImage reconciles an image with its host directory
+type SyncMode string
+
+const (
+	// SyncModeMirror wipes the image before copying so it exactly matches the host directory
+	SyncModeMirror SyncMode = "mirror"
+	// SyncModeMerge only adds and updates files from the host, keeping files that exist only in the image
+	SyncModeMerge SyncMode = "merge"
+)
+
+// ParseSyncMode parses a sync mode name, defaulting to mirror when empty
+func ParseSyncMode(name string) (SyncMode, error) {
+	switch SyncMode(name) {
+	case "", SyncModeMirror:
+		return SyncModeMirror, nil
+	case SyncModeMerge:
+		return SyncModeMerge, nil
+	default:
+		return "", fmt.Errorf("invalid sync mode '%s': expected 'mirror' or 'merge'", name)
+	}
+}
+
 // Manager handles mount image creation and management
 type Manager struct {
-	MountsDir string
+	MountsDir    string
+	TempDir      string            // Where images are temporarily mounted (empty = os.TempDir()); use disk, not a small tmpfs
+	LockTimeout  time.Duration     // How long to wait for a concurrent operation on the same image
+	Progress     progress.Progress // Receives progress while images are built and synced (nil discards it)
+	VerifyCopies bool              // Compare image contents with the host directories after every copy
+	ForceSync    bool              // Sync images even when their sources and contents are unchanged
+	DiffContents bool              // DiffImages compares file contents, not only sizes and mtimes
+
+	// FSCommandTimeout bounds each mkfs.ext4, e2fsck and resize2fs run (0 = DefaultFSCommandTimeout)
+	FSCommandTimeout time.Duration
+	// MaxSyncDuration bounds a whole CreateMountImage or SyncMountImage, lock wait included (0 = no limit)
+	MaxSyncDuration time.Duration
+
+	// ProtectedDirs are vmm data directories, besides MountsDir, that mount sources may not overlap
+	ProtectedDirs []string
+	// CheckQuota, if set, is called with the bytes a new image is expected to allocate and refuses it with an error
+	CheckQuota func(need int64) error
+
+	ctx  context.Context // Cancels the commands of the create or sync in progress (nil = never)
+	held *MountLock      // The caller's lock, set by WithMountLock, which operations on its image run under
 }
 
 // NewManager creates a new mount manager
 func NewManager(mountsDir string) *Manager {
 	return &Manager{
-		MountsDir: mountsDir,
+		MountsDir:   mountsDir,
+		LockTimeout: DefaultLockTimeout,
+		Progress:    progress.NewStdout(1),
 	}
 }
 
-// CreateMountImage creates an ext4 image from a host directory
-// The image will contain a copy of all files from the host directory
-func (m *Manager) CreateMountImage(mount *vm.Mount, vmName string) error {
-	// Validate host path exists
-	info, err := os.Stat(mount.HostPath)
-	if err != nil {
-		return fmt.Errorf("host path '%s' does not exist: %w", mount.HostPath, err)
+// progress returns the manager's progress sink
+func (m *Manager) progress() progress.Progress {
+	if m.Progress == nil {
+		return progress.Discard
 	}
-	if !info.IsDir() {
-		return fmt.Errorf("host path '%s' is not a directory", mount.HostPath)
+	return m.Progress
+}
+
+// checkQuota applies the manager's disk quota, if any, to an image expected to allocate need bytes
+func (m *Manager) checkQuota(need int64) error {
+	if m.CheckQuota == nil {
+		return nil
 	}
+	return m.CheckQuota(need)
+}
 
-	// Create the image path
-	imagePath := m.GetMountImagePath(vmName, mount.GuestTag)
-	mount.ImagePath = imagePath
+// CreateMountImage creates an ext4 or vfat image from a host directory
+// The image will contain a copy of all files from the host directory
+func (m *Manager) CreateMountImage(mount *vm.Mount, vmName string) error {
+	return m.timed(fmt.Sprintf("Creating mount image for '%s'", mount.GuestTag), func(op *Manager) error {
+		return op.lockAndCreateMountImage(mount, vmName)
+	})
+}
+
+// lockAndCreateMountImage does the work of CreateMountImage
+func (m *Manager) lockAndCreateMountImage(mount *vm.Mount, vmName string) error {
+	if mount.IsTmpfs() {
+		return errTmpfsHasNoImage(mount)
+	}
 
 	// Ensure mounts directory exists
 	if err := os.MkdirAll(m.MountsDir, 0755); err != nil {
 		return fmt.Errorf("failed to create mounts directory: %w", err)
 	}
 
-	// Calculate size needed for the directory
-	sizeMB, err := calculateDirSize(mount.HostPath)
+	// Hold the image lock for the whole create so concurrent callers can't loop-mount it
+	unlock, err := m.lockImage(m.GetMountImagePath(vmName, mount.GuestTag))
 	if err != nil {
-		return fmt.Errorf("failed to calculate directory size: %w", err)
+		return err
 	}
+	defer unlock()
 
-	// Add 20% overhead for filesystem metadata, minimum 16MB
-	sizeMB = int(float64(sizeMB) * 1.2)
-	if sizeMB < 16 {
-		sizeMB = 16
+	return m.createMountImage(mount, vmName)
+}
+
+// createMountImage builds the image; the caller must hold the image lock
+func (m *Manager) createMountImage(mount *vm.Mount, vmName string) error {
+	if mount.IsArchive() {
+		return m.createArchiveImage(mount, vmName)
+	}
+	if err := ValidateMkfsOptions(mount); err != nil {
+		return err
 	}
 
-	fmt.Printf("  Creating mount image for '%s' (%d MB)...\n", mount.GuestTag, sizeMB)
+	// Validate host paths exist and calculate the size needed for all layers
+	layers, err := m.sourceLayers(mount)
+	if err != nil {
+		return err
+	}
 
+	// Create the image path
+	imagePath := m.GetMountImagePath(vmName, mount.GuestTag)
+	mount.ImagePath = imagePath
+	return m.buildImage(mount, layers, imagePath)
+}
+
+// buildImage creates imagePath, sized for the layers, and fills it; nothing is left behind on failure
+func (m *Manager) buildImage(mount *vm.Mount, layers []sourceLayer, imagePath string) error {
+	// The image is sparse, so it allocates about as much as the files copied in
+	if err := m.checkQuota(totalLayerBytes(layers)); err != nil {
+		return fmt.Errorf("cannot create mount image for '%s': %w", mount.GuestTag, err)
+	}
+	sizeMB := mountImageSizeMB(mount, totalLayerBytes(layers))
+
+	name := fmt.Sprintf("Creating mount image for '%s' (%d MB)", mount.GuestTag, sizeMB)
+	return progress.Run(m.progress(), name, func() error {
+		return m.writeImage(mount, layers, imagePath, sizeMB)
+	})
+}
+
+// writeImage creates a sparse image file of sizeMB, formats it and copies the layers in
+func (m *Manager) writeImage(mount *vm.Mount, layers []sourceLayer, imagePath string, sizeMB int) error {
 	// Create a sparse file
 	if err := exec.Command("truncate", "-s", fmt.Sprintf("%dM", sizeMB), imagePath).Run(); err != nil {
 		return fmt.Errorf("failed to create image file: %w", err)
 	}
 
-	// Create ext4 filesystem
-	mkfsCmd := exec.Command("mkfs.ext4", "-F", "-L", mount.GuestTag, imagePath)
-	if output, err := mkfsCmd.CombinedOutput(); err != nil {
+	if mount.Encrypted {
+		if err := formatEncrypted(mount, imagePath); err != nil {
+			os.Remove(imagePath)
+			return err
+		}
+	}
+	device, closeDevice, err := imageDevice(mount, imagePath)
+	if err != nil {
 		os.Remove(imagePath)
-		return fmt.Errorf("failed to create ext4 filesystem: %w: %s", err, string(output))
+		return err
 	}
-
-	// Copy files from host directory to the image
-	if err := m.copyFilesToImage(mount.HostPath, imagePath); err != nil {
+	err = m.fillImage(mount, layers, device)
+	closeDevice()
+	if err != nil {
 		os.Remove(imagePath)
+		return err
+	}
+	return nil
+}
+
+// fillImage formats the device holding a new image and copies the source layers into it
+func (m *Manager) fillImage(mount *vm.Mount, layers []sourceLayer, device string) error {
+	if err := m.makeFilesystem(mount, device); err != nil {
+		return err
+	}
+
+	// Copy files from host directories to the image
+	if err := m.copyFilesToImage(mount, layers, device); err != nil {
 		return fmt.Errorf("failed to copy files to mount image: %w", err)
 	}
 
+	if m.VerifyCopies {
+		return m.verifyCopy(mount, device, false)
+	}
 	return nil
 }
 
 // SyncMountImage refreshes a mount image from the host directory
-func (m *Manager) SyncMountImage(mount *vm.Mount, vmName string) error {
+// In SyncModeMirror (the default) files not present on the host are removed from the image;
+// in SyncModeMerge they are kept, preserving data written by the guest.
+// An archive mount's image is re-extracted from its archive, which only SyncModeMirror supports.
+// A read-write mount's image open in a running VM is refused with ErrImageAttached
+func (m *Manager) SyncMountImage(mount *vm.Mount, vmName string, mode SyncMode) error {
+	return m.timed(fmt.Sprintf("Syncing mount image for '%s'", mount.GuestTag), func(op *Manager) error {
+		return op.syncMountImage(mount, vmName, mode)
+	})
+}
+
+// syncMountImage does the work of SyncMountImage
+func (m *Manager) syncMountImage(mount *vm.Mount, vmName string, mode SyncMode) error {
+	if mount.IsTmpfs() {
+		return errTmpfsHasNoImage(mount)
+	}
+	if Shareable(mount) {
+		// A shared image is never modified; new content gets a new image
+		return m.prepareSharedImage(mount, vmName)
+	}
+	if mode == "" {
+		mode = SyncModeMirror
+	}
+	if mount.IsArchive() && mode != SyncModeMirror {
+		return fmt.Errorf("mount '%s' is extracted from an archive and can only be synced in %s mode", mount.GuestTag, SyncModeMirror)
+	}
+	if mount.IsVFAT() && mode != SyncModeMirror {
+		return fmt.Errorf("mount '%s' is vfat and can only be synced in %s mode", mount.GuestTag, SyncModeMirror)
+	}
+
 	if mount.ImagePath == "" {
 		mount.ImagePath = m.GetMountImagePath(vmName, mount.GuestTag)
 	}
 
+	// Ensure mounts directory exists
+	if err := os.MkdirAll(m.MountsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create mounts directory: %w", err)
+	}
+
+	// Hold the image lock across the mount-modify-unmount sequence
+	unlock, err := m.lockImage(mount.ImagePath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := checkNotAttached(mount); err != nil {
+		return err
+	}
+
+	if mount.IsArchive() {
+		// Rebuild from the archive rather than extracting over the guest's changes
+		if err := os.Remove(mount.ImagePath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove mount image: %w", err)
+		}
+		return m.createArchiveImage(mount, vmName)
+	}
+
+	// Validate host paths exist
+	layers, err := m.sourceLayers(mount)
+	if err != nil {
+		return err
+	}
+	sources, err := sourceFingerprint(layers, mode)
+	if err != nil {
+		return err
+	}
+
 	// Check if image exists
 	if _, err := os.Stat(mount.ImagePath); os.IsNotExist(err) {
 		// Image doesn't exist, create it
-		return m.CreateMountImage(mount, vmName)
+		if err := m.createMountImage(mount, vmName); err != nil {
+			return err
+		}
+		recordSync(mount.ImagePath, sources)
+		return nil
 	}
 
-	// Validate host path exists
-	info, err := os.Stat(mount.HostPath)
-	if err != nil {
-		return fmt.Errorf("host path '%s' does not exist: %w", mount.HostPath, err)
+	// Skip the copy when neither the host directories nor the image changed since the last sync
+	if !m.ForceSync && imageUnchangedSince(mount.ImagePath, sources) {
+		return nil
 	}
-	if !info.IsDir() {
-		return fmt.Errorf("host path '%s' is not a directory", mount.HostPath)
+	removeFingerprint(mount.ImagePath)
+
+	// Sync a copy so that an interrupted sync leaves the previous image, rather than an emptied one
+	if err := replaceImage(m.context(), mount.ImagePath, func(workPath string) error {
+		return m.syncImageFile(mount, layers, workPath, mode)
+	}); err != nil {
+		return err
 	}
+	recordSync(mount.ImagePath, sources)
+	return nil
+}
 
-	// Check if we need to resize the image
-	sizeMB, err := calculateDirSize(mount.HostPath)
+// syncImageFile grows an image file if the sources need more room and syncs its files with them
+func (m *Manager) syncImageFile(mount *vm.Mount, layers []sourceLayer, imagePath string, mode SyncMode) error {
+	device, closeDevice, err := imageDevice(mount, imagePath)
 	if err != nil {
-		return fmt.Errorf("failed to calculate directory size: %w", err)
+		return err
 	}
-	sizeMB = int(float64(sizeMB) * 1.2)
-	if sizeMB < 16 {
-		sizeMB = 16
+	defer func() { closeDevice() }()
+
+	// Check if we need to resize the image
+	contentBytes := totalLayerBytes(layers)
+	if mode == SyncModeMerge {
+		// Files already in the image are kept, so they need room alongside the copy
+		usedBytes, err := imageUsedBytes(device)
+		if err != nil {
+			return err
+		}
+		contentBytes += usedBytes
 	}
+	sizeMB := mountImageSizeMB(mount, contentBytes)
 
 	// Get current image size
-	imgInfo, err := os.Stat(mount.ImagePath)
+	imgInfo, err := os.Stat(imagePath)
 	if err != nil {
 		return fmt.Errorf("failed to stat image: %w", err)
 	}
@@ -117,127 +317,283 @@ func (m *Manager) SyncMountImage(mount *vm.Mount, vmName string) error {
 
 	// Resize if needed (only grow, never shrink)
 	if sizeMB > currentSizeMB {
-		fmt.Printf("  Resizing mount image to %d MB...\n", sizeMB)
-		if err := exec.Command("truncate", "-s", fmt.Sprintf("%dM", sizeMB), mount.ImagePath).Run(); err != nil {
-			return fmt.Errorf("failed to resize image file: %w", err)
-		}
-		// Check filesystem
-		exec.Command("e2fsck", "-f", "-y", mount.ImagePath).Run()
-		// Resize filesystem
-		if err := exec.Command("resize2fs", mount.ImagePath).Run(); err != nil {
-			return fmt.Errorf("failed to resize filesystem: %w", err)
+		name := fmt.Sprintf("Resizing mount image for '%s' to %d MB", mount.GuestTag, sizeMB)
+		if err := progress.Run(m.progress(), name, func() error {
+			// An encrypted mapping takes its size when opened, so reopen it around the resize
+			closeDevice()
+			if err := exec.Command("truncate", "-s", fmt.Sprintf("%dM", sizeMB), imagePath).Run(); err != nil {
+				return fmt.Errorf("failed to resize image file: %w", err)
+			}
+			var err error
+			if device, closeDevice, err = imageDevice(mount, imagePath); err != nil {
+				return err
+			}
+			if mount.IsVFAT() {
+				// There is no FAT resize tool to rely on; only mirror syncs reach here, and they
+				// replace every file anyway
+				return m.makeFilesystem(mount, device)
+			}
+			// Check filesystem; its problems are left to resize2fs to report, but a hang is not
+			if _, err := m.runFSCommand("e2fsck", "-f", "-y", device); errors.Is(err, ErrFSCommandTimeout) {
+				return err
+			}
+			// Resize filesystem
+			if output, err := m.runFSCommand("resize2fs", device); err != nil {
+				return fmt.Errorf("failed to resize filesystem: %w: %s", err, string(output))
+			}
+			return nil
+		}); err != nil {
+			return err
 		}
 	}
 
-	fmt.Printf("  Syncing mount image for '%s'...\n", mount.GuestTag)
-
-	// Mount, clear, and copy files
-	mountPoint, err := os.MkdirTemp("", "vmm-mount-sync-*")
-	if err != nil {
-		return fmt.Errorf("failed to create mount point: %w", err)
-	}
-	defer os.RemoveAll(mountPoint)
+	name := fmt.Sprintf("Syncing mount image for '%s' (%s)", mount.GuestTag, mode)
+	return progress.Run(m.progress(), name, func() error {
+		return m.syncFiles(mount, layers, device, mode)
+	})
+}
 
-	// Mount the image
-	mountCmd := exec.Command("mount", "-o", "loop", mount.ImagePath, mountPoint)
-	if output, err := mountCmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to mount image: %w: %s", err, string(output))
-	}
-	defer exec.Command("umount", mountPoint).Run()
+// syncFiles replaces or merges the files in an image, given by the device holding its filesystem,
+// with the source layers and verifies the result if enabled; the image is left unmounted
+func (m *Manager) syncFiles(mount *vm.Mount, layers []sourceLayer, device string, mode SyncMode) error {
+	// The image is unmounted again before it can be verified through a fresh read-only mount,
+	// and before its mtime is final for the fingerprint
+	if err := m.withLoopMount(device, false, func(mountPoint string) error {
+		// Remove all files from the image (except lost+found) when mirroring
+		if mode == SyncModeMirror {
+			entries, err := os.ReadDir(mountPoint)
+			if err != nil {
+				return fmt.Errorf("failed to read mount point: %w", err)
+			}
+			for _, entry := range entries {
+				if entry.Name() == lostAndFound {
+					continue
+				}
+				path := filepath.Join(mountPoint, entry.Name())
+				if err := os.RemoveAll(path); err != nil {
+					return fmt.Errorf("failed to remove %s: %w", path, err)
+				}
+			}
+		}
 
-	// Remove all files from the image (except lost+found)
-	entries, err := os.ReadDir(mountPoint)
-	if err != nil {
-		return fmt.Errorf("failed to read mount point: %w", err)
-	}
-	for _, entry := range entries {
-		if entry.Name() == "lost+found" {
-			continue
+		// Copy files from host to image using tar to preserve permissions
+		if err := m.copyLayers(layers, mountPoint, mount.IsVFAT()); err != nil {
+			return err
 		}
-		path := filepath.Join(mountPoint, entry.Name())
-		if err := os.RemoveAll(path); err != nil {
-			return fmt.Errorf("failed to remove %s: %w", path, err)
+		if mount.IsVFAT() {
+			return nil
 		}
+		return ensureLostAndFound(mountPoint)
+	}); err != nil {
+		return err
 	}
+	if m.VerifyCopies {
+		if err := m.verifyCopy(mount, device, mode == SyncModeMerge); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	// Copy files from host to image using tar to preserve permissions
-	tarCreate := exec.Command("tar", "-cf", "-", "-C", mount.HostPath, ".")
-	tarExtract := exec.Command("tar", "-xf", "-", "-C", mountPoint)
-	tarExtract.Stdin, _ = tarCreate.StdoutPipe()
-
-	if err := tarExtract.Start(); err != nil {
-		return fmt.Errorf("failed to start tar extract: %w", err)
+// PrepareMountImage readies a mount's image for a VM start
+// A missing image is created; an existing one is synced in the mount's sync mode instead of
+// being reformatted, so with SyncModeMerge files written by the guest survive a restart.
+// An archive mount is extracted only when its image is missing
+func (m *Manager) PrepareMountImage(mount *vm.Mount, vmName string) error {
+	if Shareable(mount) {
+		return m.prepareSharedImage(mount, vmName)
+	}
+	if m.IsSharedImage(mount.ImagePath) {
+		// The mount stopped being shared; it gets its own image from here on
+		if err := m.releaseSharedImage(mount.ImagePath, vmName, mount.GuestTag); err != nil {
+			return err
+		}
+		mount.ImagePath = ""
 	}
-	if err := tarCreate.Run(); err != nil {
-		return fmt.Errorf("failed to create tar: %w", err)
+	if mount.IsArchive() {
+		imagePath := m.GetMountImagePath(vmName, mount.GuestTag)
+		if _, err := os.Stat(imagePath); err == nil {
+			mount.ImagePath = imagePath
+			return nil
+		}
+		return m.CreateMountImage(mount, vmName)
 	}
-	if err := tarExtract.Wait(); err != nil {
-		return fmt.Errorf("failed to extract tar: %w", err)
+	mode, err := ParseSyncMode(mount.SyncMode)
+	if err != nil {
+		return err
 	}
-
-	return nil
+	return m.SyncMountImage(mount, vmName, mode)
 }
 
-// DeleteMountImage removes a mount image file
+// DeleteMountImage removes a mount image file and its lock file
 func (m *Manager) DeleteMountImage(vmName, guestTag string) error {
 	imagePath := m.GetMountImagePath(vmName, guestTag)
 	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
 		return nil // Already deleted
 	}
-	return os.Remove(imagePath)
+
+	// Wait for any operation still using the image
+	unlock, err := m.lockImage(imagePath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// An encrypted image left open by a VM that exited on its own would keep its blocks allocated
+	if err := closeEncrypted(imagePath); err != nil {
+		return err
+	}
+
+	if err := os.Remove(imagePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	os.Remove(syncWorkPath(imagePath))
+	removeFingerprint(imagePath)
+	m.removeLockFile(imagePath)
+	return nil
 }
 
 // DeleteAllMountImages removes all mount images for a VM
 func (m *Manager) DeleteAllMountImages(vmName string, mounts []vm.Mount) error {
-	for _, mount := range mounts {
-		if err := m.DeleteMountImage(vmName, mount.GuestTag); err != nil {
+	for i := range mounts {
+		if err := m.ReleaseMountImage(&mounts[i], vmName); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-// GetMountImagePath returns the path for a mount image
-func (m *Manager) GetMountImagePath(vmName, guestTag string) string {
-	return filepath.Join(m.MountsDir, fmt.Sprintf("%s-%s.ext4", vmName, guestTag))
+// ReleaseMountImage gives up a VM's use of a mount's image: a shared image loses a reference
+// and is deleted with its last one, and a per-VM image is deleted
+func (m *Manager) ReleaseMountImage(mount *vm.Mount, vmName string) error {
+	if m.IsSharedImage(mount.ImagePath) {
+		return m.releaseSharedImage(mount.ImagePath, vmName, mount.GuestTag)
+	}
+	return m.DeleteMountImage(vmName, mount.GuestTag)
 }
 
-// copyFilesToImage mounts an image and copies files into it
-func (m *Manager) copyFilesToImage(srcDir, imagePath string) error {
-	// Create mount point
-	mountPoint, err := os.MkdirTemp("", "vmm-mount-*")
+// RenameMountTag renames a mount image to a new tag
+// The image file is moved to the path for the new tag and its filesystem label is updated, with
+// e2label for ext4 or fatlabel for vfat
+func (m *Manager) RenameMountTag(vmName, oldTag, newTag string) error {
+	if err := ValidateTag(newTag); err != nil {
+		return err
+	}
+	if oldTag == newTag {
+		return nil
+	}
+
+	oldPath := m.GetMountImagePath(vmName, oldTag)
+	newPath := m.GetMountImagePath(vmName, newTag)
+
+	// Lock both names so nothing can use or create either image mid-rename
+	unlock, err := m.lockImages(oldPath, newPath)
 	if err != nil {
-		return fmt.Errorf("failed to create mount point: %w", err)
+		return err
 	}
-	defer os.RemoveAll(mountPoint)
+	defer unlock()
 
-	// Mount the image
-	mountCmd := exec.Command("mount", "-o", "loop", imagePath, mountPoint)
-	if output, err := mountCmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to mount image: %w: %s", err, string(output))
+	if _, err := os.Stat(oldPath); err != nil {
+		return fmt.Errorf("%w: '%s' has none at %s: %w", ErrMountImageNotFound, oldTag, oldPath, err)
 	}
-	defer exec.Command("umount", mountPoint).Run()
+	if _, err := os.Stat(newPath); err == nil {
+		return fmt.Errorf("mount image for '%s' already exists at %s", newTag, newPath)
+	}
+
+	// Update the filesystem label first so a failure leaves the image untouched
+	labelTool, label, oldLabel := "e2label", newTag, oldTag
+	if imageFSType(oldPath) == vm.FilesystemVFAT {
+		labelTool, label, oldLabel = "fatlabel", FATLabel(newTag), FATLabel(oldTag)
+	}
+	labelCmd := exec.Command(labelTool, oldPath, label)
+	if output, err := labelCmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to update filesystem label: %w: %s", err, string(output))
+	}
+
+	if err := os.Rename(oldPath, newPath); err != nil {
+		// Restore the original label
+		exec.Command(labelTool, oldPath, oldLabel).Run()
+		return fmt.Errorf("failed to rename mount image: %w", err)
+	}
+
+	// The old name no longer has an image; the relabel changed the image, so its next sync copies in full
+	removeFingerprint(oldPath)
+	m.removeLockFile(oldPath)
+	return nil
+}
+
+// GetMountImagePath returns the path for a mount image
+func (m *Manager) GetMountImagePath(vmName, guestTag string) string {
+	return filepath.Join(m.MountsDir, fmt.Sprintf("%s-%s.ext4", vmName, guestTag))
+}
 
+// copyFilesToImage mounts an image and copies files into it
+func (m *Manager) copyFilesToImage(mount *vm.Mount, layers []sourceLayer, imagePath string) error {
 	// Copy files using tar to preserve permissions and special files
-	tarCreate := exec.Command("tar", "-cf", "-", "-C", srcDir, ".")
-	tarExtract := exec.Command("tar", "-xf", "-", "-C", mountPoint)
-	tarExtract.Stdin, _ = tarCreate.StdoutPipe()
+	return m.withLoopMount(imagePath, false, func(mountPoint string) error {
+		return m.copyLayers(layers, mountPoint, mount.IsVFAT())
+	})
+}
 
-	if err := tarExtract.Start(); err != nil {
-		return fmt.Errorf("failed to start tar extract: %w", err)
+// sourceLayer is one host directory contributing to a mount image
+type sourceLayer struct {
+	Path  string
+	Bytes int64
+}
+
+// sourceLayers validates every host directory of a mount and measures its size
+// Layers are returned lowest first: the base HostPath, then each overlay path
+func (m *Manager) sourceLayers(mount *vm.Mount) ([]sourceLayer, error) {
+	if err := m.ValidateSourcePaths(mount); err != nil {
+		return nil, err
 	}
-	if err := tarCreate.Run(); err != nil {
-		return fmt.Errorf("failed to create tar: %w", err)
+	var layers []sourceLayer
+	for _, path := range mount.SourcePaths() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: '%s': %w", ErrHostPathMissing, path, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("host path '%s' is not a directory", path)
+		}
+
+		size, err := calculateDirBytes(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to calculate directory size: %w", err)
+		}
+		layers = append(layers, sourceLayer{Path: path, Bytes: size})
 	}
-	if err := tarExtract.Wait(); err != nil {
-		return fmt.Errorf("failed to extract tar: %w", err)
+	return layers, nil
+}
+
+// totalLayerBytes sums the size of all layers
+// Files shadowed by a higher layer are counted twice, so this slightly overestimates
+func totalLayerBytes(layers []sourceLayer) int64 {
+	var total int64
+	for _, layer := range layers {
+		total += layer.Bytes
 	}
+	return total
+}
 
+// copyLayers copies each layer into dstDir in order, so files in later layers
+// replace files at the same path in earlier ones. fat copies onto a FAT filesystem
+func (m *Manager) copyLayers(layers []sourceLayer, dstDir string, fat bool) error {
+	for _, layer := range layers {
+		if err := m.tarCopy(layer.Path, dstDir, "Copying "+layer.Path, layer.Bytes, fat); err != nil {
+			return fmt.Errorf("failed to copy '%s': %w", layer.Path, err)
+		}
+	}
 	return nil
 }
 
-// calculateDirSize returns the size of a directory in MB
-func calculateDirSize(path string) (int, error) {
+// bytesToMB converts a byte count to MB, rounding up
+func bytesToMB(size int64) int {
+	return int((size + 1024*1024 - 1) / (1024 * 1024))
+}
+
+// calculateDirBytes returns the total size of regular files in a directory in bytes
+func calculateDirBytes(path string) (int64, error) {
 	var size int64
 	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -251,24 +607,28 @@ func calculateDirSize(path string) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	// Convert to MB (round up)
-	sizeMB := int((size + 1024*1024 - 1) / (1024 * 1024))
-	return sizeMB, nil
+	return size, nil
 }
 
-// ParseMountSpec parses a mount specification string in format "host_path:tag[:ro|rw]"
+// ParseMountSpec parses a mount specification string in format "host_path[,overlay_path...]:tag[:ro|rw]"
+// Multiple comma-separated host paths are layered in order, later paths taking precedence
+// A comma that is part of a path must be escaped as "\,"
 func ParseMountSpec(spec string) (*vm.Mount, error) {
 	// Split by colon
 	parts := splitMountSpec(spec)
 	if len(parts) < 2 || len(parts) > 3 {
-		return nil, fmt.Errorf("invalid mount spec '%s': expected format 'host_path:tag[:ro|rw]'", spec)
+		return nil, fmt.Errorf("invalid mount spec '%s': expected format 'host_path[,overlay_path...]:tag[:ro|rw]'", spec)
 	}
 
+	hostPaths := splitHostPaths(parts[0])
 	mount := &vm.Mount{
-		HostPath: parts[0],
+		HostPath: hostPaths[0],
 		GuestTag: parts[1],
 		ReadOnly: false, // Default to read-write
 	}
+	if len(hostPaths) > 1 {
+		mount.OverlayPaths = hostPaths[1:]
+	}
 
 	if len(parts) == 3 {
 		switch parts[2] {
@@ -281,21 +641,161 @@ func ParseMountSpec(spec string) (*vm.Mount, error) {
 		}
 	}
 
-	// Validate host path exists
-	if _, err := os.Stat(mount.HostPath); err != nil {
-		return nil, fmt.Errorf("host path '%s' does not exist", mount.HostPath)
+	// Validate host paths exist
+	for _, path := range mount.SourcePaths() {
+		if path == "" {
+			return nil, fmt.Errorf("invalid mount spec '%s': empty host path", spec)
+		}
+		if _, err := os.Stat(path); err != nil {
+			if len(hostPaths) > 1 {
+				if _, err := os.Stat(parts[0]); err == nil {
+					return nil, fmt.Errorf("%w: '%s' (commas separate overlay paths; "+
+						"to mount '%s' escape them as '\\,')", ErrHostPathMissing, path, parts[0])
+				}
+			}
+			return nil, fmt.Errorf("%w: '%s'", ErrHostPathMissing, path)
+		}
 	}
 
 	// Validate tag (no special characters)
-	for _, c := range mount.GuestTag {
-		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '_') {
-			return nil, fmt.Errorf("invalid mount tag '%s': only alphanumeric, dash, and underscore allowed", mount.GuestTag)
+	if err := ValidateTag(mount.GuestTag); err != nil {
+		return nil, err
+	}
+
+	return mount, nil
+}
+
+// ParseMountSpecs parses and validates several mount specifications before any image is created
+// Every invalid spec and every repeated tag is reported, joined into a single error
+func ParseMountSpecs(specs []string) ([]*vm.Mount, error) {
+	var mounts []*vm.Mount
+	var errs []error
+	tags := make(map[string]string)
+	for _, spec := range specs {
+		mount, err := ParseMountSpec(spec)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("mount '%s': %w", spec, err))
+			continue
+		}
+		if first, ok := tags[mount.GuestTag]; ok {
+			errs = append(errs, fmt.Errorf("mount '%s': tag '%s' is already used by '%s'", spec, mount.GuestTag, first))
+			continue
+		}
+		tags[mount.GuestTag] = spec
+		mounts = append(mounts, mount)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return mounts, nil
+}
+
+// splitHostPaths splits the host path part of a mount spec on commas, keeping escaped commas ("\,") in the path
+func splitHostPaths(field string) []string {
+	var paths []string
+	var current strings.Builder
+	for i := 0; i < len(field); i++ {
+		switch {
+		case field[i] == '\\' && i+1 < len(field) && field[i+1] == ',':
+			current.WriteByte(',')
+			i++
+		case field[i] == ',':
+			paths = append(paths, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(field[i])
+		}
+	}
+	return append(paths, current.String())
+}
+
+// ParseTmpfsSpec parses a tmpfs mount specification in format "tag:size_mb"
+func ParseTmpfsSpec(spec string) (*vm.Mount, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid tmpfs spec '%s': expected format 'tag:size_mb'", spec)
+	}
+
+	sizeMB, err := strconv.Atoi(parts[1])
+	if err != nil || sizeMB < 1 {
+		return nil, fmt.Errorf("invalid tmpfs size '%s': expected a positive number of MB", parts[1])
+	}
+
+	if err := ValidateTag(parts[0]); err != nil {
+		return nil, err
+	}
+
+	return &vm.Mount{
+		Mode:        vm.MountModeTmpfs,
+		GuestTag:    parts[0],
+		TmpfsSizeMB: sizeMB,
+	}, nil
+}
+
+// ParseArchiveSpec parses an archive mount specification in format "archive_path:tag[:size_mb][:ro|rw]"
+// size_mb is required for compressed archives and may be omitted for plain tar files
+func ParseArchiveSpec(spec string) (*vm.Mount, error) {
+	parts := strings.Split(spec, ":")
+	mount := &vm.Mount{Mode: vm.MountModeArchive}
+
+	// Parse optional fields from the end so the archive path may contain colons
+	if n := len(parts); n > 2 && (parts[n-1] == "ro" || parts[n-1] == "rw") {
+		mount.ReadOnly = parts[n-1] == "ro"
+		parts = parts[:n-1]
+	}
+	if n := len(parts); n > 2 {
+		if sizeMB, err := strconv.Atoi(parts[n-1]); err == nil {
+			if sizeMB < 1 {
+				return nil, fmt.Errorf("invalid archive image size '%s': expected a positive number of MB", parts[n-1])
+			}
+			mount.ArchiveSizeMB = sizeMB
+			parts = parts[:n-1]
 		}
 	}
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid archive spec '%s': expected format 'archive_path:tag[:size_mb][:ro|rw]'", spec)
+	}
+
+	mount.GuestTag = parts[len(parts)-1]
+	mount.ArchivePath = strings.Join(parts[:len(parts)-1], ":")
+	if err := ValidateTag(mount.GuestTag); err != nil {
+		return nil, err
+	}
+
+	absPath, err := filepath.Abs(mount.ArchivePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive path '%s': %w", mount.ArchivePath, err)
+	}
+	mount.ArchivePath = absPath
 
+	_, compression, err := inspectArchive(absPath)
+	if err != nil {
+		return nil, err
+	}
+	if compression != compressionNone && mount.ArchiveSizeMB == 0 {
+		return nil, fmt.Errorf("archive '%s' is %s compressed: give an image size as 'archive_path:tag:size_mb'", absPath, compression.name)
+	}
 	return mount, nil
 }
 
+// errTmpfsHasNoImage is returned by image operations on a tmpfs mount
+func errTmpfsHasNoImage(mount *vm.Mount) error {
+	return fmt.Errorf("mount '%s' is a tmpfs and has no host image", mount.GuestTag)
+}
+
+// ValidateTag checks that a mount tag contains only alphanumeric characters, dashes, and underscores
+func ValidateTag(tag string) error {
+	if tag == "" {
+		return fmt.Errorf("invalid mount tag: tag cannot be empty")
+	}
+	for _, c := range tag {
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '_') {
+			return fmt.Errorf("invalid mount tag '%s': only alphanumeric, dash, and underscore allowed", tag)
+		}
+	}
+	return nil
+}
+
 // splitMountSpec splits a mount spec, handling paths that may contain colons (like Windows paths or special paths)
 // It assumes the format is: path:tag[:mode] where tag and mode are simple identifiers
 func splitMountSpec(spec string) []string {
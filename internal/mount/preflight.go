@@ -0,0 +1,62 @@
+package mount
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// ImageProblem is a mount whose image can't be attached as it is
+type ImageProblem struct {
+	Mount   *vm.Mount
+	Missing bool // The image file is gone, rather than replaced or unreadable
+	Err     error
+}
+
+// CheckMountImages verifies that the image of each drive-backed mount exists and is a regular file.
+// Mounts not yet given an image are skipped. Problems are returned in mount order; a missing
+// image's error wraps ErrMountImageNotFound
+func CheckMountImages(mounts []vm.Mount) []ImageProblem {
+	var problems []ImageProblem
+	for i := range mounts {
+		m := &mounts[i]
+		if m.IsTmpfs() || m.ImagePath == "" {
+			continue
+		}
+		info, err := os.Stat(m.ImagePath)
+		switch {
+		case os.IsNotExist(err):
+			problems = append(problems, ImageProblem{m, true, fmt.Errorf("mount '%s': %w at %s", m.GuestTag, ErrMountImageNotFound, m.ImagePath)})
+		case err != nil:
+			problems = append(problems, ImageProblem{m, false, fmt.Errorf("mount '%s': failed to check image: %w", m.GuestTag, err)})
+		case !info.Mode().IsRegular():
+			problems = append(problems, ImageProblem{m, false, fmt.Errorf("mount '%s': image %s is not a regular file; move it aside so the image can be recreated", m.GuestTag, m.ImagePath)})
+		}
+	}
+	return problems
+}
+
+// CanRecreateImage checks that a mount's image can be rebuilt: its archive, or every host directory
+// it is built from, still exists
+func (m *Manager) CanRecreateImage(mount *vm.Mount) error {
+	if mount.IsArchive() {
+		if _, err := os.Stat(mount.ArchivePath); err != nil {
+			return fmt.Errorf("archive '%s' can't be read: %w", mount.ArchivePath, err)
+		}
+		return nil
+	}
+	if err := m.ValidateSourcePaths(mount); err != nil {
+		return err
+	}
+	for _, path := range mount.SourcePaths() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("%w: '%s': %w", ErrHostPathMissing, path, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("host path '%s' is not a directory", path)
+		}
+	}
+	return nil
+}
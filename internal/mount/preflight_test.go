@@ -0,0 +1,35 @@
+package mount
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+func TestCheckMountImages(t *testing.T) {
+	dir := t.TempDir()
+	present := filepath.Join(dir, "present.ext4")
+	if err := os.WriteFile(present, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	mounts := []vm.Mount{
+		{GuestTag: "ok", ImagePath: present},
+		{GuestTag: "gone", ImagePath: filepath.Join(dir, "gone.ext4")},
+		{GuestTag: "dir", ImagePath: dir},
+		{GuestTag: "new"},
+		{GuestTag: "scratch", Mode: vm.MountModeTmpfs},
+	}
+	problems := CheckMountImages(mounts)
+	if len(problems) != 2 {
+		t.Fatalf("got %d problems, want 2: %v", len(problems), problems)
+	}
+	if problems[0].Mount.GuestTag != "gone" || !problems[0].Missing || !errors.Is(problems[0].Err, ErrMountImageNotFound) {
+		t.Errorf("first problem = %+v, want missing image of 'gone'", problems[0])
+	}
+	if problems[1].Mount.GuestTag != "dir" || problems[1].Missing {
+		t.Errorf("second problem = %+v, want non-file image of 'dir'", problems[1])
+	}
+}
@@ -0,0 +1,67 @@
+package mount
+
+import (
+	"fmt"
+	"os/exec"
+
+	"github.com/raesene/baremetalvmm/internal/progress"
+)
+
+// tarCreateArgs builds the tar arguments that archive srcDir to stdout for tarCopy
+// Never copy a source lost+found over the image's own, which e2fsck relies on; --anchored
+// limits the exclusion to the top level so nested lost+found directories are still copied
+// For a FAT destination symlinks are followed, as FAT can't hold them
+func tarCreateArgs(srcDir string, fat bool) []string {
+	args := []string{"-cf", "-", "--anchored", "--exclude=./" + lostAndFound}
+	if fat {
+		args = append(args, "-h")
+	}
+	return append(args, "-C", srcDir, ".")
+}
+
+// tarCopyExtractArgs builds the tar arguments that extract tarCopy's stream into dstDir
+// FAT has no owners or permission bits, so tar is told not to try setting them
+func tarCopyExtractArgs(dstDir string, fat bool) []string {
+	args := []string{"-xf", "-", "-C", dstDir}
+	if fat {
+		args = append(args, "--no-same-owner", "--no-same-permissions")
+	}
+	return args
+}
+
+// tarCopy streams srcDir into dstDir using a tar pipe, preserving permissions and special files
+// The copy is reported as the operation name, sized totalBytes, through the manager's Progress.
+// fat copies onto a FAT filesystem, which keeps only file contents
+func (m *Manager) tarCopy(srcDir, dstDir, name string, totalBytes int64, fat bool) error {
+	m.progress().Start(name, totalBytes)
+	err := m.runTarCopy(srcDir, dstDir, fat)
+	m.progress().Done(name, err)
+	return err
+}
+
+// runTarCopy runs the tar pipe for tarCopy
+// The count reported may slightly exceed the directory size because of tar headers
+func (m *Manager) runTarCopy(srcDir, dstDir string, fat bool) error {
+	tarCreate := exec.CommandContext(m.context(), "tar", tarCreateArgs(srcDir, fat)...)
+	tarExtract := exec.CommandContext(m.context(), "tar", tarCopyExtractArgs(dstDir, fat)...)
+
+	stdout, err := tarCreate.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create tar pipe: %w", err)
+	}
+	tarExtract.Stdin = progress.NewReader(stdout, m.progress())
+
+	if err := tarCreate.Start(); err != nil {
+		return fmt.Errorf("failed to create tar: %w", err)
+	}
+	if err := tarExtract.Run(); err != nil {
+		// Unblock the producer before reaping it
+		stdout.Close()
+		tarCreate.Wait()
+		return fmt.Errorf("failed to extract tar: %w", err)
+	}
+	if err := tarCreate.Wait(); err != nil {
+		return fmt.Errorf("failed to create tar: %w", err)
+	}
+	return nil
+}
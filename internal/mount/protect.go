@@ -0,0 +1,85 @@
+package mount
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// ValidateSourcePaths rejects a mount whose host directories overlap vmm's own data, or whose
+// SubPath leaves them (see ValidateSubPath)
+// A source inside MountsDir or one of ProtectedDirs, or one containing them, would copy
+// images into images, growing without bound. Paths are compared after resolving symlinks
+func (m *Manager) ValidateSourcePaths(mount *vm.Mount) error {
+	if err := ValidateSubPath(mount); err != nil {
+		return err
+	}
+	protected := append([]string{m.MountsDir}, m.ProtectedDirs...)
+	for _, path := range mount.SourcePaths() {
+		source, err := resolvePath(path)
+		if err != nil {
+			return fmt.Errorf("%w: '%s': %w", ErrHostPathMissing, path, err)
+		}
+		for _, dir := range protected {
+			if dir == "" {
+				continue
+			}
+			resolved, err := resolvePath(dir)
+			if err != nil {
+				// A directory that doesn't exist yet can't overlap anything
+				continue
+			}
+			switch {
+			case pathWithin(source, resolved):
+				return fmt.Errorf("host path '%s' for mount '%s' is inside vmm's data directory %s", path, mount.GuestTag, dir)
+			case pathWithin(resolved, source):
+				return fmt.Errorf("host path '%s' for mount '%s' contains vmm's data directory %s", path, mount.GuestTag, dir)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateSubPath checks a mount's SubPath: a clean relative path that stays within each source
+// root once symlinks are resolved, so a link in the tree can't pull in the rest of the host
+func ValidateSubPath(mount *vm.Mount) error {
+	sub := mount.SubPath
+	if sub == "" {
+		return nil
+	}
+	if filepath.IsAbs(sub) || filepath.Clean(sub) != sub || sub == "." || sub == ".." || strings.HasPrefix(sub, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("invalid subpath '%s' for mount '%s': must be a clean relative path inside the host directory", sub, mount.GuestTag)
+	}
+	for _, root := range mount.SourceRoots() {
+		resolvedRoot, err := resolvePath(root)
+		if err != nil {
+			return fmt.Errorf("%w: '%s': %w", ErrHostPathMissing, root, err)
+		}
+		path := filepath.Join(root, sub)
+		resolved, err := resolvePath(path)
+		if err != nil {
+			return fmt.Errorf("%w: subpath '%s' of mount '%s': %w", ErrHostPathMissing, path, mount.GuestTag, err)
+		}
+		if !pathWithin(resolved, resolvedRoot) {
+			return fmt.Errorf("subpath '%s' of mount '%s' resolves to %s, outside %s", sub, mount.GuestTag, resolved, root)
+		}
+	}
+	return nil
+}
+
+// resolvePath returns the absolute path with all symlinks resolved
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// pathWithin reports whether path is dir or below it; both must be clean absolute paths
+func pathWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,75 @@
+package mount
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+func TestValidateSourcePathsRejectsDataDirectories(t *testing.T) {
+	dataDir := t.TempDir()
+	m := NewManager(filepath.Join(dataDir, "mounts"))
+	m.ProtectedDirs = []string{filepath.Join(dataDir, "images")}
+	for _, dir := range []string{"mounts/sub", "images", "project"} {
+		if err := os.MkdirAll(filepath.Join(dataDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(filepath.Join(dataDir, "images"), link); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		source string
+		ok     bool
+	}{
+		{filepath.Join(dataDir, "project"), true},
+		{filepath.Join(dataDir, "mounts", "sub"), false},
+		{link, false},
+		{dataDir, false},
+	} {
+		err := m.ValidateSourcePaths(&vm.Mount{HostPath: tc.source, GuestTag: "data"})
+		if tc.ok && err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.source, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("%s: overlap with a data directory not rejected", tc.source)
+		}
+	}
+}
+
+func TestValidateSubPath(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "src", "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("src", filepath.Join(root, "inside")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		sub string
+		ok  bool
+	}{
+		{"", true},
+		{"src/app", true},
+		{"inside/app", true},
+		{"escape", false},
+		{"../" + filepath.Base(outside), false},
+		{"/src", false},
+		{"src/../src", false},
+		{"missing", false},
+	} {
+		m := &vm.Mount{HostPath: root, GuestTag: "code", SubPath: tc.sub}
+		if err := ValidateSubPath(m); (err == nil) != tc.ok {
+			t.Errorf("ValidateSubPath(%q) = %v, want ok=%t", tc.sub, err, tc.ok)
+		}
+	}
+}
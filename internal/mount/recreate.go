@@ -0,0 +1,72 @@
+package mount
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/raesene/baremetalvmm/internal/progress"
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// RecreateMountImage deletes a mount's image and builds a new one from its host directories or
+// archive, for an image too damaged for e2fsck to repair. The mount keeps its tag, mode and
+// filesystem options. This is not a repair: anything the guest wrote to the image is lost.
+// The sources are checked before the old image is deleted, and the image may not be open in a VM
+func (m *Manager) RecreateMountImage(mount *vm.Mount, vmName string) error {
+	if mount.IsTmpfs() {
+		return errTmpfsHasNoImage(mount)
+	}
+	if Shareable(mount) {
+		return fmt.Errorf("mount '%s' uses a shared image that other VMs may have open; sync it to move it to a new image", mount.GuestTag)
+	}
+	if err := m.CanRecreateImage(mount); err != nil {
+		return fmt.Errorf("cannot recreate mount image for '%s': %w", mount.GuestTag, err)
+	}
+
+	imagePath := m.GetMountImagePath(vmName, mount.GuestTag)
+	unlock, err := m.lockImage(imagePath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if pid, comm := imageHolder(imagePath); pid != 0 {
+		return fmt.Errorf("%w: %s is open in %s (PID %d); stop the VM before recreating mount '%s'",
+			ErrImageBusy, imagePath, comm, pid, mount.GuestTag)
+	}
+	if err := closeEncrypted(imagePath); err != nil {
+		return err
+	}
+
+	// Fingerprint the sources before copying, as a sync does, so changes made during the copy are picked up later
+	var sources string
+	if !mount.IsArchive() {
+		mode, err := ParseSyncMode(mount.SyncMode)
+		if err != nil {
+			return err
+		}
+		layers, err := m.sourceLayers(mount)
+		if err != nil {
+			return err
+		}
+		if sources, err = sourceFingerprint(layers, mode); err != nil {
+			return err
+		}
+	}
+
+	name := fmt.Sprintf("Recreating mount image for '%s' (not a repair: changes made in the guest are lost)", mount.GuestTag)
+	return progress.Run(m.progress(), name, func() error {
+		if err := os.Remove(imagePath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove mount image: %w", err)
+		}
+		os.Remove(syncWorkPath(imagePath))
+		removeFingerprint(imagePath)
+		if err := m.createMountImage(mount, vmName); err != nil {
+			return err
+		}
+		if sources != "" {
+			recordSync(imagePath, sources)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,56 @@
+package mount
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// syncWorkSuffix is appended to an image path to name the copy a sync works on
+const syncWorkSuffix = ".sync"
+
+// syncWorkPath returns where a sync writes its copy of an image
+func syncWorkPath(imagePath string) string {
+	return imagePath + syncWorkSuffix
+}
+
+// replaceImage applies modify to a copy of an image and renames the copy over the image once
+// modify succeeds, so a sync that fails or is killed part way leaves the previous image intact.
+// A copy left behind by an interrupted sync is discarded. The caller must hold the image lock
+func replaceImage(ctx context.Context, imagePath string, modify func(workPath string) error) error {
+	workPath := syncWorkPath(imagePath)
+	os.Remove(workPath)
+
+	// The copy stays sparse, and shares blocks with the image on filesystems that support reflinks
+	if output, err := exec.CommandContext(ctx, "cp", "--reflink=auto", "--sparse=always", imagePath, workPath).CombinedOutput(); err != nil {
+		os.Remove(workPath)
+		return fmt.Errorf("failed to copy mount image: %w: %s", err, string(output))
+	}
+	if err := modify(workPath); err != nil {
+		os.Remove(workPath)
+		return err
+	}
+	if err := syncFile(workPath); err != nil {
+		os.Remove(workPath)
+		return err
+	}
+	if err := os.Rename(workPath, imagePath); err != nil {
+		os.Remove(workPath)
+		return fmt.Errorf("failed to replace mount image: %w", err)
+	}
+	return nil
+}
+
+// syncFile flushes a file to disk, so a rename of it can't be persisted ahead of its contents
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to flush %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package mount
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// killSyncImageEnv names the image TestReplaceImageKilledSyncHelper syncs before killing itself
+const killSyncImageEnv = "VMM_TEST_KILL_SYNC_IMAGE"
+
+func TestReplaceImageSurvivesKilledSync(t *testing.T) {
+	imagePath := filepath.Join(t.TempDir(), "vm1-data.ext4")
+	if err := os.WriteFile(imagePath, []byte("old data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sync runs in a child process so it can die without any cleanup running
+	cmd := exec.Command(os.Args[0], "-test.run=^TestReplaceImageKilledSyncHelper$")
+	cmd.Env = append(os.Environ(), killSyncImageEnv+"="+imagePath)
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Sys().(syscall.WaitStatus).Signal() != syscall.SIGKILL {
+		t.Fatalf("sync was not killed: %v", err)
+	}
+
+	if data, _ := os.ReadFile(imagePath); string(data) != "old data" {
+		t.Fatalf("image holds %q after the killed sync, want the old data", data)
+	}
+	if _, err := os.Stat(syncWorkPath(imagePath)); err != nil {
+		t.Fatalf("killed sync left no working copy: %v", err)
+	}
+
+	// The next sync discards the stale copy and replaces the image
+	if err := replaceImage(t.Context(), imagePath, func(workPath string) error {
+		return os.WriteFile(workPath, []byte("new data"), 0644)
+	}); err != nil {
+		t.Fatalf("replaceImage: %v", err)
+	}
+	if data, _ := os.ReadFile(imagePath); string(data) != "new data" {
+		t.Fatalf("image holds %q, want the new data", data)
+	}
+	if _, err := os.Stat(syncWorkPath(imagePath)); !os.IsNotExist(err) {
+		t.Fatal("working copy left behind after a completed sync")
+	}
+}
+
+// TestReplaceImageKilledSyncHelper wipes the working copy, starts copying, and is killed part way
+func TestReplaceImageKilledSyncHelper(t *testing.T) {
+	imagePath := os.Getenv(killSyncImageEnv)
+	if imagePath == "" {
+		t.Skip("run by TestReplaceImageSurvivesKilledSync")
+	}
+	replaceImage(t.Context(), imagePath, func(workPath string) error {
+		if err := os.WriteFile(workPath, []byte("new"), 0644); err != nil {
+			return err
+		}
+		syscall.Kill(os.Getpid(), syscall.SIGKILL)
+		select {}
+	})
+}
@@ -0,0 +1,205 @@
+package mount
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/raesene/baremetalvmm/internal/progress"
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+const (
+	// sharedDir is the subdirectory of the mounts directory holding images used by several VMs
+	sharedDir = "shared"
+	// refsSuffix is appended to a shared image path to name the file listing the mounts using it
+	refsSuffix = ".refs"
+)
+
+// Shareable reports whether a mount uses a shared image
+// Only read-only copies of host directories can be shared; a writable mount keeps its own copy
+func Shareable(mount *vm.Mount) bool {
+	return mount.Shared && mount.ReadOnly && !mount.IsTmpfs() && !mount.IsArchive() && !mount.Encrypted
+}
+
+// sharedKey identifies the content of a shared image: the state of its sources and how it is formatted
+// Mounts of the same unchanged host directories with the same filesystem options get the same key
+func sharedKey(mount *vm.Mount, layers []sourceLayer) (string, error) {
+	sources, err := sourceFingerprint(layers, SyncModeMirror)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%d %d %q\n", sources, mount.InodeRatio, mount.BlockSize, mount.MkfsOptions)
+	if mount.ReservedBlocksPercent != 0 {
+		fmt.Fprintf(h, "reserved %d\n", mount.ReservedBlocksPercent)
+	}
+	if mount.IsVFAT() {
+		fmt.Fprintf(h, "fs %s\n", mount.Filesystem)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// sharedImagePath returns where the shared image with the given key is stored
+func (m *Manager) sharedImagePath(key string) string {
+	return filepath.Join(m.MountsDir, sharedDir, key+".ext4")
+}
+
+// IsSharedImage reports whether an image path is one of the manager's shared images
+func (m *Manager) IsSharedImage(imagePath string) bool {
+	return imagePath != "" && filepath.Dir(imagePath) == filepath.Join(m.MountsDir, sharedDir)
+}
+
+// sharedRef names a mount's reference to a shared image
+func sharedRef(vmName, guestTag string) string {
+	return vmName + "/" + guestTag
+}
+
+// prepareSharedImage points a mount at the shared image for the current content of its sources,
+// building it if no VM uses it yet, and releases the image the mount used before
+func (m *Manager) prepareSharedImage(mount *vm.Mount, vmName string) error {
+	layers, err := m.sourceLayers(mount)
+	if err != nil {
+		return err
+	}
+	key, err := sharedKey(mount, layers)
+	if err != nil {
+		return err
+	}
+	imagePath := m.sharedImagePath(key)
+	if err := os.MkdirAll(filepath.Dir(imagePath), 0755); err != nil {
+		return fmt.Errorf("failed to create shared mounts directory: %w", err)
+	}
+
+	unlock, err := m.lockImage(imagePath)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
+		if err := ValidateMkfsOptions(mount); err != nil {
+			unlock()
+			return err
+		}
+		if err := m.buildImage(mount, layers, imagePath); err != nil {
+			unlock()
+			return err
+		}
+	}
+	ref := sharedRef(vmName, mount.GuestTag)
+	err = progress.Run(m.progress(), fmt.Sprintf("Attaching shared mount image for '%s'", mount.GuestTag), func() error {
+		return addRef(imagePath, ref)
+	})
+	unlock()
+	if err != nil {
+		return err
+	}
+
+	previous := mount.ImagePath
+	mount.ImagePath = imagePath
+	switch {
+	case previous == imagePath:
+		return nil
+	case m.IsSharedImage(previous):
+		// The sources changed since the last start, so the old content is no longer wanted here
+		return m.releaseSharedImage(previous, vmName, mount.GuestTag)
+	default:
+		// The mount was just made shared; its per-VM copy is no longer used
+		return m.DeleteMountImage(vmName, mount.GuestTag)
+	}
+}
+
+// releaseSharedImage drops a mount's reference to a shared image, deleting the image with its last reference
+func (m *Manager) releaseSharedImage(imagePath, vmName, guestTag string) error {
+	unlock, err := m.lockImage(imagePath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	refs, err := readRefs(imagePath)
+	if err != nil {
+		return err
+	}
+	ref := sharedRef(vmName, guestTag)
+	remaining := refs[:0]
+	for _, r := range refs {
+		if r != ref {
+			remaining = append(remaining, r)
+		}
+	}
+	if len(remaining) > 0 {
+		return writeRefs(imagePath, remaining)
+	}
+
+	if err := os.Remove(imagePath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove shared mount image: %w", err)
+	}
+	os.Remove(imagePath + refsSuffix)
+	removeFingerprint(imagePath)
+	m.removeLockFile(imagePath)
+	return nil
+}
+
+// RenameSharedRef moves a mount's reference to a shared image to its new tag
+func (m *Manager) RenameSharedRef(imagePath, vmName, oldTag, newTag string) error {
+	unlock, err := m.lockImage(imagePath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	refs, err := readRefs(imagePath)
+	if err != nil {
+		return err
+	}
+	oldRef := sharedRef(vmName, oldTag)
+	for i, r := range refs {
+		if r == oldRef {
+			refs[i] = sharedRef(vmName, newTag)
+		}
+	}
+	return writeRefs(imagePath, refs)
+}
+
+// SharedImageUsers returns the VM/tag references holding a shared image
+func SharedImageUsers(imagePath string) ([]string, error) {
+	return readRefs(imagePath)
+}
+
+// addRef records a reference to a shared image; the caller must hold the image lock
+func addRef(imagePath, ref string) error {
+	refs, err := readRefs(imagePath)
+	if err != nil {
+		return err
+	}
+	for _, r := range refs {
+		if r == ref {
+			return nil
+		}
+	}
+	return writeRefs(imagePath, append(refs, ref))
+}
+
+// readRefs loads the references to a shared image; a missing file means none
+func readRefs(imagePath string) ([]string, error) {
+	data, err := os.ReadFile(imagePath + refsSuffix)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shared image references: %w", err)
+	}
+	return strings.Fields(string(data)), nil
+}
+
+// writeRefs replaces the references to a shared image
+func writeRefs(imagePath string, refs []string) error {
+	data := strings.Join(refs, "\n") + "\n"
+	if err := os.WriteFile(imagePath+refsSuffix, []byte(data), 0644); err != nil {
+		return fmt.Errorf("failed to write shared image references: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package mount
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSharedImageDeletedWithLastReference(t *testing.T) {
+	m := NewManager(t.TempDir())
+	imagePath := m.sharedImagePath("0123456789abcdef")
+	if !m.IsSharedImage(imagePath) {
+		t.Fatalf("%s not recognised as a shared image", imagePath)
+	}
+	if m.IsSharedImage(m.GetMountImagePath("vm1", "data")) {
+		t.Fatal("per-VM image recognised as a shared image")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(imagePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(imagePath, []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, ref := range []string{"vm1/data", "vm2/data", "vm1/data"} {
+		if err := addRef(imagePath, ref); err != nil {
+			t.Fatalf("addRef: %v", err)
+		}
+	}
+	if users, _ := SharedImageUsers(imagePath); len(users) != 2 {
+		t.Fatalf("got users %v, want vm1/data and vm2/data once each", users)
+	}
+
+	if err := m.RenameSharedRef(imagePath, "vm2", "data", "ref"); err != nil {
+		t.Fatalf("RenameSharedRef: %v", err)
+	}
+	if err := m.releaseSharedImage(imagePath, "vm1", "data"); err != nil {
+		t.Fatalf("releaseSharedImage: %v", err)
+	}
+	if _, err := os.Stat(imagePath); err != nil {
+		t.Fatal("image deleted while vm2 still uses it")
+	}
+	if err := m.releaseSharedImage(imagePath, "vm2", "ref"); err != nil {
+		t.Fatalf("releaseSharedImage: %v", err)
+	}
+	for _, path := range []string{imagePath, imagePath + refsSuffix, lockFilePath(imagePath)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s left behind after the last reference was released", path)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package mount
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// MountStatus describes one of a VM's mounts and the state of its image
+type MountStatus struct {
+	Tag            string
+	Source         string // Host directories, archive or tmpfs size
+	Mode           string // vm.MountModeImage, vm.MountModeTmpfs or vm.MountModeArchive
+	ReadOnly       bool
+	Encrypted      bool
+	Shared         bool
+	ImagePath      string // Empty for tmpfs mounts and images not created yet
+	ImageExists    bool
+	ImageBytes     int64  // Apparent size of the image file
+	AllocatedBytes int64  // Host disk the sparse image file occupies
+	DriveID        string // Firecracker drive the mount was attached as at the last start
+	Attached       bool   // Whether the running VM has the mount
+}
+
+// MountStatus reports the state of each of a VM's mounts
+// The VM's state should be current (see firecracker.Client.UpdateVMState). For a running VM,
+// a mount is attached if the last start gave it a drive; a tmpfs lives in the guest and is
+// always attached. A stopped VM has nothing attached and only its images are inspected.
+// This is a Manager method rather than one on vm.VM because package vm can't import mount
+func (m *Manager) MountStatus(v *vm.VM) ([]MountStatus, error) {
+	running := v.State == vm.StateRunning
+	statuses := make([]MountStatus, 0, len(v.Mounts))
+	for _, mount := range v.Mounts {
+		status := MountStatus{
+			Tag:       mount.GuestTag,
+			Source:    mount.SourceDescription(),
+			Mode:      mount.EffectiveMode(),
+			ReadOnly:  mount.ReadOnly,
+			Encrypted: mount.Encrypted,
+			Shared:    m.IsSharedImage(mount.ImagePath),
+			ImagePath: mount.ImagePath,
+		}
+		if mount.IsTmpfs() {
+			status.Attached = running
+			statuses = append(statuses, status)
+			continue
+		}
+
+		if status.ImagePath == "" {
+			status.ImagePath = m.GetMountImagePath(v.Name, mount.GuestTag)
+		}
+		info, err := os.Stat(status.ImagePath)
+		switch {
+		case err == nil:
+			status.ImageExists = true
+			status.ImageBytes = info.Size()
+			status.AllocatedBytes = info.Size()
+			if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+				status.AllocatedBytes = stat.Blocks * 512
+			}
+		case os.IsNotExist(err):
+			status.ImagePath = mount.ImagePath
+		default:
+			return nil, fmt.Errorf("failed to inspect image for mount '%s': %w", mount.GuestTag, err)
+		}
+
+		status.DriveID = v.MountDriveIDs[mount.GuestTag]
+		status.Attached = running && status.DriveID != ""
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
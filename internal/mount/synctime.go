@@ -0,0 +1,40 @@
+package mount
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/raesene/baremetalvmm/internal/progress"
+)
+
+// timed runs an image create or sync, given a copy of the manager whose commands (tar, cp,
+// mkfs.ext4, e2fsck, resize2fs) are killed once MaxSyncDuration has passed; the error is then
+// ErrSyncTimeout. How long it took is reported through the manager's Progress as name
+func (m *Manager) timed(name string, fn func(op *Manager) error) error {
+	ctx := context.Background()
+	if m.MaxSyncDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.MaxSyncDuration)
+		defer cancel()
+	}
+	op := *m
+	op.ctx = ctx
+
+	start := time.Now()
+	err := fn(&op)
+	progress.ReportDuration(m.progress(), name, time.Since(start))
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %s took longer than %s: %w", ErrSyncTimeout, name, m.MaxSyncDuration, err)
+	}
+	return err
+}
+
+// context returns the context of the create or sync in progress
+func (m *Manager) context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
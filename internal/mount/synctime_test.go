@@ -0,0 +1,33 @@
+package mount
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestTimedCancelsCommandsPastMaxSyncDuration(t *testing.T) {
+	m := NewManager(t.TempDir())
+	m.MaxSyncDuration = 100 * time.Millisecond
+
+	start := time.Now()
+	err := m.timed("test sync", func(op *Manager) error {
+		return exec.CommandContext(op.context(), "sleep", "10").Run()
+	})
+	if !errors.Is(err, ErrSyncTimeout) {
+		t.Fatalf("err = %v, want ErrSyncTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("command ran for %s past the deadline", elapsed)
+	}
+}
+
+func TestTimedWithoutLimit(t *testing.T) {
+	m := NewManager(t.TempDir())
+	if err := m.timed("test sync", func(op *Manager) error {
+		return op.context().Err()
+	}); err != nil {
+		t.Errorf("timed without MaxSyncDuration: %v", err)
+	}
+}
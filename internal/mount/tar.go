@@ -0,0 +1,214 @@
+package mount
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/raesene/baremetalvmm/internal/progress"
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// tarCompression identifies how a tar archive is compressed
+type tarCompression struct {
+	name string
+	flag string // tar flag that selects the decompressor
+}
+
+var (
+	compressionNone = tarCompression{name: "none"}
+	compressionGzip = tarCompression{name: "gzip", flag: "-z"}
+	compressionXz   = tarCompression{name: "xz", flag: "-J"}
+	compressionZstd = tarCompression{name: "zstd", flag: "--zstd"}
+)
+
+// compressionMagic maps the leading bytes of a compressed file to its compression
+var compressionMagic = []struct {
+	magic       []byte
+	compression tarCompression
+}{
+	{[]byte{0x1f, 0x8b}, compressionGzip},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, compressionXz},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, compressionZstd},
+}
+
+// CreateMountImageFromTar creates an ext4 mount image for a VM and extracts a tar archive into it
+// gzip, xz and zstd compressed archives are detected automatically. sizeMB may be 0 for
+// uncompressed archives, in which case the image is sized from the archive
+func (m *Manager) CreateMountImageFromTar(tarPath, vmName, guestTag string, sizeMB int) error {
+	return m.CreateMountImage(&vm.Mount{
+		Mode:          vm.MountModeArchive,
+		ArchivePath:   tarPath,
+		ArchiveSizeMB: sizeMB,
+		GuestTag:      guestTag,
+	}, vmName)
+}
+
+// createArchiveImage builds an archive mount's image; the caller must hold the image lock
+func (m *Manager) createArchiveImage(mount *vm.Mount, vmName string) error {
+	if mount.Encrypted {
+		return fmt.Errorf("mount '%s': archive mounts cannot be encrypted", mount.GuestTag)
+	}
+	if err := ValidateTag(mount.GuestTag); err != nil {
+		return err
+	}
+	if err := ValidateMkfsOptions(mount); err != nil {
+		return err
+	}
+
+	info, compression, err := inspectArchive(mount.ArchivePath)
+	if err != nil {
+		return err
+	}
+	if err := checkTarNotEmpty(mount.ArchivePath, compression); err != nil {
+		return err
+	}
+
+	sizeMB, err := archiveImageSizeMB(mount, info.Size(), compression)
+	if err != nil {
+		return err
+	}
+	// A compressed archive's contents are only bounded by the image size
+	need := int64(sizeMB) << 20
+	if compression == compressionNone {
+		need = info.Size()
+	}
+	if err := m.checkQuota(need); err != nil {
+		return fmt.Errorf("cannot create mount image for '%s': %w", mount.GuestTag, err)
+	}
+
+	imagePath := m.GetMountImagePath(vmName, mount.GuestTag)
+	mount.ImagePath = imagePath
+
+	// Extraction dominates the time taken, so progress is reported against the archive's size on disk
+	name := fmt.Sprintf("Creating mount image for '%s' from %s (%d MB)", mount.GuestTag, mount.ArchivePath, sizeMB)
+	m.progress().Start(name, info.Size())
+	err = m.writeArchiveImage(mount, compression, imagePath, sizeMB)
+	m.progress().Done(name, err)
+	return err
+}
+
+// writeArchiveImage creates a sparse image file of sizeMB, formats it and extracts the archive into it
+func (m *Manager) writeArchiveImage(mount *vm.Mount, compression tarCompression, imagePath string, sizeMB int) error {
+	// Create a sparse file
+	if err := exec.Command("truncate", "-s", fmt.Sprintf("%dM", sizeMB), imagePath).Run(); err != nil {
+		return fmt.Errorf("failed to create image file: %w", err)
+	}
+
+	// Create ext4 filesystem
+	if output, err := m.runFSCommand("mkfs.ext4", mkfsArgs(mount, imagePath)...); err != nil {
+		os.Remove(imagePath)
+		return fmt.Errorf("%w: %w: %s", ErrMkfsFailed, err, string(output))
+	}
+
+	if err := m.extractTarToImage(mount.ArchivePath, compression, imagePath); err != nil {
+		os.Remove(imagePath)
+		return fmt.Errorf("failed to extract archive to mount image: %w", err)
+	}
+
+	return nil
+}
+
+// inspectArchive checks that an archive is a regular file and detects its compression
+func inspectArchive(tarPath string) (os.FileInfo, tarCompression, error) {
+	info, err := os.Stat(tarPath)
+	if err != nil {
+		return nil, compressionNone, fmt.Errorf("archive '%s' does not exist: %w", tarPath, err)
+	}
+	if info.IsDir() {
+		return nil, compressionNone, fmt.Errorf("archive '%s' is a directory", tarPath)
+	}
+	compression, err := detectCompression(tarPath)
+	if err != nil {
+		return nil, compressionNone, err
+	}
+	return info, compression, nil
+}
+
+// extractTarToImage mounts an image and extracts an archive into it
+// The bytes of the archive read so far are reported as the current operation's progress
+func (m *Manager) extractTarToImage(tarPath string, compression tarCompression, imagePath string) error {
+	archive, err := os.Open(tarPath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer archive.Close()
+
+	return m.withLoopMount(imagePath, false, func(mountPoint string) error {
+		extract := exec.CommandContext(m.context(), "tar", tarExtractArgs(compression, mountPoint)...)
+		extract.Stdin = progress.NewReader(archive, m.progress())
+		if output, err := extract.CombinedOutput(); err != nil {
+			return fmt.Errorf("tar failed: %w: %s", err, string(output))
+		}
+		return nil
+	})
+}
+
+// detectCompression identifies an archive's compression from its leading bytes
+func detectCompression(path string) (tarCompression, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return compressionNone, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+
+	header := make([]byte, 6)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return compressionNone, fmt.Errorf("failed to read archive: %w", err)
+	}
+	header = header[:n]
+
+	for _, c := range compressionMagic {
+		if bytes.HasPrefix(header, c.magic) {
+			return c.compression, nil
+		}
+	}
+	return compressionNone, nil
+}
+
+// checkTarNotEmpty lists the archive and fails if it is unreadable or has no entries
+func checkTarNotEmpty(tarPath string, compression tarCompression) error {
+	list := exec.Command("tar", tarArgs("-t", compression, tarPath)...)
+	stdout, err := list.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create tar pipe: %w", err)
+	}
+	var stderr bytes.Buffer
+	list.Stderr = &stderr
+	if err := list.Start(); err != nil {
+		return fmt.Errorf("failed to list archive: %w", err)
+	}
+
+	// One entry is enough; stop tar instead of listing a large archive in full
+	hasEntry := bufio.NewScanner(stdout).Scan()
+	if hasEntry {
+		list.Process.Kill()
+		list.Wait()
+		return nil
+	}
+	if err := list.Wait(); err != nil {
+		return fmt.Errorf("failed to read archive '%s': %w: %s", tarPath, err, stderr.String())
+	}
+	return fmt.Errorf("archive '%s' is empty", tarPath)
+}
+
+// tarExtractArgs builds the tar arguments that extract an archive from stdin into dstDir
+// Leave the image's lost+found alone whether or not archive members have a ./ prefix
+func tarExtractArgs(compression tarCompression, dstDir string) []string {
+	return tarArgs("-x", compression, "-", "--anchored",
+		"--exclude=./"+lostAndFound, "--exclude="+lostAndFound, "-C", dstDir)
+}
+
+// tarArgs builds tar arguments for an operation on a possibly compressed archive ("-" reads stdin)
+func tarArgs(operation string, compression tarCompression, archive string, rest ...string) []string {
+	args := []string{operation}
+	if compression.flag != "" {
+		args = append(args, compression.flag)
+	}
+	args = append(args, "-f", archive)
+	return append(args, rest...)
+}
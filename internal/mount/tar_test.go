@@ -0,0 +1,153 @@
+package mount
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeFiles creates each relative path under root as a small file
+func writeFiles(t *testing.T, root string, paths ...string) {
+	t.Helper()
+	for _, rel := range paths {
+		path := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(rel), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// assertExists fails unless each relative path exists under root
+func assertExists(t *testing.T, root string, paths ...string) {
+	t.Helper()
+	for _, rel := range paths {
+		if _, err := os.Lstat(filepath.Join(root, rel)); err != nil {
+			t.Errorf("%s was not copied: %v", rel, err)
+		}
+	}
+}
+
+// assertMissing fails if any relative path exists under root
+func assertMissing(t *testing.T, root string, paths ...string) {
+	t.Helper()
+	for _, rel := range paths {
+		if _, err := os.Lstat(filepath.Join(root, rel)); err == nil {
+			t.Errorf("%s should have been excluded", rel)
+		}
+	}
+}
+
+func requireTar(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not installed")
+	}
+}
+
+func TestTarArgsAnchorLostAndFoundExclusion(t *testing.T) {
+	create := tarCreateArgs("/src", false)
+	want := []string{"-cf", "-", "--anchored", "--exclude=./lost+found", "-C", "/src", "."}
+	if !slices.Equal(create, want) {
+		t.Fatalf("tarCreateArgs = %q, want %q", create, want)
+	}
+
+	extract := tarExtractArgs(compressionZstd, "/mnt")
+	want = []string{"-x", "--zstd", "-f", "-", "--anchored", "--exclude=./lost+found", "--exclude=lost+found", "-C", "/mnt"}
+	if !slices.Equal(extract, want) {
+		t.Fatalf("tarExtractArgs = %q, want %q", extract, want)
+	}
+}
+
+func TestTarCopySkipsOnlyTopLevelLostAndFound(t *testing.T) {
+	requireTar(t)
+	src, dst := t.TempDir(), t.TempDir()
+	writeFiles(t, src, "lost+found/orphan", "data/lost+found/kept", "data/file")
+
+	m := &Manager{}
+	if err := m.tarCopy(src, dst, "test", 0, false); err != nil {
+		t.Fatalf("tarCopy: %v", err)
+	}
+
+	assertExists(t, dst, "data/file", "data/lost+found/kept")
+	assertMissing(t, dst, "lost+found")
+}
+
+func TestTarExtractSkipsOnlyTopLevelLostAndFound(t *testing.T) {
+	requireTar(t)
+	for _, prefix := range []string{"./", ""} {
+		t.Run("prefix="+prefix, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			writeFiles(t, src, "lost+found/orphan", "data/lost+found/kept", "data/file")
+
+			members := []string{prefix + "lost+found", prefix + "data"}
+			archivePath := filepath.Join(t.TempDir(), "archive.tar")
+			create := exec.Command("tar", append([]string{"-cf", archivePath, "-C", src}, members...)...)
+			if output, err := create.CombinedOutput(); err != nil {
+				t.Fatalf("creating archive: %v: %s", err, output)
+			}
+
+			archive, err := os.Open(archivePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer archive.Close()
+			extract := exec.Command("tar", tarExtractArgs(compressionNone, dst)...)
+			extract.Stdin = archive
+			if output, err := extract.CombinedOutput(); err != nil {
+				t.Fatalf("extracting archive: %v: %s", err, output)
+			}
+
+			assertExists(t, dst, "data/file", "data/lost+found/kept")
+			assertMissing(t, dst, "lost+found")
+		})
+	}
+}
+
+func TestEnsureLostAndFound(t *testing.T) {
+	if _, err := exec.LookPath("mklost+found"); err != nil {
+		t.Skip("mklost+found not installed")
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := ensureLostAndFound(dir); err != nil {
+			t.Fatalf("ensureLostAndFound: %v", err)
+		}
+		assertDir(t, filepath.Join(dir, lostAndFound))
+	})
+
+	t.Run("replaced by a file", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFiles(t, dir, lostAndFound)
+		if err := ensureLostAndFound(dir); err != nil {
+			t.Fatalf("ensureLostAndFound: %v", err)
+		}
+		assertDir(t, filepath.Join(dir, lostAndFound))
+	})
+
+	t.Run("existing directory is kept", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFiles(t, dir, "lost+found/#1234")
+		if err := ensureLostAndFound(dir); err != nil {
+			t.Fatalf("ensureLostAndFound: %v", err)
+		}
+		assertExists(t, dir, "lost+found/#1234")
+	})
+}
+
+// assertDir fails unless path is a directory
+func assertDir(t *testing.T, path string) {
+	t.Helper()
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+	if !info.IsDir() {
+		t.Fatalf("%s is not a directory", path)
+	}
+}
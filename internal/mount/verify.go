@@ -0,0 +1,183 @@
+package mount
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/raesene/baremetalvmm/internal/progress"
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// maxReportedPaths limits how many paths of each kind a verify error lists
+const maxReportedPaths = 5
+
+// VerifyResult describes how the contents of a mount image compare with its host directories
+type VerifyResult struct {
+	ExpectedFiles int
+	ExpectedBytes int64
+	ActualFiles   int
+	ActualBytes   int64
+	Missing       []string // Files present on the host but not in the image
+	Extra         []string // Files present in the image but not on the host
+	SizeMismatch  []string // Files whose size differs between host and image
+}
+
+// OK reports whether the image matched its source
+func (r *VerifyResult) OK() bool {
+	return len(r.Missing) == 0 && len(r.Extra) == 0 && len(r.SizeMismatch) == 0
+}
+
+// Err returns an error summarizing the discrepancies, or nil if the image matched
+func (r *VerifyResult) Err() error {
+	if r.OK() {
+		return nil
+	}
+	var details []string
+	if len(r.Missing) > 0 {
+		details = append(details, fmt.Sprintf("%d missing (%s)", len(r.Missing), summarizePaths(r.Missing)))
+	}
+	if len(r.Extra) > 0 {
+		details = append(details, fmt.Sprintf("%d unexpected (%s)", len(r.Extra), summarizePaths(r.Extra)))
+	}
+	if len(r.SizeMismatch) > 0 {
+		details = append(details, fmt.Sprintf("%d with wrong size (%s)", len(r.SizeMismatch), summarizePaths(r.SizeMismatch)))
+	}
+	return fmt.Errorf("mount image does not match source: expected %d files (%d bytes), found %d files (%d bytes): %s",
+		r.ExpectedFiles, r.ExpectedBytes, r.ActualFiles, r.ActualBytes, strings.Join(details, "; "))
+}
+
+// VerifyMountImage mounts an existing image read-only and compares it with the mount's host directories
+// Files that exist only in the image are reported as extra, so images synced in merge mode may not match
+func (m *Manager) VerifyMountImage(mount *vm.Mount, vmName string) (*VerifyResult, error) {
+	if mount.IsTmpfs() {
+		return nil, errTmpfsHasNoImage(mount)
+	}
+	if mount.IsArchive() {
+		return nil, fmt.Errorf("mount '%s' is extracted from an archive and has no host directories to verify against", mount.GuestTag)
+	}
+	imagePath := mount.ImagePath
+	if imagePath == "" {
+		imagePath = m.GetMountImagePath(vmName, mount.GuestTag)
+	}
+	if _, err := os.Stat(imagePath); err != nil {
+		return nil, fmt.Errorf("%w: '%s' has none at %s: %w", ErrMountImageNotFound, mount.GuestTag, imagePath, err)
+	}
+
+	unlock, err := m.lockImage(imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	device, closeDevice, err := imageDevice(mount, imagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer closeDevice()
+
+	return m.verifyImage(mount.SourcePaths(), device, false)
+}
+
+// verifyCopy checks a freshly written image, given by the device holding its filesystem, and reports the outcome
+func (m *Manager) verifyCopy(mount *vm.Mount, device string, allowExtra bool) error {
+	return progress.Run(m.progress(), fmt.Sprintf("Verifying mount image for '%s'", mount.GuestTag), func() error {
+		result, err := m.verifyImage(mount.SourcePaths(), device, allowExtra)
+		if err != nil {
+			return fmt.Errorf("failed to verify mount image: %w", err)
+		}
+		if err := result.Err(); err != nil {
+			return err
+		}
+		m.progress().Update(result.ActualBytes)
+		return nil
+	})
+}
+
+// verifyImage loop-mounts an image read-only and compares its files with the layered source directories
+// With allowExtra, files found only in the image are not treated as discrepancies
+func (m *Manager) verifyImage(sourcePaths []string, imagePath string, allowExtra bool) (*VerifyResult, error) {
+	// Later layers replace files at the same path in earlier ones
+	expected := make(map[string]int64)
+	for _, path := range sourcePaths {
+		files, err := listFiles(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan '%s': %w", path, err)
+		}
+		for rel, size := range files {
+			expected[rel] = size
+		}
+	}
+
+	var actual map[string]int64
+	if err := m.withLoopMount(imagePath, true, func(mountPoint string) error {
+		var err error
+		if actual, err = listFiles(mountPoint); err != nil {
+			return fmt.Errorf("failed to scan mount image: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	result := &VerifyResult{}
+	for rel, size := range expected {
+		result.ExpectedFiles++
+		result.ExpectedBytes += size
+		actualSize, ok := actual[rel]
+		if !ok {
+			result.Missing = append(result.Missing, rel)
+		} else if actualSize != size {
+			result.SizeMismatch = append(result.SizeMismatch, rel)
+		}
+	}
+	for rel, size := range actual {
+		result.ActualFiles++
+		result.ActualBytes += size
+		if _, ok := expected[rel]; !ok && !allowExtra {
+			result.Extra = append(result.Extra, rel)
+		}
+	}
+
+	sort.Strings(result.Missing)
+	sort.Strings(result.Extra)
+	sort.Strings(result.SizeMismatch)
+	return result, nil
+}
+
+// listFiles returns the size of every non-directory entry under root, keyed by relative path
+// The top-level lost+found directory is skipped
+func listFiles(root string) (map[string]int64, error) {
+	files := make(map[string]int64)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if rel == lostAndFound {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		files[rel] = info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// summarizePaths joins the first few paths for an error message
+func summarizePaths(paths []string) string {
+	if len(paths) <= maxReportedPaths {
+		return strings.Join(paths, ", ")
+	}
+	return fmt.Sprintf("%s, and %d more", strings.Join(paths[:maxReportedPaths], ", "), len(paths)-maxReportedPaths)
+}
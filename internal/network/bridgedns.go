@@ -0,0 +1,99 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// BridgeDomain is the DNS domain VM names are served under on the bridge
+const BridgeDomain = "vmm"
+
+// BridgeHost is a VM known to the bridge's DNS and DHCP service
+type BridgeHost struct {
+	Name string
+	IP   string
+	MAC  string
+}
+
+// EnsureBridgeDNS runs dnsmasq on the bridge so VMs can resolve each other as <name> and
+// <name>.vmm, and so guests that use DHCP get their leased address. Its hosts files and pid file
+// live in dir. A dnsmasq already running from dir is sent SIGHUP to reread the hosts; otherwise
+// one is started. Queries for other names are forwarded to the host's resolvers
+func (m *Manager) EnsureBridgeDNS(dir string, hosts []BridgeHost) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	hostsPath := filepath.Join(dir, "hosts")
+	dhcpPath := filepath.Join(dir, "dhcp-hosts")
+	pidPath := filepath.Join(dir, "dnsmasq.pid")
+
+	var hostLines, dhcpLines strings.Builder
+	for _, h := range hosts {
+		if h.IP == "" {
+			continue
+		}
+		fmt.Fprintf(&hostLines, "%s %s\n", h.IP, h.Name)
+		if h.MAC != "" {
+			fmt.Fprintf(&dhcpLines, "%s,%s,%s\n", h.MAC, h.IP, h.Name)
+		}
+	}
+	if err := os.WriteFile(hostsPath, []byte(hostLines.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write bridge hosts: %w", err)
+	}
+	if err := os.WriteFile(dhcpPath, []byte(dhcpLines.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write bridge DHCP hosts: %w", err)
+	}
+
+	if pid := runningDnsmasq(pidPath); pid > 0 {
+		if err := syscall.Kill(pid, syscall.SIGHUP); err != nil {
+			return fmt.Errorf("failed to reload dnsmasq: %w", err)
+		}
+		return nil
+	}
+
+	_, ipnet, err := net.ParseCIDR(m.Subnet)
+	if err != nil {
+		return fmt.Errorf("invalid subnet: %w", err)
+	}
+	err = m.runCmd("dnsmasq",
+		"--interface="+m.BridgeName,
+		"--bind-interfaces",
+		"--except-interface=lo",
+		"--pid-file="+pidPath,
+		"--no-hosts",
+		"--addn-hosts="+hostsPath,
+		"--expand-hosts",
+		"--domain="+BridgeDomain,
+		"--local=/"+BridgeDomain+"/",
+		"--dhcp-range="+ipnet.IP.String()+",static,"+net.IP(ipnet.Mask).String(),
+		"--dhcp-hostsfile="+dhcpPath,
+		"--dhcp-option=option:router,"+m.Gateway,
+		"--dhcp-option=option:dns-server,"+m.Gateway,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to start dnsmasq: %w", err)
+	}
+	return nil
+}
+
+// runningDnsmasq returns the pid of the dnsmasq recorded in pidPath, or 0 if it isn't running
+func runningDnsmasq(pidPath string) int {
+	data, err := os.ReadFile(pidPath)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0
+	}
+	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil || strings.TrimSpace(string(comm)) != "dnsmasq" {
+		return 0
+	}
+	return pid
+}
@@ -0,0 +1,172 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/raesene/baremetalvmm/internal/vm"
+)
+
+// Layers a connectivity test can find failing, lowest first
+const (
+	LayerLink    = "link"
+	LayerIP      = "ip"
+	LayerRouting = "routing"
+)
+
+// ConnResult is what TestConnectivity found, layer by layer
+type ConnResult struct {
+	TapExists   bool
+	TapUp       bool
+	TapOnBridge bool
+	BridgeUp    bool
+	// RxPackets counts packets the guest sent into the TAP, TxPackets those the host delivered to it
+	RxPackets uint64
+	TxPackets uint64
+
+	GuestMAC string // The guest's MAC from the host's neighbour table, empty if ARP didn't resolve
+	PingOK   bool
+
+	RouteDevice   string // The device the host routes the guest IP through
+	ForwardingOn  bool   // net.ipv4.ip_forward is enabled
+	MasqueradeSet bool   // The NAT rule for outbound guest traffic is in place
+
+	// FailedLayer is the lowest layer that failed (LayerLink, LayerIP or LayerRouting), empty if none did
+	FailedLayer string
+	// Problems explains each failed check, lowest layer first
+	Problems []string
+}
+
+// OK reports whether every check passed
+func (r *ConnResult) OK() bool {
+	return r.FailedLayer == ""
+}
+
+// fail records a failed check
+func (r *ConnResult) fail(layer, format string, args ...any) {
+	if r.FailedLayer == "" {
+		r.FailedLayer = layer
+	}
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// TestConnectivity checks from the host whether a running VM's network works: the link (TAP
+// device up and on the bridge, packets flowing), the IP layer (the guest answers ARP and ping)
+// and routing (the host routes the guest IP through the bridge and forwards and NATs its
+// outbound traffic). Every check runs, so the result shows all that is wrong, not just the first
+func (m *Manager) TestConnectivity(v *vm.VM) (*ConnResult, error) {
+	if v.TapDevice == "" || v.IPAddress == "" {
+		return nil, fmt.Errorf("VM '%s' has no network configured", v.Name)
+	}
+	if net.ParseIP(v.IPAddress) == nil {
+		return nil, fmt.Errorf("VM '%s' has an invalid IP address '%s'", v.Name, v.IPAddress)
+	}
+	r := &ConnResult{}
+
+	// Probe first: the ping makes the host resolve the guest's MAC and draws a reply through the
+	// TAP, so the neighbour table and packet counters read below reflect a fresh exchange
+	r.PingOK = exec.Command("ping", "-c", "1", "-W", "1", v.IPAddress).Run() == nil
+
+	// Link
+	r.TapExists = m.TapExists(v.TapDevice)
+	if !r.TapExists {
+		r.fail(LayerLink, "TAP device %s does not exist (is the VM running?)", v.TapDevice)
+	} else {
+		r.TapUp = linkUp(v.TapDevice)
+		if !r.TapUp {
+			r.fail(LayerLink, "TAP device %s is down", v.TapDevice)
+		}
+		master, _ := os.Readlink(filepath.Join("/sys/class/net", v.TapDevice, "master"))
+		r.TapOnBridge = filepath.Base(master) == m.BridgeName
+		if !r.TapOnBridge {
+			r.fail(LayerLink, "TAP device %s is not attached to bridge %s", v.TapDevice, m.BridgeName)
+		}
+		r.RxPackets = linkCounter(v.TapDevice, "rx_packets")
+		r.TxPackets = linkCounter(v.TapDevice, "tx_packets")
+		if r.RxPackets == 0 {
+			r.fail(LayerLink, "the guest has sent no packets on %s: check that its network interface is up", v.TapDevice)
+		}
+	}
+	r.BridgeUp = m.bridgeExists() && linkUp(m.BridgeName)
+	if !r.BridgeUp {
+		r.fail(LayerLink, "bridge %s is missing or down", m.BridgeName)
+	}
+
+	// IP
+	r.GuestMAC = neighbourMAC(v.IPAddress, m.BridgeName)
+	if r.GuestMAC == "" {
+		r.fail(LayerIP, "%s does not answer ARP: check the guest's IP configuration", v.IPAddress)
+	}
+	if !r.PingOK {
+		r.fail(LayerIP, "%s does not answer ping", v.IPAddress)
+	}
+
+	// Routing
+	r.RouteDevice = routeDevice(v.IPAddress)
+	if r.RouteDevice != m.BridgeName {
+		r.fail(LayerRouting, "the host routes %s through '%s', not bridge %s", v.IPAddress, r.RouteDevice, m.BridgeName)
+	}
+	forward, _ := os.ReadFile("/proc/sys/net/ipv4/ip_forward")
+	r.ForwardingOn = strings.TrimSpace(string(forward)) == "1"
+	if !r.ForwardingOn {
+		r.fail(LayerRouting, "IP forwarding is disabled, so the guest can't reach other networks")
+	}
+	r.MasqueradeSet = m.runCmd("iptables", "-t", "nat", "-C", "POSTROUTING",
+		"-s", m.Subnet, "-o", m.HostInterface, "-j", "MASQUERADE") == nil
+	if !r.MasqueradeSet {
+		r.fail(LayerRouting, "no NAT rule for %s out of %s, so the guest can't reach other networks", m.Subnet, m.HostInterface)
+	}
+	return r, nil
+}
+
+// linkUp reports whether a network interface is administratively up
+func linkUp(name string) bool {
+	iface, err := net.InterfaceByName(name)
+	return err == nil && iface.Flags&net.FlagUp != 0
+}
+
+// linkCounter reads one of an interface's statistics counters, 0 if unavailable
+func linkCounter(name, counter string) uint64 {
+	data, err := os.ReadFile(filepath.Join("/sys/class/net", name, "statistics", counter))
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	return n
+}
+
+// neighbourMAC returns the MAC the host has resolved for ip on dev, empty if it has none
+func neighbourMAC(ip, dev string) string {
+	output, err := exec.Command("ip", "neigh", "show", ip, "dev", dev).Output()
+	if err != nil {
+		return ""
+	}
+	// e.g. "172.16.0.2 lladdr 06:00:ac:10:00:02 REACHABLE"; FAILED and INCOMPLETE entries have no lladdr
+	fields := strings.Fields(string(output))
+	for i, field := range fields {
+		if field == "lladdr" && i+1 < len(fields) {
+			return fields[i+1]
+		}
+	}
+	return ""
+}
+
+// routeDevice returns the device the host would route ip through, empty if it has no route
+func routeDevice(ip string) string {
+	output, err := exec.Command("ip", "route", "get", ip).Output()
+	if err != nil {
+		return ""
+	}
+	fields := strings.Fields(string(output))
+	for i, field := range fields {
+		if field == "dev" && i+1 < len(fields) {
+			return fields[i+1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,179 @@
+package network
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// IPAM hands out guest addresses from a subnet, keeping each VM's lease in a JSON file
+// (VM name to address) so an address stays with its VM across restarts until it is released
+type IPAM struct {
+	LeasePath string
+	Subnet    string
+	Gateway   string
+}
+
+// NewIPAM creates an allocator for subnet whose leases are kept in leasePath
+func NewIPAM(leasePath, subnet, gateway string) *IPAM {
+	return &IPAM{
+		LeasePath: leasePath,
+		Subnet:    subnet,
+		Gateway:   gateway,
+	}
+}
+
+// Allocate returns the address leased to a VM, leasing one first if it has none: preferred if it
+// is in the subnet and free, otherwise the lowest free address. An address is free when it isn't
+// the subnet's network, broadcast or gateway address, isn't leased and isn't in inUse, which
+// covers addresses held by VMs that predate their lease
+func (a *IPAM) Allocate(name, preferred string, inUse []string) (string, error) {
+	var ip string
+	err := a.update(func(leases map[string]string) error {
+		if leased, ok := leases[name]; ok {
+			ip = leased
+			return nil
+		}
+		_, ipnet, err := net.ParseCIDR(a.Subnet)
+		if err != nil {
+			return fmt.Errorf("invalid subnet: %w", err)
+		}
+		if ipnet.IP.To4() == nil {
+			return fmt.Errorf("invalid IPv4 subnet")
+		}
+
+		taken := map[string]bool{a.Gateway: true}
+		for _, addr := range leases {
+			taken[addr] = true
+		}
+		for _, addr := range inUse {
+			taken[addr] = true
+		}
+
+		if parsed := net.ParseIP(preferred).To4(); parsed != nil && ipnet.Contains(parsed) && !taken[preferred] && usableHost(ipnet, parsed) {
+			ip = preferred
+		} else if ip, err = lowestFree(ipnet, taken); err != nil {
+			return err
+		}
+		leases[name] = ip
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to allocate IP for VM '%s': %w", name, err)
+	}
+	return ip, nil
+}
+
+// Release drops a VM's lease, freeing its address; releasing a VM with no lease does nothing
+func (a *IPAM) Release(name string) error {
+	err := a.update(func(leases map[string]string) error {
+		delete(leases, name)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release IP of VM '%s': %w", name, err)
+	}
+	return nil
+}
+
+// Leases returns the current leases, keyed by VM name
+func (a *IPAM) Leases() (map[string]string, error) {
+	unlock, err := a.lock(syscall.LOCK_SH)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return a.load()
+}
+
+// update runs fn on the leases under an exclusive lock, saving them afterwards if fn succeeds
+func (a *IPAM) update(fn func(leases map[string]string) error) error {
+	unlock, err := a.lock(syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	leases, err := a.load()
+	if err != nil {
+		return err
+	}
+	if err := fn(leases); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(leases, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := a.LeasePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, a.LeasePath)
+}
+
+// lock takes a flock of the given kind (LOCK_SH to read, LOCK_EX to change) on the leases'
+// lock file, returning a function that releases it
+func (a *IPAM) lock(how int) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(a.LeasePath), 0755); err != nil {
+		return nil, err
+	}
+	lockFile, err := os.OpenFile(a.LeasePath+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(lockFile.Fd()), how); err != nil {
+		lockFile.Close()
+		return nil, fmt.Errorf("failed to lock leases: %w", err)
+	}
+	return func() {
+		syscall.Flock(int(lockFile.Fd()), syscall.LOCK_UN)
+		lockFile.Close()
+	}, nil
+}
+
+// load reads the leases; a missing lease file has none
+func (a *IPAM) load() (map[string]string, error) {
+	leases := make(map[string]string)
+	data, err := os.ReadFile(a.LeasePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &leases); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", a.LeasePath, err)
+		}
+	}
+	return leases, nil
+}
+
+// lowestFree returns the lowest usable host address of ipnet that isn't taken
+func lowestFree(ipnet *net.IPNet, taken map[string]bool) (string, error) {
+	base := binary.BigEndian.Uint32(ipnet.IP.To4())
+	ones, bits := ipnet.Mask.Size()
+	size := uint32(1) << (bits - ones)
+	for offset := uint32(1); offset < size; offset++ {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, base+offset)
+		if usableHost(ipnet, ip) && !taken[ip.String()] {
+			return ip.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no free addresses left in %s", ipnet)
+}
+
+// usableHost reports whether ip is neither the network nor the broadcast address of ipnet
+func usableHost(ipnet *net.IPNet, ip net.IP) bool {
+	ip = ip.To4()
+	network := ipnet.IP.To4()
+	broadcast := make(net.IP, 4)
+	for i := range broadcast {
+		broadcast[i] = network[i] | ^ipnet.Mask[i]
+	}
+	return !ip.Equal(network) && !ip.Equal(broadcast)
+}
@@ -0,0 +1,92 @@
+package network
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestIPAM(t *testing.T, subnet, gateway string) *IPAM {
+	return NewIPAM(filepath.Join(t.TempDir(), "leases.json"), subnet, gateway)
+}
+
+func TestAllocateKeepsLeases(t *testing.T) {
+	a := newTestIPAM(t, "172.16.0.0/24", "172.16.0.1")
+
+	first, err := a.Allocate("one", "", nil)
+	if err != nil {
+		t.Fatalf("Allocate(one): %v", err)
+	}
+	if first != "172.16.0.2" {
+		t.Errorf("first address = %s, want 172.16.0.2 (after the gateway)", first)
+	}
+	second, err := a.Allocate("two", "", []string{"172.16.0.3"})
+	if err != nil {
+		t.Fatalf("Allocate(two): %v", err)
+	}
+	if second != "172.16.0.4" {
+		t.Errorf("second address = %s, want 172.16.0.4 (skipping the in-use one)", second)
+	}
+	preferred, err := a.Allocate("three", "172.16.0.50", nil)
+	if err != nil || preferred != "172.16.0.50" {
+		t.Errorf("Allocate with a free preferred address = %s, %v; want 172.16.0.50", preferred, err)
+	}
+	if again, err := a.Allocate("one", "172.16.0.99", nil); err != nil || again != first {
+		t.Errorf("Allocate(one) again = %s, %v; want its lease %s", again, err, first)
+	}
+
+	leases, err := a.Leases()
+	if err != nil {
+		t.Fatalf("Leases: %v", err)
+	}
+	if len(leases) != 3 || leases["two"] != second {
+		t.Errorf("Leases = %v", leases)
+	}
+}
+
+func TestReleaseFreesAddressForReuse(t *testing.T) {
+	a := newTestIPAM(t, "172.16.0.0/24", "172.16.0.1")
+
+	ip, err := a.Allocate("one", "", nil)
+	if err != nil {
+		t.Fatalf("Allocate(one): %v", err)
+	}
+	if err := a.Release("one"); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if err := a.Release("one"); err != nil {
+		t.Errorf("Release without a lease: %v", err)
+	}
+	if leases, err := a.Leases(); err != nil || len(leases) != 0 {
+		t.Errorf("Leases after release = %v, %v; want none", leases, err)
+	}
+	if reused, err := a.Allocate("two", "", nil); err != nil || reused != ip {
+		t.Errorf("Allocate after release = %s, %v; want the released %s", reused, err, ip)
+	}
+}
+
+func TestAllocateExhaustedPool(t *testing.T) {
+	// A /30 has two usable hosts, one of them the gateway
+	a := newTestIPAM(t, "172.16.0.0/30", "172.16.0.1")
+
+	if ip, err := a.Allocate("one", "", nil); err != nil || ip != "172.16.0.2" {
+		t.Fatalf("Allocate(one) = %s, %v; want 172.16.0.2", ip, err)
+	}
+	if ip, err := a.Allocate("two", "", nil); err == nil {
+		t.Fatalf("Allocate from an exhausted pool returned %s", ip)
+	}
+	if leases, err := a.Leases(); err != nil || len(leases) != 1 {
+		t.Errorf("Leases after a failed allocation = %v, %v; want only one", leases, err)
+	}
+}
+
+func TestLeasesDoesNotWrite(t *testing.T) {
+	a := newTestIPAM(t, "172.16.0.0/24", "172.16.0.1")
+
+	if leases, err := a.Leases(); err != nil || len(leases) != 0 {
+		t.Fatalf("Leases with no lease file = %v, %v", leases, err)
+	}
+	if _, err := os.Stat(a.LeasePath); !os.IsNotExist(err) {
+		t.Errorf("Leases created the lease file: %v", err)
+	}
+}
@@ -85,35 +85,6 @@ func (m *Manager) DeleteTap(tapName string) error {
 	return m.runCmd("ip", "link", "del", tapName)
 }
 
-// AllocateIP allocates an IP address for a VM
-// Uses a simple sequential allocation based on VM index
-func (m *Manager) AllocateIP(vmIndex int) (string, error) {
-	// Parse the subnet to get the base address
-	_, ipnet, err := net.ParseCIDR(m.Subnet)
-	if err != nil {
-		return "", fmt.Errorf("invalid subnet: %w", err)
-	}
-
-	// Start from .2 (gateway is .1)
-	// Each VM gets the next available IP
-	ip := ipnet.IP.To4()
-	if ip == nil {
-		return "", fmt.Errorf("invalid IPv4 subnet")
-	}
-
-	// Calculate IP: base + 2 + vmIndex
-	offset := 2 + vmIndex
-	ip[2] = byte(offset / 256)
-	ip[3] = byte(offset % 256)
-
-	// Make sure we don't exceed the subnet
-	if !ipnet.Contains(ip) {
-		return "", fmt.Errorf("IP allocation exceeded subnet range")
-	}
-
-	return ip.String(), nil
-}
-
 // AddPortForward adds a DNAT rule for port forwarding
 func (m *Manager) AddPortForward(hostPort, guestPort int, guestIP, protocol string) error {
 	rule := fmt.Sprintf("-t nat -A PREROUTING -p %s --dport %d -j DNAT --to-destination %s:%d",
@@ -155,6 +126,15 @@ func (m *Manager) setupNAT() error {
 		}
 	}
 
+	// Allow guest-to-guest traffic, which passes through FORWARD when br_netfilter is loaded
+	if err := m.runCmd("iptables", "-C", "FORWARD",
+		"-i", m.BridgeName, "-o", m.BridgeName, "-j", "ACCEPT"); err != nil {
+		if err := m.runCmd("iptables", "-A", "FORWARD",
+			"-i", m.BridgeName, "-o", m.BridgeName, "-j", "ACCEPT"); err != nil {
+			return err
+		}
+	}
+
 	// Allow forwarding to bridge (established connections)
 	if err := m.runCmd("iptables", "-C", "FORWARD",
 		"-i", m.HostInterface, "-o", m.BridgeName,
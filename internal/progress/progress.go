@@ -0,0 +1,195 @@
+// Package progress reports long-running operations such as downloads and image copies
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reportInterval is how often Printer reports an operation's progress
+const reportInterval = 2 * time.Second
+
+// Progress receives the progress of long-running operations
+// An operation started while another is running is a step of it
+type Progress interface {
+	// Start begins an operation; total is the number of bytes it will process, or 0 if unknown
+	Start(name string, total int64)
+	// Update reports the bytes the current operation has processed so far
+	Update(n int64)
+	// Done ends the named operation, with the error it failed with, if any
+	Done(name string, err error)
+}
+
+// DurationReporter is implemented by a Progress that also wants to know how long a finished
+// operation took, such as the creation or sync of a mount image
+type DurationReporter interface {
+	Duration(name string, d time.Duration)
+}
+
+// ReportDuration passes how long an operation took to p, if p is a DurationReporter
+func ReportDuration(p Progress, name string, d time.Duration) {
+	if r, ok := p.(DurationReporter); ok {
+		r.Duration(name, d)
+	}
+}
+
+// Discard ignores all progress
+var Discard Progress = discard{}
+
+type discard struct{}
+
+func (discard) Start(string, int64) {}
+func (discard) Update(int64)        {}
+func (discard) Done(string, error)  {}
+
+// Run reports fn as an operation without a known size
+func Run(p Progress, name string, fn func() error) error {
+	p.Start(name, 0)
+	err := fn()
+	p.Done(name, err)
+	return err
+}
+
+// operation is an operation a Printer has started and not yet finished
+type operation struct {
+	name       string
+	total      int64
+	done       int64
+	updated    bool
+	started    time.Time
+	lastReport time.Time
+}
+
+// Printer writes progress as indented lines, one level per nested operation
+// The byte count of an operation is printed every few seconds with an estimate of the time
+// remaining, and once more when it finishes. A failed step is printed under its parent;
+// a failed top-level operation is left to the caller, which returns the error
+type Printer struct {
+	w      io.Writer
+	indent int
+
+	mu  sync.Mutex
+	ops []*operation
+}
+
+// NewPrinter creates a Printer writing to w
+// indent is the nesting level of top-level operations, for output under a caller's own heading
+func NewPrinter(w io.Writer, indent int) *Printer {
+	return &Printer{w: w, indent: indent}
+}
+
+// NewStdout creates a Printer writing to stdout
+func NewStdout(indent int) *Printer {
+	return NewPrinter(os.Stdout, indent)
+}
+
+// Start prints the operation's name and makes it the current operation
+func (p *Printer) Start(name string, total int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fmt.Fprintf(p.w, "%s%s...\n", p.prefix(len(p.ops)), name)
+	now := time.Now()
+	p.ops = append(p.ops, &operation{name: name, total: total, started: now, lastReport: now})
+}
+
+// Update records the current operation's byte count, printing it if the last report is old enough
+func (p *Printer) Update(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.ops) == 0 {
+		return
+	}
+	op := p.ops[len(p.ops)-1]
+	op.done = n
+	op.updated = true
+	if time.Since(op.lastReport) >= reportInterval {
+		op.lastReport = time.Now()
+		p.report(op, len(p.ops))
+	}
+}
+
+// Done finishes the named operation and any steps of it left running
+func (p *Printer) Done(name string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := len(p.ops) - 1
+	for i >= 0 && p.ops[i].name != name {
+		i--
+	}
+	if i < 0 {
+		return
+	}
+	op := p.ops[i]
+	p.ops = p.ops[:i]
+
+	switch {
+	case err != nil && i > 0:
+		fmt.Fprintf(p.w, "%s%s failed: %v\n", p.prefix(i), name, err)
+	case err == nil && op.updated:
+		p.report(op, i+1)
+	}
+}
+
+// Duration prints how long an operation took, unless it was too quick to be worth a line
+func (p *Printer) Duration(name string, d time.Duration) {
+	if d < reportInterval {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintf(p.w, "%s%s took %s\n", p.prefix(len(p.ops)), name, d.Round(time.Second))
+}
+
+// report prints an operation's byte count at the given nesting depth
+func (p *Printer) report(op *operation, depth int) {
+	const mb = 1024 * 1024
+	prefix := p.prefix(depth)
+	if op.total <= 0 {
+		fmt.Fprintf(p.w, "%s%.1f MB\n", prefix, float64(op.done)/mb)
+		return
+	}
+
+	done := min(op.done, op.total)
+	line := fmt.Sprintf("%s%.1f of %.1f MB (%.0f%%)", prefix,
+		float64(done)/mb, float64(op.total)/mb, float64(done)*100/float64(op.total))
+	if done > 0 && done < op.total {
+		elapsed := time.Since(op.started)
+		remaining := time.Duration(float64(elapsed) * float64(op.total-done) / float64(done))
+		line += fmt.Sprintf(", about %s remaining", remaining.Round(time.Second))
+	}
+	fmt.Fprintln(p.w, line)
+}
+
+// prefix returns the indentation for a line at the given nesting depth
+func (p *Printer) prefix(depth int) string {
+	return strings.Repeat("  ", p.indent+depth)
+}
+
+// Reader reports the bytes read through it as the current operation's progress
+type Reader struct {
+	r    io.Reader
+	p    Progress
+	read int64
+}
+
+// NewReader wraps r so that reads are reported to p
+func NewReader(r io.Reader, p Progress) *Reader {
+	return &Reader{r: r, p: p}
+}
+
+// Read reads from the wrapped reader and reports the running total
+func (r *Reader) Read(buf []byte) (int, error) {
+	n, err := r.r.Read(buf)
+	if n > 0 {
+		r.read += int64(n)
+		r.p.Update(r.read)
+	}
+	return n, err
+}
@@ -0,0 +1,589 @@
+package vm
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+)
+
+// ManifestVersion is the current manifest format version
+const ManifestVersion = 1
+
+// Manifest is a portable description of a VM containing everything needed to recreate it
+// Runtime-only fields (ID, state, PID, socket, TAP device, image paths) are not included
+type Manifest struct {
+	Version       int             `json:"version"`
+	Name          string          `json:"name"`
+	CPUs          int             `json:"cpus"`
+	MemoryMB      int             `json:"memory_mb"`
+	MinMemoryMB   int             `json:"min_memory_mb,omitempty"`
+	CPULimit      float64         `json:"cpu_limit,omitempty"`
+	MemoryLimitMB int             `json:"memory_limit_mb,omitempty"`
+	DiskSizeMB    int             `json:"disk_size_mb"`
+	Image         string          `json:"image,omitempty"`
+	Kernel        string          `json:"kernel,omitempty"`
+	InitrdPath    string          `json:"initrd_path,omitempty"`
+	ModulesImage  string          `json:"modules_image,omitempty"`
+	Init          string          `json:"init,omitempty"`
+	SyncClock     bool            `json:"sync_clock,omitempty"`
+	RootReadOnly  bool            `json:"rootfs_read_only,omitempty"`
+	DiskCache     string          `json:"disk_cache,omitempty"`
+	DiskIOEngine  string          `json:"disk_io_engine,omitempty"`
+	SSHPublicKey  string          `json:"ssh_public_key,omitempty"`
+	DNSServers    []string        `json:"dns_servers,omitempty"`
+	AutoStart     bool            `json:"auto_start"`
+	GuestAgent    bool            `json:"guest_agent,omitempty"`
+	PortForwards  []PortForward   `json:"port_forwards,omitempty"`
+	Mounts        []ManifestMount `json:"mounts,omitempty"`
+
+	EnvVars map[string]string `json:"env,omitempty"`
+
+	ExtraKernelArgs   string `json:"extra_kernel_args,omitempty"`
+	ReplaceKernelArgs bool   `json:"replace_kernel_args,omitempty"`
+
+	CloudInitUserData      string `json:"cloud_init_user_data,omitempty"`
+	CloudInitNetworkConfig string `json:"cloud_init_network_config,omitempty"`
+
+	CPUAffinity []int `json:"cpu_affinity,omitempty"`
+	Priority    int   `json:"priority,omitempty"`
+
+	Verity     bool     `json:"verity,omitempty"`
+	DNSSearch  []string `json:"dns_search,omitempty"`
+	NTPServers []string `json:"ntp_servers,omitempty"`
+
+	PostStopHook string `json:"post_stop_hook,omitempty"`
+	PreStartHook string `json:"pre_start_hook,omitempty"`
+
+	Drives []Drive `json:"drives,omitempty"`
+}
+
+// ManifestMount describes a host directory mount in a manifest
+type ManifestMount struct {
+	Mode          string   `json:"mode,omitempty"`
+	TmpfsSizeMB   int      `json:"tmpfs_size_mb,omitempty"`
+	ArchivePath   string   `json:"archive_path,omitempty"`
+	ArchiveSizeMB int      `json:"archive_size_mb,omitempty"`
+	HostPath      string   `json:"host_path,omitempty"`
+	OverlayPaths  []string `json:"overlay_paths,omitempty"`
+	GuestTag      string   `json:"guest_tag"`
+	ReadOnly      bool     `json:"read_only"`
+	InodeRatio    int      `json:"inode_ratio,omitempty"`
+	BlockSize     int      `json:"block_size,omitempty"`
+	MkfsOptions   []string `json:"mkfs_options,omitempty"`
+	SyncMode      string   `json:"sync_mode,omitempty"`
+	Encrypted     bool     `json:"encrypted,omitempty"`
+	KeyFile       string   `json:"key_file,omitempty"`
+	Shared        bool     `json:"shared,omitempty"`
+	DriveOrder    int      `json:"drive_order,omitempty"`
+	DriveID       string   `json:"drive_id,omitempty"`
+
+	ReservedBlocksPercent int    `json:"reserved_blocks_percent,omitempty"`
+	GuestPath             string `json:"guest_path,omitempty"`
+	Filesystem            string `json:"filesystem,omitempty"`
+	SubPath               string `json:"sub_path,omitempty"`
+}
+
+// NewManifest builds a manifest from a VM
+func NewManifest(v *VM) *Manifest {
+	manifest := &Manifest{
+		Version:       ManifestVersion,
+		Name:          v.Name,
+		CPUs:          v.CPUs,
+		MemoryMB:      v.MemoryMB,
+		MinMemoryMB:   v.MinMemoryMB,
+		CPULimit:      v.CPULimit,
+		MemoryLimitMB: v.MemoryLimitMB,
+		DiskSizeMB:    v.DiskSizeMB,
+		Image:         v.Image,
+		Kernel:        v.Kernel,
+		InitrdPath:    v.InitrdPath,
+		ModulesImage:  v.ModulesImage,
+		Init:          v.Init,
+		SyncClock:     v.SyncClock,
+		RootReadOnly:  v.RootReadOnly,
+		DiskCache:     v.DiskCache,
+		DiskIOEngine:  v.DiskIOEngine,
+		SSHPublicKey:  v.SSHPublicKey,
+		DNSServers:    v.DNSServers,
+		EnvVars:       v.EnvVars,
+		AutoStart:     v.AutoStart,
+		GuestAgent:    v.GuestAgent,
+		PortForwards:  v.PortForwards,
+
+		ExtraKernelArgs:   v.ExtraKernelArgs,
+		ReplaceKernelArgs: v.ReplaceKernelArgs,
+
+		CloudInitUserData:      v.CloudInitUserData,
+		CloudInitNetworkConfig: v.CloudInitNetworkConfig,
+
+		CPUAffinity: v.CPUAffinity,
+		Priority:    v.Priority,
+
+		Verity:     v.Verity,
+		DNSSearch:  v.DNSSearch,
+		NTPServers: v.NTPServers,
+
+		PostStopHook: v.PostStopHook,
+		PreStartHook: v.PreStartHook,
+
+		Drives: v.Drives,
+	}
+	for _, m := range v.Mounts {
+		manifest.Mounts = append(manifest.Mounts, ManifestMount{
+			Mode:          m.Mode,
+			TmpfsSizeMB:   m.TmpfsSizeMB,
+			ArchivePath:   m.ArchivePath,
+			ArchiveSizeMB: m.ArchiveSizeMB,
+			HostPath:      m.HostPath,
+			OverlayPaths:  m.OverlayPaths,
+			GuestTag:      m.GuestTag,
+			ReadOnly:      m.ReadOnly,
+			InodeRatio:    m.InodeRatio,
+			BlockSize:     m.BlockSize,
+			MkfsOptions:   m.MkfsOptions,
+			SyncMode:      m.SyncMode,
+			Encrypted:     m.Encrypted,
+			KeyFile:       m.KeyFile,
+			Shared:        m.Shared,
+			DriveOrder:    m.DriveOrder,
+			DriveID:       m.DriveID,
+
+			ReservedBlocksPercent: m.ReservedBlocksPercent,
+			GuestPath:             m.GuestPath,
+			Filesystem:            m.Filesystem,
+			SubPath:               m.SubPath,
+		})
+	}
+	return manifest
+}
+
+// Validate checks that the manifest describes a usable VM
+func (m *Manifest) Validate() error {
+	if m.Version != ManifestVersion {
+		return fmt.Errorf("unsupported manifest version %d (expected %d)", m.Version, ManifestVersion)
+	}
+	if m.Name == "" {
+		return fmt.Errorf("manifest is missing a VM name")
+	}
+	if err := ValidateName(m.Name); err != nil {
+		return err
+	}
+	if m.CPUs < 1 {
+		return fmt.Errorf("VM '%s': cpus must be at least 1", m.Name)
+	}
+	if m.MemoryMB < 1 {
+		return fmt.Errorf("VM '%s': memory_mb must be positive", m.Name)
+	}
+	if m.MinMemoryMB < 0 || m.MinMemoryMB > m.MemoryMB {
+		return fmt.Errorf("VM '%s': min_memory_mb must be between 0 and memory_mb", m.Name)
+	}
+	if m.CPULimit < 0 {
+		return fmt.Errorf("VM '%s': cpu_limit cannot be negative", m.Name)
+	}
+	if m.MemoryLimitMB != 0 && m.MemoryLimitMB <= m.MemoryMB {
+		return fmt.Errorf("VM '%s': memory_limit_mb must be larger than memory_mb", m.Name)
+	}
+	if m.DiskSizeMB < 0 {
+		return fmt.Errorf("VM '%s': disk_size_mb cannot be negative", m.Name)
+	}
+	tags := make(map[string]bool)
+	for _, mount := range m.Mounts {
+		switch mount.Mode {
+		case "", MountModeImage:
+			if mount.HostPath == "" || mount.GuestTag == "" {
+				return fmt.Errorf("VM '%s': mounts require host_path and guest_tag", m.Name)
+			}
+		case MountModeTmpfs:
+			if mount.GuestTag == "" || mount.TmpfsSizeMB < 1 {
+				return fmt.Errorf("VM '%s': tmpfs mounts require guest_tag and a positive tmpfs_size_mb", m.Name)
+			}
+		case MountModeArchive:
+			if mount.ArchivePath == "" || mount.GuestTag == "" {
+				return fmt.Errorf("VM '%s': archive mounts require archive_path and guest_tag", m.Name)
+			}
+			if mount.ArchiveSizeMB < 0 {
+				return fmt.Errorf("VM '%s': archive_size_mb cannot be negative", m.Name)
+			}
+		default:
+			return fmt.Errorf("VM '%s': invalid mount mode '%s'", m.Name, mount.Mode)
+		}
+		if mount.Mode == MountModeTmpfs && (mount.DriveOrder != 0 || mount.DriveID != "") {
+			return fmt.Errorf("VM '%s': tmpfs mount '%s' has no drive to order or name", m.Name, mount.GuestTag)
+		}
+		if tags[mount.GuestTag] {
+			return fmt.Errorf("VM '%s': duplicate mount tag '%s'", m.Name, mount.GuestTag)
+		}
+		tags[mount.GuestTag] = true
+	}
+	return nil
+}
+
+// ToVM creates a new VM (with a fresh ID and MAC address) from the manifest
+func (m *Manifest) ToVM() *VM {
+	v := NewVM(m.Name)
+	v.CPUs = m.CPUs
+	v.MemoryMB = m.MemoryMB
+	v.MinMemoryMB = m.MinMemoryMB
+	v.CPULimit = m.CPULimit
+	v.MemoryLimitMB = m.MemoryLimitMB
+	v.DiskSizeMB = m.DiskSizeMB
+	v.Image = m.Image
+	v.Kernel = m.Kernel
+	v.InitrdPath = m.InitrdPath
+	v.ModulesImage = m.ModulesImage
+	v.Init = m.Init
+	v.SyncClock = m.SyncClock
+	v.RootReadOnly = m.RootReadOnly
+	v.DiskCache = m.DiskCache
+	v.DiskIOEngine = m.DiskIOEngine
+	v.SSHPublicKey = m.SSHPublicKey
+	v.DNSServers = m.DNSServers
+	v.EnvVars = m.EnvVars
+	v.ExtraKernelArgs = m.ExtraKernelArgs
+	v.ReplaceKernelArgs = m.ReplaceKernelArgs
+	v.CloudInitUserData = m.CloudInitUserData
+	v.CloudInitNetworkConfig = m.CloudInitNetworkConfig
+	v.CPUAffinity = m.CPUAffinity
+	v.Priority = m.Priority
+	v.Verity = m.Verity
+	v.DNSSearch = m.DNSSearch
+	v.NTPServers = m.NTPServers
+	v.PostStopHook = m.PostStopHook
+	v.PreStartHook = m.PreStartHook
+	v.Drives = m.Drives
+	v.AutoStart = m.AutoStart
+	v.GuestAgent = m.GuestAgent
+	v.PortForwards = m.PortForwards
+	v.MacAddress = v.GenerateMacAddress()
+	for _, mount := range m.Mounts {
+		v.Mounts = append(v.Mounts, Mount{
+			Mode:          mount.Mode,
+			TmpfsSizeMB:   mount.TmpfsSizeMB,
+			ArchivePath:   mount.ArchivePath,
+			ArchiveSizeMB: mount.ArchiveSizeMB,
+			HostPath:      mount.HostPath,
+			OverlayPaths:  mount.OverlayPaths,
+			GuestTag:      mount.GuestTag,
+			ReadOnly:      mount.ReadOnly,
+			InodeRatio:    mount.InodeRatio,
+			BlockSize:     mount.BlockSize,
+			MkfsOptions:   mount.MkfsOptions,
+			SyncMode:      mount.SyncMode,
+			Encrypted:     mount.Encrypted,
+			KeyFile:       mount.KeyFile,
+			Shared:        mount.Shared,
+			DriveOrder:    mount.DriveOrder,
+			DriveID:       mount.DriveID,
+
+			ReservedBlocksPercent: mount.ReservedBlocksPercent,
+			GuestPath:             mount.GuestPath,
+			Filesystem:            mount.Filesystem,
+			SubPath:               mount.SubPath,
+		})
+	}
+	return v
+}
+
+// ExportManifest serializes a VM's configuration to a portable JSON manifest
+func ExportManifest(v *VM) ([]byte, error) {
+	data, err := json.MarshalIndent(NewManifest(v), "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// ImportManifest parses and validates a JSON manifest, returning a new unsaved VM
+func ImportManifest(data []byte) (*VM, error) {
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if err := manifest.Validate(); err != nil {
+		return nil, err
+	}
+	return manifest.ToVM(), nil
+}
+
+// ManifestSet describes a group of VMs to be managed declaratively
+type ManifestSet struct {
+	Version int        `json:"version"`
+	VMs     []Manifest `json:"vms"`
+}
+
+// LoadManifestSet parses and validates a multi-VM manifest file
+func LoadManifestSet(path string) (*ManifestSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var set ManifestSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if set.Version != ManifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d (expected %d)", set.Version, ManifestVersion)
+	}
+
+	names := make(map[string]bool)
+	for i := range set.VMs {
+		// Individual entries inherit the file's version
+		if set.VMs[i].Version == 0 {
+			set.VMs[i].Version = set.Version
+		}
+		if err := set.VMs[i].Validate(); err != nil {
+			return nil, err
+		}
+		if names[set.VMs[i].Name] {
+			return nil, fmt.Errorf("duplicate VM name '%s' in manifest", set.VMs[i].Name)
+		}
+		names[set.VMs[i].Name] = true
+	}
+	return &set, nil
+}
+
+// Diff lists the settings that differ between two manifests, as human-readable strings
+func (m *Manifest) Diff(other *Manifest) []string {
+	var changes []string
+	if m.CPUs != other.CPUs {
+		changes = append(changes, fmt.Sprintf("cpus: %d -> %d", m.CPUs, other.CPUs))
+	}
+	if m.MemoryMB != other.MemoryMB {
+		changes = append(changes, fmt.Sprintf("memory_mb: %d -> %d", m.MemoryMB, other.MemoryMB))
+	}
+	if m.MinMemoryMB != other.MinMemoryMB {
+		changes = append(changes, fmt.Sprintf("min_memory_mb: %d -> %d", m.MinMemoryMB, other.MinMemoryMB))
+	}
+	if m.CPULimit != other.CPULimit {
+		changes = append(changes, fmt.Sprintf("cpu_limit: %g -> %g", m.CPULimit, other.CPULimit))
+	}
+	if m.MemoryLimitMB != other.MemoryLimitMB {
+		changes = append(changes, fmt.Sprintf("memory_limit_mb: %d -> %d", m.MemoryLimitMB, other.MemoryLimitMB))
+	}
+	if m.DiskSizeMB != other.DiskSizeMB {
+		changes = append(changes, fmt.Sprintf("disk_size_mb: %d -> %d", m.DiskSizeMB, other.DiskSizeMB))
+	}
+	if m.Image != other.Image {
+		changes = append(changes, fmt.Sprintf("image: %q -> %q", m.Image, other.Image))
+	}
+	if m.Kernel != other.Kernel {
+		changes = append(changes, fmt.Sprintf("kernel: %q -> %q", m.Kernel, other.Kernel))
+	}
+	if m.InitrdPath != other.InitrdPath {
+		changes = append(changes, fmt.Sprintf("initrd_path: %q -> %q", m.InitrdPath, other.InitrdPath))
+	}
+	if m.ModulesImage != other.ModulesImage {
+		changes = append(changes, fmt.Sprintf("modules_image: %q -> %q", m.ModulesImage, other.ModulesImage))
+	}
+	if m.Init != other.Init {
+		changes = append(changes, fmt.Sprintf("init: %q -> %q", m.Init, other.Init))
+	}
+	if m.SyncClock != other.SyncClock {
+		changes = append(changes, fmt.Sprintf("sync_clock: %t -> %t", m.SyncClock, other.SyncClock))
+	}
+	if m.SSHPublicKey != other.SSHPublicKey {
+		changes = append(changes, "ssh_public_key changed")
+	}
+	if !equalStrings(m.DNSServers, other.DNSServers) {
+		changes = append(changes, fmt.Sprintf("dns_servers: %v -> %v", m.DNSServers, other.DNSServers))
+	}
+	if !maps.Equal(m.EnvVars, other.EnvVars) {
+		changes = append(changes, "env changed")
+	}
+	if m.ExtraKernelArgs != other.ExtraKernelArgs {
+		changes = append(changes, fmt.Sprintf("extra_kernel_args: %q -> %q", m.ExtraKernelArgs, other.ExtraKernelArgs))
+	}
+	if m.ReplaceKernelArgs != other.ReplaceKernelArgs {
+		changes = append(changes, fmt.Sprintf("replace_kernel_args: %t -> %t", m.ReplaceKernelArgs, other.ReplaceKernelArgs))
+	}
+	if m.CloudInitUserData != other.CloudInitUserData {
+		changes = append(changes, fmt.Sprintf("cloud_init_user_data: %q -> %q", m.CloudInitUserData, other.CloudInitUserData))
+	}
+	if m.CloudInitNetworkConfig != other.CloudInitNetworkConfig {
+		changes = append(changes, fmt.Sprintf("cloud_init_network_config: %q -> %q", m.CloudInitNetworkConfig, other.CloudInitNetworkConfig))
+	}
+	if !slices.Equal(m.CPUAffinity, other.CPUAffinity) {
+		changes = append(changes, fmt.Sprintf("cpu_affinity: %v -> %v", m.CPUAffinity, other.CPUAffinity))
+	}
+	if m.Priority != other.Priority {
+		changes = append(changes, fmt.Sprintf("priority: %d -> %d", m.Priority, other.Priority))
+	}
+	if m.Verity != other.Verity {
+		changes = append(changes, fmt.Sprintf("verity: %t -> %t", m.Verity, other.Verity))
+	}
+	if !equalStrings(m.DNSSearch, other.DNSSearch) {
+		changes = append(changes, fmt.Sprintf("dns_search: %v -> %v", m.DNSSearch, other.DNSSearch))
+	}
+	if !equalStrings(m.NTPServers, other.NTPServers) {
+		changes = append(changes, fmt.Sprintf("ntp_servers: %v -> %v", m.NTPServers, other.NTPServers))
+	}
+	if m.PostStopHook != other.PostStopHook {
+		changes = append(changes, fmt.Sprintf("post_stop_hook: %q -> %q", m.PostStopHook, other.PostStopHook))
+	}
+	if m.PreStartHook != other.PreStartHook {
+		changes = append(changes, fmt.Sprintf("pre_start_hook: %q -> %q", m.PreStartHook, other.PreStartHook))
+	}
+	if m.AutoStart != other.AutoStart {
+		changes = append(changes, fmt.Sprintf("auto_start: %t -> %t", m.AutoStart, other.AutoStart))
+	}
+	if m.RootReadOnly != other.RootReadOnly {
+		changes = append(changes, fmt.Sprintf("rootfs_read_only: %t -> %t", m.RootReadOnly, other.RootReadOnly))
+	}
+	if m.DiskCache != other.DiskCache {
+		changes = append(changes, fmt.Sprintf("disk_cache: %q -> %q", m.DiskCache, other.DiskCache))
+	}
+	if m.DiskIOEngine != other.DiskIOEngine {
+		changes = append(changes, fmt.Sprintf("disk_io_engine: %q -> %q", m.DiskIOEngine, other.DiskIOEngine))
+	}
+	if m.GuestAgent != other.GuestAgent {
+		changes = append(changes, fmt.Sprintf("guest_agent: %t -> %t", m.GuestAgent, other.GuestAgent))
+	}
+	if !equalPortForwards(m.PortForwards, other.PortForwards) {
+		changes = append(changes, "port_forwards changed")
+	}
+	if !equalDrives(m.Drives, other.Drives) {
+		changes = append(changes, "drives changed")
+	}
+	if !equalMounts(m.Mounts, other.Mounts) {
+		changes = append(changes, "mounts changed")
+	}
+	return changes
+}
+
+// equalStrings compares string slices, treating nil and empty as equal
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// equalDrives compares drive lists, treating nil and empty as equal
+func equalDrives(a, b []Drive) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// equalPortForwards compares port forward lists, treating nil and empty as equal
+func equalPortForwards(a, b []PortForward) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// equalMounts compares mount lists, treating nil and empty slices (and an empty or "image" mode) as equal
+func equalMounts(a, b []ManifestMount) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := a[i], b[i]
+		if mountMode(x.Mode) != mountMode(y.Mode) || x.TmpfsSizeMB != y.TmpfsSizeMB ||
+			x.ArchivePath != y.ArchivePath || x.ArchiveSizeMB != y.ArchiveSizeMB ||
+			x.HostPath != y.HostPath || x.GuestTag != y.GuestTag || x.ReadOnly != y.ReadOnly ||
+			x.InodeRatio != y.InodeRatio || x.BlockSize != y.BlockSize || x.SyncMode != y.SyncMode ||
+			x.Encrypted != y.Encrypted || x.KeyFile != y.KeyFile || x.Shared != y.Shared ||
+			x.DriveOrder != y.DriveOrder || x.DriveID != y.DriveID || x.ReservedBlocksPercent != y.ReservedBlocksPercent ||
+			x.GuestPath != y.GuestPath || x.Filesystem != y.Filesystem || x.SubPath != y.SubPath ||
+			!equalStrings(x.OverlayPaths, y.OverlayPaths) || !equalStrings(x.MkfsOptions, y.MkfsOptions) {
+			return false
+		}
+	}
+	return true
+}
+
+// mountMode returns a mount mode with the default spelled out
+func mountMode(mode string) string {
+	if mode == "" {
+		return MountModeImage
+	}
+	return mode
+}
+
+// ApplyTo updates an existing VM's configuration to match the manifest
+// Identity and runtime fields (ID, MAC, TAP, socket, state) are preserved, as are the image
+// paths of mounts whose tag is kept. Mounts whose tag is no longer present, or that must be
+// re-extracted from a different archive, are returned so the caller can delete their images
+func (m *Manifest) ApplyTo(v *VM) []Mount {
+	desired := m.ToVM()
+	v.CPUs = desired.CPUs
+	v.MemoryMB = desired.MemoryMB
+	v.MinMemoryMB = desired.MinMemoryMB
+	v.CPULimit = desired.CPULimit
+	v.MemoryLimitMB = desired.MemoryLimitMB
+	v.DiskSizeMB = desired.DiskSizeMB
+	v.Image = desired.Image
+	v.Kernel = desired.Kernel
+	v.InitrdPath = desired.InitrdPath
+	v.ModulesImage = desired.ModulesImage
+	v.Init = desired.Init
+	v.SyncClock = desired.SyncClock
+	v.RootReadOnly = desired.RootReadOnly
+	v.DiskCache = desired.DiskCache
+	v.DiskIOEngine = desired.DiskIOEngine
+	v.SSHPublicKey = desired.SSHPublicKey
+	v.DNSServers = desired.DNSServers
+	v.EnvVars = desired.EnvVars
+	v.ExtraKernelArgs = desired.ExtraKernelArgs
+	v.ReplaceKernelArgs = desired.ReplaceKernelArgs
+	v.CloudInitUserData = desired.CloudInitUserData
+	v.CloudInitNetworkConfig = desired.CloudInitNetworkConfig
+	v.CPUAffinity = desired.CPUAffinity
+	v.Priority = desired.Priority
+	v.Verity = desired.Verity
+	v.DNSSearch = desired.DNSSearch
+	v.NTPServers = desired.NTPServers
+	v.PostStopHook = desired.PostStopHook
+	v.PreStartHook = desired.PreStartHook
+	v.Drives = desired.Drives
+	v.AutoStart = desired.AutoStart
+	v.GuestAgent = desired.GuestAgent
+	v.PortForwards = desired.PortForwards
+
+	desiredByTag := make(map[string]*Mount, len(desired.Mounts))
+	for i := range desired.Mounts {
+		desiredByTag[desired.Mounts[i].GuestTag] = &desired.Mounts[i]
+	}
+
+	var removed []Mount
+	for _, mount := range v.Mounts {
+		kept, ok := desiredByTag[mount.GuestTag]
+		switch {
+		case ok && kept.IsArchive() && (!mount.IsArchive() || mount.ArchivePath != kept.ArchivePath):
+			// An archive is only extracted into a missing image, so the old image must go
+			removed = append(removed, mount)
+			delete(v.MountDriveIDs, mount.GuestTag)
+		case ok && !kept.IsTmpfs():
+			kept.ImagePath = mount.ImagePath
+		case ok && mount.IsTmpfs():
+			// Still a tmpfs; there is no image to carry over
+		default:
+			// The tag is gone or now names a tmpfs, so its image and drive are no longer used
+			removed = append(removed, mount)
+			delete(v.MountDriveIDs, mount.GuestTag)
+		}
+	}
+	v.Mounts = desired.Mounts
+	return removed
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -24,29 +25,74 @@ const (
 
 // VM represents a microVM instance
 type VM struct {
-	ID           string    `json:"id"`
-	Name         string    `json:"name"`
-	State        State     `json:"state"`
-	CPUs         int       `json:"cpus"`
-	MemoryMB     int       `json:"memory_mb"`
-	DiskSizeMB   int       `json:"disk_size_mb"`
-	Image        string    `json:"image,omitempty"`
-	Kernel       string    `json:"kernel,omitempty"` // Custom kernel name (empty = default)
-	KernelPath   string    `json:"kernel_path"`
-	RootfsPath   string    `json:"rootfs_path"`
-	IPAddress    string    `json:"ip_address"`
-	TapDevice    string    `json:"tap_device"`
-	MacAddress   string    `json:"mac_address"`
-	SSHPort      int       `json:"ssh_port"`
-	SSHPublicKey string    `json:"ssh_public_key,omitempty"`
-	DNSServers   []string  `json:"dns_servers,omitempty"`
-	SocketPath   string    `json:"socket_path"`
-	PID          int       `json:"pid"`
-	AutoStart    bool      `json:"auto_start"`
-	CreatedAt    time.Time `json:"created_at"`
-	StartedAt    time.Time     `json:"started_at,omitempty"`
-	PortForwards []PortForward `json:"port_forwards,omitempty"`
-	Mounts       []Mount       `json:"mounts,omitempty"`
+	ID            string        `json:"id"`
+	Name          string        `json:"name"`
+	State         State         `json:"state"`
+	CPUs          int           `json:"cpus"`
+	MemoryMB      int           `json:"memory_mb"`
+	MinMemoryMB   int           `json:"min_memory_mb,omitempty"`   // Balloon floor; MemoryMB becomes the ceiling (0 = fixed memory)
+	CPULimit      float64       `json:"cpu_limit,omitempty"`       // Host CPU cap for the Firecracker process, in cores (0 = none)
+	MemoryLimitMB int           `json:"memory_limit_mb,omitempty"` // Host memory cap for the Firecracker process (0 = none)
+	DiskSizeMB    int           `json:"disk_size_mb"`
+	Image         string        `json:"image,omitempty"`
+	Kernel        string        `json:"kernel,omitempty"` // Custom kernel name (empty = default)
+	KernelPath    string        `json:"kernel_path"`
+	InitrdPath    string        `json:"initrd_path,omitempty"`   // Optional initrd/initramfs loaded with the kernel
+	ModulesImage  string        `json:"modules_image,omitempty"` // Kernel modules image mounted read-only at /lib/modules
+	Init          string        `json:"init,omitempty"`          // Guest program run as PID 1 instead of the rootfs's init
+	SyncClock     bool          `json:"sync_clock,omitempty"`    // Set the guest clock from the host time at boot
+	RootfsPath    string        `json:"rootfs_path"`
+	RootReadOnly  bool          `json:"rootfs_read_only,omitempty"` // Attach the rootfs read-only for an immutable guest
+	DiskCache     string        `json:"disk_cache,omitempty"`       // Cache type of the rootfs and mount drives: writeback (default) or unsafe
+	DiskIOEngine  string        `json:"disk_io_engine,omitempty"`   // I/O engine of the rootfs and mount drives: sync (default) or async
+	IPAddress     string        `json:"ip_address"`
+	TapDevice     string        `json:"tap_device"`
+	MacAddress    string        `json:"mac_address"`
+	SSHPort       int           `json:"ssh_port"`
+	SSHPublicKey  string        `json:"ssh_public_key,omitempty"`
+	DNSServers    []string      `json:"dns_servers,omitempty"`
+	SocketPath    string        `json:"socket_path"`
+	GuestAgent    bool          `json:"guest_agent,omitempty"` // Guest runs an agent on vsock for clean shutdown
+	VsockPath     string        `json:"vsock_path,omitempty"`
+	LogPath       string        `json:"log_path,omitempty"`       // Firecracker output and serial console, set at start
+	MemSnapshot   string        `json:"mem_snapshot,omitempty"`   // Guest memory saved by 'vmm suspend'; while set, start resumes from it
+	StateSnapshot string        `json:"state_snapshot,omitempty"` // VM state saved alongside MemSnapshot
+	PID           int           `json:"pid"`
+	AutoStart     bool          `json:"auto_start"`
+	CreatedAt     time.Time     `json:"created_at"`
+	StartedAt     time.Time     `json:"started_at,omitempty"`
+	PortForwards  []PortForward `json:"port_forwards,omitempty"`
+	Mounts        []Mount       `json:"mounts,omitempty"`
+	// MountDriveIDs maps mount guest tags to the Firecracker drive IDs assigned at the last start
+	MountDriveIDs map[string]string `json:"mount_drive_ids,omitempty"`
+	// KernelArgs is the kernel command line of the last boot; a resume from a snapshot keeps it
+	KernelArgs string `json:"kernel_args,omitempty"`
+	// EnvVars are written to the guest's /etc/environment at each start
+	EnvVars map[string]string `json:"env,omitempty"`
+	// ExtraKernelArgs are merged into the default kernel command line, or replace it with ReplaceKernelArgs
+	ExtraKernelArgs   string `json:"extra_kernel_args,omitempty"`
+	ReplaceKernelArgs bool   `json:"replace_kernel_args,omitempty"`
+	// CloudInitUserData and CloudInitNetworkConfig are host files put on a cloud-init NoCloud seed at each start
+	CloudInitUserData      string `json:"cloud_init_user_data,omitempty"`
+	CloudInitNetworkConfig string `json:"cloud_init_network_config,omitempty"`
+	// CPUAffinity pins vCPU i to host CPU CPUAffinity[i % len] at each start (empty = unpinned)
+	CPUAffinity []int `json:"cpu_affinity,omitempty"`
+	// Priority orders VMs for the memory guard: the lowest-priority running VMs are paused first
+	Priority int `json:"priority,omitempty"`
+	// Verity boots the rootfs read-only as a dm-verity device; its hash tree is rebuilt at each start
+	Verity bool `json:"verity,omitempty"`
+	// DNSSearch are the guest's DNS search domains, written to resolv.conf and the cloud-init seed
+	DNSSearch []string `json:"dns_search,omitempty"`
+	// NTPServers are handed to cloud-init through the seed's vendor-data
+	NTPServers []string `json:"ntp_servers,omitempty"`
+	// PostStopHook is a host shell command run after the VM's Firecracker process has exited on a stop
+	PostStopHook string `json:"post_stop_hook,omitempty"`
+	// PreStartHook is a host shell command run before each start; the start is aborted if it fails
+	PreStartHook string `json:"pre_start_hook,omitempty"`
+	// Drives are host block devices or image files attached as is after every other drive
+	Drives []Drive `json:"drives,omitempty"`
+	// DiskSnapshots are copies of the rootfs and mount images taken by 'vmm suspend --with-disks', by image path
+	DiskSnapshots map[string]string `json:"disk_snapshots,omitempty"`
 }
 
 // PortForward represents a port forwarding rule
@@ -56,12 +102,143 @@ type PortForward struct {
 	Protocol  string `json:"protocol"` // tcp or udp
 }
 
+// Drive is a host block device, such as a partition or LVM volume, or an image file given
+// straight to the guest without the mount abstraction
+type Drive struct {
+	Path     string `json:"path"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+	ID       string `json:"id,omitempty"` // Firecracker drive ID (empty = extraN by position)
+}
+
+// Mount modes
+const (
+	MountModeImage   = "image"   // ext4 image built from host directories (the default)
+	MountModeTmpfs   = "tmpfs"   // guest-only tmpfs; nothing is stored on the host
+	MountModeArchive = "archive" // ext4 image extracted once from a tar archive
+)
+
 // Mount represents a host directory mount configuration
 type Mount struct {
-	HostPath  string `json:"host_path"`  // Path on host to mount
-	GuestTag  string `json:"guest_tag"`  // Tag/name for mount point (/mnt/<tag>)
-	ReadOnly  bool   `json:"read_only"`  // Whether mount is read-only
-	ImagePath string `json:"image_path"` // Path to the ext4 image created from host dir
+	Mode          string   `json:"mode,omitempty"`            // MountModeImage (default when empty), MountModeTmpfs or MountModeArchive
+	TmpfsSizeMB   int      `json:"tmpfs_size_mb,omitempty"`   // Size of the tmpfs for MountModeTmpfs
+	ArchivePath   string   `json:"archive_path,omitempty"`    // Tar archive the image is extracted from for MountModeArchive
+	ArchiveSizeMB int      `json:"archive_size_mb,omitempty"` // Image size for MountModeArchive (0 = sized from the archive)
+	HostPath      string   `json:"host_path"`                 // Path on host to mount
+	OverlayPaths  []string `json:"overlay_paths,omitempty"`   // Extra host dirs layered over HostPath, later ones win
+	GuestTag      string   `json:"guest_tag"`                 // Tag/name for the drive and default mount point (/mnt/<tag>)
+	ReadOnly      bool     `json:"read_only"`                 // Whether mount is read-only
+	InodeRatio    int      `json:"inode_ratio,omitempty"`     // Bytes per inode passed to mkfs.ext4 -i (0 = default)
+	BlockSize     int      `json:"block_size,omitempty"`      // Filesystem block size passed to mkfs.ext4 -b (0 = default)
+	MkfsOptions   []string `json:"mkfs_options,omitempty"`    // Extra arguments passed to mkfs.ext4
+	SyncMode      string   `json:"sync_mode,omitempty"`       // How the image is refreshed on start: mirror (default) or merge
+	Encrypted     bool     `json:"encrypted,omitempty"`       // Whether the image is a LUKS2 container, opened on the host while the VM runs
+	KeyFile       string   `json:"key_file,omitempty"`        // Passphrase file for an encrypted image (VMM_MOUNT_KEY when empty)
+	Shared        bool     `json:"shared,omitempty"`          // Use one image for every VM mounting the same read-only content
+	DriveOrder    int      `json:"drive_order,omitempty"`     // Attach order among the mount drives, lowest first (ties keep list order)
+	DriveID       string   `json:"drive_id,omitempty"`        // Firecracker drive ID (empty = mount<N> by attach position)
+	ImagePath     string   `json:"image_path"`                // Path to the ext4 image created from host dir
+
+	// ReservedBlocksPercent is the share of blocks mkfs.ext4 -m reserves for root; data mounts reserve none by default
+	ReservedBlocksPercent int `json:"reserved_blocks_percent,omitempty"`
+	// GuestPath is where the guest mounts it (empty = /mnt/<tag>)
+	GuestPath string `json:"guest_path,omitempty"`
+	// Filesystem formats the image: FilesystemExt4 (default when empty) or FilesystemVFAT
+	Filesystem string `json:"filesystem,omitempty"`
+	// SubPath narrows each source directory to this relative subdirectory, whose contents become the image root
+	SubPath string `json:"sub_path,omitempty"`
+}
+
+// Mount image filesystems
+const (
+	FilesystemExt4 = "ext4"
+	FilesystemVFAT = "vfat" // FAT for guests that expect it; no ownership, permissions or symlinks
+)
+
+// MountPath returns where the guest mounts the mount: GuestPath, or /mnt/<tag> by default
+func (m *Mount) MountPath() string {
+	if m.GuestPath != "" {
+		return m.GuestPath
+	}
+	return "/mnt/" + m.GuestTag
+}
+
+// IsTmpfs reports whether the mount is a guest tmpfs with no host image
+func (m *Mount) IsTmpfs() bool {
+	return m.Mode == MountModeTmpfs
+}
+
+// IsArchive reports whether the mount's image is extracted from a tar archive
+func (m *Mount) IsArchive() bool {
+	return m.Mode == MountModeArchive
+}
+
+// FSType returns the filesystem of the mount's image, with the default spelled out
+func (m *Mount) FSType() string {
+	if m.Filesystem == "" {
+		return FilesystemExt4
+	}
+	return m.Filesystem
+}
+
+// IsVFAT reports whether the mount's image is FAT formatted
+func (m *Mount) IsVFAT() bool {
+	return m.Filesystem == FilesystemVFAT
+}
+
+// EffectiveMode returns the mount mode, with the default spelled out
+func (m *Mount) EffectiveMode() string {
+	if m.Mode == "" {
+		return MountModeImage
+	}
+	return m.Mode
+}
+
+// SourceRoots returns the host directories given for the mount, lowest layer first, before
+// SubPath narrows them. tmpfs and archive mounts have none
+func (m *Mount) SourceRoots() []string {
+	if m.IsTmpfs() || m.IsArchive() {
+		return nil
+	}
+	return append([]string{m.HostPath}, m.OverlayPaths...)
+}
+
+// SourcePaths returns all host directories copied into the mount, lowest layer first: the
+// source roots, each narrowed to SubPath when one is set. tmpfs and archive mounts have none
+func (m *Mount) SourcePaths() []string {
+	roots := m.SourceRoots()
+	if m.SubPath == "" {
+		return roots
+	}
+	paths := make([]string, len(roots))
+	for i, root := range roots {
+		paths[i] = filepath.Join(root, m.SubPath)
+	}
+	return paths
+}
+
+// SourceDescription returns the source of the mount as a display string
+func (m *Mount) SourceDescription() string {
+	switch {
+	case m.IsTmpfs():
+		return fmt.Sprintf("tmpfs (%d MB)", m.TmpfsSizeMB)
+	case m.IsArchive():
+		return fmt.Sprintf("archive %s", m.ArchivePath)
+	}
+	return strings.Join(m.SourcePaths(), " + ")
+}
+
+// ValidateName checks that a VM name contains only alphanumeric characters, dashes, and underscores
+// Names are used in file paths, so anything else (such as "/" or "..") is rejected
+func ValidateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("invalid VM name: name cannot be empty")
+	}
+	for _, c := range name {
+		if !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '-' || c == '_') {
+			return fmt.Errorf("invalid VM name '%s': only alphanumeric, dash, and underscore allowed", name)
+		}
+	}
+	return nil
 }
 
 // NewVM creates a new VM with default settings
@@ -187,6 +187,8 @@ create_kernel_config() {
     ./scripts/config --enable CONFIG_SERIAL_8250
     ./scripts/config --enable CONFIG_SERIAL_8250_CONSOLE
     ./scripts/config --enable CONFIG_EXT4_FS
+    # ISO9660 for cloud-init NoCloud seeds
+    ./scripts/config --enable CONFIG_ISO9660_FS
     ./scripts/config --enable CONFIG_NET
     ./scripts/config --enable CONFIG_INET
 